@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// A role is what a user may do with the pages: read them, edit them too, or as an
+// admin everything, the access control included. Every user has one, editor
+// unless an admin gave them another; visitors who aren't logged in are readers.
+type role int
+
+const (
+	roleNone role = iota
+	roleReader
+	roleEditor
+	roleAdmin
+)
+
+var roleNames = map[string]role{"reader": roleReader, "editor": roleEditor, "admin": roleAdmin}
+
+func (r role) String() string {
+	for name, named := range roleNames {
+		if named == r {
+			return name
+		}
+	}
+	return "none"
+}
+
+// parseRole returns the role of the name, the users' default for "".
+func parseRole(name string) (role, error) {
+	if name == "" {
+		return roleEditor, nil
+	}
+	if named, ok := roleNames[name]; ok {
+		return named, nil
+	}
+	return roleNone, fmt.Errorf("%q is not a role, expected reader, editor or admin", name)
+}
+
+// userRole returns the role of the user, that of a visitor for "".
+func (wiki *Wiki) userRole(name string) role {
+	if name == "" {
+		return roleReader
+	}
+	wiki.usersMu.Lock()
+	user, ok := wiki.users[name]
+	wiki.usersMu.Unlock()
+	if !ok {
+		return roleReader
+	}
+	assigned, err := parseRole(user.Role)
+	if err != nil {
+		return roleReader
+	}
+	return assigned
+}
+
+// setRole gives the user the role.
+func (wiki *Wiki) setRole(name string, assigned role) error {
+	wiki.usersMu.Lock()
+	defer wiki.usersMu.Unlock()
+	user, ok := wiki.users[name]
+	if !ok {
+		return fmt.Errorf("there is no user %s", name)
+	}
+	user.Role = assigned.String()
+	wiki.users[name] = user
+	if err := wiki.storeUsers(); err != nil {
+		return err
+	}
+	return wiki.journalChange(JournalEntry{Op: "user", User: name, Comment: "role " + user.Role})
+}
+
+// aclTitle is the page that says who may read and edit the pages of a namespace.
+// Only admins edit it. Every line of it names a namespace, "/" for the whole
+// wiki, a role and the users who have it there, "*" for everybody:
+//
+//	docs     editor alice bob
+//	docs     reader *
+//	private  editor alice
+//
+// The namespace closest to a page that has lines decides the role of a user with
+// it: the highest one listed for them, or none when they aren't. The pages of a
+// namespace without lines, down to the root, go by the roles of the users.
+// Namespaces match in any case, like titles. Lines starting with # are comments.
+const aclTitle = "ACL"
+
+// aclRule is a line of the ACL page.
+type aclRule struct {
+	role  role
+	users []string
+}
+
+// accessList are the rules of the ACL page, by namespace.
+type accessList map[string][]aclRule
+
+// parseACL reads the ACL page.
+func parseACL(body []byte) (accessList, error) {
+	acl := make(accessList)
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d of %s should name a namespace, a role and its users", line, aclTitle)
+		}
+		namespace := strings.Trim(fields[0], "/")
+		if namespace != "" && !validTitle.MatchString(namespace) {
+			return nil, fmt.Errorf("line %d of %s: %q is not a namespace", line, aclTitle, fields[0])
+		}
+		granted, ok := roleNames[fields[1]]
+		if !ok {
+			return nil, fmt.Errorf("line %d of %s: %q is not a role, expected reader, editor or admin", line, aclTitle, fields[1])
+		}
+		namespace = strings.ToLower(namespace)
+		acl[namespace] = append(acl[namespace], aclRule{role: granted, users: fields[2:]})
+	}
+	return acl, scanner.Err()
+}
+
+// currentACL returns the rules of the ACL page; an ACL page that can't be read
+// grants nobody anything, so that a broken one doesn't open the wiki up.
+func (wiki *Wiki) currentACL() accessList {
+	var body string
+	if page, err := wiki.peek(aclTitle); err == nil {
+		body = string(page.Body)
+	}
+	wiki.accessControl.Lock()
+	defer wiki.accessControl.Unlock()
+	if wiki.accessControl.acl == nil || body != wiki.accessControl.body {
+		acl, err := parseACL([]byte(body))
+		if err != nil {
+			acl = accessList{"": nil}
+		}
+		wiki.accessControl.body, wiki.accessControl.acl = body, acl
+	}
+	return wiki.accessControl.acl
+}
+
+// roleOf returns the role of the user ("" for a visitor) with the page.
+func (wiki *Wiki) roleOf(acl accessList, user, title string) role {
+	global := wiki.userRole(user)
+	if global == roleAdmin {
+		return roleAdmin
+	}
+	if strings.EqualFold(title, aclTitle) {
+		return min(global, roleReader)
+	}
+	namespace, ruled := acl.ruling(title)
+	if !ruled {
+		return global
+	}
+	granted := roleNone
+	for _, rule := range acl[namespace] {
+		for _, listed := range rule.users {
+			if listed == "*" || user != "" && listed == user {
+				granted = max(granted, rule.role)
+			}
+		}
+	}
+	return granted
+}
+
+// ruling returns the namespace closest to the page that has lines, which decide
+// the roles with it, and false when none has and the roles of the users do.
+func (acl accessList) ruling(title string) (string, bool) {
+	namespace := strings.ToLower(namespaceOf(title))
+	for {
+		if _, ok := acl[namespace]; ok {
+			return namespace, true
+		}
+		if namespace == "" {
+			return "", false
+		}
+		namespace = namespaceOf(namespace)
+	}
+}
+
+// pageAccess returns the page a request is about and the role it needs with it:
+// a reader's for what shows the page, an editor's for what changes it. Requests
+// that aren't about one page need none.
+func (wiki *Wiki) pageAccess(r *http.Request) (string, role, bool) {
+	reading := r.Method == http.MethodGet || r.Method == http.MethodHead
+	needed := roleEditor
+	if reading {
+		needed = roleReader
+	}
+	path := r.URL.Path
+	if match := validPath.FindStringSubmatch(path); match != nil {
+		switch match[1] {
+		case "view", "history", "diff", "backlinks", "watch", "print":
+			return match[2], roleReader, true
+		}
+		return match[2], roleEditor, true
+	}
+	switch {
+	case strings.HasPrefix(path, "/api/v1/pages/"):
+		title, _ := wiki.apiPageTitle(path)
+		return title, needed, title != ""
+	case strings.HasPrefix(path, "/attachments/"):
+		title := strings.TrimPrefix(path, "/attachments/")
+		if reading {
+			slash := strings.LastIndex(title, "/")
+			if slash < 0 {
+				return "", roleNone, false
+			}
+			title = title[:slash]
+		}
+		return title, needed, true
+	case strings.HasPrefix(path, "/export/"):
+		title, ok := strings.CutSuffix(strings.TrimPrefix(path, "/export/"), ".html")
+		if !ok {
+			title, ok = strings.CutSuffix(strings.TrimPrefix(path, "/export/"), ".pdf")
+		}
+		return title, roleReader, ok
+	case strings.HasPrefix(path, "/api/v1/lint/"), strings.HasPrefix(path, "/api/lint/"):
+		title, _ := lintAPITitle(path)
+		return title, roleReader, true
+	case strings.HasPrefix(path, "/api/v1/drafts/"), strings.HasPrefix(path, "/api/drafts/"):
+		title, _ := draftsAPITitle(path)
+		return title, roleEditor, true
+	}
+	return "", roleNone, false
+}
+
+// checkAccess lets a request about a page through only with the role it needs
+// with the page. A visitor is sent to log in first.
+func (wiki *Wiki) checkAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		title, needed, ok := wiki.pageAccess(r)
+		title = wiki.titles.Canonical(title)
+		// the admin token is looked for last, it may be in a form that's still to be read
+		if !ok || wiki.roleOf(wiki.currentACL(), wiki.currentUser(r), title) >= needed || wiki.isAdmin(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if wiki.currentUser(r) == "" && r.Method == http.MethodGet {
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+			return
+		}
+		verb := "edit"
+		if needed == roleReader {
+			verb = "read"
+		}
+		wiki.serveError(w, forbidden("you are not allowed to "+verb+" "+title))
+	})
+}
+
+// UsersPage is the data of the users template.
+type UsersPage struct {
+	Users []UserRole
+	Roles []string
+	Error string
+}
+
+// UserRole is a user with their role.
+type UserRole struct {
+	Name string
+	Role string
+}
+
+// usersHandler serves /admin/users to admins: the users with their roles, and
+// POST user= with role= gives one of them another.
+func (wiki *Wiki) usersHandler(w http.ResponseWriter, r *http.Request) {
+	if !wiki.isAdmin(r) {
+		wiki.serveError(w, forbidden("only admins can assign roles"))
+		return
+	}
+	data := UsersPage{Roles: []string{"reader", "editor", "admin"}}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		assigned, err := parseRole(r.FormValue("role"))
+		if err == nil {
+			err = wiki.setRole(r.FormValue("user"), assigned)
+		}
+		if err != nil {
+			data.Error = err.Error()
+		}
+	default:
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	wiki.usersMu.Lock()
+	for name := range wiki.users {
+		data.Users = append(data.Users, UserRole{Name: name})
+	}
+	wiki.usersMu.Unlock()
+	sort.Slice(data.Users, func(i, j int) bool { return data.Users[i].Name < data.Users[j].Name })
+	for idx := range data.Users {
+		data.Users[idx].Role = wiki.userRole(data.Users[idx].Name).String()
+	}
+	wiki.renderTemplate(w, "users.html", data)
+}
@@ -0,0 +1,598 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// maxAttachmentBytes bounds the size of an uploaded attachment, whatever the
+	// settings allow.
+	maxAttachmentBytes = 32 << 20
+	// defaultMaxAttachments is the number of attachments a page can have unless
+	// its settings say otherwise.
+	defaultMaxAttachments = 20
+)
+
+// defaultAttachmentTypes are the types attachments can have unless the settings
+// say otherwise: images and documents that are displayed, not run. SVG and HTML,
+// which can carry scripts, are left out.
+var defaultAttachmentTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp", "application/pdf", "text/plain"}
+
+// attachmentName matches the file names attachments are stored under.
+var attachmentName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,99}$`)
+
+// blob is the stored content of attachments, kept once in attachments/blobs under
+// its SHA-256 however many pages attach it. Refs counts the attachments using it;
+// the blob is removed with the last of them.
+type blob struct {
+	Size int64  `json:"size"`
+	Type string `json:"type"`
+	Refs int    `json:"refs"`
+}
+
+func (wiki *Wiki) blobFilename(hash string) string {
+	return wiki.dataPath("attachments", "blobs", hash)
+}
+
+func (wiki *Wiki) manifestFilename(title string) string {
+	return wiki.dataPath("attachments", "pages", filepath.FromSlash(title)+".json")
+}
+
+// loadAttachments reads the blobs and every page's manifest. The index.json of
+// the wikis before the manifests is split into them, and removed.
+func (wiki *Wiki) loadAttachments() error {
+	if data, err := os.ReadFile(wiki.dataPath("attachments", "index.json")); err == nil {
+		if err := json.Unmarshal(data, &wiki.attachments); err != nil {
+			return err
+		}
+		if err := wiki.saveAttachments(); err != nil {
+			return err
+		}
+		return os.Remove(wiki.dataPath("attachments", "index.json"))
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	data, err := os.ReadFile(wiki.dataPath("attachments", "blobs.json"))
+	if err == nil {
+		err = json.Unmarshal(data, &wiki.attachments.Blobs)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = filepath.WalkDir(wiki.dataPath("attachments", "pages"), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(wiki.dataPath("attachments", "pages"), path)
+		if err != nil {
+			return err
+		}
+		title, ok := strings.CutSuffix(filepath.ToSlash(name), ".json")
+		if !ok {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var named map[string]string
+		if err := json.Unmarshal(data, &named); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if len(named) > 0 {
+			wiki.attachments.Pages[title] = named
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// saveBlobsLocked writes blobs.json. It must be called with attachments locked.
+func (wiki *Wiki) saveBlobsLocked() error {
+	if err := os.MkdirAll(wiki.dataPath("attachments"), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(wiki.attachments.Blobs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(wiki.dataPath("attachments", "blobs.json"), data, 0600)
+}
+
+// saveManifestLocked writes the manifest of the page, or removes it with the
+// directories of its namespaces once the page has no attachments. It must be
+// called with attachments locked.
+func (wiki *Wiki) saveManifestLocked(title string) error {
+	filename := wiki.manifestFilename(title)
+	named := wiki.attachments.Pages[title]
+	if len(named) == 0 {
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for dir := filepath.Dir(filename); dir != wiki.dataPath("attachments", "pages"); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(named, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0600)
+}
+
+// saveAttachments writes the blobs and every manifest, removing those of the
+// pages left without attachments. It must be called with attachments locked.
+func (wiki *Wiki) saveAttachments() error {
+	var stale []string
+	err := filepath.WalkDir(wiki.dataPath("attachments", "pages"), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		if name, err := filepath.Rel(wiki.dataPath("attachments", "pages"), path); err == nil {
+			if title, ok := strings.CutSuffix(filepath.ToSlash(name), ".json"); ok && wiki.attachments.Pages[title] == nil {
+				stale = append(stale, title)
+			}
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, title := range stale {
+		if err := wiki.saveManifestLocked(title); err != nil {
+			return err
+		}
+	}
+	for title := range wiki.attachments.Pages {
+		if err := wiki.saveManifestLocked(title); err != nil {
+			return err
+		}
+	}
+	return wiki.saveBlobsLocked()
+}
+
+// spoolUpload copies content into a temporary file of the blob store, hashing it.
+// The caller removes the file, unless it becomes a blob.
+func (wiki *Wiki) spoolUpload(content io.Reader) (string, string, *blob, error) {
+	if err := os.MkdirAll(wiki.dataPath("attachments", "blobs"), 0700); err != nil {
+		return "", "", nil, err
+	}
+	tmp, err := os.CreateTemp(wiki.dataPath("attachments", "blobs"), ".upload.*.tmp")
+	if err != nil {
+		return "", "", nil, err
+	}
+	defer tmp.Close()
+
+	digest := sha256.New()
+	var head [512]byte
+	n, _ := io.ReadFull(content, head[:])
+	size, err := io.Copy(io.MultiWriter(tmp, digest), io.MultiReader(bytes.NewReader(head[:n]), content))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	return tmp.Name(), hex.EncodeToString(digest.Sum(nil)), &blob{Size: size, Type: http.DetectContentType(head[:n])}, err
+}
+
+// unrefLocked drops a reference to the blob, removing it with the last one. It
+// must be called with attachments locked.
+func (wiki *Wiki) unrefLocked(hash string) error {
+	stored := wiki.attachments.Blobs[hash]
+	if stored == nil {
+		return nil
+	}
+	if stored.Refs--; stored.Refs > 0 {
+		return nil
+	}
+	delete(wiki.attachments.Blobs, hash)
+	if err := os.Remove(wiki.blobFilename(hash)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// attach stores content as the page's attachment name, replacing an attachment
+// of the same name, once the upload scanner accepts it.
+func (wiki *Wiki) attach(title, name, author string, content io.Reader) error {
+	tmpName, hash, uploaded, err := wiki.spoolUpload(content)
+	defer os.Remove(tmpName)
+	if err != nil {
+		return err
+	}
+	if err := wiki.checkAttachmentPolicy(title, uploaded); err != nil {
+		return err
+	}
+	if err := wiki.checkAttachmentQuota(title, name, uploaded.Size); err != nil {
+		return &attachmentPolicyError{http.StatusRequestEntityTooLarge, err.Error()}
+	}
+	if err := wiki.scanUpload(tmpName, QuarantineRecord{Author: author, Page: title, Name: name, Hash: hash, Size: uploaded.Size}); err != nil {
+		return err
+	}
+	wiki.attachments.Lock()
+	defer wiki.attachments.Unlock()
+	if _, replaced := wiki.attachments.Pages[title][name]; !replaced {
+		if limit := wiki.settingsFor(title).maxAttachments(); len(wiki.attachments.Pages[title]) >= limit {
+			return &attachmentPolicyError{http.StatusConflict, fmt.Sprintf("%s has %d attachments already, the most it can have; remove one first", title, limit)}
+		}
+	}
+	// Content that is stored already is only referenced once more.
+	if stored := wiki.attachments.Blobs[hash]; stored != nil {
+		stored.Refs++
+	} else {
+		if err := os.Rename(tmpName, wiki.blobFilename(hash)); err != nil {
+			return err
+		}
+		uploaded.Refs = 1
+		wiki.attachments.Blobs[hash] = uploaded
+	}
+	if wiki.attachments.Pages[title] == nil {
+		wiki.attachments.Pages[title] = make(map[string]string)
+	}
+	if previous, ok := wiki.attachments.Pages[title][name]; ok {
+		if err := wiki.unrefLocked(previous); err != nil {
+			return err
+		}
+	}
+	wiki.attachments.Pages[title][name] = hash
+	if err := wiki.saveManifestLocked(title); err != nil {
+		return err
+	}
+	return wiki.saveBlobsLocked()
+}
+
+func (wiki *Wiki) detach(title, name string) error {
+	wiki.attachments.Lock()
+	defer wiki.attachments.Unlock()
+	if _, ok := wiki.attachments.Pages[title][name]; !ok {
+		return os.ErrNotExist
+	}
+	if err := wiki.detachLocked(title, name); err != nil {
+		return err
+	}
+	if err := wiki.saveManifestLocked(title); err != nil {
+		return err
+	}
+	return wiki.saveBlobsLocked()
+}
+
+// dropAttachments detaches every attachment of the page, as it's deleted for
+// good, removing the blobs no other page uses.
+func (wiki *Wiki) dropAttachments(title string) error {
+	wiki.attachments.Lock()
+	defer wiki.attachments.Unlock()
+	if wiki.attachments.Pages[title] == nil {
+		return nil
+	}
+	for name := range wiki.attachments.Pages[title] {
+		if err := wiki.detachLocked(title, name); err != nil {
+			return err
+		}
+	}
+	if err := wiki.saveManifestLocked(title); err != nil {
+		return err
+	}
+	return wiki.saveBlobsLocked()
+}
+
+// detachLocked drops an attachment from the index, and its blob with the last
+// reference. It must be called with attachments locked.
+func (wiki *Wiki) detachLocked(title, name string) error {
+	hash := wiki.attachments.Pages[title][name]
+	delete(wiki.attachments.Pages[title], name)
+	if len(wiki.attachments.Pages[title]) == 0 {
+		delete(wiki.attachments.Pages, title)
+	}
+	return wiki.unrefLocked(hash)
+}
+
+// Attachment is one file attached to a page, as listed on the view page.
+type Attachment struct {
+	Name string
+	URL  string
+	Size int64
+	Type string
+}
+
+// attachmentsOf returns the attachments of the page, by name.
+func (wiki *Wiki) attachmentsOf(title string) []Attachment {
+	wiki.attachments.Lock()
+	defer wiki.attachments.Unlock()
+	var listed []Attachment
+	for name, hash := range wiki.attachments.Pages[title] {
+		stored := wiki.attachments.Blobs[hash]
+		listed = append(listed, Attachment{Name: name, URL: "/files/" + title + "/" + name, Size: stored.Size, Type: stored.Type})
+	}
+	sort.Slice(listed, func(i, j int) bool { return listed[i].Name < listed[j].Name })
+	return listed
+}
+
+// attachmentsHandler serves the attachments of the pages: GET
+// /attachments/{title}/{name} downloads one, like /files/{title}/{name}, and POST
+// /attachments/{title} uploads the multipart file "file", or removes the
+// attachment named by "delete". The edit page uploads with next=/edit/{title} to
+// come back to the editor.
+func (wiki *Wiki) attachmentsHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/attachments/")
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		slash := strings.LastIndex(path, "/")
+		if slash < 0 {
+			wiki.serveError(w, notFound(""))
+			return
+		}
+		wiki.serveAttachment(w, r, path[:slash], path[slash+1:])
+	case http.MethodPost:
+		if !validTitle.MatchString(path) || !wiki.titles.Has(path) {
+			wiki.serveError(w, notFound(""))
+			return
+		}
+		wiki.uploadAttachment(w, r, path)
+	default:
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+	}
+}
+
+func (wiki *Wiki) serveAttachment(w http.ResponseWriter, r *http.Request, title, name string) {
+	wiki.attachments.Lock()
+	hash, ok := wiki.attachments.Pages[title][name]
+	var stored blob
+	if ok {
+		stored = *wiki.attachments.Blobs[hash]
+	}
+	wiki.attachments.Unlock()
+	if !ok {
+		wiki.serveError(w, notFound(""))
+		return
+	}
+	file, err := os.Open(wiki.blobFilename(hash))
+	if err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	defer file.Close()
+	w.Header().Set("Content-Type", stored.Type)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// Only images are shown inline: anything else, HTML included, is downloaded.
+	if !strings.HasPrefix(stored.Type, "image/") {
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	}
+	// The content of a hash never changes.
+	w.Header().Set("ETag", `"`+hash+`"`)
+	http.ServeContent(w, r, "", time.Time{}, file)
+}
+
+func (wiki *Wiki) uploadAttachment(w http.ResponseWriter, r *http.Request, title string) {
+	if ok, reason := wiki.canEdit(r, title); !ok {
+		wiki.serveError(w, forbidden(reason))
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentBytes+1<<20)
+	if name := r.FormValue("delete"); name != "" {
+		if err := wiki.detach(title, name); os.IsNotExist(err) {
+			wiki.serveError(w, notFound(""))
+			return
+		} else if err != nil {
+			wiki.serveError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/view/"+title, http.StatusFound)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if tooLarge(err) {
+		http.Error(w, translate(wiki.requestLocale(w), "error.attachmentTooLarge", maxAttachmentBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, translate(wiki.requestLocale(w), "error.attachmentUpload", err), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	name := header.Filename
+	if slash := strings.LastIndexAny(name, `/\`); slash >= 0 {
+		name = name[slash+1:]
+	}
+	if !attachmentName.MatchString(name) {
+		http.Error(w, translate(wiki.requestLocale(w), "error.attachmentName"), http.StatusBadRequest)
+		return
+	}
+	if limit := wiki.settingsFor(title).maxAttachmentBytes(); header.Size > limit {
+		http.Error(w, translate(wiki.requestLocale(w), "error.attachmentsTooLarge", title, limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	// the scanner's and the settings' refusals are answered with their own status
+	if err := wiki.attach(title, name, wiki.requestAuthor(r), file); err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	if r.FormValue("next") == "/edit/"+title {
+		http.Redirect(w, r, "/edit/"+title, http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/view/"+title, http.StatusFound)
+}
+
+// AttachmentReport is the data of the attachments report template: how much the
+// content-addressed storage saves over storing every attachment separately.
+type AttachmentReport struct {
+	Attachments  int
+	Blobs        int
+	StoredBytes  int64
+	LogicalBytes int64
+	SavedBytes   int64
+	Shared       []SharedBlob
+	// Quarantined are the latest uploads the scanner rejected, newest first.
+	Quarantined []QuarantineRecord
+}
+
+// SharedBlob is stored content attached more than once.
+type SharedBlob struct {
+	Hash string
+	Size int64
+	Refs int
+}
+
+// attachmentReportHandler serves /reports/attachments to admins.
+func (wiki *Wiki) attachmentReportHandler(w http.ResponseWriter, r *http.Request) {
+	if !wiki.isAdmin(r) {
+		wiki.serveError(w, forbidden("only admins can see the attachment storage"))
+		return
+	}
+	var report AttachmentReport
+	wiki.attachments.Lock()
+	for _, byName := range wiki.attachments.Pages {
+		report.Attachments += len(byName)
+	}
+	for hash, stored := range wiki.attachments.Blobs {
+		report.Blobs++
+		report.StoredBytes += stored.Size
+		report.LogicalBytes += stored.Size * int64(stored.Refs)
+		if stored.Refs > 1 {
+			report.Shared = append(report.Shared, SharedBlob{hash, stored.Size, stored.Refs})
+		}
+	}
+	wiki.attachments.Unlock()
+	report.SavedBytes = report.LogicalBytes - report.StoredBytes
+	report.Quarantined = wiki.recentQuarantine()
+	sort.Slice(report.Shared, func(i, j int) bool {
+		return report.Shared[i].Size*int64(report.Shared[i].Refs) > report.Shared[j].Size*int64(report.Shared[j].Refs)
+	})
+	wiki.renderTemplate(w, "attachments.html", report)
+}
+
+// attachmentPolicyError rejects an upload the settings of its page don't allow,
+// with the status to answer.
+type attachmentPolicyError struct {
+	status  int
+	message string
+}
+
+func (err *attachmentPolicyError) Error() string {
+	return err.message
+}
+
+func (s Settings) attachmentTypes() []string {
+	if s.AttachmentTypes != nil {
+		return s.AttachmentTypes
+	}
+	return defaultAttachmentTypes
+}
+
+func (s Settings) maxAttachmentBytes() int64 {
+	if s.MaxAttachmentBytes > 0 {
+		return min(s.MaxAttachmentBytes, maxAttachmentBytes)
+	}
+	return maxAttachmentBytes
+}
+
+func (s Settings) maxAttachments() int {
+	if s.MaxAttachments > 0 {
+		return s.MaxAttachments
+	}
+	return defaultMaxAttachments
+}
+
+// allowsType reports whether the sniffed content type, like
+// "text/plain; charset=utf-8", matches one of the allowed types.
+func (s Settings) allowsType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	for _, allowed := range s.attachmentTypes() {
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); (ok && strings.HasPrefix(mediaType, prefix+"/")) || allowed == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAttachmentPolicy checks the type and size of an upload to the page.
+func (wiki *Wiki) checkAttachmentPolicy(title string, uploaded *blob) error {
+	settings := wiki.settingsFor(title)
+	if !settings.allowsType(uploaded.Type) {
+		return &attachmentPolicyError{http.StatusUnsupportedMediaType, fmt.Sprintf("attachments of %s can't be %s, only %s", title, uploaded.Type, strings.Join(settings.attachmentTypes(), ", "))}
+	}
+	if limit := settings.maxAttachmentBytes(); uploaded.Size > limit {
+		return &attachmentPolicyError{http.StatusRequestEntityTooLarge, fmt.Sprintf("attachments of %s are at most %d bytes", title, limit)}
+	}
+	return nil
+}
+
+// moveAttachments gives the attachments of the page from to the page to: its
+// manifest moves, the blobs and their references stay as they are.
+func (wiki *Wiki) moveAttachments(from, to string) error {
+	wiki.attachments.Lock()
+	defer wiki.attachments.Unlock()
+	if wiki.attachments.Pages[from] == nil {
+		return nil
+	}
+	wiki.attachments.Pages[to] = wiki.attachments.Pages[from]
+	delete(wiki.attachments.Pages, from)
+	if err := wiki.saveManifestLocked(to); err != nil {
+		return err
+	}
+	return wiki.saveManifestLocked(from)
+}
+
+// attachmentEmbed matches ![name], which shows the page's attachment name: an
+// image inline, anything else as a link to download it. ![alt](src) is a Markdown
+// image, and left alone.
+var attachmentEmbed = regexp.MustCompile(`!\[([a-zA-Z0-9][a-zA-Z0-9._-]{0,99})\](\(?)`)
+
+// embedAttachments turns the ![name] embeds of the page's attachments into
+// Markdown images and links, before the body is rendered.
+func (wiki *Wiki) embedAttachments(title string, body []byte) []byte {
+	attached := make(map[string]Attachment)
+	for _, attachment := range wiki.attachmentsOf(title) {
+		attached[attachment.Name] = attachment
+	}
+	if len(attached) == 0 {
+		return body
+	}
+	return attachmentEmbed.ReplaceAllFunc(body, func(embed []byte) []byte {
+		match := attachmentEmbed.FindSubmatch(embed)
+		attachment, ok := attached[string(match[1])]
+		if !ok || len(match[2]) > 0 {
+			return embed
+		}
+		if strings.HasPrefix(attachment.Type, "image/") {
+			return []byte("![" + attachment.Name + "](" + attachment.URL + ")")
+		}
+		return []byte("[" + attachment.Name + "](" + attachment.URL + ")")
+	})
+}
+
+// filesHandler serves GET /files/{title}/{name}, the attachment name of the page.
+func (wiki *Wiki) filesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/files/")
+	slash := strings.LastIndex(path, "/")
+	if slash < 0 {
+		wiki.serveError(w, notFound(""))
+		return
+	}
+	wiki.serveAttachment(w, r, path[:slash], path[slash+1:])
+}
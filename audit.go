@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+)
+
+// auditShown is how many entries /admin/audit lists, the latest first.
+const auditShown = 500
+
+// AuditEntry is a line of the audit trail, audit.jsonl in the data directory:
+// a save, delete or rename of a page, or a login, or a failed one, with who made
+// it from which address. Hash is the SHA-256 of the body of the revision a save
+// or delete recorded, Seq the number of the change in the journal.
+type AuditEntry struct {
+	Time     time.Time `json:"time"`
+	Op       string    `json:"op"`
+	User     string    `json:"user,omitempty"`
+	Client   string    `json:"client,omitempty"`
+	Title    string    `json:"title,omitempty"`
+	To       string    `json:"to,omitempty"`
+	Revision int       `json:"revision,omitempty"`
+	Hash     string    `json:"hash,omitempty"`
+	Seq      uint64    `json:"seq,omitempty"`
+}
+
+func (wiki *Wiki) auditFilename() string {
+	return wiki.dataPath("audit.jsonl")
+}
+
+// audit appends the entry to the audit trail, with -audit.
+func (wiki *Wiki) audit(entry AuditEntry) {
+	if !wiki.config.Audit {
+		return
+	}
+	entry.Time = clock.Now().UTC()
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("could not audit the %s of %s: %v", entry.Op, entry.Title, err)
+		return
+	}
+	wiki.auditFile.Lock()
+	defer wiki.auditFile.Unlock()
+	file, err := os.OpenFile(wiki.auditFilename(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err == nil {
+		if _, err = file.Write(append(line, '\n')); err == nil {
+			err = file.Sync()
+		}
+		file.Close()
+	}
+	if err != nil {
+		log.Printf("could not audit the %s of %s: %v", entry.Op, entry.Title, err)
+	}
+}
+
+// auditLogin audits the login of the user by the request, or the failed
+// attempt to log in as them.
+func (wiki *Wiki) auditLogin(r *http.Request, user string, ok bool) {
+	op := "login"
+	if !ok {
+		op = "login-failed"
+	}
+	wiki.audit(AuditEntry{Op: op, User: user, Client: clientAddr(r)})
+}
+
+// clientAddr is the address of the request's client, as withForwardedClient
+// found it.
+func clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type auditClient struct {
+	addr     string
+	requests int
+}
+
+// withAuditClient registers the address of every write, with -audit, for the
+// audit trail, while it's served.
+func (wiki *Wiki) withAuditClient(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !wiki.config.Audit || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		author := wiki.requestAuthor(r)
+		wiki.auditClients.Lock()
+		client := wiki.auditClients.byAuthor[author]
+		if client == nil {
+			client = &auditClient{}
+			wiki.auditClients.byAuthor[author] = client
+		}
+		client.addr = clientAddr(r)
+		client.requests++
+		wiki.auditClients.Unlock()
+		defer func() {
+			wiki.auditClients.Lock()
+			if client.requests--; client.requests == 0 {
+				delete(wiki.auditClients.byAuthor, author)
+			}
+			wiki.auditClients.Unlock()
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (wiki *Wiki) auditClientOf(author string) string {
+	wiki.auditClients.Lock()
+	defer wiki.auditClients.Unlock()
+	if client := wiki.auditClients.byAuthor[author]; client != nil {
+		return client.addr
+	}
+	return ""
+}
+
+// auditHook is the page hook that audits the saves, deletions and renames the
+// journal records.
+type auditHook struct {
+	wiki *Wiki
+	noPageHook
+}
+
+func (hook auditHook) AfterChange(entry JournalEntry) {
+	if !hook.wiki.config.Audit || (entry.Op != "save" && entry.Op != "delete" && entry.Op != "rename") {
+		return
+	}
+	audited := AuditEntry{Op: entry.Op, User: entry.Author, Client: hook.wiki.auditClientOf(entry.Author), Title: entry.Title,
+		To: entry.To, Revision: entry.Revision, Seq: entry.Seq}
+	if entry.Revision > 0 {
+		revisions, err := hook.wiki.loadRevisions(entry.Title)
+		if err != nil {
+			log.Printf("could not read the revision %d of %s to audit it: %v", entry.Revision, entry.Title, err)
+		}
+		for _, revision := range revisions {
+			if revision.ID == entry.Revision {
+				sum := sha256.Sum256([]byte(revision.Body))
+				audited.Hash = hex.EncodeToString(sum[:])
+				break
+			}
+		}
+	}
+	hook.wiki.audit(audited)
+}
+
+// AuditPage is the data of the audit template: the latest entries of the
+// audit trail by User, of Title, or all of them.
+type AuditPage struct {
+	Auditing bool
+	User     string
+	Title    string
+	Entries  []AuditEntry
+	// More is whether there are older entries than those shown.
+	More bool
+}
+
+// auditHandler serves /admin/audit to admins: the audit trail, the latest first,
+// of ?user= and of the page ?title=, a rename of it included.
+func (wiki *Wiki) auditHandler(w http.ResponseWriter, r *http.Request) {
+	if !wiki.isAdmin(r) {
+		wiki.serveError(w, forbidden("only admins can see the audit trail"))
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	page := AuditPage{Auditing: wiki.config.Audit, User: r.FormValue("user"), Title: r.FormValue("title")}
+	entries, err := wiki.readAudit(func(entry AuditEntry) bool {
+		return (page.User == "" || entry.User == page.User) && (page.Title == "" || entry.Title == page.Title || entry.To == page.Title)
+	})
+	if err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	slices.Reverse(entries)
+	if len(entries) > auditShown {
+		entries, page.More = entries[:auditShown], true
+	}
+	page.Entries = entries
+	wiki.renderTemplate(w, "audit.html", page)
+}
+
+// readAudit returns the entries of the audit trail that match, oldest first.
+func (wiki *Wiki) readAudit(matches func(AuditEntry) bool) ([]AuditEntry, error) {
+	file, err := os.Open(wiki.auditFilename())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, err
+		}
+		if matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
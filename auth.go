@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	authCookie = "gowiki_auth"
+	// loginTTL is how long a login lasts.
+	loginTTL = 7 * 24 * time.Hour
+	// passwordIterations is the PBKDF2-SHA256 work factor of new password hashes.
+	passwordIterations = 600000
+)
+
+// bcryptHash and bcryptCompare hash passwords with bcrypt, and check them
+// against those hashes, if the wiki was built with -tags bcrypt; see bcrypt.go.
+var (
+	bcryptHash    func(password string) (string, error)
+	bcryptCompare func(hash, password string) error
+)
+
+// User is an account of the wiki. Passwords are stored in users.json in the
+// data directory as bcrypt hashes, in a wiki built with -tags bcrypt, otherwise
+// as salted PBKDF2-SHA256 ones; a bcrypt hash has its salt and cost in Hash, and
+// no Iterations. A user signed up with an identity provider has no password.
+type User struct {
+	Name       string `json:"name"`
+	Salt       string `json:"salt"`
+	Hash       string `json:"hash"`
+	Iterations int    `json:"iterations"`
+	// Role is reader, editor or admin, editor when it's empty.
+	Role string `json:"role,omitempty"`
+}
+
+var userNamePattern = validName
+
+func (wiki *Wiki) loadUsers() error {
+	data, err := os.ReadFile(wiki.dataPath("users.json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &wiki.users)
+}
+
+func hashPassword(password string, salt []byte, iterations int) (string, error) {
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, 32)
+	return hex.EncodeToString(key), err
+}
+
+// hashNewPassword returns the user with the password hashed, with bcrypt if the
+// wiki has it.
+func hashNewPassword(user User, password string) (User, error) {
+	if bcryptHash != nil {
+		hash, err := bcryptHash(password)
+		user.Salt, user.Hash, user.Iterations = "", hash, 0
+		return user, err
+	}
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	hash, err := hashPassword(password, salt, passwordIterations)
+	user.Salt, user.Hash, user.Iterations = hex.EncodeToString(salt), hash, passwordIterations
+	return user, err
+}
+
+// setPassword creates the user, or changes their password.
+func (wiki *Wiki) setPassword(name, password string) error {
+	if !userNamePattern.MatchString(name) {
+		return fmt.Errorf("user names are letters and digits only")
+	}
+	if len(password) < 8 {
+		return fmt.Errorf("passwords need at least 8 characters")
+	}
+	user, err := hashNewPassword(User{Name: name}, password)
+	if err != nil {
+		return err
+	}
+	wiki.usersMu.Lock()
+	defer wiki.usersMu.Unlock()
+	previous, existed := wiki.users[name]
+	user.Role = previous.Role
+	wiki.users[name] = user
+	if err := wiki.storeUsers(); err != nil {
+		return err
+	}
+	comment := "added"
+	if existed {
+		comment = "new password"
+	}
+	return wiki.journalChange(JournalEntry{Op: "user", User: name, Comment: comment})
+}
+
+// storeUsers must be called with usersMu held.
+func (wiki *Wiki) storeUsers() error {
+	data, err := json.MarshalIndent(wiki.users, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(wiki.dataPath("users.json"), data, 0600)
+}
+
+// hasUser reports whether the user has an account.
+func (wiki *Wiki) hasUser(name string) bool {
+	wiki.usersMu.Lock()
+	defer wiki.usersMu.Unlock()
+	_, ok := wiki.users[name]
+	return ok
+}
+
+// checkPassword reports whether password is the user's. A password hashed with
+// PBKDF2-SHA256 is hashed again with bcrypt, if the wiki has it, as it's checked.
+func (wiki *Wiki) checkPassword(name, password string) bool {
+	wiki.usersMu.Lock()
+	user, ok := wiki.users[name]
+	wiki.usersMu.Unlock()
+	if !ok {
+		// Hash anyway, so unknown names take as long as wrong passwords.
+		hashNewPassword(user, password)
+		return false
+	}
+	if user.Iterations == 0 {
+		return user.Hash != "" && bcryptCompare != nil && bcryptCompare(user.Hash, password) == nil
+	}
+	salt, err := hex.DecodeString(user.Salt)
+	if err != nil {
+		return false
+	}
+	hash, err := hashPassword(password, salt, user.Iterations)
+	if err != nil || subtle.ConstantTimeCompare([]byte(hash), []byte(user.Hash)) != 1 {
+		return false
+	}
+	if bcryptHash != nil {
+		wiki.rehashPassword(user, password)
+	}
+	return true
+}
+
+// rehashPassword hashes the user's password again with bcrypt, unless it was
+// changed since it was checked.
+func (wiki *Wiki) rehashPassword(user User, password string) {
+	rehashed, err := hashNewPassword(user, password)
+	if err != nil {
+		log.Printf("could not hash the password of %s with bcrypt: %v", user.Name, err)
+		return
+	}
+	wiki.usersMu.Lock()
+	defer wiki.usersMu.Unlock()
+	current, ok := wiki.users[user.Name]
+	if !ok || current.Hash != user.Hash {
+		return
+	}
+	rehashed.Role = current.Role
+	wiki.users[user.Name] = rehashed
+	if err := wiki.storeUsers(); err != nil {
+		log.Printf("could not store the bcrypt hash of the password of %s: %v", user.Name, err)
+		wiki.users[user.Name] = current
+	}
+}
+
+type login struct {
+	User    string    `json:"user"`
+	Expires time.Time `json:"expires"`
+}
+
+// loginKey is the key of the token in logins, which keeps it out of logins.json.
+func loginKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (wiki *Wiki) loadLogins() error {
+	data, err := os.ReadFile(wiki.dataPath("logins.json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	wiki.logins.Lock()
+	defer wiki.logins.Unlock()
+	if err := json.Unmarshal(data, &wiki.logins.byToken); err != nil {
+		return err
+	}
+	wiki.sweepLogins()
+	return nil
+}
+
+// sweepLogins forgets the expired logins. It must be called with logins held.
+func (wiki *Wiki) sweepLogins() {
+	now := clock.Now()
+	for key, current := range wiki.logins.byToken {
+		if now.After(current.Expires) {
+			delete(wiki.logins.byToken, key)
+		}
+	}
+}
+
+// storeLogins must be called with logins held.
+func (wiki *Wiki) storeLogins() {
+	data, err := json.MarshalIndent(wiki.logins.byToken, "", "  ")
+	if err == nil {
+		err = os.WriteFile(wiki.dataPath("logins.json"), data, 0600)
+	}
+	if err != nil {
+		log.Printf("could not store the logins: %v", err)
+	}
+}
+
+// currentUser returns the name of the logged in user, or "".
+func (wiki *Wiki) currentUser(r *http.Request) string {
+	cookie, err := r.Cookie(authCookie)
+	if err != nil {
+		return ""
+	}
+	wiki.logins.Lock()
+	defer wiki.logins.Unlock()
+	key := loginKey(cookie.Value)
+	current, ok := wiki.logins.byToken[key]
+	if !ok {
+		return ""
+	}
+	if clock.Now().After(current.Expires) {
+		delete(wiki.logins.byToken, key)
+		return ""
+	}
+	return current.User
+}
+
+// isSecure tells whether the client reached the wiki over https, directly or
+// through a proxy, so cookies can be marked Secure.
+func isSecure(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// LoginPage is the data of the login template.
+type LoginPage struct {
+	Next      string
+	Error     string
+	Providers []OAuthLink
+}
+
+// localRedirect returns next if it's a path on this site, "/" otherwise.
+func localRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// logIn logs the user in, with a new auth cookie, and audits it.
+func (wiki *Wiki) logIn(w http.ResponseWriter, r *http.Request, name string) {
+	wiki.auditLogin(r, name, true)
+	value := ids.Token(32)
+	wiki.logins.Lock()
+	wiki.sweepLogins()
+	wiki.logins.byToken[loginKey(value)] = login{User: name, Expires: clock.Now().Add(loginTTL)}
+	wiki.storeLogins()
+	wiki.logins.Unlock()
+	http.SetCookie(w, &http.Cookie{Name: authCookie, Value: value, Path: "/", MaxAge: int(loginTTL.Seconds()),
+		HttpOnly: true, Secure: isSecure(r), SameSite: http.SameSiteLaxMode})
+}
+
+// loginHandler serves the /login form and logs the user in on POST.
+func (wiki *Wiki) loginHandler(w http.ResponseWriter, r *http.Request) {
+	page := LoginPage{Next: localRedirect(r.FormValue("next")), Providers: wiki.oauthLinks()}
+	if r.Method == http.MethodPost {
+		name := r.FormValue("name")
+		if wiki.checkPassword(name, r.FormValue("password")) {
+			wiki.logIn(w, r, name)
+			http.Redirect(w, r, page.Next, http.StatusFound)
+			return
+		}
+		wiki.auditLogin(r, name, false)
+		w.WriteHeader(http.StatusUnauthorized)
+		page.Error = translate(wiki.requestLocale(w), "login.wrong")
+	}
+	wiki.renderTemplate(w, "login.html", page)
+}
+
+// logoutHandler serves POST /logout.
+func (wiki *Wiki) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if cookie, err := r.Cookie(authCookie); err == nil {
+		wiki.logins.Lock()
+		delete(wiki.logins.byToken, loginKey(cookie.Value))
+		wiki.storeLogins()
+		wiki.logins.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: authCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true, Secure: isSecure(r)})
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// runUser implements "gowiki user add <name>", which creates a user or resets
+// their password, read from the first line of standard input, and "gowiki user
+// role <name> <role>", which gives them the role.
+func (wiki *Wiki) runUser(args []string) error {
+	if len(args) == 3 && args[0] == "role" {
+		assigned, err := parseRole(args[2])
+		if err != nil {
+			return err
+		}
+		if err := wiki.setRole(args[1], assigned); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%s is %s now\n", args[1], assigned)
+		return nil
+	}
+	if len(args) != 2 || args[0] != "add" {
+		return fmt.Errorf("usage: gowiki user add <name>, or gowiki user role <name> reader|editor|admin")
+	}
+	fmt.Fprintf(os.Stderr, "password for %s: ", args[1])
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		return err
+	}
+	if err := wiki.setPassword(args[1], strings.TrimRight(password, "\r\n")); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "\nsaved user %s\n", args[1])
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSessionCookieOverHTTPS(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("X-Forwarded-Proto", "https")
+	response := httptest.NewRecorder()
+	sessionID(response, request)
+	cookies := response.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessionCookie || !cookies[0].Secure {
+		t.Errorf("the session cookie over https is %v, want it secure", cookies)
+	}
+}
+
+func TestLoginsAreRateLimited(t *testing.T) {
+	rate, burst := testWiki.config.WriteRate, testWiki.config.WriteBurst
+	testWiki.config.WriteRate, testWiki.config.WriteBurst = 1, 2
+	defer func() { testWiki.config.WriteRate, testWiki.config.WriteBurst = rate, burst }()
+	router := testWiki.newRouter(context.Background())
+	var codes []int
+	for range 3 {
+		form := url.Values{"name": {"nobody"}, "password": {"guess"}}
+		request := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.RemoteAddr = "198.51.100.7:1234"
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		codes = append(codes, response.Code)
+	}
+	if codes[0] == http.StatusTooManyRequests || codes[1] == http.StatusTooManyRequests || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("three logins within the burst of two were answered %v, want the third 429", codes)
+	}
+}
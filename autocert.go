@@ -0,0 +1,28 @@
+//go:build autocert
+
+// The autocert mode gets the wiki's certificates from Let's Encrypt, for the
+// domains of -autocert, and renews them before they expire. It is only built
+// with -tags autocert, after fetching golang.org/x/crypto/acme/autocert, so the
+// default build keeps no dependencies.
+
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func init() {
+	newAutocert = newAutocertManager
+}
+
+func newAutocertManager(domains []string, dir string) (*tls.Config, func(fallback http.Handler) http.Handler) {
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(dir),
+	}
+	return manager.TLSConfig(), manager.HTTPHandler
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupLayout dates the archives of the backups, gowiki-{time}.tar.gz, so that
+// their names sort by when they were made.
+const backupLayout = "20060102-150405"
+
+// snapshottingStore is a store that keeps a file of the data directory open,
+// which a backup can't just copy while it's written: the store hands it a
+// consistent copy of the file instead, named as it is in the data directory.
+type snapshottingStore interface {
+	Snapshot(write func(name string, size int64, content io.WriterTo) error) error
+}
+
+// snapshotStore hands the write the snapshot of the store's open file, if it
+// keeps one.
+func snapshotStore(s PageStore, write func(name string, size int64, content io.WriterTo) error) error {
+	if snapshotting, ok := s.(snapshottingStore); ok {
+		return snapshotting.Snapshot(write)
+	}
+	return nil
+}
+
+// Backup is an archive of the data directory in -backup-dir.
+type Backup struct {
+	Name string
+	Time time.Time
+	Size int64
+}
+
+// listBackups returns the archives in -backup-dir, the last made first.
+func (wiki *Wiki) listBackups() ([]Backup, error) {
+	entries, err := os.ReadDir(wiki.config.BackupDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var all []Backup
+	for _, entry := range entries {
+		stamp, ok := strings.CutPrefix(entry.Name(), "gowiki-")
+		if stamp, ok = strings.CutSuffix(stamp, ".tar.gz"); !ok || entry.IsDir() {
+			continue
+		}
+		made, err := time.Parse(backupLayout, stamp)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		all = append(all, Backup{Name: entry.Name(), Time: made, Size: info.Size()})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Time.After(all[j].Time) })
+	return all, nil
+}
+
+// backUp archives the data directory as a new gowiki-{time}.tar.gz in
+// -backup-dir, and prunes the old ones. The archive is written aside and renamed
+// into place, so a backup that's listed is whole.
+func (wiki *Wiki) backUp() (Backup, error) {
+	wiki.backups.Lock()
+	if wiki.backups.running {
+		wiki.backups.Unlock()
+		return Backup{}, errors.New("a backup is being made already")
+	}
+	wiki.backups.running = true
+	wiki.backups.Unlock()
+	backup, err := wiki.writeBackup()
+	wiki.backups.Lock()
+	wiki.backups.running, wiki.backups.lastErr = false, err
+	if err == nil {
+		wiki.backups.last = backup.Time
+	}
+	wiki.backups.Unlock()
+	if err != nil {
+		return backup, err
+	}
+	return backup, wiki.pruneBackups()
+}
+
+func (wiki *Wiki) writeBackup() (Backup, error) {
+	if err := os.MkdirAll(wiki.config.BackupDir, 0700); err != nil {
+		return Backup{}, err
+	}
+	made := clock.Now().UTC().Truncate(time.Second)
+	backup := Backup{Name: "gowiki-" + made.Format(backupLayout) + ".tar.gz", Time: made}
+	filename := filepath.Join(wiki.config.BackupDir, backup.Name)
+	if _, err := os.Stat(filename); err == nil {
+		return backup, fmt.Errorf("there's a backup of %s already", made.Format(time.RFC3339))
+	}
+	file, err := os.CreateTemp(wiki.config.BackupDir, ".backup-*")
+	if err != nil {
+		return backup, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	compressed := gzip.NewWriter(file)
+	archive := tar.NewWriter(compressed)
+	if err := wiki.archiveDataDir(archive); err != nil {
+		return backup, err
+	}
+	if err := archive.Close(); err != nil {
+		return backup, err
+	}
+	if err := compressed.Close(); err != nil {
+		return backup, err
+	}
+	if err := file.Sync(); err != nil {
+		return backup, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return backup, err
+	}
+	backup.Size = info.Size()
+	if err := file.Close(); err != nil {
+		return backup, err
+	}
+	return backup, os.Rename(file.Name(), filename)
+}
+
+// archiveDataDir writes every file of the data directory to the archive, but
+// the backups themselves when they're kept in it, and the store's open file
+// from its snapshot. A file gone since the directory was read is left out.
+func (wiki *Wiki) archiveDataDir(archive *tar.Writer) error {
+	skip, _ := filepath.Abs(wiki.config.BackupDir)
+	snapshotted := make(map[string]bool)
+	err := snapshotStore(wiki.store, func(name string, size int64, content io.WriterTo) error {
+		snapshotted[name] = true
+		if err := archive.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: size, ModTime: clock.Now()}); err != nil {
+			return err
+		}
+		_, err := content.WriteTo(archive)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return filepath.WalkDir(wiki.config.DataDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if abs, _ := filepath.Abs(path); abs == skip {
+			return filepath.SkipDir
+		}
+		name, err := filepath.Rel(wiki.config.DataDir, path)
+		if err != nil || name == "." || snapshotted[filepath.ToSlash(name)] {
+			return err
+		}
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || !info.Mode().IsRegular() && !info.IsDir() {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if info.IsDir() {
+			header.Name += "/"
+			return archive.WriteHeader(header)
+		}
+		file, err := os.Open(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		// the header says how long the file was when it was listed; a file
+		// written since is archived as long as that
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		copied, err := io.Copy(archive, io.LimitReader(file, header.Size))
+		if err != nil {
+			return err
+		}
+		if copied < header.Size {
+			_, err = archive.Write(make([]byte, header.Size-copied))
+		}
+		return err
+	})
+}
+
+// pruneBackups removes the backups past the -backup-keep made last and those
+// older than -backup-max-age; 0 leaves them be.
+func (wiki *Wiki) pruneBackups() error {
+	all, err := wiki.listBackups()
+	if err != nil {
+		return err
+	}
+	now := clock.Now()
+	for i, backup := range all {
+		if wiki.config.BackupKeep > 0 && i >= wiki.config.BackupKeep || wiki.config.BackupMaxAge > 0 && now.Sub(backup.Time) > wiki.config.BackupMaxAge {
+			if err := os.Remove(filepath.Join(wiki.config.BackupDir, backup.Name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lastBackup is when the last backup was made: this run, or else the newest
+// archive in -backup-dir; the zero time if there's none.
+func (wiki *Wiki) lastBackup() time.Time {
+	wiki.backups.Lock()
+	last := wiki.backups.last
+	wiki.backups.Unlock()
+	if !last.IsZero() {
+		return last
+	}
+	if all, err := wiki.listBackups(); err == nil && len(all) > 0 {
+		return all[0].Time
+	}
+	return time.Time{}
+}
+
+// runBackups backs the data directory up every -backup-interval until ctx is
+// done, the first time once the interval is up since the last backup, which
+// may be at once. A -backup-interval of 0 makes none.
+func (wiki *Wiki) runBackups(ctx context.Context) {
+	if wiki.config.BackupInterval <= 0 {
+		return
+	}
+	wait := wiki.config.BackupInterval - clock.Now().Sub(wiki.lastBackup())
+	for {
+		timer := time.NewTimer(max(wait, 0))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if backup, err := wiki.backUp(); err != nil {
+			log.Printf("could not back up the data directory: %v", err)
+		} else {
+			log.Printf("backed up the data directory to %s, %d bytes", backup.Name, backup.Size)
+		}
+		wait = wiki.config.BackupInterval
+	}
+}
+
+// BackupStatus is the data of the backups template.
+type BackupStatus struct {
+	Dir      string
+	Interval time.Duration
+	Keep     int
+	MaxAge   time.Duration
+	Last     time.Time
+	Next     time.Time
+	Error    string
+	Running  bool
+	Backups  []Backup
+}
+
+// backupsHandler serves /admin/backups to admins: when the last backup was made,
+// when the next one is due and the archives kept, and POST to back up at once.
+func (wiki *Wiki) backupsHandler(w http.ResponseWriter, r *http.Request) {
+	if !wiki.isAdmin(r) {
+		wiki.serveError(w, forbidden("only admins can see the backups"))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost:
+		if _, err := wiki.backUp(); err != nil {
+			wiki.serveError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/admin/backups", http.StatusSeeOther)
+		return
+	default:
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	all, err := wiki.listBackups()
+	if err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	status := BackupStatus{Dir: wiki.config.BackupDir, Interval: wiki.config.BackupInterval, Keep: wiki.config.BackupKeep, MaxAge: wiki.config.BackupMaxAge, Last: wiki.lastBackup(), Backups: all}
+	wiki.backups.Lock()
+	if wiki.backups.lastErr != nil {
+		status.Error = wiki.backups.lastErr.Error()
+	}
+	status.Running = wiki.backups.running
+	wiki.backups.Unlock()
+	if status.Interval > 0 {
+		status.Next = clock.Now()
+		if !status.Last.IsZero() && status.Last.Add(status.Interval).After(status.Next) {
+			status.Next = status.Last.Add(status.Interval)
+		}
+	}
+	wiki.renderTemplate(w, "backups.html", status)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Banner is a site-wide announcement shown at the top of every page between Start
+// and End. It shows either Message or the summary of the pinned Page, linked.
+type Banner struct {
+	Message string    `json:"message,omitempty"`
+	Page    string    `json:"page,omitempty"`
+	Start   time.Time `json:"start,omitempty"`
+	End     time.Time `json:"end,omitempty"`
+}
+
+// bannerView is what the "banner" template renders.
+type bannerView struct {
+	Text string
+	Page string
+}
+
+func (wiki *Wiki) bannerFilename() string {
+	return wiki.dataPath("banner.json")
+}
+
+// loadBanner restores the banner saved by the banner API, if any.
+func (wiki *Wiki) loadBanner() error {
+	data, err := os.ReadFile(wiki.bannerFilename())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved Banner
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	wiki.banner = &saved
+	return nil
+}
+
+func (b *Banner) isActive(now time.Time) bool {
+	return (b.Start.IsZero() || !now.Before(b.Start)) && (b.End.IsZero() || now.Before(b.End))
+}
+
+// currentBanner is the "banner" template function: the banner to show right now,
+// or nil.
+func (wiki *Wiki) currentBanner() *bannerView {
+	wiki.bannerMu.Lock()
+	current := wiki.banner
+	wiki.bannerMu.Unlock()
+	if current == nil || !current.isActive(clock.Now()) {
+		return nil
+	}
+	view := &bannerView{Text: current.Message, Page: current.Page}
+	if current.Page != "" && view.Text == "" {
+		page, err := wiki.load(current.Page)
+		if err != nil {
+			return nil
+		}
+		view.Text = summarize(page).Extract
+	}
+	return view
+}
+
+// newTemplateFuncs returns the functions the templates of the wiki call.
+func (wiki *Wiki) newTemplateFuncs() template.FuncMap {
+	return template.FuncMap{"banner": wiki.currentBanner, "theme": wiki.themeOf, "isSandbox": isSandbox, "displayTitle": wiki.displayTitle, "breadcrumbs": breadcrumbs, "readOnly": wiki.isReadOnly, "highlightCSS": highlightCSS,
+		"currentTheme": func() string { return wiki.config.Theme }, "basePath": func() string { return wiki.config.BasePath }, "themes": themeNames, "canExportPDF": wiki.canExportPDF, "lockRenewal": lockRenewal,
+		"currentLocale": func() string { return wiki.config.Locale }, "locales": localeChoices, "t": func(key string, args ...interface{}) string { return translate(wiki.config.Locale, key, args...) }}
+}
+
+// bannerHandler serves the banner API at /api/v1/banner: GET returns the configured
+// banner, and admins PUT a new one as JSON or DELETE it.
+func (wiki *Wiki) bannerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && !wiki.isAdmin(r) {
+		wiki.serveError(w, forbidden("only admins can change the banner"))
+		return
+	}
+	wiki.bannerMu.Lock()
+	defer wiki.bannerMu.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var updated Banner
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&updated); err != nil {
+			http.Error(w, translate(wiki.requestLocale(w), "error.invalidJSON", err), http.StatusBadRequest)
+			return
+		}
+		if (updated.Message == "") == (updated.Page == "") {
+			http.Error(w, translate(wiki.requestLocale(w), "error.bannerMessageOrPage"), http.StatusBadRequest)
+			return
+		}
+		if updated.Page != "" && !validTitle.MatchString(updated.Page) {
+			http.Error(w, translate(wiki.requestLocale(w), "error.invalidTitle"), http.StatusBadRequest)
+			return
+		}
+		if !updated.End.IsZero() && updated.End.Before(updated.Start) {
+			http.Error(w, translate(wiki.requestLocale(w), "error.bannerEnd"), http.StatusBadRequest)
+			return
+		}
+		data, _ := json.Marshal(updated)
+		if err := os.WriteFile(wiki.bannerFilename(), data, 0600); err != nil {
+			wiki.serveError(w, err)
+			return
+		}
+		wiki.banner = &updated
+	case http.MethodDelete:
+		if err := os.Remove(wiki.bannerFilename()); err != nil && !os.IsNotExist(err) {
+			wiki.serveError(w, err)
+			return
+		}
+		wiki.banner = nil
+	default:
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]*Banner{"banner": wiki.banner})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// checkBasePath checks -base-path, a path from the root of the site without a
+// slash at the end, and compiles the patterns of the links the wiki renders,
+// which start with it.
+func (wiki *Wiki) checkBasePath() error {
+	base := strings.TrimSuffix(wiki.config.BasePath, "/")
+	if base != "" && (!strings.HasPrefix(base, "/") || strings.HasPrefix(base, "//") || strings.ContainsAny(base, "\"'<>?#\\ ")) {
+		return fmt.Errorf("-base-path %q is no path like /w/docs", wiki.config.BasePath)
+	}
+	wiki.config.BasePath = base
+	quoted := regexp.QuoteMeta(base)
+	wiki.viewLinkPattern = regexp.MustCompile(`<a href="` + quoted + `/view/([\p{L}\p{M}\p{N}/~-]+)(#[^"]*)?"(?:\s[^>]*)?>(.*?)</a>`)
+	wiki.absoluteLink = regexp.MustCompile(`<a href="` + quoted + `(/[^"]*)"([^>]*)>(.*?)</a>|src="` + quoted + `(/files/[^"]*)"`)
+	return nil
+}
+
+// wikiPath returns the path from the root of the site of the wiki's path, under
+// -base-path.
+func (wiki *Wiki) wikiPath(path string) string {
+	return wiki.config.BasePath + path
+}
+
+// underBasePath points a link of a page to a path from the root of the wiki under
+// -base-path; the links to other sites, and relative ones, are left as they are.
+func (wiki *Wiki) underBasePath(link string) string {
+	if strings.HasPrefix(link, "/") && !strings.HasPrefix(link, "//") && !strings.HasPrefix(link, `/\`) {
+		return wiki.config.BasePath + link
+	}
+	return link
+}
+
+// withBasePath serves the wiki under -base-path: a request under it is served as
+// if the part of its path after it were all of it, as from a proxy stripping it,
+// and the redirects to the wiki's paths point under it. The requests for paths
+// that aren't under it are served as they are.
+func (wiki *Wiki) withBasePath(next http.Handler) http.Handler {
+	if wiki.config.BasePath == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rest, ok := strings.CutPrefix(r.URL.Path, wiki.config.BasePath); ok && (rest == "" || rest[0] == '/') {
+			stripped := r.Clone(r.Context())
+			stripped.URL.Path, stripped.URL.RawPath = "/"+strings.TrimPrefix(rest, "/"), ""
+			r = stripped
+		}
+		next.ServeHTTP(&basePathWriter{wiki: wiki, ResponseWriter: w}, r)
+	})
+}
+
+// basePathWriter points the Location of a redirect to a path of the wiki under
+// -base-path as the header is written.
+type basePathWriter struct {
+	wiki *Wiki
+	http.ResponseWriter
+}
+
+func (bw *basePathWriter) WriteHeader(status int) {
+	if location := bw.Header().Get("Location"); location != "" && !strings.HasPrefix(location, bw.wiki.config.BasePath+"/") {
+		bw.Header().Set("Location", bw.wiki.underBasePath(location))
+	}
+	bw.ResponseWriter.WriteHeader(status)
+}
+
+func (bw *basePathWriter) Flush() {
+	if flusher, ok := bw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (bw *basePathWriter) Unwrap() http.ResponseWriter {
+	return bw.ResponseWriter
+}
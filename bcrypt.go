@@ -0,0 +1,23 @@
+//go:build bcrypt
+
+// With -tags bcrypt, after fetching golang.org/x/crypto/bcrypt, the passwords of
+// the users are hashed with bcrypt, and those hashed with PBKDF2-SHA256 before
+// are hashed again as their users log in. The default build keeps no
+// dependencies.
+
+package main
+
+import "golang.org/x/crypto/bcrypt"
+
+// bcryptCost is the work factor of the bcrypt hashes.
+const bcryptCost = 12
+
+func init() {
+	bcryptHash = func(password string) (string, error) {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+		return string(hash), err
+	}
+	bcryptCompare = func(hash, password string) error {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// benchmarkCorpus is the generated corpus of the benchmarks, like the one the
+// profile-render command renders.
+var benchmarkCorpus = generateCorpus(200, 1, "Page")
+
+// withRegistry runs the benchmark with a title registry of size titles, those of
+// the corpus first.
+func withRegistry(b *testing.B, size int, bench func(b *testing.B)) {
+	previous := testWiki.titles
+	testWiki.titles = testWiki.registryOf(benchmarkCorpus, size)
+	defer func() { testWiki.titles = previous }()
+	bench(b)
+}
+
+// BenchmarkAutoLink times rendering the bodies of the corpus, the auto-linker
+// linking the mentions of the titles among them, with registries of every size.
+func BenchmarkAutoLink(b *testing.B) {
+	for _, size := range []int{100, 10000} {
+		b.Run("titles="+strconv.Itoa(size), func(b *testing.B) {
+			withRegistry(b, size, func(b *testing.B) {
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					page := benchmarkCorpus[i%len(benchmarkCorpus)]
+					testWiki.rendererFor(page.Title, false).Render(page.Body)
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkRenderView times rendering the view page of the pages of the corpus.
+func BenchmarkRenderView(b *testing.B) {
+	withRegistry(b, len(benchmarkCorpus), func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			page := benchmarkCorpus[i%len(benchmarkCorpus)]
+			response := &discardResponse{header: make(http.Header), status: http.StatusOK}
+			testWiki.renderViewTemplate(response, testWiki.everyone, "view.html", page, nil, false, false)
+			if response.status != http.StatusOK {
+				b.Fatalf("could not render %s, status %d", page.Title, response.status)
+			}
+		}
+	})
+}
+
+func BenchmarkStoreSave(b *testing.B) {
+	benchStore(b, func(pages PageStore, i int) error {
+		return pages.Save(benchmarkCorpus[i%len(benchmarkCorpus)])
+	})
+}
+
+func BenchmarkStoreLoad(b *testing.B) {
+	benchStore(b, func(pages PageStore, i int) error {
+		_, err := pages.Load(benchmarkCorpus[i%len(benchmarkCorpus)].Title)
+		return err
+	})
+}
+
+// benchStore times op on a store of -store in a directory of its own, with the
+// pages of the corpus saved in it beforehand.
+func benchStore(b *testing.B, op func(pages PageStore, i int) error) {
+	pages, err := openStore(testWiki.config.Store, b.TempDir())
+	if err != nil {
+		b.Fatal(err)
+	}
+	if closer, ok := pages.(interface{ Close() error }); ok {
+		defer closer.Close()
+	}
+	for _, page := range benchmarkCorpus {
+		if err := pages.Save(page); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := op(pages, i); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+//go:build bolt
+
+// The bolt backend keeps every page in a single BoltDB file. It is only built
+// with -tags bolt, after fetching go.etcd.io/bbolt, so the default build keeps no
+// dependencies.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func init() {
+	storeBackends["bolt"] = newBoltStore
+}
+
+var pagesBucket = []byte("pages")
+
+type boltStore struct {
+	db *bolt.DB
+}
+
+// newBoltStore keeps the pages in wiki.db in the data directory.
+func newBoltStore(dir string) (PageStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(filepath.Join(dir, "wiki.db"), 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(pagesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) Load(title string) (*Page, error) {
+	var body []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		stored := tx.Bucket(pagesBucket).Get([]byte(title))
+		if stored == nil {
+			return fmt.Errorf("page %s: %w", title, os.ErrNotExist)
+		}
+		// stored is only valid during the transaction.
+		body = append([]byte(nil), stored...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Page{Title: title, Body: body}, nil
+}
+
+func (s *boltStore) Save(p *Page) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(pagesBucket).Put([]byte(p.Title), p.Body)
+	})
+}
+
+func (s *boltStore) List() ([]string, error) {
+	var titles []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(pagesBucket).ForEach(func(title, _ []byte) error {
+			titles = append(titles, string(title))
+			return nil
+		})
+	})
+	return titles, err
+}
+
+func (s *boltStore) Delete(title string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(pagesBucket)
+		if bucket.Get([]byte(title)) == nil {
+			return fmt.Errorf("page %s: %w", title, os.ErrNotExist)
+		}
+		return bucket.Delete([]byte(title))
+	})
+}
+
+// Close releases the database once the server has finished with it.
+// Ping checks that the database answers, and still has the bucket of the pages.
+func (s *boltStore) Ping() error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(pagesBucket) == nil {
+			return fmt.Errorf("wiki.db has no %s bucket", pagesBucket)
+		}
+		return nil
+	})
+}
+
+// Snapshot hands a backup wiki.db as one transaction sees it, which the writes
+// since don't change.
+func (s *boltStore) Snapshot(write func(name string, size int64, content io.WriterTo) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return write("wiki.db", tx.Size(), tx)
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	bundleFormat  = "gowiki-bundle"
+	bundleVersion = 1
+	manifestName  = "manifest.json"
+)
+
+// A bundle is a whole wiki in one .tar.gz: its manifest first, then the pages
+// under pages/, their metadata under meta/ and their revisions under history/,
+// the attachments as the manifests of attachments/pages/, attachments/blobs.json
+// and attachments/blobs/, and the users, settings, saved searches and the
+// journal. The same wiki makes the same bundle: the entries are sorted and carry
+// no times. The bundle command writes and reads them, as do the admins at /export
+// and /import.
+//
+// BundleManifest describes a bundle: its format and version, and the SHA-256 of
+// every other entry, which import checks before it writes anything.
+type BundleManifest struct {
+	Format  string            `json:"format"`
+	Version int               `json:"version"`
+	Pages   int               `json:"pages"`
+	Files   map[string]string `json:"files"`
+}
+
+// bundledState are the files of the data directory a bundle carries besides the
+// pages, their metadata, history and attachments.
+var bundledState = []string{"users.json", "settings.json", "savedSearches.json", "journal.jsonl"}
+
+// bundleEntry is an entry of a bundle, with how to read its content.
+type bundleEntry struct {
+	name string
+	read func() ([]byte, error)
+}
+
+func fileEntry(name, filename string) bundleEntry {
+	return bundleEntry{name, func() ([]byte, error) { return os.ReadFile(filename) }}
+}
+
+// bundleEntries lists what goes into the bundle of the wiki, sorted by name.
+func (wiki *Wiki) bundleEntries() ([]bundleEntry, int, error) {
+	var entries []bundleEntry
+	pages := 0
+	for _, title := range wiki.titles.List() {
+		title := title
+		entries = append(entries, bundleEntry{"pages/" + title + ".txt", func() ([]byte, error) {
+			page, err := wiki.peek(title)
+			if err != nil {
+				return nil, err
+			}
+			return page.Body, nil
+		}})
+		pages++
+	}
+	for _, dir := range []string{"meta", "history", "attachments"} {
+		err := filepath.WalkDir(wiki.dataPath(dir), func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				return err
+			}
+			name, err := filepath.Rel(wiki.config.DataDir, path)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, fileEntry(filepath.ToSlash(name), path))
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return nil, 0, err
+		}
+	}
+	for _, name := range bundledState {
+		if _, err := os.Stat(wiki.dataPath(name)); err == nil {
+			entries = append(entries, fileEntry(name, wiki.dataPath(name)))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+	return entries, pages, nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// exportBundle writes the bundle of the wiki to w.
+func (wiki *Wiki) exportBundle(w io.Writer) (BundleManifest, error) {
+	entries, pages, err := wiki.bundleEntries()
+	if err != nil {
+		return BundleManifest{}, err
+	}
+	manifest := BundleManifest{Format: bundleFormat, Version: bundleVersion, Pages: pages, Files: make(map[string]string)}
+	for _, entry := range entries {
+		data, err := entry.read()
+		if err != nil {
+			return BundleManifest{}, fmt.Errorf("could not read %s: %w", entry.name, err)
+		}
+		manifest.Files[entry.name] = sha256Hex(data)
+	}
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return BundleManifest{}, err
+	}
+	compressed := gzip.NewWriter(w)
+	archive := tar.NewWriter(compressed)
+	write := func(name string, data []byte) error {
+		if err := archive.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: time.Unix(0, 0)}); err != nil {
+			return err
+		}
+		_, err := archive.Write(data)
+		return err
+	}
+	if err := write(manifestName, manifestData); err != nil {
+		return BundleManifest{}, err
+	}
+	for _, entry := range entries {
+		data, err := entry.read()
+		if err != nil {
+			return BundleManifest{}, fmt.Errorf("could not read %s: %w", entry.name, err)
+		}
+		if sha256Hex(data) != manifest.Files[entry.name] {
+			return BundleManifest{}, fmt.Errorf("%s changed while the bundle was written, export it again", entry.name)
+		}
+		if err := write(entry.name, data); err != nil {
+			return BundleManifest{}, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return BundleManifest{}, err
+	}
+	return manifest, compressed.Close()
+}
+
+// readBundle reads the bundle in filename, checking its manifest, and calls entry
+// with every entry after it, once it's checked against its checksum. The bundle
+// fails once an entry of the manifest turns out to be missing.
+func readBundle(filename string, entry func(name string, data []byte) error) (BundleManifest, error) {
+	var manifest BundleManifest
+	file, err := os.Open(filename)
+	if err != nil {
+		return manifest, err
+	}
+	defer file.Close()
+	compressed, err := gzip.NewReader(file)
+	if err != nil {
+		return manifest, fmt.Errorf("%s is not a bundle: %w", filename, err)
+	}
+	archive := tar.NewReader(compressed)
+	seen := make(map[string]bool)
+	for first := true; ; first = false {
+		header, err := archive.Next()
+		if err == io.EOF {
+			if first {
+				return manifest, fmt.Errorf("%s is empty", filename)
+			}
+			for name := range manifest.Files {
+				if !seen[name] {
+					return manifest, fmt.Errorf("the bundle is missing %s", name)
+				}
+			}
+			return manifest, nil
+		}
+		if err != nil {
+			return manifest, fmt.Errorf("%s is not a bundle: %w", filename, err)
+		}
+		if header.Typeflag == tar.TypeDir {
+			continue // an archive repacked by hand may have its directories
+		}
+		data, err := io.ReadAll(archive)
+		if err != nil {
+			return manifest, fmt.Errorf("%s is not a bundle: %w", filename, err)
+		}
+		if !first {
+			sum, listed := manifest.Files[header.Name]
+			switch {
+			case !listed:
+				return manifest, fmt.Errorf("%s isn't in the manifest", header.Name)
+			case seen[header.Name]:
+				return manifest, fmt.Errorf("the bundle has %s twice", header.Name)
+			case sha256Hex(data) != sum:
+				return manifest, fmt.Errorf("%s is corrupt, its checksum doesn't match the manifest", header.Name)
+			}
+			seen[header.Name] = true
+			if err := entry(header.Name, data); err != nil {
+				return manifest, err
+			}
+			continue
+		}
+		if header.Name != manifestName {
+			return manifest, fmt.Errorf("%s is not a bundle, it doesn't start with a manifest", filename)
+		}
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return manifest, fmt.Errorf("the manifest of %s can't be read: %w", filename, err)
+		}
+		if manifest.Format != bundleFormat {
+			return manifest, fmt.Errorf("%s is not a bundle of a wiki", filename)
+		}
+		if manifest.Version < 1 || manifest.Version > bundleVersion {
+			return manifest, fmt.Errorf("%s is a bundle of version %d, this wiki reads versions up to %d", filename, manifest.Version, bundleVersion)
+		}
+	}
+}
+
+// bundleTarget returns where an entry of a bundle goes: the title of a page, or
+// the file of the data directory. Only the entries a bundle is made of are
+// accepted, none of them can leave the data directory.
+func (wiki *Wiki) bundleTarget(name string) (string, string, error) {
+	if title, ok := strings.CutPrefix(name, "pages/"); ok {
+		if title, ok = strings.CutSuffix(title, ".txt"); ok && validTitle.MatchString(title) && !isReserved(title) {
+			return title, "", nil
+		}
+		return "", "", fmt.Errorf("the bundle has a page %s, which isn't a title", name)
+	}
+	for _, state := range bundledState {
+		if name == state {
+			return "", wiki.dataPath(name), nil
+		}
+	}
+	top, rest, _ := strings.Cut(name, "/")
+	if (top == "meta" || top == "history" || top == "attachments") && rest != "" && path.Clean(name) == name && !strings.Contains(name, "..") {
+		return "", wiki.dataPath(filepath.FromSlash(name)), nil
+	}
+	return "", "", fmt.Errorf("the bundle has an entry %s that isn't part of a wiki", name)
+}
+
+// How an import treats a wiki that has pages already: importEmpty refuses it,
+// importMerge overwrites the pages the bundle has too and keeps the others, and
+// importReplace makes the pages, their metadata, history and attachments those
+// of the bundle, deleting the rest.
+const (
+	importEmpty   = ""
+	importMerge   = "merge"
+	importReplace = "replace"
+)
+
+// importBundle writes the wiki of the bundle into the store and the data
+// directory, once every entry checked out.
+func (wiki *Wiki) importBundle(filename, mode string) (BundleManifest, error) {
+	switch mode {
+	case importEmpty:
+		if len(wiki.titles.List()) > 0 {
+			return BundleManifest{}, errors.New("the wiki has pages already, import into an empty one, or merge or replace its pages")
+		}
+	case importMerge, importReplace:
+	default:
+		return BundleManifest{}, fmt.Errorf("a bundle is imported into an empty wiki, merged or replacing it, not %q", mode)
+	}
+	// the first reading checks the whole bundle, the second writes it
+	bundled := make(map[string]bool)
+	manifest, err := readBundle(filename, func(name string, data []byte) error {
+		title, _, err := wiki.bundleTarget(name)
+		if title != "" {
+			bundled[title] = true
+		}
+		return err
+	})
+	if err != nil {
+		return manifest, err
+	}
+	if mode == importReplace {
+		for _, title := range wiki.titles.List() {
+			if bundled[title] {
+				continue
+			}
+			if err := wiki.store.Delete(title); err != nil {
+				return manifest, err
+			}
+			wiki.titles.Remove(title)
+			wiki.afterDelete(title, "")
+		}
+		for _, dir := range []string{"meta", "history", "attachments"} {
+			if err := os.RemoveAll(wiki.dataPath(dir)); err != nil {
+				return manifest, err
+			}
+		}
+	}
+	_, err = readBundle(filename, func(name string, data []byte) error {
+		title, filename, _ := wiki.bundleTarget(name)
+		if title != "" {
+			page := &Page{Title: title, Body: data}
+			if err := wiki.storePage(page, "", ""); err != nil {
+				return err
+			}
+			if !wiki.titles.Has(title) {
+				wiki.titles.Add(title)
+				wiki.backlinks.relink(title)
+			}
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+			return err
+		}
+		return os.WriteFile(filename, data, 0600)
+	})
+	return manifest, err
+}
+
+// reloadImported reads the files an import wrote to the data directory again, for
+// the wiki serving it: the users, attachments, metadata and saved searches. The
+// settings are only read at startup, a restart applies those of the bundle.
+func (wiki *Wiki) reloadImported() error {
+	wiki.usersMu.Lock()
+	wiki.users = map[string]User{}
+	err := wiki.loadUsers()
+	wiki.usersMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("could not read the users: %w", err)
+	}
+	wiki.attachments.Lock()
+	wiki.attachments.Pages, wiki.attachments.Blobs = make(map[string]map[string]string), make(map[string]*blob)
+	err = wiki.loadAttachments()
+	wiki.attachments.Unlock()
+	if err != nil {
+		return fmt.Errorf("could not read the attachments: %w", err)
+	}
+	wiki.pageMetadata.Lock()
+	wiki.pageMetadata.byTitle, wiki.pageMetadata.byTag = make(map[string]PageMetadata), make(map[string]map[string]bool)
+	err = wiki.loadMetadata()
+	wiki.pageMetadata.Unlock()
+	if err != nil {
+		return fmt.Errorf("could not read the page metadata: %w", err)
+	}
+	wiki.savedSearchesMu.Lock()
+	defer wiki.savedSearchesMu.Unlock()
+	clear(wiki.savedSearches)
+	if err := wiki.loadSavedSearches(); err != nil {
+		return fmt.Errorf("could not read the saved searches: %w", err)
+	}
+	return nil
+}
+
+// bundleExportHandler serves GET /export to admins: the bundle of the whole wiki,
+// which holds the users' password hashes too. The form of /import posts to it,
+// with the admin token in its body.
+func (wiki *Wiki) bundleExportHandler(w http.ResponseWriter, r *http.Request) {
+	if !wiki.isAdmin(r) {
+		wiki.serveError(w, forbidden("only admins can export the wiki"))
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="wiki.bundle.tar.gz"`)
+	if _, err := wiki.exportBundle(w); err != nil {
+		// the bundle is cut short, which its reader notices
+		log.Printf("could not export the wiki: %v", err)
+	}
+}
+
+// ImportPage is the data of the import template.
+type ImportPage struct {
+	Mode     string
+	Manifest *BundleManifest
+	Error    string
+}
+
+// bundleImportHandler serves /import to admins: POST uploads a bundle as the
+// multipart file "bundle" and imports it with mode= merge or replace, or into an
+// empty wiki without one.
+func (wiki *Wiki) bundleImportHandler(w http.ResponseWriter, r *http.Request) {
+	if !wiki.isAdmin(r) {
+		wiki.serveError(w, forbidden("only admins can import into the wiki"))
+		return
+	}
+	var data ImportPage
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		data.Mode = r.FormValue("mode")
+		manifest, err := wiki.importUpload(r)
+		if err != nil {
+			data.Error = err.Error()
+		} else {
+			data.Manifest = &manifest
+		}
+	default:
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	wiki.renderTemplate(w, "import.html", data)
+}
+
+// importUpload imports the bundle uploaded with the request, which is read twice
+// and so kept in a temporary file meanwhile.
+func (wiki *Wiki) importUpload(r *http.Request) (BundleManifest, error) {
+	upload, _, err := r.FormFile("bundle")
+	if err != nil {
+		return BundleManifest{}, fmt.Errorf("upload the bundle as the multipart file \"bundle\": %w", err)
+	}
+	defer upload.Close()
+	file, err := os.CreateTemp("", "gowiki-import-*.tar.gz")
+	if err != nil {
+		return BundleManifest{}, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if _, err := io.Copy(file, upload); err != nil {
+		return BundleManifest{}, err
+	}
+	if err := file.Close(); err != nil {
+		return BundleManifest{}, err
+	}
+	manifest, err := wiki.importBundle(file.Name(), r.FormValue("mode"))
+	if err != nil {
+		return manifest, err
+	}
+	return manifest, wiki.reloadImported()
+}
+
+// runBundle is the bundle command: "bundle export" writes the whole wiki to one
+// file, "bundle import" reads it into this one, whatever its store.
+func (wiki *Wiki) runBundle(args []string) error {
+	if len(args) == 0 {
+		return errors.New("bundle export or bundle import?")
+	}
+	flags := flag.NewFlagSet("bundle "+args[0], flag.ContinueOnError)
+	switch args[0] {
+	case "export":
+		out := flags.String("out", "wiki.bundle.tar.gz", "the file to write; it holds the users' password hashes, keep it safe")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		manifest, err := wiki.exportBundle(file)
+		if err != nil {
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+		fmt.Printf("bundled %d pages in %d files to %s\n", manifest.Pages, len(manifest.Files), *out)
+		return nil
+	case "import":
+		in := flags.String("in", "wiki.bundle.tar.gz", "the bundle to read")
+		overwrite := flags.Bool("overwrite", false, "import into a wiki that has pages, overwriting those the bundle has too")
+		replace := flags.Bool("replace", false, "replace the pages of the wiki, their metadata, history and attachments with those of the bundle")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		mode := importEmpty
+		if *overwrite {
+			mode = importMerge
+		}
+		if *replace {
+			mode = importReplace
+		}
+		manifest, err := wiki.importBundle(*in, mode)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("imported %d pages in %d files from %s\n", manifest.Pages, len(manifest.Files), *in)
+		return nil
+	}
+	return fmt.Errorf("bundle export or bundle import, not bundle %s", args[0])
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"container/list"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// cachedStore keeps the pages read last in memory, so that viewing a page doesn't
+// read the store every time. It holds up to max pages and forgets the one read
+// least recently to make room. Saving or deleting a page through it, which a
+// rename does both of, forgets the page; a page changed in the store behind the
+// wiki's back is served as it was until then.
+type cachedStore struct {
+	PageStore
+	max int
+
+	mu      sync.Mutex
+	pages   map[string]*list.Element
+	recent  *list.List // of *Page, the one read last in front
+	version uint64     // counts the pages forgotten, see Load
+	hits    uint64
+	misses  uint64
+}
+
+func newCachedStore(store PageStore, max int) *cachedStore {
+	return &cachedStore{PageStore: store, max: max, pages: make(map[string]*list.Element), recent: list.New()}
+}
+
+func (s *cachedStore) Load(title string) (*Page, error) {
+	s.mu.Lock()
+	if element, ok := s.pages[title]; ok {
+		s.recent.MoveToFront(element)
+		s.hits++
+		page := copyPage(element.Value.(*Page))
+		s.mu.Unlock()
+		return page, nil
+	}
+	s.misses++
+	version := s.version
+	s.mu.Unlock()
+
+	page, err := s.PageStore.Load(title)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// a page saved or deleted while this one was read may be this one, whose
+	// body read is outdated then
+	if s.version == version {
+		s.add(copyPage(page))
+	}
+	return page, nil
+}
+
+// add caches the page, forgetting the least recently read when full. It must be
+// called with s.mu held.
+func (s *cachedStore) add(page *Page) {
+	if _, ok := s.pages[page.Title]; ok {
+		return
+	}
+	s.pages[page.Title] = s.recent.PushFront(page)
+	for s.recent.Len() > s.max {
+		oldest := s.recent.Back()
+		s.recent.Remove(oldest)
+		delete(s.pages, oldest.Value.(*Page).Title)
+	}
+}
+
+// forget drops the page from the cache.
+func (s *cachedStore) forget(title string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+	if element, ok := s.pages[title]; ok {
+		s.recent.Remove(element)
+		delete(s.pages, title)
+	}
+}
+
+func (s *cachedStore) Save(p *Page) error {
+	defer s.forget(p.Title)
+	return s.PageStore.Save(p)
+}
+
+func (s *cachedStore) Delete(title string) error {
+	defer s.forget(title)
+	return s.PageStore.Delete(title)
+}
+
+func (s *cachedStore) SaveAs(p *Page, author, message string) error {
+	defer s.forget(p.Title)
+	return saveAs(s.PageStore, p, author, message)
+}
+
+func (s *cachedStore) DeleteAs(title, author, message string) error {
+	defer s.forget(title)
+	return deleteAs(s.PageStore, title, author, message)
+}
+
+// ModTime, Snapshot and Close pass on what the store they wrap tells and does.
+func (s *cachedStore) ModTime(title string) (time.Time, error) {
+	if timed, ok := s.PageStore.(interface {
+		ModTime(string) (time.Time, error)
+	}); ok {
+		return timed.ModTime(title)
+	}
+	return time.Time{}, nil
+}
+
+func (s *cachedStore) Ping() error {
+	return pingStore(s.PageStore)
+}
+
+func (s *cachedStore) Snapshot(write func(name string, size int64, content io.WriterTo) error) error {
+	return snapshotStore(s.PageStore, write)
+}
+
+func (s *cachedStore) Close() error {
+	if closer, ok := s.PageStore.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// copyPage returns a page of its own, so that a caller changing the body of what
+// it loaded doesn't change the cached one.
+func copyPage(page *Page) *Page {
+	return &Page{Title: page.Title, Body: append([]byte(nil), page.Body...)}
+}
+
+// CacheStats is how well the page cache does.
+type CacheStats struct {
+	Pages  int    `json:"pages"`
+	Max    int    `json:"max"`
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+func (s *cachedStore) stats() CacheStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return CacheStats{Pages: s.recent.Len(), Max: s.max, Hits: s.hits, Misses: s.misses}
+}
+
+// cacheStatsHandler serves GET /api/v1/cache, the stats of the page cache.
+func (wiki *Wiki) cacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET to get the stats")
+		return
+	}
+	if wiki.pageCache == nil {
+		writeJSONError(w, http.StatusNotFound, "the page cache is off, turn it on with -cache-pages")
+		return
+	}
+	writeJSON(w, http.StatusOK, wiki.pageCache.stats())
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultChanges is how many changes the recent changes list, and
+	// maxChanges how many a client may ask for.
+	defaultChanges = 50
+	maxChanges     = 500
+)
+
+// Change is a revision of a page as the recent changes, the feed and the API list
+// it: who saved which revision of which page when.
+type Change struct {
+	Title    string    `json:"title"`
+	Revision int       `json:"revision"`
+	Author   string    `json:"author"`
+	Time     time.Time `json:"time"`
+	Comment  string    `json:"comment,omitempty"`
+	Size     int       `json:"size"`
+}
+
+func changeOf(title string, revision Revision) Change {
+	return Change{Title: title, Revision: revision.ID, Author: revision.Author, Time: revision.Time, Comment: revision.Comment, Size: len(revision.Body)}
+}
+
+// pageChanges returns the revisions of a page as changes, newest first, only those
+// by author unless it's "".
+func (wiki *Wiki) pageChanges(title, author string) ([]Change, error) {
+	revisions, err := wiki.loadRevisions(title)
+	if err != nil {
+		return nil, err
+	}
+	var changes []Change
+	for idx := len(revisions) - 1; idx >= 0; idx-- {
+		if author == "" || revisions[idx].Author == author {
+			changes = append(changes, changeOf(title, revisions[idx]))
+		}
+	}
+	return changes, nil
+}
+
+// recentChanges returns the latest limit changes to the pages listed for the
+// viewer, newest first, only those by author unless it's "".
+func (wiki *Wiki) recentChanges(v viewer, author string, limit int) ([]Change, error) {
+	var changes []Change
+	for _, title := range wiki.titles.List() {
+		if !v.lists(title) {
+			continue
+		}
+		pageChanges, err := wiki.pageChanges(title, author)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, pageChanges...)
+	}
+	slices.SortStableFunc(changes, func(a, b Change) int { return b.Time.Compare(a.Time) })
+	return changes[:min(len(changes), limit)], nil
+}
+
+// recentlyChangedPages returns the limit pages listed for the viewer changed last,
+// newest first, each with its latest change, by author unless it's "". A page
+// changed before the history was kept has only the time the store modified it.
+func (wiki *Wiki) recentlyChangedPages(v viewer, author string, limit int) ([]Change, error) {
+	var changes []Change
+	for _, title := range wiki.titles.List() {
+		if !v.lists(title) {
+			continue
+		}
+		pageChanges, err := wiki.pageChanges(title, author)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case len(pageChanges) > 0:
+			changes = append(changes, pageChanges[0])
+		case author == "":
+			page, err := wiki.load(title)
+			if err != nil {
+				continue
+			}
+			changes = append(changes, Change{Title: title, Time: wiki.pageModTime(title), Size: len(page.Body)})
+		}
+	}
+	slices.SortStableFunc(changes, func(a, b Change) int { return b.Time.Compare(a.Time) })
+	return changes[:min(len(changes), limit)], nil
+}
+
+// pageModTime returns when the store last modified the page, or the zero time
+// when the store doesn't tell.
+func (wiki *Wiki) pageModTime(title string) time.Time {
+	var modTime time.Time
+	if timed, ok := wiki.store.(interface {
+		ModTime(string) (time.Time, error)
+	}); ok {
+		modTime, _ = timed.ModTime(title)
+	}
+	return modTime
+}
+
+// changesLimit returns the number of changes asked for by ?limit=.
+func changesLimit(r *http.Request) int {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit < 1 {
+		return defaultChanges
+	}
+	return min(limit, maxChanges)
+}
+
+// ChangesPage is the data of the changes template.
+type ChangesPage struct {
+	Author  string
+	Changes []Change
+}
+
+// recentChangesHandler serves /changes, the pages changed last with their latest
+// change, and with ?author= the pages one author changed last.
+func (wiki *Wiki) recentChangesHandler(w http.ResponseWriter, r *http.Request) {
+	author := r.URL.Query().Get("author")
+	changes, err := wiki.recentlyChangedPages(wiki.viewerOf(r), author, changesLimit(r))
+	if err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	wiki.renderTemplate(w, "changes.html", ChangesPage{Author: author, Changes: changes})
+}
+
+// changesAPIHandler serves GET /api/v1/changes, the recent changes as JSON, taking
+// the same ?author= and ?limit= as /changes.
+func (wiki *Wiki) changesAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET to list the changes")
+		return
+	}
+	changes, err := wiki.recentChanges(wiki.viewerOf(r), r.URL.Query().Get("author"), changesLimit(r))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]Change{"changes": changes})
+}
+
+// pageHistoryAPIHandler serves GET /api/v1/pages/{title}/history, the revisions of
+// the page without their bodies, newest first, with ?author= only those of one
+// author.
+func (wiki *Wiki) pageHistoryAPIHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET to list the revisions")
+		return
+	}
+	if !wiki.titles.Has(title) {
+		writeJSONError(w, http.StatusNotFound, "there is no page "+title)
+		return
+	}
+	changes, err := wiki.pageChanges(title, r.URL.Query().Get("author"))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]Change{"revisions": changes})
+}
+
+// atomFeed and atomEntry are the parts of an Atom feed (RFC 4287) the changes
+// feed uses.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Link    atomLink    `xml:"link"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Title   string     `xml:"title"`
+	ID      string     `xml:"id"`
+	Link    atomLink   `xml:"link"`
+	Updated string     `xml:"updated"`
+	Author  atomAuthor `xml:"author"`
+	Summary string     `xml:"summary,omitempty"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+// changesFeedHandler serves /changes.atom, the pages changed last as an Atom feed,
+// taking the same ?author= and ?limit= as /changes. An entry is a page, identified
+// by its latest revision, so a reader shows a page again once it changes again.
+func (wiki *Wiki) changesFeedHandler(w http.ResponseWriter, r *http.Request) {
+	author := r.URL.Query().Get("author")
+	changes, err := wiki.recentlyChangedPages(wiki.viewerOf(r), author, changesLimit(r))
+	if err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	base := strings.TrimSuffix(wiki.config.BaseURL, "/")
+	feed := atomFeed{Title: "Recently changed pages", ID: base + "/changes", Link: atomLink{base + "/changes"}, Updated: clock.Now().UTC().Format(time.RFC3339)}
+	if author != "" {
+		feed.Title += " by " + author
+		feed.ID += "?author=" + url.QueryEscape(author)
+	}
+	if len(changes) > 0 && !changes[0].Time.IsZero() {
+		feed.Updated = changes[0].Time.UTC().Format(time.RFC3339)
+	}
+	for _, change := range changes {
+		// pages and revisions from before the history was kept have no author
+		if change.Author == "" {
+			change.Author = "unknown"
+		}
+		entry := atomEntry{
+			Title:   wiki.displayTitle(change.Title),
+			ID:      base + "/view/" + change.Title,
+			Link:    atomLink{base + "/view/" + change.Title},
+			Updated: change.Time.UTC().Format(time.RFC3339),
+			Author:  atomAuthor{change.Author},
+			Summary: change.Comment,
+		}
+		if change.Revision > 0 {
+			entry.ID += "?revision=" + strconv.Itoa(change.Revision)
+			entry.Link = atomLink{base + "/diff/" + change.Title + "?to=" + strconv.Itoa(change.Revision)}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	data, err := xml.Marshal(feed)
+	if err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write(append([]byte(xml.Header), data...))
+}
@@ -0,0 +1,40 @@
+//go:build chroma
+
+// The chroma highlighter colours the fenced code blocks of the languages chroma
+// knows. It is only built with -tags chroma, after fetching
+// github.com/alecthomas/chroma/v2, so the default build keeps no dependencies.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+func init() {
+	highlighters["chroma"] = chromaHighlight
+}
+
+// chromaFormatter writes the tokens as spans with chroma's classes, without the
+// <pre> around them, which the renderer writes.
+var chromaFormatter = chromahtml.New(chromahtml.WithClasses(true), chromahtml.PreventSurroundingPre(true))
+
+func chromaHighlight(language, code string) (string, bool) {
+	lexer := lexers.Get(language)
+	if lexer == nil {
+		return "", false
+	}
+	tokens, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+	if err != nil {
+		return "", false
+	}
+	var out strings.Builder
+	if err := chromaFormatter.Format(&out, styles.Fallback, tokens); err != nil {
+		return "", false
+	}
+	return out.String(), true
+}
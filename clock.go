@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Clock tells the wiki the time: of revisions, reviews, drafts, logins, sessions,
+// feeds and digests. Durations, like a request's latency, are still measured on
+// the system's monotonic clock.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// IDSource makes the wiki's identifiers and tokens.
+type IDSource interface {
+	// ID returns a new identifier of something that's only named by it, a request
+	// or a matrix job.
+	ID() string
+	// Token returns a new unguessable token of n random bytes, for what it grants:
+	// a login, a session, an edit form.
+	Token(n int) string
+}
+
+// clock and ids are what the wiki reads the time and takes its IDs from. A test
+// sets them to fixed ones, a deployment picks the IDs with -ids.
+var (
+	clock Clock    = systemClock{}
+	ids   IDSource = randomIDs{}
+)
+
+// randomIDs are random hex strings.
+type randomIDs struct{}
+
+func (randomIDs) ID() string { return randomHex(8) }
+
+func (randomIDs) Token(n int) string { return randomHex(n) }
+
+func randomHex(n int) string {
+	random := make([]byte, n)
+	rand.Read(random)
+	return hex.EncodeToString(random)
+}
+
+// ulidIDs are ULIDs: the millisecond of the clock followed by random bits, in
+// Crockford's base 32, so that IDs made by every instance of the wiki sort by
+// when they were made. IDs of the same millisecond count up from the first, to
+// keep sorting in order.
+type ulidIDs struct {
+	clock Clock
+
+	mu       sync.Mutex
+	lastTime uint64
+	last     [16]byte
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+func (u *ulidIDs) ID() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	now := uint64(u.clock.Now().UnixMilli())
+	if now == u.lastTime {
+		// count the 80 random bits up by one
+		for idx := len(u.last) - 1; idx >= 6; idx-- {
+			u.last[idx]++
+			if u.last[idx] != 0 {
+				break
+			}
+		}
+	} else {
+		var id [16]byte
+		binary.BigEndian.PutUint64(id[:8], now<<16)
+		rand.Read(id[6:])
+		u.last, u.lastTime = id, now
+	}
+	return encodeULID(u.last)
+}
+
+// Tokens grant access, they stay random whatever the IDs are.
+func (u *ulidIDs) Token(n int) string { return randomHex(n) }
+
+// encodeULID writes the 128 bits of id as 26 base 32 digits, the first holding
+// the top 3 bits.
+func encodeULID(id [16]byte) string {
+	hi, lo := binary.BigEndian.Uint64(id[:8]), binary.BigEndian.Uint64(id[8:])
+	var out [26]byte
+	for idx := 25; idx >= 0; idx-- {
+		out[idx] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
+
+// newIDSource returns the IDs of a scheme: "random" or "ulid".
+func newIDSource(scheme string, clock Clock) (IDSource, error) {
+	switch scheme {
+	case "random":
+		return randomIDs{}, nil
+	case "ulid":
+		return &ulidIDs{clock: clock}, nil
+	}
+	return nil, fmt.Errorf("the IDs are random or ulid, not %q", scheme)
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// command is a subcommand of gowiki: the function that runs it on the wiki with
+// the arguments after its name, and a line of what it does for the usage.
+type command struct {
+	run     func(wiki *Wiki, args []string) error
+	summary string
+}
+
+// commands are the subcommands of gowiki, by name. Every one but serve works on
+// the wiki opened by the flags before it, once its pages are scanned, through
+// the same store as the server, so scripts and cron jobs can change the wiki
+// without HTTP; serve, what gowiki runs without one, is the server.
+var commands = map[string]command{
+	"serve":          {(*Wiki).runServe, "serve the wiki, the flags before it configure the server"},
+	"list":           {(*Wiki).runList, "print the titles of the pages in listing order"},
+	"get":            {(*Wiki).runGet, "print the body of a page, or of one of its revisions"},
+	"put":            {(*Wiki).runPut, "save a page from the standard input or a file"},
+	"rename":         {(*Wiki).runRename, "rename a page, rewriting the links to it"},
+	"export":         {(*Wiki).runExport, "export pages as a static site or an archive of their sources"},
+	"export-static":  {(*Wiki).runExportStatic, "export the pages listed for everyone as a static site"},
+	"import":         {(*Wiki).runImport, "save the pages of an export archive or a directory of page sources"},
+	"reindex":        {(*Wiki).runReindex, "build the title registry and the search, link and tag indexes again from the store, reporting what was out of step"},
+	"user":           {(*Wiki).runUser, "add a user or set the role of one"},
+	"replace":        {(*Wiki).runReplace, "find and replace across pages"},
+	"bundle":         {(*Wiki).runBundle, "export or import the whole wiki as a bundle"},
+	"profile-render": {(*Wiki).runProfileRender, "profile rendering the pages with title registries of every size"},
+	"fsck":           {(*Wiki).runFsck, "check the wiki for inconsistencies, repairing them with -repair"},
+	"digest":         {(*Wiki).runDigest, "print the digest of the changes a user would get now, mailing it with -send"},
+	"generate":       {(*Wiki).runGenerate, "save a number of generated pages into the wiki, for load tests"},
+}
+
+// printUsage is the usage of gowiki: its commands and then its flags.
+func printUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "usage: %s [flags] [command [command flags] [arguments]]\n\ncommands:\n", os.Args[0])
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-15s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintf(out, "\nrun %s [flags] <command> -h for the flags of a command\n\nflags:\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+// runServe serves the wiki until it's interrupted or terminated.
+func (wiki *Wiki) runServe(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("serve takes no arguments, the flags go before it: %s", strings.Join(args, " "))
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return wiki.Run(ctx)
+}
+
+// runList is the list command: the titles of the pages outside the sandboxes,
+// or of a namespace or a tag, one a line.
+func (wiki *Wiki) runList(args []string) error {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	namespace := flags.String("namespace", "", "list only the pages of this namespace and the namespaces below it")
+	tag := flags.String("tag", "", "list only the pages with this tag")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	var listed []string
+	for _, title := range wiki.selectPages(*namespace) {
+		if *tag == "" || slices.Contains(wiki.metadataOf(title).Tags, *tag) {
+			listed = append(listed, title)
+		}
+	}
+	wiki.sortListing(listed)
+	for _, title := range listed {
+		fmt.Println(title)
+	}
+	return nil
+}
+
+// runGet is the get command: the body of the page, or with -revision that of
+// the revision of its history.
+func (wiki *Wiki) runGet(args []string) error {
+	flags := flag.NewFlagSet("get", flag.ContinueOnError)
+	id := flags.Int("revision", 0, "print the body of the revision with this id instead, as the history lists it")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: gowiki get [-revision id] <title>")
+	}
+	title := wiki.titles.Canonical(flags.Arg(0))
+	if *id == 0 {
+		page, err := wiki.load(title)
+		if err != nil {
+			return fmt.Errorf("there's no page %s", title)
+		}
+		_, err = os.Stdout.Write(page.Body)
+		return err
+	}
+	revisions, err := wiki.loadRevisions(title)
+	if err != nil {
+		return err
+	}
+	for _, revision := range revisions {
+		if revision.ID == *id {
+			_, err := io.WriteString(os.Stdout, revision.Body)
+			return err
+		}
+	}
+	return fmt.Errorf("%s has no revision %d", title, *id)
+}
+
+// runPut is the put command: it saves the page, as a revision by -author, with
+// the body read from the standard input or -file.
+func (wiki *Wiki) runPut(args []string) error {
+	flags := flag.NewFlagSet("put", flag.ContinueOnError)
+	filename := flags.String("file", "", "read the body from this file instead of the standard input")
+	author := flags.String("author", "admin", "the author of the revision")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: gowiki put [-file name] [-author name] <title> < body")
+	}
+	title := wiki.titles.Canonical(flags.Arg(0))
+	if !validTitle.MatchString(title) {
+		return fmt.Errorf("%s is not a title, use letters, digits and hyphens", title)
+	}
+	if isReserved(title) {
+		return reservedError(title)
+	}
+	var body []byte
+	var err error
+	if *filename != "" {
+		body, err = os.ReadFile(*filename)
+	} else {
+		body, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return err
+	}
+	existed := wiki.titles.Has(title)
+	if err := wiki.savePage(&Page{Title: title, Body: body}, *author); err != nil {
+		return err
+	}
+	if existed {
+		fmt.Fprintf(os.Stderr, "saved %s\n", title)
+	} else {
+		fmt.Fprintf(os.Stderr, "created %s\n", title)
+	}
+	return nil
+}
+
+// runRename is the rename command, which renames a page as the rename page does.
+func (wiki *Wiki) runRename(args []string) error {
+	flags := flag.NewFlagSet("rename", flag.ContinueOnError)
+	var rename Rename
+	flags.BoolVar(&rename.RewriteLinks, "rewrite-links", true, "point the links of the other pages at the new title")
+	flags.BoolVar(&rename.Redirect, "redirect", false, "leave the old title as a redirect to the new one")
+	author := flags.String("author", "admin", "the author of the revisions")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errors.New("usage: gowiki rename [-rewrite-links=false] [-redirect] [-author name] <from> <to>")
+	}
+	rename.From, rename.To = wiki.titles.Canonical(flags.Arg(0)), flags.Arg(1)
+	wiki.editsMu.Lock()
+	defer wiki.editsMu.Unlock()
+	rewrites, err := wiki.planRename(rename)
+	if err != nil {
+		return err
+	}
+	if err := wiki.applyRename(rename, *author, rewrites); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "renamed %s to %s, rewriting the links of %d pages\n", rename.From, rename.To, len(rewrites))
+	return nil
+}
+
+// runImport is the import command: it saves the pages of an archive written by
+// export -format archive, or of the .txt and .md files of a directory, their
+// paths below it the titles, as revisions by -author. The pages the wiki has
+// already are left as they are, unless -overwrite is set. Unlike bundle import
+// it brings in the page sources alone, without their history or attachments.
+func (wiki *Wiki) runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	overwrite := flags.Bool("overwrite", false, "save over the pages the wiki has already")
+	author := flags.String("author", "admin", "the author of the revisions")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: gowiki import [-overwrite] [-author name] <archive.tar.gz or directory>")
+	}
+	sources, err := readPageSources(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	var names []string
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	imported, skipped := 0, 0
+	for _, name := range names {
+		title := strings.TrimSuffix(strings.TrimSuffix(name, ".txt"), ".md")
+		switch {
+		case !validTitle.MatchString(title), isReserved(title):
+			fmt.Fprintf(os.Stderr, "skipped %s, it isn't the name of a page\n", name)
+			skipped++
+			continue
+		case wiki.titles.Has(title) && !*overwrite:
+			fmt.Fprintf(os.Stderr, "skipped %s, there's a page %s already\n", name, title)
+			skipped++
+			continue
+		}
+		if err := wiki.savePage(&Page{Title: title, Body: sources[name]}, *author); err != nil {
+			return fmt.Errorf("imported %d pages, then %s failed: %w", imported, title, err)
+		}
+		imported++
+	}
+	fmt.Printf("imported %d pages, skipped %d\n", imported, skipped)
+	return nil
+}
+
+// readPageSources reads the page sources of the archive or directory at name, by
+// their paths below the directory, or below data/ in the archive.
+func readPageSources(name string) (map[string][]byte, error) {
+	sources := make(map[string][]byte)
+	isSource := func(name string) bool { return path.Ext(name) == ".txt" || path.Ext(name) == ".md" }
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		err := fs.WalkDir(os.DirFS(name), ".", func(file string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() || !isSource(file) {
+				return err
+			}
+			sources[file], err = os.ReadFile(filepath.Join(name, filepath.FromSlash(file)))
+			return err
+		})
+		return sources, err
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	compressed, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s is neither a directory nor a .tar.gz archive: %w", name, err)
+	}
+	archive := tar.NewReader(compressed)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return sources, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry, ok := strings.CutPrefix(path.Clean(header.Name), "data/")
+		if header.Typeflag != tar.TypeReg || !ok || !isSource(entry) {
+			continue
+		}
+		if sources[entry], err = io.ReadAll(archive); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// runReindex is the reindex command: it builds the title registry and the
+// indexes again from the store, as the server does when it starts and admins do
+// at /admin/reindex, and prints what was out of step with it.
+func (wiki *Wiki) runReindex(args []string) error {
+	flags := flag.NewFlagSet("reindex", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	report, err := wiki.reindex()
+	if err != nil {
+		return err
+	}
+	unreadable := 0
+	for _, problem := range report.Problems {
+		fmt.Println(problem)
+		if problem.Kind == "page" {
+			unreadable++
+		}
+	}
+	if unreadable > 0 {
+		return fmt.Errorf("indexed %d pages, %d pages can't be read", report.Indexed, unreadable)
+	}
+	fmt.Printf("indexed %d pages, %d inconsistencies fixed\n", report.Indexed, len(report.Problems))
+	return nil
+}
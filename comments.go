@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// commentMaxLength is the longest comment taken, in bytes.
+const commentMaxLength = 8 << 10
+
+// Comment is a comment of the discussion of a page, a reply to the comment
+// Parent, or 0 to none.
+type Comment struct {
+	ID     int       `json:"id"`
+	Parent int       `json:"parent,omitempty"`
+	Author string    `json:"author"`
+	Time   time.Time `json:"time"`
+	Body   string    `json:"body"`
+}
+
+// CommentThread is a comment shown with the replies to it, themselves threads,
+// on the page Title. Removable tells that the viewer may remove it.
+type CommentThread struct {
+	Comment
+	Title     string
+	Removable bool
+	Replies   []CommentThread
+}
+
+func (wiki *Wiki) commentsFilename(title string) string {
+	return wiki.dataPath("comments", filepath.FromSlash(title)+".json")
+}
+
+// commentsOf returns the comments of the page, oldest first.
+func (wiki *Wiki) commentsOf(title string) ([]Comment, error) {
+	data, err := os.ReadFile(wiki.commentsFilename(title))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var all []Comment
+	return all, json.Unmarshal(data, &all)
+}
+
+func (wiki *Wiki) saveComments(title string, all []Comment) error {
+	filename := wiki.commentsFilename(title)
+	if len(all) == 0 {
+		if err := os.Remove(filename); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0600)
+}
+
+// errNoSuchComment is why a reply to, or the removal of, a comment fails.
+var errNoSuchComment = notFound("there's no such comment")
+
+// addComment adds the comment by author to the discussion of the page.
+func (wiki *Wiki) addComment(title, author string, parent int, body string) (Comment, error) {
+	wiki.comments.Lock()
+	defer wiki.comments.Unlock()
+	all, err := wiki.commentsOf(title)
+	if err != nil {
+		return Comment{}, err
+	}
+	comment := Comment{ID: 1, Parent: parent, Author: author, Time: clock.Now(), Body: body}
+	found := parent == 0
+	for _, other := range all {
+		comment.ID = max(comment.ID, other.ID+1)
+		found = found || other.ID == parent
+	}
+	if !found {
+		return Comment{}, errNoSuchComment
+	}
+	return comment, wiki.saveComments(title, append(all, comment))
+}
+
+// removeComment takes the comment out of the discussion of the page, with the
+// replies to it.
+func (wiki *Wiki) removeComment(title string, id int) error {
+	wiki.comments.Lock()
+	defer wiki.comments.Unlock()
+	all, err := wiki.commentsOf(title)
+	if err != nil {
+		return err
+	}
+	removed := map[int]bool{id: true}
+	var kept []Comment
+	for _, comment := range all {
+		// a reply comes after what it replies to
+		if removed[comment.ID] || removed[comment.Parent] {
+			removed[comment.ID] = true
+			continue
+		}
+		kept = append(kept, comment)
+	}
+	if len(kept) == len(all) {
+		return errNoSuchComment
+	}
+	return wiki.saveComments(title, kept)
+}
+
+// moveComments gives the discussion of a renamed page to its new title.
+func (wiki *Wiki) moveComments(from, to string) error {
+	wiki.comments.Lock()
+	defer wiki.comments.Unlock()
+	all, err := wiki.commentsOf(from)
+	if err != nil || all == nil {
+		return err
+	}
+	if err := wiki.saveComments(to, all); err != nil {
+		return err
+	}
+	return wiki.saveComments(from, nil)
+}
+
+// commentThreads arranges the comments of the page as the threads they start,
+// oldest first, for the viewer.
+func commentThreads(v viewer, title string, all []Comment) []CommentThread {
+	replies := make(map[int][]Comment)
+	for _, comment := range all {
+		replies[comment.Parent] = append(replies[comment.Parent], comment)
+	}
+	var thread func(parent int) []CommentThread
+	thread = func(parent int) []CommentThread {
+		var threads []CommentThread
+		for _, comment := range replies[parent] {
+			removable := v.admin || v.user != "" && comment.Author == v.user
+			threads = append(threads, CommentThread{Comment: comment, Title: title, Removable: removable, Replies: thread(comment.ID)})
+		}
+		return threads
+	}
+	return thread(0)
+}
+
+// lastCommented is when the page was last commented on, the zero time if it
+// never was.
+func lastCommented(all []Comment) time.Time {
+	var last time.Time
+	for _, comment := range all {
+		if comment.Time.After(last) {
+			last = comment.Time
+		}
+	}
+	return last
+}
+
+// commentHandler serves POST /comment/{title}: the comment body, a reply to the
+// comment parent if there's one, or with remove=id the removal of a comment,
+// with its replies, by an admin or the comment's author. Commenting needs a
+// login and the right to edit the page.
+func (wiki *Wiki) commentHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if !wiki.titles.Has(title) {
+		wiki.serveError(w, notFound(""))
+		return
+	}
+	if wiki.readOnly.Load() && !wiki.isAdmin(r) {
+		wiki.serveError(w, forbidden("the wiki is read-only"))
+		return
+	}
+	if wiki.currentUser(r) == "" && !wiki.isAdmin(r) {
+		http.Error(w, translate(wiki.requestLocale(w), "error.commentLogIn"), http.StatusUnauthorized)
+		return
+	}
+	author := wiki.requestAuthor(r)
+	if remove := r.FormValue("remove"); remove != "" {
+		id, _ := strconv.Atoi(remove)
+		if !wiki.isAdmin(r) {
+			all, err := wiki.commentsOf(title)
+			if err != nil {
+				wiki.serveError(w, err)
+				return
+			}
+			for _, comment := range all {
+				if comment.ID == id && comment.Author != author {
+					wiki.serveError(w, forbidden("only admins remove the comments of others"))
+					return
+				}
+			}
+		}
+		if err := wiki.removeComment(title, id); err != nil {
+			wiki.serveError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/view/"+title+"#comments", http.StatusFound)
+		return
+	}
+	body := strings.TrimSpace(r.FormValue("body"))
+	if body == "" || len(body) > commentMaxLength {
+		http.Error(w, translate(wiki.requestLocale(w), "error.commentLength", commentMaxLength), http.StatusBadRequest)
+		return
+	}
+	var parent int
+	if value := r.FormValue("parent"); value != "" {
+		var err error
+		if parent, err = strconv.Atoi(value); err != nil {
+			http.Error(w, translate(wiki.requestLocale(w), "error.commentParent"), http.StatusBadRequest)
+			return
+		}
+	}
+	comment, err := wiki.addComment(title, author, parent, body)
+	if err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/view/"+title+"#comment-"+strconv.Itoa(comment.ID), http.StatusFound)
+}
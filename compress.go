@@ -0,0 +1,202 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// compressMinSize is how long a response has to be before it's compressed;
+// shorter ones would gain less than the compression costs.
+const compressMinSize = 1024
+
+// compressibleTypes are the media types, besides text/*, compressed.
+var compressibleTypes = map[string]bool{
+	"application/json": true, "application/xml": true, "application/atom+xml": true,
+	"application/javascript": true, "application/x-tar": true, "image/svg+xml": true,
+}
+
+// isCompressible reports whether a response of the content type is worth
+// compressing: text, JSON and XML, and uncompressed archives. The event streams
+// are left alone, as they're sent as they come, and so is anything compressed
+// already, like the images and zip files of attachments and the gzipped exports.
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mediaType == "text/event-stream":
+		return false
+	case strings.HasPrefix(mediaType, "text/"), compressibleTypes[mediaType]:
+		return true
+	}
+	return strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+// acceptedEncoding picks the encoding of the response from the request's
+// Accept-Encoding: gzip, else deflate, or "" for none.
+func acceptedEncoding(accept string) string {
+	qualities := make(map[string]float64)
+	for _, field := range strings.Split(accept, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(field), ";")
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				quality = parsed
+			}
+		}
+		qualities[strings.ToLower(strings.TrimSpace(coding))] = quality
+	}
+	for _, encoding := range []string{"gzip", "deflate"} {
+		if quality, ok := qualities[encoding]; ok {
+			if quality > 0 {
+				return encoding
+			}
+			continue
+		}
+		if quality, ok := qualities["*"]; ok && quality > 0 {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// compressResponses compresses the responses to clients that accept it, with
+// gzip or deflate, once they're compressMinSize long and of a compressible type.
+func compressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter holds back the start of a response until it knows whether to
+// compress it: once it's compressMinSize long, or ends or is flushed before.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+	buffered []byte
+	decided  bool
+	// compressor is what the body is written through once compressed, nil
+	// when it's sent as it is.
+	compressor io.WriteCloser
+}
+
+// WriteHeader holds the status back with the start of the body; informational
+// responses go out at once.
+func (cw *compressWriter) WriteHeader(status int) {
+	switch {
+	case cw.decided, status >= 100 && status < 200:
+		cw.ResponseWriter.WriteHeader(status)
+	case cw.status == 0:
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(data []byte) (int, error) {
+	if cw.decided {
+		return cw.write(data)
+	}
+	cw.buffered = append(cw.buffered, data...)
+	if len(cw.buffered) >= compressMinSize {
+		if err := cw.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (cw *compressWriter) write(data []byte) (int, error) {
+	if cw.compressor != nil {
+		return cw.compressor.Write(data)
+	}
+	return cw.ResponseWriter.Write(data)
+}
+
+// decide sends the header, compressed if the response is long enough and of a
+// compressible type, and what's buffered of the body.
+func (cw *compressWriter) decide() error {
+	cw.decided = true
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	header := cw.Header()
+	if header.Get("Content-Type") == "" && len(cw.buffered) > 0 {
+		// sniffed here, as the server would sniff the compressed bytes
+		header.Set("Content-Type", http.DetectContentType(cw.buffered))
+	}
+	compressible := isCompressible(header.Get("Content-Type"))
+	if compressible {
+		header.Add("Vary", "Accept-Encoding")
+	}
+	hasBody := cw.status != http.StatusNoContent && cw.status != http.StatusNotModified && cw.status != http.StatusPartialContent
+	if compressible && hasBody && len(cw.buffered) >= compressMinSize && header.Get("Content-Encoding") == "" && header.Get("Content-Range") == "" {
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		// the compressed body is another one, which only a weak ETag still names
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		if cw.encoding == "gzip" {
+			cw.compressor = gzip.NewWriter(cw.ResponseWriter)
+		} else {
+			cw.compressor = zlib.NewWriter(cw.ResponseWriter)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	buffered := cw.buffered
+	cw.buffered = nil
+	if len(buffered) == 0 {
+		return nil
+	}
+	_, err := cw.write(buffered)
+	return err
+}
+
+// Flush sends what's written so far, deciding on the compression first.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide()
+	}
+	if flusher, ok := cw.compressor.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close ends the response: it sends what's buffered of a short one, or the end
+// of the compressed body.
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		if cw.status == 0 && len(cw.buffered) == 0 {
+			// nothing was written, the server answers 200 with no body
+			return nil
+		}
+		if err := cw.decide(); err != nil {
+			return err
+		}
+	}
+	if cw.compressor != nil {
+		return cw.compressor.Close()
+	}
+	return nil
+}
+
+// Unwrap gives http.ResponseController the underlying writer.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Config is where the wiki keeps its files and how it's served. Every field is set
+// by a flag, whose default comes from an environment variable when that's set.
+type Config struct {
+	// Addr is the address the server listens on (-addr, GOWIKI_ADDR).
+	Addr string
+	// TLSCert and TLSKey are the files of the certificate and its key the
+	// server serves HTTPS with (-tls-cert, GOWIKI_TLS_CERT, -tls-key,
+	// GOWIKI_TLS_KEY).
+	TLSCert, TLSKey string
+	// AutocertDomains are the domains, separated by commas, to get certificates
+	// for from Let's Encrypt instead, kept in AutocertDir (-autocert,
+	// GOWIKI_AUTOCERT, -autocert-dir, GOWIKI_AUTOCERT_DIR).
+	AutocertDomains, AutocertDir string
+	// HTTPAddr is an address to redirect plain HTTP from to HTTPS, and answer
+	// the challenges of Let's Encrypt on (-http-addr, GOWIKI_HTTP_ADDR).
+	HTTPAddr string
+	// DataDir holds the pages of the file store and the wiki's own state:
+	// revision history, settings, saved searches and the banner
+	// (-data, GOWIKI_DATA_DIR).
+	DataDir string
+	// TemplateDir holds the html templates and the page templates, read from it
+	// instead of those built into the binary when it's set (-tmpl-dir,
+	// GOWIKI_TMPL_DIR).
+	TemplateDir string
+	// Theme is the theme pages are rendered in unless a visitor chooses
+	// another, light or dark or one of ThemeDir, a directory of more themes,
+	// one a subdirectory (-theme, GOWIKI_THEME, -theme-dir, GOWIKI_THEME_DIR).
+	Theme    string
+	ThemeDir string
+	// Locale is the language of the chrome of the pages for the visitors whose
+	// browsers prefer none there's a catalog for, and who chose none; LocaleDir
+	// is a directory of more catalogs, {locale}.json files, which may replace
+	// the messages of the built in ones (-locale, GOWIKI_LOCALE, -locale-dir,
+	// GOWIKI_LOCALE_DIR).
+	Locale    string
+	LocaleDir string
+	// Pprof serves the profiles of net/http/pprof at /debug/pprof/ to admins
+	// (-pprof, GOWIKI_PPROF).
+	Pprof bool
+	// Dev reads the templates again on every page, from -tmpl-dir or else
+	// tmpl/ of the working directory, the source tree (-dev, GOWIKI_DEV).
+	Dev bool
+	// Store is the page store backend (-store, GOWIKI_STORE).
+	Store string
+	// MigrateFrom is a legacy data directory of <title>.txt files that pages
+	// missing from the store are read from and migrated as they're read
+	// (-migrate-from, GOWIKI_MIGRATE_FROM).
+	MigrateFrom string
+	// CachePages is how many pages are kept in memory, 0 for none
+	// (-cache-pages, GOWIKI_CACHE_PAGES).
+	CachePages int
+	// SafeMode renders pages without macros unless a request asks otherwise
+	// (-safe, GOWIKI_SAFE_MODE).
+	SafeMode bool
+	// RawHTML lets the raw HTML of pages through, unescaped and unsanitized, for
+	// a wiki whose every editor is trusted (-raw-html, GOWIKI_RAW_HTML).
+	RawHTML bool
+	// AutoLink links every mention of a title in Markdown pages, not only their
+	// [[Title]] links (-auto-link, GOWIKI_AUTO_LINK).
+	AutoLink bool
+	// CountViews counts the views of the pages, for /admin/stats and the most
+	// viewed pages of the front page (-count-views, GOWIKI_COUNT_VIEWS).
+	CountViews bool
+	// ScanCommand scans uploaded attachments, given the file as its last
+	// argument (-scan-command, GOWIKI_SCAN_COMMAND).
+	ScanCommand string
+	// ScanURL scans uploaded attachments POSTed to it (-scan-url, GOWIKI_SCAN_URL).
+	ScanURL string
+	// BaseURL is where readers reach the wiki, for links in mail and the sitemap
+	// (-base-url, GOWIKI_BASE_URL).
+	BaseURL string
+	// BasePath is the path the wiki is served under, like /w/docs, which the
+	// links of its pages start with (-base-path, GOWIKI_BASE_PATH).
+	BasePath string
+	// SMTPAddr is the host:port of the SMTP server mail is sent through, and
+	// MailFrom its sender (-smtp-addr, GOWIKI_SMTP_ADDR, -mail-from,
+	// GOWIKI_MAIL_FROM).
+	SMTPAddr string
+	MailFrom string
+	// Audit keeps the audit trail of the saves, deletions, renames and logins,
+	// with the addresses they came from (-audit, GOWIKI_AUDIT).
+	Audit bool
+	// Webhooks are the URLs, separated by commas, the changes to the pages are
+	// POSTed to as JSON (-webhooks, GOWIKI_WEBHOOKS), signed with WebhookSecret
+	// (GOWIKI_WEBHOOK_SECRET).
+	Webhooks      string
+	WebhookSecret string
+	// StubBytes is how short in bytes a page /stubs lists is (-stub-bytes,
+	// GOWIKI_STUB_BYTES).
+	StubBytes int
+	// DigestHour is the hour of the day the activity digests are mailed
+	// (-digest-hour, GOWIKI_DIGEST_HOUR).
+	DigestHour int
+	// LogFormat is how the logs are written, as text or json lines
+	// (-log-format, GOWIKI_LOG_FORMAT).
+	LogFormat string
+	// Format is the markup of pages that don't choose their own (-format,
+	// GOWIKI_FORMAT), and MarkupRules the file defining the custom markup
+	// (-markup-rules, GOWIKI_MARKUP_RULES).
+	Format      string
+	MarkupRules string
+	// QueueWait is how long a request over a limit of -limit waits to be served
+	// before it's answered 503 (-queue-wait, GOWIKI_QUEUE_WAIT).
+	QueueWait time.Duration
+	// WriteRate is how many saves, API writes and logins a client makes a
+	// minute, after a burst of WriteBurst, before it's answered 429
+	// (-write-rate, GOWIKI_WRITE_RATE, -write-burst, GOWIKI_WRITE_BURST).
+	WriteRate  float64
+	WriteBurst int
+	// ReadOnly starts the wiki read-only, where only admins change pages
+	// (-read-only, GOWIKI_READ_ONLY).
+	ReadOnly bool
+	// MatrixTimeout stops a matrix computation that holds its request open
+	// for longer (-matrix-timeout, GOWIKI_MATRIX_TIMEOUT).
+	MatrixTimeout time.Duration
+	// TrashRetention is how long deleted pages stay in the trash before they're
+	// purged (-trash-retention, GOWIKI_TRASH_RETENTION).
+	TrashRetention time.Duration
+	// BackupDir is where the data directory is backed up to, every
+	// BackupInterval, keeping the BackupKeep made last and none older than
+	// BackupMaxAge (-backup-dir, GOWIKI_BACKUP_DIR, -backup-interval,
+	// GOWIKI_BACKUP_INTERVAL, -backup-keep, GOWIKI_BACKUP_KEEP, -backup-max-age,
+	// GOWIKI_BACKUP_MAX_AGE).
+	BackupDir      string
+	BackupInterval time.Duration
+	BackupKeep     int
+	BackupMaxAge   time.Duration
+	// Highlight names the highlighter of the fenced code blocks, "none" for
+	// none, the one built in by default (-highlight, GOWIKI_HIGHLIGHT).
+	Highlight string
+	// Robots is a file to serve as /robots.txt instead of the one made from
+	// the routes (-robots, GOWIKI_ROBOTS).
+	Robots string
+	// IDs is how requests and jobs are identified, by random or ulid IDs
+	// (-ids, GOWIKI_IDS).
+	IDs string
+	// Wikis is a JSON file of the other wikis the server hosts, each a wiki of
+	// its own served under /w/{name}/ and at its host (-wikis, GOWIKI_WIKIS).
+	Wikis string
+	// AdminToken makes the requests carrying it admins', see isAdmin
+	// (GOWIKI_ADMIN_TOKEN); without it only the admin users are admins.
+	AdminToken string
+	// TrustProxy takes the client's address from the X-Forwarded-For of the
+	// reverse proxy in front, as the host of -wikis is to its wikis
+	// (-trust-proxy, GOWIKI_TRUST_PROXY).
+	TrustProxy bool
+	// OAuthProviders is a JSON file of the identity providers users log in
+	// with, Google, GitHub or OpenID Connect ones (-oauth-providers,
+	// GOWIKI_OAUTH_PROVIDERS).
+	OAuthProviders string
+	// Dictionary is a file of the words the linter knows, one a line, to warn
+	// of the others (-dictionary, GOWIKI_DICTIONARY), and LintStrict refuses
+	// a save the linter warns of (-lint-strict, GOWIKI_LINT_STRICT).
+	Dictionary string
+	LintStrict bool
+	// PDFCommand turns the print view of a page into the PDF of
+	// /export/{title}.pdf, reading the HTML from its standard input and
+	// writing the PDF to its standard output (-pdf-command, GOWIKI_PDF_COMMAND).
+	PDFCommand string
+	// MaxPageBytes bounds the body of a page saved from the editor or the API
+	// (-max-page-bytes, GOWIKI_MAX_PAGE_BYTES), and StorageQuota the bytes the
+	// pages and attachments of the wiki take together, 0 for no bound
+	// (-storage-quota, GOWIKI_STORAGE_QUOTA).
+	MaxPageBytes int64
+	StorageQuota int64
+	// AllowIPs are the addresses and CIDR networks that may reach a private
+	// wiki (-allow-ips, GOWIKI_ALLOW_IPS), and BasicAuth the file of the
+	// name:password lines of HTTP Basic Auth letting the others in
+	// (-basic-auth, GOWIKI_BASIC_AUTH); PrivateScope guards "all" the
+	// requests with them, or only the "writes" (-private-scope,
+	// GOWIKI_PRIVATE_SCOPE).
+	AllowIPs     string
+	BasicAuth    string
+	PrivateScope string
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// registerConfigFlags defines the flags of config on the command line; the
+// defaults are relative to the working directory.
+func registerConfigFlags(flags *flag.FlagSet, config *Config) {
+	flags.StringVar(&config.Addr, "addr", envOr("GOWIKI_ADDR", ":8080"), "the address to listen on (GOWIKI_ADDR)")
+	flags.StringVar(&config.TLSCert, "tls-cert", os.Getenv("GOWIKI_TLS_CERT"), "serve HTTPS with the certificate in this PEM file, and -tls-key (GOWIKI_TLS_CERT)")
+	flags.StringVar(&config.TLSKey, "tls-key", os.Getenv("GOWIKI_TLS_KEY"), "the PEM file of the key of -tls-cert (GOWIKI_TLS_KEY)")
+	flags.StringVar(&config.AutocertDomains, "autocert", os.Getenv("GOWIKI_AUTOCERT"), "serve HTTPS with certificates from Let's Encrypt for these domains, separated by commas; needs a build with -tags autocert (GOWIKI_AUTOCERT)")
+	flags.StringVar(&config.AutocertDir, "autocert-dir", os.Getenv("GOWIKI_AUTOCERT_DIR"), "the directory to keep the certificates of -autocert in, certs/ of the data directory by default (GOWIKI_AUTOCERT_DIR)")
+	flags.StringVar(&config.HTTPAddr, "http-addr", os.Getenv("GOWIKI_HTTP_ADDR"), "also listen for plain HTTP on this address, like :80, and redirect it to HTTPS (GOWIKI_HTTP_ADDR)")
+	flags.StringVar(&config.DataDir, "data", envOr("GOWIKI_DATA_DIR", "data"), "the directory of the pages and the wiki's state (GOWIKI_DATA_DIR)")
+	flags.StringVar(&config.TemplateDir, "tmpl-dir", os.Getenv("GOWIKI_TMPL_DIR"), "a directory to read the templates from instead of the built-in ones (GOWIKI_TMPL_DIR)")
+	flags.StringVar(&config.TemplateDir, "templates", os.Getenv("GOWIKI_TMPL_DIR"), "the same as -tmpl-dir")
+	flags.StringVar(&config.Theme, "theme", envOr("GOWIKI_THEME", "light"), "the theme pages are rendered in unless a visitor chooses another, light, dark or one of -theme-dir (GOWIKI_THEME)")
+	flags.StringVar(&config.Locale, "locale", envOr("GOWIKI_LOCALE", "en"), "the language of the pages for visitors who chose none and whose browsers prefer none of the catalogs (GOWIKI_LOCALE)")
+	flags.StringVar(&config.LocaleDir, "locale-dir", os.Getenv("GOWIKI_LOCALE_DIR"), "a directory of more message catalogs, {locale}.json files, which may replace messages of the built in ones (GOWIKI_LOCALE_DIR)")
+	flags.StringVar(&config.ThemeDir, "theme-dir", os.Getenv("GOWIKI_THEME_DIR"), "a directory of more themes, each a subdirectory of a theme.css and the templates it replaces (GOWIKI_THEME_DIR)")
+	flags.StringVar(&config.Store, "store", envOr("GOWIKI_STORE", "file"), "the page store backend (GOWIKI_STORE)")
+	flags.StringVar(&config.MigrateFrom, "migrate-from", os.Getenv("GOWIKI_MIGRATE_FROM"), "a legacy directory of .txt pages to read pages missing from the store from, migrating them as they're read (GOWIKI_MIGRATE_FROM)")
+	flags.StringVar(&config.BaseURL, "base-url", envOr("GOWIKI_BASE_URL", "http://localhost:8080"), "the URL readers reach the wiki at, for links in mail and the sitemap (GOWIKI_BASE_URL)")
+	flags.StringVar(&config.BasePath, "base-path", os.Getenv("GOWIKI_BASE_PATH"), "serve the wiki under this path, like /w/docs, behind a proxy passing it on; its pages link under it (GOWIKI_BASE_PATH)")
+	flags.StringVar(&config.SMTPAddr, "smtp-addr", os.Getenv("GOWIKI_SMTP_ADDR"), "the host:port of the SMTP server to mail digests through (GOWIKI_SMTP_ADDR)")
+	flags.StringVar(&config.Robots, "robots", os.Getenv("GOWIKI_ROBOTS"), "a file to serve as /robots.txt, instead of one keeping crawlers to the pages and pointing them at /sitemap.xml (GOWIKI_ROBOTS)")
+	flags.StringVar(&config.MailFrom, "mail-from", os.Getenv("GOWIKI_MAIL_FROM"), "the sender of the mail (GOWIKI_MAIL_FROM)")
+	flags.StringVar(&config.Webhooks, "webhooks", os.Getenv("GOWIKI_WEBHOOKS"), "URLs, separated by commas, to POST the changes to the pages to as JSON, signed with GOWIKI_WEBHOOK_SECRET (GOWIKI_WEBHOOKS)")
+	// the secrets are kept out of the flags, which anybody sees in the process list
+	config.WebhookSecret = os.Getenv("GOWIKI_WEBHOOK_SECRET")
+	config.AdminToken = os.Getenv("GOWIKI_ADMIN_TOKEN")
+	cachePages, err := strconv.Atoi(envOr("GOWIKI_CACHE_PAGES", "256"))
+	if err != nil {
+		cachePages = 256
+	}
+	flags.IntVar(&config.CachePages, "cache-pages", cachePages, "how many of the pages read last to keep in memory, 0 for none (GOWIKI_CACHE_PAGES)")
+	stubBytes, err := strconv.Atoi(envOr("GOWIKI_STUB_BYTES", "500"))
+	if err != nil {
+		stubBytes = 500
+	}
+	flags.IntVar(&config.StubBytes, "stub-bytes", stubBytes, "list the pages shorter than this many bytes at /stubs (GOWIKI_STUB_BYTES)")
+	digestHour, err := strconv.Atoi(envOr("GOWIKI_DIGEST_HOUR", "2"))
+	if err != nil {
+		digestHour = 2
+	}
+	flags.IntVar(&config.DigestHour, "digest-hour", digestHour, "the hour of the day, 0 to 23, the digests are mailed at (GOWIKI_DIGEST_HOUR)")
+	flags.StringVar(&config.Format, "format", envOr("GOWIKI_FORMAT", "markdown"), "the markup of pages that don't choose one: markdown, autolink, plain or custom (GOWIKI_FORMAT)")
+	flags.StringVar(&config.Highlight, "highlight", os.Getenv("GOWIKI_HIGHLIGHT"), "the highlighter of fenced code blocks with a language: chroma, in a build with -tags chroma, or none; the one built in by default (GOWIKI_HIGHLIGHT)")
+	flags.StringVar(&config.MarkupRules, "markup-rules", os.Getenv("GOWIKI_MARKUP_RULES"), "a file of pattern => replacement rules defining the custom markup (GOWIKI_MARKUP_RULES)")
+	flags.StringVar(&config.LogFormat, "log-format", envOr("GOWIKI_LOG_FORMAT", "text"), "write the logs as text or json lines (GOWIKI_LOG_FORMAT)")
+	queueWait, err := time.ParseDuration(envOr("GOWIKI_QUEUE_WAIT", "2s"))
+	if err != nil {
+		queueWait = 2 * time.Second
+	}
+	flags.DurationVar(&config.QueueWait, "queue-wait", queueWait, "how long a request over its -limit waits before the wiki answers 503 (GOWIKI_QUEUE_WAIT)")
+	matrixTimeout, err := time.ParseDuration(envOr("GOWIKI_MATRIX_TIMEOUT", "30s"))
+	if err != nil {
+		matrixTimeout = 30 * time.Second
+	}
+	flags.DurationVar(&config.MatrixTimeout, "matrix-timeout", matrixTimeout, "stop a matrix computation that holds its request open for longer and answer 503, 0 for no limit (GOWIKI_MATRIX_TIMEOUT)")
+	trashRetention, err := time.ParseDuration(envOr("GOWIKI_TRASH_RETENTION", "720h"))
+	if err != nil {
+		trashRetention = 30 * 24 * time.Hour
+	}
+	flags.DurationVar(&config.TrashRetention, "trash-retention", trashRetention, "how long deleted pages stay in the trash for admins to restore before they're purged, 0 to keep them (GOWIKI_TRASH_RETENTION)")
+	flags.StringVar(&config.BackupDir, "backup-dir", envOr("GOWIKI_BACKUP_DIR", "backups"), "the directory the data directory is backed up to, as timestamped .tar.gz archives (GOWIKI_BACKUP_DIR)")
+	backupInterval, err := time.ParseDuration(envOr("GOWIKI_BACKUP_INTERVAL", "0"))
+	if err != nil {
+		backupInterval = 0
+	}
+	flags.DurationVar(&config.BackupInterval, "backup-interval", backupInterval, "how often the data directory is backed up to -backup-dir, 0 for only when an admin asks (GOWIKI_BACKUP_INTERVAL)")
+	backupKeep, err := strconv.Atoi(envOr("GOWIKI_BACKUP_KEEP", "7"))
+	if err != nil {
+		backupKeep = 7
+	}
+	flags.IntVar(&config.BackupKeep, "backup-keep", backupKeep, "how many of the backups made last are kept, 0 for all (GOWIKI_BACKUP_KEEP)")
+	backupMaxAge, err := time.ParseDuration(envOr("GOWIKI_BACKUP_MAX_AGE", "0"))
+	if err != nil {
+		backupMaxAge = 0
+	}
+	flags.DurationVar(&config.BackupMaxAge, "backup-max-age", backupMaxAge, "remove the backups older than this, 0 to keep them whatever their age (GOWIKI_BACKUP_MAX_AGE)")
+	writeRate, err := strconv.ParseFloat(envOr("GOWIKI_WRITE_RATE", "30"), 64)
+	if err != nil {
+		writeRate = 30
+	}
+	flags.Float64Var(&config.WriteRate, "write-rate", writeRate, "how many saves, API writes and logins a client makes a minute before it's answered 429, 0 for no limit (GOWIKI_WRITE_RATE)")
+	writeBurst, err := strconv.Atoi(envOr("GOWIKI_WRITE_BURST", "10"))
+	if err != nil {
+		writeBurst = 10
+	}
+	flags.IntVar(&config.WriteBurst, "write-burst", writeBurst, "how many writes a client makes at once before -write-rate applies (GOWIKI_WRITE_BURST)")
+	flags.StringVar(&config.Wikis, "wikis", os.Getenv("GOWIKI_WIKIS"), "a JSON file listing the other wikis to host, by name, host, data directory, templates, admin token and flags, each served under /w/{name}/ (GOWIKI_WIKIS)")
+	trustProxy, _ := strconv.ParseBool(os.Getenv("GOWIKI_TRUST_PROXY"))
+	flags.BoolVar(&config.TrustProxy, "trust-proxy", trustProxy, "take the client's address from X-Forwarded-For, for a wiki only reached through a reverse proxy (GOWIKI_TRUST_PROXY)")
+	flags.StringVar(&config.OAuthProviders, "oauth-providers", os.Getenv("GOWIKI_OAUTH_PROVIDERS"), "a JSON file listing the Google, GitHub and OpenID Connect identity providers to log in with, by name, client ID and secret (GOWIKI_OAUTH_PROVIDERS)")
+	flags.StringVar(&config.Dictionary, "dictionary", os.Getenv("GOWIKI_DICTIONARY"), "a file of the words the linter knows, one a line, like /usr/share/dict/words, to warn of misspelled ones (GOWIKI_DICTIONARY)")
+	lintStrict, _ := strconv.ParseBool(os.Getenv("GOWIKI_LINT_STRICT"))
+	flags.BoolVar(&config.LintStrict, "lint-strict", lintStrict, "refuse to save a page the linter warns of, showing the warnings in the edit form (GOWIKI_LINT_STRICT)")
+	flags.StringVar(&config.PDFCommand, "pdf-command", os.Getenv("GOWIKI_PDF_COMMAND"), "the command that turns HTML on its standard input into a PDF on its standard output, like \"wkhtmltopdf --quiet - -\", for /export/{title}.pdf (GOWIKI_PDF_COMMAND)")
+	maxPageBytes, err := strconv.ParseInt(envOr("GOWIKI_MAX_PAGE_BYTES", "4194304"), 10, 64)
+	if err != nil {
+		maxPageBytes = 4 << 20
+	}
+	flags.Int64Var(&config.MaxPageBytes, "max-page-bytes", maxPageBytes, "the most bytes the body of a page saved from the editor or the API may have, answered 413 past it (GOWIKI_MAX_PAGE_BYTES)")
+	storageQuota, _ := strconv.ParseInt(os.Getenv("GOWIKI_STORAGE_QUOTA"), 10, 64)
+	flags.Int64Var(&config.StorageQuota, "storage-quota", storageQuota, "the most bytes the pages and attachments of the wiki may take together, 0 for no bound (GOWIKI_STORAGE_QUOTA)")
+	flags.StringVar(&config.AllowIPs, "allow-ips", os.Getenv("GOWIKI_ALLOW_IPS"), "the addresses and CIDR networks, separated by commas, that may reach the wiki, the others are refused or asked for -basic-auth (GOWIKI_ALLOW_IPS)")
+	flags.StringVar(&config.BasicAuth, "basic-auth", os.Getenv("GOWIKI_BASIC_AUTH"), "a file of name:password or name:sha256:{hex} lines, the HTTP Basic Auth credentials that let the clients outside -allow-ips in (GOWIKI_BASIC_AUTH)")
+	flags.StringVar(&config.PrivateScope, "private-scope", envOr("GOWIKI_PRIVATE_SCOPE", "all"), "what -allow-ips and -basic-auth guard: all the requests, or only the writes (GOWIKI_PRIVATE_SCOPE)")
+	flags.StringVar(&config.IDs, "ids", envOr("GOWIKI_IDS", "random"), "identify requests and jobs by random or ulid IDs, ULIDs sort by time across instances (GOWIKI_IDS)")
+	safeMode, _ := strconv.ParseBool(os.Getenv("GOWIKI_SAFE_MODE"))
+	flags.StringVar(&config.ScanCommand, "scan-command", os.Getenv("GOWIKI_SCAN_COMMAND"), "the command that scans uploads, like \"clamdscan --no-summary --fdpass\" (GOWIKI_SCAN_COMMAND)")
+	flags.StringVar(&config.ScanURL, "scan-url", os.Getenv("GOWIKI_SCAN_URL"), "the URL uploads are POSTed to for scanning (GOWIKI_SCAN_URL)")
+	pprofOn, _ := strconv.ParseBool(os.Getenv("GOWIKI_PPROF"))
+	flags.BoolVar(&config.Pprof, "pprof", pprofOn, "serve the CPU, heap, goroutine and other profiles of the wiki at /debug/pprof/ to admins (GOWIKI_PPROF)")
+	dev, _ := strconv.ParseBool(os.Getenv("GOWIKI_DEV"))
+	flags.BoolVar(&config.Dev, "dev", dev, "development mode: read the templates again on every page, from -tmpl-dir or tmpl/, so a change shows on a reload (GOWIKI_DEV)")
+	rawHTML, _ := strconv.ParseBool(os.Getenv("GOWIKI_RAW_HTML"))
+	flags.BoolVar(&config.RawHTML, "raw-html", rawHTML, "render the raw HTML of pages as it is, scripts included; only for a wiki whose editors are all trusted (GOWIKI_RAW_HTML)")
+	autoLink, err := strconv.ParseBool(envOr("GOWIKI_AUTO_LINK", "true"))
+	if err != nil {
+		autoLink = true
+	}
+	flags.BoolVar(&config.AutoLink, "auto-link", autoLink, "link every mention of a page's title in Markdown pages, not only their [[Title]] links (GOWIKI_AUTO_LINK)")
+	countViews, err := strconv.ParseBool(envOr("GOWIKI_COUNT_VIEWS", "true"))
+	if err != nil {
+		countViews = true
+	}
+	auditOn, _ := strconv.ParseBool(os.Getenv("GOWIKI_AUDIT"))
+	flags.BoolVar(&config.Audit, "audit", auditOn, "append every save, deletion, rename and login, with the user and their address, to audit.jsonl for admins to read at /admin/audit (GOWIKI_AUDIT)")
+	flags.BoolVar(&config.CountViews, "count-views", countViews, "count the views of the pages for /admin/stats and the front page, false to keep no record of what's read (GOWIKI_COUNT_VIEWS)")
+	readOnlyMode, _ := strconv.ParseBool(os.Getenv("GOWIKI_READ_ONLY"))
+	flags.BoolVar(&config.ReadOnly, "read-only", readOnlyMode, "start the wiki read-only: only admins change pages, which admins toggle at /admin/read-only (GOWIKI_READ_ONLY)")
+	flags.BoolVar(&config.SafeMode, "safe", safeMode, "render pages in safe mode, without macros, unless ?safe=0 (GOWIKI_SAFE_MODE)")
+}
+
+// hostConfig is the configuration the flags give the wiki gowiki opens, which
+// hosts those of -wikis.
+var hostConfig Config
+
+func init() {
+	registerConfigFlags(flag.CommandLine, &hostConfig)
+}
+
+// dataPath returns the path of a file of the wiki's state in the data directory.
+func (wiki *Wiki) dataPath(elem ...string) string {
+	return filepath.Join(append([]string{wiki.config.DataDir}, elem...)...)
+}
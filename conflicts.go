@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// revisionToken identifies the version of a page an edit starts from: the hash of
+// its body, of the empty body for a page that doesn't exist yet. The edit form
+// sends it back as "base".
+func revisionToken(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// EditConflict is the data of the conflict template: an edit of a version of the
+// page that has been changed since.
+type EditConflict struct {
+	Title        string
+	DisplayTitle string
+	Weight       string
+	Format       string
+	Tags         string
+	EditToken    string
+	Yours        string
+	Theirs       string
+	TheirAuthor  string
+	// Diff goes from the saved version to yours.
+	Diff []DiffLine
+	// Merged is the three-way merge of both edits when Mergeable, that is when
+	// the version the edit started from is in the history, and otherwise
+	// your version. Conflicts counts the regions both edits changed.
+	Merged    string
+	Mergeable bool
+	Conflicts int
+	// Base is the token of the saved version, which the merge is an edit of.
+	Base string
+}
+
+// editConflict returns the conflict of the edit yours of title, made to the
+// version base, or nil when the page is still at that version.
+func (wiki *Wiki) editConflict(title, base, yours string) (*EditConflict, error) {
+	theirs := ""
+	if current, err := wiki.load(title); err == nil {
+		theirs = string(current.Body)
+	}
+	if revisionToken([]byte(theirs)) == base || theirs == yours {
+		return nil, nil
+	}
+	revisions, err := wiki.loadRevisions(title)
+	if err != nil {
+		return nil, err
+	}
+	conflict := &EditConflict{Title: title, Yours: yours, Theirs: theirs, Diff: diffLines(theirs, yours), Merged: yours, Base: revisionToken([]byte(theirs))}
+	if len(revisions) > 0 {
+		conflict.TheirAuthor = revisions[len(revisions)-1].Author
+	}
+	baseBody, found := "", base == revisionToken(nil)
+	for idx := len(revisions) - 1; idx >= 0 && !found; idx-- {
+		if revisionToken([]byte(revisions[idx].Body)) == base {
+			baseBody, found = revisions[idx].Body, true
+		}
+	}
+	if found {
+		conflict.Mergeable = true
+		conflict.Merged, conflict.Conflicts = merge3(baseBody, yours, theirs, "your edit", "the saved version")
+	}
+	return conflict, nil
+}
+
+// renderConflict rejects a stale save with 409 Conflict and the conflict page,
+// whose form saves the merge on top of the saved version.
+func (wiki *Wiki) renderConflict(w http.ResponseWriter, conflict *EditConflict) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusConflict)
+	wiki.renderTemplate(w, "conflict.html", conflict)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxConvertBytes bounds the size of pasted HTML.
+const maxConvertBytes = 8 << 20
+
+// htmlConverter turns rich HTML into Markdown-style wiki markup. It only keeps the
+// structure (headings, paragraphs, lists, emphasis, links, code and quotes) and
+// drops styling, classes and the wrapper elements editors like Google Docs add.
+type htmlConverter struct {
+	out       strings.Builder
+	lists     []listState
+	links     []string // href of every open <a>
+	skipDepth int      // > 0 inside <script>, <style> and others whose text is dropped
+	preDepth  int
+	quote     int
+	closers   [][]string // markup to write when the element at each depth closes
+}
+
+type listState struct {
+	ordered bool
+	count   int
+}
+
+var (
+	whitespace    = regexp.MustCompile(`\s+`)
+	extraNewlines = regexp.MustCompile(`\n{3,}`)
+	trailingBlank = regexp.MustCompile(`[ \t]+\n`)
+)
+
+var headingLevels = map[string]int{"h1": 1, "h2": 2, "h3": 3, "h4": 4, "h5": 5, "h6": 6}
+
+var droppedElements = map[string]bool{"script": true, "style": true, "head": true, "title": true, "meta": true, "noscript": true}
+
+func (c *htmlConverter) write(s string) {
+	c.out.WriteString(s)
+}
+
+// newBlock starts a new block, separated from the previous one by a blank line and
+// prefixed with the quote markers of the blockquotes it's in.
+func (c *htmlConverter) newBlock() {
+	c.write("\n" + strings.TrimSpace(strings.Repeat("> ", c.quote)) + "\n" + strings.Repeat("> ", c.quote))
+}
+
+func (c *htmlConverter) start(element xml.StartElement) {
+	name := strings.ToLower(element.Name.Local)
+	attr := func(key string) string {
+		for _, a := range element.Attr {
+			if strings.EqualFold(a.Name.Local, key) {
+				return a.Value
+			}
+		}
+		return ""
+	}
+	var closer []string
+	switch {
+	case droppedElements[name]:
+		c.skipDepth++
+		closer = append(closer, "skip")
+	case headingLevels[name] > 0:
+		c.newBlock()
+		c.write(strings.Repeat("#", headingLevels[name]) + " ")
+	case name == "p" || name == "div":
+		c.newBlock()
+	case name == "br":
+		c.write("\n" + strings.Repeat("> ", c.quote))
+	case name == "hr":
+		c.newBlock()
+		c.write("---")
+	case name == "blockquote":
+		c.newBlock()
+		c.quote++
+		c.write("> ")
+		closer = append(closer, "quote")
+	case name == "ul" || name == "ol":
+		if len(c.lists) == 0 {
+			c.newBlock()
+		}
+		c.lists = append(c.lists, listState{ordered: name == "ol"})
+		closer = append(closer, "list")
+	case name == "li":
+		c.write("\n" + strings.Repeat("  ", max(len(c.lists)-1, 0)))
+		if len(c.lists) > 0 && c.lists[len(c.lists)-1].ordered {
+			c.lists[len(c.lists)-1].count++
+			c.write(strconv.Itoa(c.lists[len(c.lists)-1].count) + ". ")
+		} else {
+			c.write("- ")
+		}
+	case name == "pre":
+		c.newBlock()
+		c.write("```\n")
+		c.preDepth++
+		closer = append(closer, "pre")
+	case name == "code" && c.preDepth == 0:
+		c.write("`")
+		closer = append(closer, "`")
+	case name == "strong" || name == "b":
+		// Google Docs wraps whole documents in <b style="font-weight:normal">.
+		if !strings.Contains(strings.ReplaceAll(attr("style"), " ", ""), "font-weight:normal") {
+			c.write("**")
+			closer = append(closer, "**")
+		}
+	case name == "em" || name == "i":
+		c.write("*")
+		closer = append(closer, "*")
+	case name == "span":
+		// Pasted spans carry emphasis in their style only.
+		style := strings.ReplaceAll(attr("style"), " ", "")
+		if strings.Contains(style, "font-weight:700") || strings.Contains(style, "font-weight:bold") {
+			c.write("**")
+			closer = append(closer, "**")
+		}
+		if strings.Contains(style, "font-style:italic") {
+			c.write("*")
+			closer = append(closer, "*")
+		}
+	case name == "a":
+		c.links = append(c.links, attr("href"))
+		if attr("href") != "" {
+			c.write("[")
+		}
+		closer = append(closer, "link")
+	case name == "img":
+		if src := attr("src"); src != "" {
+			c.write("![" + attr("alt") + "](" + src + ")")
+		}
+	}
+	c.closers = append(c.closers, closer)
+}
+
+func (c *htmlConverter) end() {
+	if len(c.closers) == 0 {
+		return
+	}
+	closer := c.closers[len(c.closers)-1]
+	c.closers = c.closers[:len(c.closers)-1]
+	for idx := len(closer) - 1; idx >= 0; idx-- {
+		switch closer[idx] {
+		case "skip":
+			c.skipDepth--
+		case "quote":
+			c.quote--
+			c.write("\n\n")
+		case "list":
+			c.lists = c.lists[:len(c.lists)-1]
+			if len(c.lists) == 0 {
+				c.write("\n\n")
+			}
+		case "pre":
+			c.preDepth--
+			c.write("\n```\n\n")
+		case "link":
+			href := c.links[len(c.links)-1]
+			c.links = c.links[:len(c.links)-1]
+			if href != "" {
+				c.write("](" + href + ")")
+			}
+		default:
+			c.write(closer[idx])
+		}
+	}
+}
+
+func (c *htmlConverter) text(data string) {
+	if c.skipDepth > 0 {
+		return
+	}
+	if c.preDepth > 0 {
+		c.write(data)
+		return
+	}
+	text := whitespace.ReplaceAllString(data, " ")
+	// Don't start a line with the whitespace that separated two tags.
+	if current := c.out.String(); current == "" || strings.HasSuffix(current, "\n") || strings.HasSuffix(current, " ") {
+		text = strings.TrimLeft(text, " ")
+	}
+	c.write(text)
+}
+
+// convertHTML converts pasted HTML into wiki markup. Malformed HTML is converted
+// as far as it parses.
+func convertHTML(r io.Reader) string {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	var c htmlConverter
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			c.start(token)
+		case xml.EndElement:
+			c.end()
+		case xml.CharData:
+			c.text(string(token))
+		}
+	}
+	markup := trailingBlank.ReplaceAllString(c.out.String(), "\n")
+	return strings.TrimSpace(extraNewlines.ReplaceAllString(markup, "\n\n")) + "\n"
+}
+
+// convertHandler serves POST /api/v1/convert: the body is the pasted HTML, either
+// as is or as the "html" field of a JSON object, and the response is the wiki
+// markup as {"markup": "..."}.
+func (wiki *Wiki) convertHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	body := http.MaxBytesReader(w, r.Body, maxConvertBytes)
+	var html io.Reader = body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var request struct {
+			HTML string `json:"html"`
+		}
+		if err := json.NewDecoder(body).Decode(&request); err != nil {
+			http.Error(w, translate(wiki.requestLocale(w), "error.invalidJSON", err), http.StatusBadRequest)
+			return
+		}
+		html = strings.NewReader(request.HTML)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"markup": convertHTML(html)})
+}
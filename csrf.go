@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"regexp"
+)
+
+// A request that changes something, anything but a GET, HEAD or OPTIONS, made
+// with the cookies of a visitor has to carry the CSRF token of their session:
+// a form in its "csrf" field, a script in the X-CSRF-Token header. A file upload,
+// whose body isn't read before the handler limits it, has the field first, and
+// it's read alone from the first csrfPeekBytes of the body. The token is never
+// taken from the URL, which ends up in logs and Referer headers. Another site
+// can make the visitor's browser send the request, but can't read the token.
+const (
+	csrfField     = "csrf"
+	csrfHeader    = "X-CSRF-Token"
+	csrfPeekBytes = 1 << 10
+)
+
+// csrfToken returns the token of a session. It's derived from the session cookie,
+// which another site can't read either, so it lasts as long as the session does
+// and needs no state, restarts of the wiki included.
+func csrfToken(sessionID string) string {
+	sum := sha256.Sum256([]byte("gowiki csrf " + sessionID))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkCSRF refuses with 403 a request that changes something with the cookies of
+// a visitor and without their session's token. A request without cookies has no
+// visitor to act for, like a first anonymous edit, and is left to the handlers.
+func (wiki *Wiki) checkCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w = &csrfWriter{ResponseWriter: w, r: r}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		session, err := r.Cookie(sessionCookie)
+		if err != nil {
+			if _, err := r.Cookie(authCookie); err == nil {
+				wiki.serveError(w, forbidden("the request has no session to check it came from the wiki, reload the form and send it again"))
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		sent := r.Header.Get(csrfHeader)
+		if mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); sent == "" && mediaType == "application/x-www-form-urlencoded" {
+			sent = r.PostFormValue(csrfField)
+		} else if sent == "" && mediaType == "multipart/form-data" {
+			sent = multipartToken(r, params["boundary"])
+		}
+		if subtle.ConstantTimeCompare([]byte(sent), []byte(csrfToken(session.Value))) != 1 {
+			wiki.serveError(w, forbidden("the request didn't come from a page of the wiki, reload the form and send it again"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// multipartToken returns the CSRF field of a multipart body when it's the first
+// part, reading no more than csrfPeekBytes of it. The body is read again in full
+// by the handler, that alone limits it.
+func multipartToken(r *http.Request, boundary string) string {
+	var peeked bytes.Buffer
+	parts := multipart.NewReader(io.TeeReader(io.LimitReader(r.Body, csrfPeekBytes), &peeked), boundary)
+	var token []byte
+	if part, err := parts.NextPart(); err == nil && part.FormName() == csrfField {
+		token, _ = io.ReadAll(io.LimitReader(part, int64(hex.EncodedLen(sha256.Size))))
+	}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(&peeked, r.Body), r.Body}
+	return string(token)
+}
+
+// csrfWriter gives renderTemplate the token of the request's session, issuing a
+// session when a page with a form needs one.
+type csrfWriter struct {
+	http.ResponseWriter
+	r *http.Request
+}
+
+func (cw *csrfWriter) token() string {
+	return csrfToken(sessionID(cw.ResponseWriter, cw.r))
+}
+
+func (cw *csrfWriter) Flush() {
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (cw *csrfWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+var postForm = regexp.MustCompile(`(?i)<form\b[^>]*\bmethod="post"[^>]*>`)
+
+// addCSRFTokens puts the token into every POST form of a rendered page, as a
+// hidden field right after its tag, which makes it the first field of the form.
+func addCSRFTokens(page []byte, token string) []byte {
+	field := `<input type="hidden" name="` + csrfField + `" value="` + token + `">`
+	return postForm.ReplaceAllFunc(page, func(form []byte) []byte {
+		// form is a slice of the page, appending to it would overwrite what follows
+		return append(append([]byte(nil), form...), field...)
+	})
+}
+
+// formToken returns the CSRF token of the request a page is rendered for, "" when
+// it isn't rendered for a request.
+func formToken(w http.ResponseWriter) string {
+	for {
+		switch writer := w.(type) {
+		case *csrfWriter:
+			return writer.token()
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = writer.Unwrap()
+		default:
+			return ""
+		}
+	}
+}
+
+// csrfAPIHandler serves GET /api/v1/csrf, the token of the session, for a client
+// of the API that logged in with a cookie: it sends the token in the X-CSRF-Token
+// header of its PUT, POST and DELETE requests.
+func csrfAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET to get the token")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"token": formToken(w)})
+}
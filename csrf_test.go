@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRFTokenOfFileUploads(t *testing.T) {
+	page := addCSRFTokens([]byte(`<form action="/attachments/Home" method="POST" enctype="multipart/form-data"><input type="file" name="file"></form>`), "token")
+	if bytes.Contains(page, []byte("csrf=")) || !bytes.Contains(page, []byte(`<input type="hidden" name="csrf" value="token"><input type="file"`)) {
+		t.Errorf("the upload form is %s, want the token in its first field", page)
+	}
+
+	session := &http.Cookie{Name: sessionCookie, Value: "csrf-session"}
+	handler := testWiki.checkCSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("the handler could not read the upload: %v", err)
+			return
+		}
+		content, _ := io.ReadAll(file)
+		if string(content) != "the attachment" {
+			t.Errorf("the handler read the upload as %q", content)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	upload := func(target, token string) int {
+		var body bytes.Buffer
+		parts := multipart.NewWriter(&body)
+		if token != "" {
+			parts.WriteField(csrfField, token)
+		}
+		file, _ := parts.CreateFormFile("file", "note.txt")
+		io.WriteString(file, "the attachment")
+		parts.Close()
+		request := httptest.NewRequest(http.MethodPost, target, &body)
+		request.Header.Set("Content-Type", parts.FormDataContentType())
+		request.AddCookie(session)
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response.Code
+	}
+	if code := upload("/attachments/Home", csrfToken(session.Value)); code != http.StatusNoContent {
+		t.Errorf("an upload with the token in its first field = %d, want %d", code, http.StatusNoContent)
+	}
+	if code := upload("/attachments/Home?csrf="+csrfToken(session.Value), ""); code != http.StatusForbidden {
+		t.Errorf("an upload with the token in its query = %d, want %d", code, http.StatusForbidden)
+	}
+}
+
+func TestAdminTokenIsNotTakenFromTheURL(t *testing.T) {
+	previous := testWiki.config.AdminToken
+	testWiki.config.AdminToken = "admin-secret"
+	defer func() { testWiki.config.AdminToken = previous }()
+
+	fromQuery := httptest.NewRequest(http.MethodGet, "/export?admin_token=admin-secret", nil)
+	form := httptest.NewRequest(http.MethodPost, "/export", strings.NewReader(url.Values{"admin_token": {"admin-secret"}}.Encode()))
+	form.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	header := httptest.NewRequest(http.MethodGet, "/export", nil)
+	header.Header.Set("X-Admin-Token", "admin-secret")
+	for _, test := range []struct {
+		name    string
+		request *http.Request
+		admin   bool
+	}{
+		{"the query", fromQuery, false},
+		{"a posted form", form, true},
+		{"the header", header, true},
+	} {
+		if admin := testWiki.isAdmin(test.request); admin != test.admin {
+			t.Errorf("the admin token in %s makes an admin: %t, want %t", test.name, admin, test.admin)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// registerDebugRoutes mounts the profiles of net/http/pprof below /debug/pprof/
+// when -pprof is set, for admins only: with the admin token in the
+// X-Admin-Token header, curl fetches /debug/pprof/profile?seconds=30 and the
+// like for go tool pprof to read. A profile may take no longer than the
+// server's writeTimeout.
+func (wiki *Wiki) registerDebugRoutes(mux *http.ServeMux) {
+	if !wiki.config.Pprof {
+		return
+	}
+	mux.Handle("/debug/pprof/", wiki.adminOnly(http.HandlerFunc(pprof.Index)))
+	mux.Handle("/debug/pprof/cmdline", wiki.adminOnly(http.HandlerFunc(pprof.Cmdline)))
+	mux.Handle("/debug/pprof/profile", wiki.adminOnly(http.HandlerFunc(pprof.Profile)))
+	mux.Handle("/debug/pprof/symbol", wiki.adminOnly(http.HandlerFunc(pprof.Symbol)))
+	mux.Handle("/debug/pprof/trace", wiki.adminOnly(http.HandlerFunc(pprof.Trace)))
+}
+
+// adminOnly answers the requests of anybody but an admin 403.
+func (wiki *Wiki) adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !wiki.isAdmin(r) {
+			wiki.serveError(w, forbidden("only admins can profile the wiki"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,55 @@
+package main
+
+import "net/http"
+
+// deletePage moves the page from the store to the trash, and takes it out of the
+// title registry and, by the page hooks, the indexes. Its history is kept, ending in an empty
+// revision recording the deletion, so the page can be looked up and restored
+// later, from the trash until it's purged, and from its history after.
+func (wiki *Wiki) deletePage(title, author string) error {
+	if err := wiki.recordBaseline(title); err != nil {
+		return err
+	}
+	page, err := wiki.peek(title)
+	if err != nil {
+		return err
+	}
+	if err := wiki.trashPage(page, author); err != nil {
+		return err
+	}
+	if err := deleteAs(wiki.store, title, author, ""); err != nil {
+		return err
+	}
+	wiki.titles.Remove(title)
+	wiki.afterDelete(title, author)
+	revision, err := wiki.recordRevision(&Page{Title: title}, author, "deleted")
+	if err != nil {
+		return err
+	}
+	return wiki.journalRevision("delete", title, revision)
+}
+
+// deleteHandler serves /delete/{title}: GET asks for confirmation, POST deletes
+// the page.
+func (wiki *Wiki) deleteHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if !wiki.titles.Has(title) {
+		wiki.serveError(w, notFound(""))
+		return
+	}
+	if ok, reason := wiki.canEdit(r, title); !ok {
+		wiki.serveError(w, forbidden(reason))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		wiki.renderTemplate(w, "delete.html", &Page{Title: title})
+	case http.MethodPost:
+		if err := wiki.deletePage(title, wiki.requestAuthor(r)); err != nil {
+			wiki.serveError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusFound)
+	default:
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+	}
+}
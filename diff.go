@@ -0,0 +1,342 @@
+package main
+
+import (
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// DiffLine is one line of a line-level diff: Op is "=" for a line both versions
+// share, "-" for a line only the old version has and "+" for one only the new
+// version has. OldLine and NewLine are 1-based, 0 where the line doesn't exist.
+// A word-level diff is made of the same, a run of words each, without lines.
+type DiffLine struct {
+	Op      string
+	Text    string
+	OldLine int
+	NewLine int
+}
+
+// DiffAlgorithm computes the diff of two sequences of tokens, the lines or the
+// words of two texts, as the tokens of both in order, each with its Op. The line
+// numbers are left to numberDiff.
+type DiffAlgorithm interface {
+	Diff(oldTokens, newTokens []string) []DiffLine
+}
+
+// diffAlgorithms are the algorithms a diff can be computed with, by name. lcs
+// finds the longest common subsequence, as the wiki always did; myers the
+// shortest edit script, faster on large texts; and patience anchors on the lines
+// both versions have once, which keeps a moved or rewritten block together.
+var diffAlgorithms = map[string]DiffAlgorithm{"lcs": lcsDiff{}, "myers": myersDiff{}, "patience": patienceDiff{}}
+
+const defaultDiffAlgorithm = "lcs"
+
+// diffAlgorithmNames returns the names of the algorithms, for the diff page.
+func diffAlgorithmNames() []string {
+	return slices.Sorted(maps.Keys(diffAlgorithms))
+}
+
+// diffLines computes the line diff of two texts.
+func diffLines(oldText, newText string) []DiffLine {
+	return diffLinesWith(diffAlgorithms[defaultDiffAlgorithm], oldText, newText)
+}
+
+func diffLinesWith(algorithm DiffAlgorithm, oldText, newText string) []DiffLine {
+	return numberDiff(algorithm.Diff(splitLines(oldText), splitLines(newText)))
+}
+
+// numberDiff numbers the lines of a diff in the version, or versions, they're in.
+func numberDiff(diff []DiffLine) []DiffLine {
+	oldLine, newLine := 0, 0
+	for idx := range diff {
+		diff[idx].OldLine, diff[idx].NewLine = 0, 0
+		if diff[idx].Op != "+" {
+			oldLine++
+			diff[idx].OldLine = oldLine
+		}
+		if diff[idx].Op != "-" {
+			newLine++
+			diff[idx].NewLine = newLine
+		}
+	}
+	return diff
+}
+
+// wordToken splits prose into words, runs of whitespace and single punctuation marks.
+var wordToken = regexp.MustCompile(`[\p{L}\p{M}\p{N}_]+|\s+|[^\p{L}\p{M}\p{N}_\s]`)
+
+// diffWords computes the word diff of two texts: the runs of words both versions
+// share, or only one of them has, without line numbers. Prose reads better this
+// way than as whole lines changed for one word.
+func diffWords(algorithm DiffAlgorithm, oldText, newText string) []DiffLine {
+	var runs []DiffLine
+	for _, token := range algorithm.Diff(wordToken.FindAllString(oldText, -1), wordToken.FindAllString(newText, -1)) {
+		if last := len(runs) - 1; last >= 0 && runs[last].Op == token.Op {
+			runs[last].Text += token.Text
+			continue
+		}
+		runs = append(runs, DiffLine{Op: token.Op, Text: token.Text})
+	}
+	return runs
+}
+
+// lcsDiff computes the diff from the longest common subsequence of the tokens.
+type lcsDiff struct{}
+
+func (lcsDiff) Diff(oldLines, newLines []string) []DiffLine {
+	// common[i][j] is the length of the longest common subsequence of
+	// oldLines[i:] and newLines[j:].
+	common := make([][]int, len(oldLines)+1)
+	for i := range common {
+		common[i] = make([]int, len(newLines)+1)
+	}
+	for i := len(oldLines) - 1; i >= 0; i-- {
+		for j := len(newLines) - 1; j >= 0; j-- {
+			if oldLines[i] == newLines[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	var diff []DiffLine
+	i, j := 0, 0
+	for i < len(oldLines) || j < len(newLines) {
+		switch {
+		case i < len(oldLines) && j < len(newLines) && oldLines[i] == newLines[j]:
+			diff = append(diff, DiffLine{Op: "=", Text: oldLines[i]})
+			i, j = i+1, j+1
+		case i < len(oldLines) && (j == len(newLines) || common[i+1][j] >= common[i][j+1]):
+			diff = append(diff, DiffLine{Op: "-", Text: oldLines[i]})
+			i++
+		default:
+			diff = append(diff, DiffLine{Op: "+", Text: newLines[j]})
+			j++
+		}
+	}
+	return diff
+}
+
+// myersDiff computes the shortest edit script of the tokens with Myers' O(ND)
+// algorithm, in O(N+M) space per edit.
+type myersDiff struct{}
+
+func (myersDiff) Diff(oldTokens, newTokens []string) []DiffLine {
+	n, m := len(oldTokens), len(newTokens)
+	offset := n + m + 1
+	// furthest[offset+k] is the furthest x reached on diagonal k = x-y; trace
+	// keeps it as it was before every round, to walk the edits back.
+	furthest := make([]int, 2*offset+1)
+	var trace [][]int
+search:
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, slices.Clone(furthest))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && furthest[offset+k-1] < furthest[offset+k+1] {
+				x = furthest[offset+k+1] // down, an insertion
+			} else {
+				x = furthest[offset+k-1] + 1 // right, a deletion
+			}
+			y := x - k
+			for x < n && y < m && oldTokens[x] == newTokens[y] {
+				x, y = x+1, y+1
+			}
+			furthest[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	var reversed []DiffLine
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		previous := trace[d]
+		k := x - y
+		prevK := k - 1
+		if k == -d || k != d && previous[offset+k-1] < previous[offset+k+1] {
+			prevK = k + 1
+		}
+		prevX := previous[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			reversed = append(reversed, DiffLine{Op: "=", Text: oldTokens[x-1]})
+			x, y = x-1, y-1
+		}
+		if d == 0 {
+			break
+		}
+		if x == prevX {
+			reversed = append(reversed, DiffLine{Op: "+", Text: newTokens[y-1]})
+		} else {
+			reversed = append(reversed, DiffLine{Op: "-", Text: oldTokens[x-1]})
+		}
+		x, y = prevX, prevY
+	}
+	slices.Reverse(reversed)
+	return reversed
+}
+
+// patienceDiff computes the diff from the tokens both versions have exactly once,
+// in the longest order they share; between those anchors it recurses, and a
+// stretch without any is left to myersDiff.
+type patienceDiff struct{}
+
+func (patience patienceDiff) Diff(oldTokens, newTokens []string) []DiffLine {
+	var diff []DiffLine
+	// the common start and end
+	start := 0
+	for start < len(oldTokens) && start < len(newTokens) && oldTokens[start] == newTokens[start] {
+		diff = append(diff, DiffLine{Op: "=", Text: oldTokens[start]})
+		start++
+	}
+	end := 0
+	for end < len(oldTokens)-start && end < len(newTokens)-start && oldTokens[len(oldTokens)-1-end] == newTokens[len(newTokens)-1-end] {
+		end++
+	}
+	oldMiddle, newMiddle := oldTokens[start:len(oldTokens)-end], newTokens[start:len(newTokens)-end]
+
+	anchors := uniqueAnchors(oldMiddle, newMiddle)
+	if len(anchors) == 0 {
+		diff = append(diff, myersDiff{}.Diff(oldMiddle, newMiddle)...)
+	} else {
+		i, j := 0, 0
+		for _, anchor := range anchors {
+			diff = append(diff, patience.Diff(oldMiddle[i:anchor[0]], newMiddle[j:anchor[1]])...)
+			diff = append(diff, DiffLine{Op: "=", Text: oldMiddle[anchor[0]]})
+			i, j = anchor[0]+1, anchor[1]+1
+		}
+		diff = append(diff, patience.Diff(oldMiddle[i:], newMiddle[j:])...)
+	}
+	for _, token := range oldTokens[len(oldTokens)-end:] {
+		diff = append(diff, DiffLine{Op: "=", Text: token})
+	}
+	return diff
+}
+
+// uniqueAnchors returns the positions in both of the tokens each has exactly once,
+// the longest sequence of them that's in the same order in both.
+func uniqueAnchors(oldTokens, newTokens []string) [][2]int {
+	counts := make(map[string][2]int)
+	positions := make(map[string][2]int)
+	for idx, token := range oldTokens {
+		count := counts[token]
+		count[0]++
+		counts[token] = count
+		position := positions[token]
+		position[0] = idx
+		positions[token] = position
+	}
+	for idx, token := range newTokens {
+		count := counts[token]
+		count[1]++
+		counts[token] = count
+		position := positions[token]
+		position[1] = idx
+		positions[token] = position
+	}
+	var pairs [][2]int
+	for _, token := range oldTokens {
+		if counts[token] == [2]int{1, 1} {
+			pairs = append(pairs, positions[token])
+		}
+	}
+	// patience sorting: the longest increasing subsequence of the new positions
+	var piles []int                 // the index in pairs of the top of every pile
+	back := make([]int, len(pairs)) // the top of the previous pile when a pair was put down
+	for idx, pair := range pairs {
+		pile, _ := slices.BinarySearchFunc(piles, pair[1], func(top, target int) int { return pairs[top][1] - target })
+		if pile > 0 {
+			back[idx] = piles[pile-1]
+		} else {
+			back[idx] = -1
+		}
+		if pile == len(piles) {
+			piles = append(piles, idx)
+		} else {
+			piles[pile] = idx
+		}
+	}
+	if len(piles) == 0 {
+		return nil
+	}
+	anchors := make([][2]int, len(piles))
+	for idx, at := len(piles)-1, piles[len(piles)-1]; idx >= 0; idx, at = idx-1, back[at] {
+		anchors[idx] = pairs[at]
+	}
+	return anchors
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(strings.ReplaceAll(text, "\r\n", "\n"), "\n"), "\n")
+}
+
+// merge3 merges the changes two versions made to their common base, line by line:
+// between the lines neither changed, a region only one of them changed takes its
+// change, and a region both changed differently is a conflict, written between
+// conflict markers whose labels name the two versions. It returns the merged text
+// and the number of conflicts.
+func merge3(base, mine, theirs, mineLabel, theirsLabel string) (string, int) {
+	baseLines, mineLines, theirsLines := splitLines(base), splitLines(mine), splitLines(theirs)
+	mineMatch, theirsMatch := matchedLines(base, mine), matchedLines(base, theirs)
+
+	var merged []string
+	conflicts := 0
+	i, a, b := 0, 0, 0
+	for {
+		// the next base line both versions kept is where the regions resynchronize
+		j := i
+		for j < len(baseLines) && (mineMatch[j] < 0 || theirsMatch[j] < 0) {
+			j++
+		}
+		endMine, endTheirs := len(mineLines), len(theirsLines)
+		if j < len(baseLines) {
+			endMine, endTheirs = mineMatch[j], theirsMatch[j]
+		}
+		baseRegion, mineRegion, theirsRegion := baseLines[i:j], mineLines[a:endMine], theirsLines[b:endTheirs]
+		switch {
+		case slices.Equal(mineRegion, baseRegion):
+			merged = append(merged, theirsRegion...)
+		case slices.Equal(theirsRegion, baseRegion), slices.Equal(mineRegion, theirsRegion):
+			merged = append(merged, mineRegion...)
+		default:
+			conflicts++
+			merged = append(merged, "<<<<<<< "+mineLabel)
+			merged = append(merged, mineRegion...)
+			merged = append(merged, "=======")
+			merged = append(merged, theirsRegion...)
+			merged = append(merged, ">>>>>>> "+theirsLabel)
+		}
+		if j == len(baseLines) {
+			break
+		}
+		merged = append(merged, baseLines[j])
+		i, a, b = j+1, endMine+1, endTheirs+1
+	}
+	if len(merged) == 0 {
+		return "", conflicts
+	}
+	return strings.Join(merged, "\n") + "\n", conflicts
+}
+
+// matchedLines maps every line of oldText to the 0-based line of newText the diff
+// keeps it as, or -1 where the line is removed.
+func matchedLines(oldText, newText string) []int {
+	matched := make([]int, len(splitLines(oldText)))
+	for idx := range matched {
+		matched[idx] = -1
+	}
+	for _, line := range diffLines(oldText, newText) {
+		if line.Op == "=" {
+			matched[line.OldLine-1] = line.NewLine - 1
+		}
+	}
+	return matched
+}
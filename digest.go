@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// digestCheckInterval is how often the scheduler looks for digests that are due.
+	digestCheckInterval = time.Hour
+	// digestMostEdited bounds how many of the most edited pages a digest lists.
+	digestMostEdited = 5
+	// digestDiffLines bounds how many changed lines a digest shows per watched page.
+	digestDiffLines = 10
+)
+
+// digestFrequencies are the periods a user can have their digest mailed at.
+var digestFrequencies = map[string]time.Duration{
+	"daily":  24 * time.Hour,
+	"weekly": 7 * 24 * time.Hour,
+}
+
+// DigestPreferences are a user's settings for the activity digest: where and how
+// often it's mailed, and the pages they watch, the stars of the view page. Notify
+// is how they're told of every change to those as it's made besides, one of
+// watchNotifications. LastSent is when the last digest was compiled; the next one
+// covers the activity since.
+type DigestPreferences struct {
+	Email     string    `json:"email,omitempty"`
+	Frequency string    `json:"frequency,omitempty"`
+	Watchlist []string  `json:"watchlist,omitempty"`
+	Notify    string    `json:"notify,omitempty"`
+	LastSent  time.Time `json:"lastSent,omitzero"`
+}
+
+func (wiki *Wiki) loadDigests() error {
+	data, err := os.ReadFile(wiki.dataPath("digests.json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &wiki.digests.byUser)
+}
+
+// saveDigests must be called with digests locked.
+func (wiki *Wiki) saveDigests() error {
+	data, err := json.MarshalIndent(wiki.digests.byUser, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(wiki.dataPath("digests.json"), data, 0600)
+}
+
+func (wiki *Wiki) digestPreferencesOf(user string) DigestPreferences {
+	wiki.digests.Lock()
+	defer wiki.digests.Unlock()
+	return wiki.digests.byUser[user]
+}
+
+// setDigestPreferences stores where and how often the user's digest is mailed. An
+// empty frequency turns the digest off.
+func (wiki *Wiki) setDigestPreferences(user, email, frequency string) error {
+	if _, ok := digestFrequencies[frequency]; !ok && frequency != "" {
+		return fmt.Errorf("a digest is sent daily or weekly, not %q", frequency)
+	}
+	if email != "" {
+		address, err := mail.ParseAddress(email)
+		if err != nil {
+			return fmt.Errorf("%q is not an e-mail address", email)
+		}
+		email = address.Address
+	}
+	if frequency != "" && email == "" {
+		return errors.New("give an e-mail address to send the digest to")
+	}
+	wiki.digests.Lock()
+	defer wiki.digests.Unlock()
+	prefs := wiki.digests.byUser[user]
+	prefs.Email, prefs.Frequency = email, frequency
+	wiki.digests.byUser[user] = prefs
+	return wiki.saveDigests()
+}
+
+// watch adds the page to the user's watchlist, or takes it off.
+func (wiki *Wiki) watch(user, title string, watching bool) error {
+	wiki.digests.Lock()
+	defer wiki.digests.Unlock()
+	prefs := wiki.digests.byUser[user]
+	prefs.Watchlist = slices.DeleteFunc(prefs.Watchlist, func(watched string) bool { return watched == title })
+	if watching {
+		prefs.Watchlist = append(prefs.Watchlist, title)
+		slices.Sort(prefs.Watchlist)
+	}
+	wiki.digests.byUser[user] = prefs
+	return wiki.saveDigests()
+}
+
+// watchHandler serves POST /watch/{title}, which stars the page, with
+// action=unwatch to stop watching it.
+func (wiki *Wiki) watchHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	user := wiki.currentUser(r)
+	if user == "" {
+		http.Error(w, translate(wiki.requestLocale(w), "error.watchLogIn"), http.StatusUnauthorized)
+		return
+	}
+	if err := wiki.watch(user, title, r.FormValue("action") != "unwatch"); err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	next := "/view/" + title
+	switch r.FormValue("next") {
+	case "profile":
+		next = "/users/" + user
+	case "watchlist":
+		next = "/watchlist"
+	}
+	http.Redirect(w, r, next, http.StatusFound)
+}
+
+// Digest is the wiki activity of a period compiled for a user.
+type Digest struct {
+	User       string
+	Since      time.Time
+	Until      time.Time
+	NewPages   []string
+	MostEdited []EditCount
+	Watched    []WatchedChange
+}
+
+// EditCount is how often a page was edited in the period of a digest.
+type EditCount struct {
+	Title string
+	Edits int
+}
+
+// WatchedChange is how a watched page changed in the period of a digest.
+type WatchedChange struct {
+	Title   string
+	Edits   int
+	Authors []string
+	Lines   []DiffLine
+	More    int
+}
+
+func (digest Digest) empty() bool {
+	return len(digest.NewPages) == 0 && len(digest.MostEdited) == 0 && len(digest.Watched) == 0
+}
+
+// compileDigest collects the activity after since up to until: the pages created,
+// the pages edited most, and the changes to the pages the user watches. Other
+// users' sandboxes are left out.
+func (wiki *Wiki) compileDigest(user string, prefs DigestPreferences, since, until time.Time) (Digest, error) {
+	digest := Digest{User: user, Since: since, Until: until}
+	for _, title := range wiki.titles.List() {
+		if !(viewer{wiki: wiki, user: user}).lists(title) {
+			continue
+		}
+		revisions, err := wiki.loadRevisions(title)
+		if err != nil {
+			return Digest{}, err
+		}
+		before, after := "", ""
+		var edits int
+		var authors []string
+		for _, revision := range revisions {
+			if !revision.Time.After(since) {
+				before = revision.Body
+				continue
+			}
+			if revision.Time.After(until) {
+				break
+			}
+			// the revision of a page saved before the history was kept isn't an edit
+			if revision.Author == "" {
+				before = revision.Body
+				continue
+			}
+			if revision.ID == 1 {
+				digest.NewPages = append(digest.NewPages, title)
+			}
+			edits++
+			after = revision.Body
+			if !slices.Contains(authors, revision.Author) {
+				authors = append(authors, revision.Author)
+			}
+		}
+		if edits == 0 {
+			continue
+		}
+		digest.MostEdited = append(digest.MostEdited, EditCount{title, edits})
+		if !slices.Contains(prefs.Watchlist, title) {
+			continue
+		}
+		change := WatchedChange{Title: title, Edits: edits, Authors: authors}
+		for _, line := range diffLines(before, after) {
+			if line.Op == "=" {
+				continue
+			}
+			if len(change.Lines) == digestDiffLines {
+				change.More++
+				continue
+			}
+			change.Lines = append(change.Lines, line)
+		}
+		digest.Watched = append(digest.Watched, change)
+	}
+	slices.SortStableFunc(digest.MostEdited, func(a, b EditCount) int { return b.Edits - a.Edits })
+	digest.MostEdited = digest.MostEdited[:min(len(digest.MostEdited), digestMostEdited)]
+	return digest, nil
+}
+
+// digestText is the digest as the body of a plain-text mail.
+func (wiki *Wiki) digestText(digest Digest) string {
+	var body strings.Builder
+	link := func(title string) string {
+		return strings.TrimSuffix(wiki.config.BaseURL, "/") + "/view/" + title
+	}
+	fmt.Fprintf(&body, "Hello %s,\n\nthis is what happened on the wiki from %s to %s.\n",
+		digest.User, digest.Since.Format("2006-01-02 15:04 MST"), digest.Until.Format("2006-01-02 15:04 MST"))
+	if len(digest.NewPages) > 0 {
+		body.WriteString("\nNew pages\n\n")
+		for _, title := range digest.NewPages {
+			fmt.Fprintf(&body, "  %s  %s\n", wiki.displayTitle(title), link(title))
+		}
+	}
+	if len(digest.MostEdited) > 0 {
+		body.WriteString("\nMost edited\n\n")
+		for _, count := range digest.MostEdited {
+			fmt.Fprintf(&body, "  %s, %d edits  %s\n", wiki.displayTitle(count.Title), count.Edits, link(count.Title))
+		}
+	}
+	if len(digest.Watched) > 0 {
+		body.WriteString("\nOn your watchlist\n")
+		for _, change := range digest.Watched {
+			fmt.Fprintf(&body, "\n  %s, %d edits by %s  %s\n", wiki.displayTitle(change.Title), change.Edits, strings.Join(change.Authors, ", "), link(change.Title))
+			for _, line := range change.Lines {
+				fmt.Fprintf(&body, "    %s %s\n", line.Op, line.Text)
+			}
+			if change.More > 0 {
+				fmt.Fprintf(&body, "    ... and %d more changed lines\n", change.More)
+			}
+		}
+	}
+	fmt.Fprintf(&body, "\nChange how often you get this mail on %s/users/%s\n", strings.TrimSuffix(wiki.config.BaseURL, "/"), digest.User)
+	return body.String()
+}
+
+// due reports whether the user's digest should be sent at now, and the start of
+// the period it covers. Digests are sent at the configured hour, so a daily
+// digest counts as due a little less than a day after the last.
+func (prefs DigestPreferences) due(now time.Time) (time.Time, bool) {
+	period, ok := digestFrequencies[prefs.Frequency]
+	if !ok || prefs.Email == "" {
+		return time.Time{}, false
+	}
+	if prefs.LastSent.IsZero() {
+		return now.Add(-period), true
+	}
+	return prefs.LastSent, now.Sub(prefs.LastSent) > period-digestCheckInterval
+}
+
+// sendDigest mails the digest, unless nothing happened, and remembers when.
+func (wiki *Wiki) sendDigest(user string, digest Digest) error {
+	prefs := wiki.digestPreferencesOf(user)
+	if !digest.empty() {
+		if wiki.mailer == nil {
+			return errors.New("configure a mailer with -smtp-addr to send digests")
+		}
+		subject := fmt.Sprintf("Wiki activity of %s", digest.Until.Format("2006-01-02"))
+		if err := wiki.mailer.Send(prefs.Email, subject, wiki.digestText(digest)); err != nil {
+			return err
+		}
+	}
+	wiki.digests.Lock()
+	defer wiki.digests.Unlock()
+	prefs = wiki.digests.byUser[user]
+	prefs.LastSent = digest.Until
+	wiki.digests.byUser[user] = prefs
+	return wiki.saveDigests()
+}
+
+// sendDueDigests compiles and mails the digests that are due at now.
+func (wiki *Wiki) sendDueDigests(now time.Time) {
+	wiki.digests.Lock()
+	users := make([]string, 0, len(wiki.digests.byUser))
+	for user := range wiki.digests.byUser {
+		users = append(users, user)
+	}
+	wiki.digests.Unlock()
+	slices.Sort(users)
+	for _, user := range users {
+		prefs := wiki.digestPreferencesOf(user)
+		since, ok := prefs.due(now)
+		if !ok {
+			continue
+		}
+		digest, err := wiki.compileDigest(user, prefs, since, now)
+		if err == nil {
+			err = wiki.sendDigest(user, digest)
+		}
+		if err != nil {
+			log.Printf("could not send the digest of %s: %v", user, err)
+		}
+	}
+}
+
+// runDigests mails the due digests at the configured hour of every day, until
+// ctx is done. Without a mailer, it does nothing.
+func (wiki *Wiki) runDigests(ctx context.Context) {
+	if wiki.mailer == nil {
+		return
+	}
+	ticker := time.NewTicker(digestCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if now.Hour() == wiki.config.DigestHour {
+				wiki.sendDueDigests(now)
+			}
+		}
+	}
+}
+
+// runDigest is the digest command: it prints the digest a user would get now,
+// and mails it with -send.
+func (wiki *Wiki) runDigest(args []string) error {
+	flags := flag.NewFlagSet("digest", flag.ContinueOnError)
+	user := flags.String("user", "", "the user to compile the digest for")
+	since := flags.Duration("since", 0, "cover this long a period instead of the one since the last digest")
+	send := flags.Bool("send", false, "mail the digest instead of only printing it")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if !wiki.hasUser(*user) {
+		return fmt.Errorf("there's no user %q", *user)
+	}
+	now := clock.Now()
+	prefs := wiki.digestPreferencesOf(*user)
+	start, _ := prefs.due(now)
+	switch {
+	case *since > 0:
+		start = now.Add(-*since)
+	case start.IsZero():
+		start = now.Add(-digestFrequencies["daily"])
+	}
+	digest, err := wiki.compileDigest(*user, prefs, start, now)
+	if err != nil {
+		return err
+	}
+	fmt.Print(wiki.digestText(digest))
+	if !*send {
+		return nil
+	}
+	if prefs.Email == "" {
+		return fmt.Errorf("%s hasn't given an e-mail address", *user)
+	}
+	return wiki.sendDigest(*user, digest)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// listedEntries returns the entries of the page index the request's viewer is
+// shown, sorted by title, without the sandboxes, as the front page lists them.
+func (wiki *Wiki) listedEntries(r *http.Request) []PageEntry {
+	v, acl := wiki.viewerOf(r), wiki.currentACL()
+	var listed []PageEntry
+	for _, entry := range wiki.pageIndex.all() {
+		if !isSandbox(entry.Title) && v.listsWith(acl, entry.Title) {
+			listed = append(listed, entry)
+		}
+	}
+	return listed
+}
+
+// randomHandler serves /random, which sends the visitor to a page picked at
+// random of those the front page lists for them.
+func (wiki *Wiki) randomHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	listed := wiki.listedEntries(r)
+	if len(listed) == 0 {
+		wiki.serveError(w, notFound("there are no pages to pick from yet"))
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, "/view/"+listed[rand.Intn(len(listed))].Title, http.StatusFound)
+}
+
+// StubsPage is the data of the stubs template: the pages shorter than Max
+// bytes, shortest first.
+type StubsPage struct {
+	Max     int
+	Entries []PageEntry
+}
+
+// stubsHandler serves /stubs, the pages the viewer is shown that are shorter
+// than -stub-bytes, or than ?max=, for somebody to write more of. The index
+// knows their sizes; only those short enough are read, to leave out the
+// redirects.
+func (wiki *Wiki) stubsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	stubs := StubsPage{Max: wiki.config.StubBytes}
+	if value := r.URL.Query().Get("max"); value != "" {
+		max, err := strconv.Atoi(value)
+		if err != nil || max < 1 {
+			http.Error(w, translate(wiki.requestLocale(w), "error.stubsMax", value), http.StatusBadRequest)
+			return
+		}
+		stubs.Max = max
+	}
+	for _, entry := range wiki.listedEntries(r) {
+		if entry.Size >= stubs.Max {
+			continue
+		}
+		if page, err := wiki.load(entry.Title); err == nil && wiki.redirectTarget(page) != "" {
+			continue
+		}
+		stubs.Entries = append(stubs.Entries, entry)
+	}
+	sort.SliceStable(stubs.Entries, func(i, j int) bool { return stubs.Entries[i].Size < stubs.Entries[j].Size })
+	wiki.renderTemplate(w, "stubs.html", stubs)
+}
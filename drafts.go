@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Draft is the unsaved text of an edit, which the editor stores as it's typed so
+// that a crash or a click away doesn't lose it. Base is the revision token of the
+// version the edit started from.
+type Draft struct {
+	Body         string    `json:"body"`
+	DisplayTitle string    `json:"displayTitle,omitempty"`
+	Base         string    `json:"base,omitempty"`
+	Time         time.Time `json:"time"`
+}
+
+// draftFilename returns the file of a user's draft of a page, under drafts/{user}
+// in the data directory.
+func (wiki *Wiki) draftFilename(user, title string) string {
+	return wiki.dataPath("drafts", user, title+".json")
+}
+
+func (wiki *Wiki) loadDraft(user, title string) (*Draft, error) {
+	data, err := os.ReadFile(wiki.draftFilename(user, title))
+	if err != nil {
+		return nil, err
+	}
+	var draft Draft
+	if err := json.Unmarshal(data, &draft); err != nil {
+		return nil, err
+	}
+	return &draft, nil
+}
+
+func (wiki *Wiki) saveDraft(user, title string, draft Draft) error {
+	filename := wiki.draftFilename(user, title)
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(draft)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0600)
+}
+
+// discardDraft removes the user's draft of a page, once the edit is saved.
+func (wiki *Wiki) discardDraft(user, title string) error {
+	if err := os.Remove(wiki.draftFilename(user, title)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// discardSavedDraft discards the draft of an edit that's been saved. The edit is
+// stored either way, so a failure is only logged.
+func (wiki *Wiki) discardSavedDraft(r *http.Request, title string) {
+	if user := wiki.currentUser(r); user != "" {
+		if err := wiki.discardDraft(user, title); err != nil {
+			log.Printf("could not discard the draft of %s by %s: %v", title, user, err)
+		}
+	}
+}
+
+// draftsHandler serves /api/v1/drafts/{title} to the logged in user: GET returns
+// their draft of the page, PUT or POST stores one from a JSON {"body": ...,
+// "displayTitle": ..., "base": ...}, and DELETE discards it. The same are served
+// under /api/drafts.
+func (wiki *Wiki) draftsHandler(w http.ResponseWriter, r *http.Request) {
+	title, ok := draftsAPITitle(r.URL.Path)
+	if !ok || !validTitle.MatchString(title) {
+		wiki.serveError(w, notFound(""))
+		return
+	}
+	user := wiki.currentUser(r)
+	if user == "" {
+		writeJSONError(w, http.StatusUnauthorized, "log in to keep drafts")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		draft, err := wiki.loadDraft(user, title)
+		if os.IsNotExist(err) {
+			writeJSONError(w, http.StatusNotFound, "there's no draft of "+title)
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, draft)
+	case http.MethodPut, http.MethodPost:
+		var draft Draft
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, wiki.config.MaxPageBytes+formOverheadBytes)).Decode(&draft); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "send the draft as JSON: "+err.Error())
+			return
+		}
+		draft.Time = clock.Now()
+		if err := wiki.saveDraft(user, title, draft); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if err := wiki.discardDraft(user, title); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET, PUT, POST or DELETE")
+	}
+}
+
+// draftsAPITitle returns the title of a path under /api/v1/drafts/ or /api/drafts/.
+func draftsAPITitle(path string) (string, bool) {
+	if title, ok := strings.CutPrefix(path, "/api/v1/drafts/"); ok {
+		return title, true
+	}
+	return strings.CutPrefix(path, "/api/drafts/")
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// loggedIn returns the cookie of a login of the user.
+func loggedIn(t *testing.T, user string) *http.Cookie {
+	t.Helper()
+	response := httptest.NewRecorder()
+	testWiki.logIn(response, httptest.NewRequest(http.MethodPost, "/login", nil), user)
+	for _, cookie := range response.Result().Cookies() {
+		if cookie.Name == authCookie {
+			return cookie
+		}
+	}
+	t.Fatalf("logging %s in set no %s cookie", user, authCookie)
+	return nil
+}
+
+func TestDraftsOfUnreadablePages(t *testing.T) {
+	savePages(t, map[string]string{
+		aclTitle:           "draftsecret editor alice\n",
+		"draftsecret/Plan": "the plan",
+	})
+	for _, user := range []string{"alice", "bob"} {
+		if err := testWiki.saveDraft(user, "draftsecret/Plan", Draft{Body: "the plan of " + user}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := testWiki.newRouter(context.Background())
+	for _, test := range []struct {
+		user   string
+		status int
+	}{
+		{"alice", http.StatusOK},
+		{"bob", http.StatusForbidden},
+	} {
+		cookie := loggedIn(t, test.user)
+		for _, path := range []string{"/api/v1/drafts/draftsecret/Plan", "/api/drafts/draftsecret/Plan"} {
+			request := httptest.NewRequest(http.MethodGet, path, nil)
+			request.AddCookie(cookie)
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, request)
+			if response.Code != test.status {
+				t.Errorf("GET %s as %s = %d, want %d", path, test.user, response.Code, test.status)
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// editLockTTL is how long an edit lock lasts unless the editor renews it, which
+// the edit page does every editLockTTL/3 while it's open.
+const editLockTTL = 3 * time.Minute
+
+// EditLock is the soft lock of a page open in a user's editor: everybody else is
+// told the page is being edited, and by whom, but may still edit it, or take the
+// lock over.
+type EditLock struct {
+	User    string    `json:"user"`
+	Since   time.Time `json:"since"`
+	Expires time.Time `json:"expires"`
+}
+
+// TakeEditLock locks the page for user until editLockTTL from now, or renews the
+// lock the user has. A lock of another user that hasn't expired is kept, and
+// returned with false, unless force takes it over.
+func (reg *TitleRegistry) TakeEditLock(title, user string, now time.Time, force bool) (EditLock, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	lock, locked := reg.locks[strings.ToLower(title)]
+	if locked && lock.User != user && now.Before(lock.Expires) && !force {
+		return lock, false
+	}
+	if !locked || lock.User != user || !now.Before(lock.Expires) {
+		lock = EditLock{User: user, Since: now}
+	}
+	lock.Expires = now.Add(editLockTTL)
+	reg.locks[strings.ToLower(title)] = lock
+	return lock, true
+}
+
+// ReleaseEditLock unlocks the page, if it's user who has it locked.
+func (reg *TitleRegistry) ReleaseEditLock(title, user string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if lock, ok := reg.locks[strings.ToLower(title)]; ok && lock.User == user {
+		delete(reg.locks, strings.ToLower(title))
+	}
+}
+
+// EditLockOf returns the lock of the page, unless it has none or it expired.
+func (reg *TitleRegistry) EditLockOf(title string, now time.Time) (EditLock, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	lock, ok := reg.locks[strings.ToLower(title)]
+	return lock, ok && now.Before(lock.Expires)
+}
+
+// lockRenewal is the "lockRenewal" template function: how many milliseconds
+// the edit page renews its lock after.
+func lockRenewal() int64 {
+	return (editLockTTL / 3).Milliseconds()
+}
+
+// lockHandler serves POST /lock/{title} to the editors of the page, with the
+// action of the form: "renew" keeps the lock of the edit page open, answering
+// 204, or 409 with who took it over; "takeover" takes the lock from whoever has
+// it and goes back to the editor; "release", the edit page's cancel, unlocks the
+// page and goes to it.
+func (wiki *Wiki) lockHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if ok, reason := wiki.canEdit(r, title); !ok {
+		wiki.serveError(w, forbidden(reason))
+		return
+	}
+	user := wiki.requestAuthor(r)
+	switch r.FormValue("action") {
+	case "renew":
+		if lock, ok := wiki.titles.TakeEditLock(title, user, clock.Now(), false); !ok {
+			http.Error(w, translate(wiki.requestLocale(w), "edit.lockTakenBy", lock.User), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "takeover":
+		wiki.titles.TakeEditLock(title, user, clock.Now(), true)
+		http.Redirect(w, r, "/edit/"+title, http.StatusSeeOther)
+	case "release":
+		wiki.titles.ReleaseEditLock(title, user)
+		http.Redirect(w, r, "/view/"+title, http.StatusSeeOther)
+	default:
+		http.Error(w, translate(wiki.requestLocale(w), "error.lockAction"), http.StatusBadRequest)
+	}
+}
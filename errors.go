@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// HTTPError is an error a handler answers with Status, telling the visitor
+// Message. Cause is what went wrong underneath, which is logged and not shown:
+// the errors of the store and the file system name the files of the data
+// directory.
+type HTTPError struct {
+	Status  int
+	Message string
+	Cause   error
+}
+
+func (err *HTTPError) Error() string {
+	if err.Cause == nil {
+		return err.Message
+	}
+	if err.Message == "" {
+		return err.Cause.Error()
+	}
+	return err.Message + ": " + err.Cause.Error()
+}
+
+func (err *HTTPError) Unwrap() error {
+	return err.Cause
+}
+
+func notFound(message string) error {
+	return &HTTPError{Status: http.StatusNotFound, Message: message}
+}
+
+func forbidden(message string) error {
+	return &HTTPError{Status: http.StatusForbidden, Message: message}
+}
+
+func conflict(message string) error {
+	return &HTTPError{Status: http.StatusConflict, Message: message}
+}
+
+func badRequest(message string) error {
+	return &HTTPError{Status: http.StatusBadRequest, Message: message}
+}
+
+// ErrorPage is the data of the error template.
+type ErrorPage struct {
+	Status    int
+	Heading   string
+	Message   string
+	RequestID string
+}
+
+// classifyError returns the status err is answered with, what the visitor is
+// told of it, and whether it's logged. The errors the wiki makes for the
+// visitor to read, like those of the quotas and the upload checks, are told as
+// they are; anything else is an internal error.
+func classifyError(err error) (int, string, bool) {
+	var httpErr *HTTPError
+	var quota *quotaError
+	var disallowed *attachmentPolicyError
+	var rejected *rejectedUploadError
+	switch {
+	case errors.As(err, &httpErr):
+		return httpErr.Status, httpErr.Message, httpErr.Cause != nil
+	case errors.As(err, &quota):
+		return http.StatusRequestEntityTooLarge, quota.Error(), false
+	case errors.As(err, &disallowed):
+		return disallowed.status, disallowed.Error(), false
+	case errors.As(err, &rejected):
+		return http.StatusUnprocessableEntity, rejected.Error(), false
+	case errors.Is(err, errScanFailed):
+		return http.StatusServiceUnavailable, errScanFailed.Error(), true
+	case tooLarge(err):
+		return http.StatusRequestEntityTooLarge, "", false
+	case errors.Is(err, fs.ErrNotExist):
+		return http.StatusNotFound, "", false
+	}
+	return http.StatusInternalServerError, "", true
+}
+
+// serveError answers the request with the error page of err, logging what went
+// wrong underneath with the request's ID for the admins to find it by.
+func (wiki *Wiki) serveError(w http.ResponseWriter, err error) {
+	status, message, logged := classifyError(err)
+	id := w.Header().Get(requestIDHeader)
+	if logged {
+		slog.Error("could not serve the request", "requestID", id, "status", status, "error", err.Error())
+	}
+	locale := wiki.requestLocale(w)
+	if message == "" {
+		message = statusMessage(locale, status)
+	}
+	heading := translate(locale, "error.status."+strconv.Itoa(status))
+	if heading == "error.status."+strconv.Itoa(status) {
+		heading = http.StatusText(status)
+	}
+	header := w.Header()
+	header.Del("Content-Length")
+	header.Del("ETag")
+	header.Del("Last-Modified")
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Cache-Control", "no-store")
+	page, renderErr := wiki.executeTemplate(w, "error.html", ErrorPage{Status: status, Heading: heading, Message: message, RequestID: id})
+	if renderErr != nil {
+		slog.Error("could not render the error page", "requestID", id, "error", renderErr.Error())
+		http.Error(w, message+" (request "+id+")", status)
+		return
+	}
+	header.Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(page)
+}
+
+// statusMessage is what the visitor is told of an error of the status that has
+// no message of its own.
+func statusMessage(locale string, status int) string {
+	if message := translate(locale, "error.message."+strconv.Itoa(status)); message != "error.message."+strconv.Itoa(status) {
+		return message
+	}
+	return translate(locale, "error.message.other")
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// bufferedResponse keeps what a page is rendered to, so it can be hashed before
+// it's sent. Unwrap lets renderTemplate find the CSRF token of the request.
+type bufferedResponse struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) WriteHeader(status int)         { b.status = status }
+func (b *bufferedResponse) Write(data []byte) (int, error) { return b.body.Write(data) }
+func (b *bufferedResponse) Unwrap() http.ResponseWriter    { return b.ResponseWriter }
+
+// serveConditional sends the page render writes, with Last-Modified, modified,
+// and a weak ETag, the hash of the page as render writes it when it's asked for
+// the page without what changes on every request, like the view count. It
+// answers If-None-Match and If-Modified-Since with 304 Not Modified.
+//
+// The hash is of the page rather than of the revision of its body, as the page
+// shows more than the body: the links to the other pages as they come and go,
+// the banner, and the forms with the token of the visitor's session, which is
+// why the answer varies by the cookies too. Browsers revalidate it every time,
+// and shared caches too unless it sets a cookie, which they don't keep.
+func serveConditional(w http.ResponseWriter, r *http.Request, modified time.Time, render func(w http.ResponseWriter, stable bool)) {
+	stable := &bufferedResponse{ResponseWriter: w, status: http.StatusOK}
+	render(stable, true)
+	if stable.status != http.StatusOK {
+		w.WriteHeader(stable.status)
+		w.Write(stable.body.Bytes())
+		return
+	}
+	page := &bufferedResponse{ResponseWriter: w, status: http.StatusOK}
+	render(page, false)
+	hash := sha256.Sum256(stable.body.Bytes())
+	w.Header().Set("ETag", `W/"`+hex.EncodeToString(hash[:16])+`"`)
+	w.Header().Add("Vary", "Cookie")
+	if len(w.Header().Values("Set-Cookie")) > 0 {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	// ServeContent answers If-None-Match and If-Modified-Since with 304 Not Modified.
+	http.ServeContent(w, r, "", modified, bytes.NewReader(page.body.Bytes()))
+}
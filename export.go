@@ -0,0 +1,317 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ExportPage is the data of the export template, one page of a static site.
+type ExportPage struct {
+	Title string
+	Body  template.HTML
+}
+
+// selectPages returns the titles in namespace ("" for every page outside the
+// sandboxes), sorted.
+func (wiki *Wiki) selectPages(namespace string) []string {
+	var selected []string
+	for _, title := range wiki.titles.List() {
+		if isSandbox(title) && !strings.HasPrefix(title, namespace+"/") {
+			continue
+		}
+		if namespace == "" || namespaceOf(title) == namespace || strings.HasPrefix(namespaceOf(title), namespace+"/") {
+			selected = append(selected, title)
+		}
+	}
+	return selected
+}
+
+// renderExportPage renders a page as a standalone HTML file. Links to exported
+// pages point to their files, links to the pages left out become plain text.
+func (wiki *Wiki) renderExportPage(p *Page, exported map[string]bool) ([]byte, error) {
+	body := wiki.viewLinkPattern.ReplaceAllStringFunc(string(wiki.rendererFor(p.Title, false).Render(p.Body)), func(link string) string {
+		match := wiki.viewLinkPattern.FindStringSubmatch(link)
+		if !exported[match[1]] {
+			return match[3]
+		}
+		return fmt.Sprintf(`<a href="%s.html%s">%s</a>`, match[1], match[2], match[3])
+	})
+	current, err := wiki.currentTemplates(wiki.config.Theme, wiki.config.Locale)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	err = current.ExecuteTemplate(&out, "export.html", ExportPage{Title: p.Title, Body: template.HTML(body)})
+	return out.Bytes(), err
+}
+
+// runExport implements "gowiki export": it writes the selected pages either as a
+// static site of HTML files into a directory, or as a .tar.gz archive of their
+// Markdown sources.
+func (wiki *Wiki) runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	namespace := flags.String("namespace", "", "export only the pages in this namespace and the namespaces below it")
+	format := flags.String("format", "site", `"site" for a directory of HTML pages, "archive" for a .tar.gz of the page sources`)
+	out := flags.String("out", "export", "the directory (site) or file (archive) to write")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	titles := wiki.selectPages(*namespace)
+	if len(titles) == 0 {
+		return fmt.Errorf("no pages match")
+	}
+	switch *format {
+	case "site":
+		err := wiki.exportSite(titles, *out)
+		if err == nil {
+			fmt.Printf("exported %d pages to %s\n", len(titles), *out)
+		}
+		return err
+	case "archive":
+		err := wiki.exportArchive(titles, *out)
+		if err == nil {
+			fmt.Printf("exported %d pages to %s\n", len(titles), *out)
+		}
+		return err
+	}
+	return fmt.Errorf("%q is an unknown format, expected site or archive", *format)
+}
+
+func (wiki *Wiki) exportSite(titles []string, dir string) error {
+	exported := make(map[string]bool)
+	for _, title := range titles {
+		exported[title] = true
+	}
+	for _, title := range titles {
+		page, err := wiki.load(title)
+		if err != nil {
+			return err
+		}
+		html, err := wiki.renderExportPage(page, exported)
+		if err != nil {
+			return err
+		}
+		filename := filepath.Join(dir, title+".html")
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filename, html, 0644); err != nil {
+			return err
+		}
+	}
+	listed := append([]string(nil), titles...)
+	wiki.sortListing(listed)
+	var index strings.Builder
+	index.WriteString("# Pages\n\n")
+	for _, title := range listed {
+		fmt.Fprintf(&index, "- [%s](/view/%s)\n", title, title)
+	}
+	html, err := wiki.renderExportPage(&Page{Title: "Pages", Body: []byte(index.String())}, exported)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "index.html"), html, 0644)
+}
+
+// relativeURL returns the URL of the file target from the page file from, both
+// relative to the root of the site.
+func relativeURL(from, target string) string {
+	var up strings.Builder
+	for range strings.Count(from, "/") {
+		up.WriteString("../")
+	}
+	return up.String() + target
+}
+
+// staticLinks makes the links of a page of the static site at filename relative:
+// links to exported pages point at their files, attachments at their copies
+// under files/ and the front page at index.html. The links to anything else,
+// the pages left out and what only the running wiki serves, become their text.
+func (wiki *Wiki) staticLinks(html, filename string, exported map[string]bool) string {
+	return wiki.absoluteLink.ReplaceAllStringFunc(html, func(link string) string {
+		match := wiki.absoluteLink.FindStringSubmatch(link)
+		if match[4] != "" {
+			return `src="` + relativeURL(filename, strings.TrimPrefix(match[4], "/")) + `"`
+		}
+		target, anchor, _ := strings.Cut(match[1], "#")
+		if anchor != "" {
+			anchor = "#" + anchor
+		}
+		switch {
+		case target == "/":
+			target = "index.html"
+		case strings.HasPrefix(target, "/files/"):
+			target = strings.TrimPrefix(target, "/")
+		case strings.HasPrefix(target, "/view/") && exported[strings.TrimPrefix(target, "/view/")]:
+			target = strings.TrimPrefix(target, "/view/") + ".html"
+		default:
+			return match[3]
+		}
+		return `<a href="` + relativeURL(filename, target) + anchor + `"` + match[2] + `>` + match[3] + `</a>`
+	})
+}
+
+// renderStaticPage renders the page through the view template for the static
+// site, as it's shown to everyone.
+func (wiki *Wiki) renderStaticPage(page *Page, exported map[string]bool) ([]byte, error) {
+	data, err := wiki.viewTemplateData(wiki.everyone, page, nil, false, false)
+	if err != nil {
+		return nil, err
+	}
+	data.Static = true
+	data.Backlinks = exportedOnly(data.Backlinks, exported)
+	current, err := wiki.currentTemplates(wiki.config.Theme, wiki.config.Locale)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := current.ExecuteTemplate(&out, "view.html", data); err != nil {
+		return nil, err
+	}
+	return []byte(wiki.staticLinks(out.String(), page.Title+".html", exported)), nil
+}
+
+// exportedOnly returns those of the titles that are exported.
+func exportedOnly(titles []string, exported map[string]bool) []string {
+	var kept []string
+	for _, title := range titles {
+		if exported[title] {
+			kept = append(kept, title)
+		}
+	}
+	return kept
+}
+
+// copyAttachments copies the attachments of the page to files/{title}/{name} of
+// the site.
+func (wiki *Wiki) copyAttachments(title, dir string) error {
+	wiki.attachments.Lock()
+	hashes := make(map[string]string, len(wiki.attachments.Pages[title]))
+	for name, hash := range wiki.attachments.Pages[title] {
+		hashes[name] = hash
+	}
+	wiki.attachments.Unlock()
+	for name, hash := range hashes {
+		data, err := os.ReadFile(wiki.blobFilename(hash))
+		if err != nil {
+			return err
+		}
+		filename := filepath.Join(dir, "files", filepath.FromSlash(title), name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filename, data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportStatic writes the pages listed for everyone into dir as a static site: a
+// file of every page as the view page shows it, with relative links, their
+// attachments and an index.html of them all, for GitHub Pages or any host that
+// serves files.
+func (wiki *Wiki) exportStatic(titles []string, dir string) error {
+	exported := make(map[string]bool)
+	for _, title := range titles {
+		exported[title] = true
+	}
+	for _, title := range titles {
+		page, err := wiki.load(title)
+		if err != nil {
+			return err
+		}
+		html, err := wiki.renderStaticPage(page, exported)
+		if err != nil {
+			return err
+		}
+		filename := filepath.Join(dir, filepath.FromSlash(title)+".html")
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filename, html, 0644); err != nil {
+			return err
+		}
+		if err := wiki.copyAttachments(title, dir); err != nil {
+			return err
+		}
+	}
+	listed := append([]string(nil), titles...)
+	wiki.sortListing(listed)
+	var index strings.Builder
+	for _, title := range listed {
+		fmt.Fprintf(&index, "- [%s](/view/%s)\n", wiki.displayTitle(title), title)
+	}
+	html, err := wiki.renderStaticPage(&Page{Title: "Pages", Body: []byte(index.String())}, exported)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "index.html"), html, 0644)
+}
+
+// runExportStatic implements "gowiki export-static": it writes every page listed
+// for everyone, or those of a namespace, as a static site into a directory.
+func (wiki *Wiki) runExportStatic(args []string) error {
+	flags := flag.NewFlagSet("export-static", flag.ContinueOnError)
+	namespace := flags.String("namespace", "", "export only the pages in this namespace and the namespaces below it")
+	out := flags.String("out", "site", "the directory to write the site to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	var titles []string
+	for _, title := range wiki.selectPages(*namespace) {
+		if wiki.everyone.lists(title) {
+			titles = append(titles, title)
+		}
+	}
+	if len(titles) == 0 {
+		return fmt.Errorf("no pages match")
+	}
+	if err := wiki.exportStatic(titles, *out); err != nil {
+		return err
+	}
+	fmt.Printf("exported %d pages to %s\n", len(titles), *out)
+	return nil
+}
+
+func (wiki *Wiki) exportArchive(titles []string, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := wiki.writeArchive(file, titles); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func (wiki *Wiki) writeArchive(w io.Writer, titles []string) error {
+	compressed := gzip.NewWriter(w)
+	archive := tar.NewWriter(compressed)
+	for _, title := range titles {
+		page, err := wiki.load(title)
+		if err != nil {
+			return err
+		}
+		header := &tar.Header{Name: "data/" + title + ".txt", Mode: 0600, Size: int64(len(page.Body)), ModTime: clock.Now()}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := archive.Write(page.Body); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return compressed.Close()
+}
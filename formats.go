@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// PageFormat is a markup page bodies can be written in: the renderer of its view
+// and the one used in safe mode, without the stages that evaluate dynamic content.
+// Its pages include others with {{include:Title}} when Includes is set.
+type PageFormat struct {
+	Renderer Renderer
+	Safe     Renderer
+	Includes bool
+}
+
+// newPageFormats returns the formats of the pages of the wiki, rendered by its
+// renderers.
+func (wiki *Wiki) newPageFormats() map[string]PageFormat {
+	return map[string]PageFormat{
+		"markdown": {wiki.pageRenderer, wiki.safeRenderer, true},
+		// autolink is how the wiki rendered pages before Markdown: the text as it
+		// was typed, with the titles of other pages in it linked.
+		"autolink": {renderPipeline{plainRenderer{}, titleLinker{wiki: wiki}}, renderPipeline{plainRenderer{}, titleLinker{wiki: wiki}}, false},
+		"plain":    {plainRenderer{}, plainRenderer{}, false},
+	}
+}
+
+// formatNames returns the names of the formats, for the edit form.
+func (wiki *Wiki) formatNames() []string {
+	return slices.Sorted(maps.Keys(wiki.pageFormats))
+}
+
+// formatOf returns the name of the format the page is written in.
+func (wiki *Wiki) formatOf(title string) string {
+	if format := wiki.metadataOf(title).Format; format != "" {
+		if _, ok := wiki.pageFormats[format]; ok {
+			return format
+		}
+	}
+	return wiki.config.Format
+}
+
+// rendererFor returns the renderer of the page's format, its safe one when safe
+// is set, ending with the HTML sanitizer unless -raw-html trusts the pages. The
+// pages it includes are those of the page, the safe renderer includes none.
+func (wiki *Wiki) rendererFor(title string, safe bool) Renderer {
+	format := wiki.formatOf(title)
+	if !wiki.pageFormats[format].Includes || safe {
+		return wiki.formatRenderer(format, safe)
+	}
+	return renderPipeline{includeRenderer{wiki, title}, wiki.formatRenderer(format, safe)}
+}
+
+// formatRenderer returns the renderer of the named format, like rendererFor,
+// which leaves out the front matter of the body first.
+func (wiki *Wiki) formatRenderer(name string, safe bool) Renderer {
+	format := wiki.pageFormats[name]
+	renderer := format.Renderer
+	if safe {
+		renderer = format.Safe
+	}
+	if wiki.config.RawHTML {
+		return renderPipeline{frontMatterStripper{}, renderer}
+	}
+	return renderPipeline{frontMatterStripper{}, renderer, htmlSanitizer{}}
+}
+
+// plainRenderer shows the text as it was typed: escaped, with its line breaks
+// and paragraphs kept.
+type plainRenderer struct{}
+
+func (plainRenderer) Render(body []byte) []byte {
+	return []byte(paragraphs(html.EscapeString(string(body))))
+}
+
+var paragraphBreak = regexp.MustCompile(`\n\s*\n`)
+
+// paragraphs wraps the paragraphs of text, separated by blank lines, in <p>
+// elements, breaking the lines within them with <br>.
+func paragraphs(text string) string {
+	var out strings.Builder
+	for _, paragraph := range paragraphBreak.Split(strings.ReplaceAll(text, "\r\n", "\n"), -1) {
+		if paragraph = strings.TrimSpace(paragraph); paragraph != "" {
+			out.WriteString("<p>" + strings.ReplaceAll(paragraph, "\n", "<br>\n") + "</p>\n")
+		}
+	}
+	return out.String()
+}
+
+// markupRule is a rule of a custom markup: every match of pattern in the escaped
+// text of a page is replaced with replacement, which may refer to the groups of
+// the match as $1, $2, ...
+type markupRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// customMarkup renders a markup defined by the rules of the -markup-rules file,
+// applied in order to the escaped text, then splits it into paragraphs.
+type customMarkup []markupRule
+
+func (markup customMarkup) Render(body []byte) []byte {
+	text := html.EscapeString(string(body))
+	for _, rule := range markup {
+		text = rule.pattern.ReplaceAllString(text, rule.replacement)
+	}
+	return []byte(paragraphs(text))
+}
+
+// loadMarkupRules reads a custom markup from a file of rules, one per line as
+// pattern => replacement, and registers it as the "custom" format. Blank lines
+// and lines starting with # are skipped. The rules come from the wiki's
+// operator, so their replacements may produce HTML: the elements the sanitizer
+// allows, or any with -raw-html.
+func (wiki *Wiki) loadMarkupRules(path string) error {
+	if path == "" {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var markup customMarkup
+	lines := bufio.NewScanner(file)
+	for number := 1; lines.Scan(); number++ {
+		line := strings.TrimSpace(lines.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pattern, replacement, ok := strings.Cut(line, " => ")
+		if !ok {
+			return fmt.Errorf("%s:%d: a rule is written as pattern => replacement", path, number)
+		}
+		compiled, err := regexp.Compile(strings.TrimSpace(pattern))
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, number, err)
+		}
+		markup = append(markup, markupRule{compiled, strings.TrimSpace(replacement)})
+	}
+	if err := lines.Err(); err != nil {
+		return err
+	}
+	wiki.pageFormats["custom"] = PageFormat{renderPipeline{markup, titleLinker{wiki: wiki}}, renderPipeline{markup, titleLinker{wiki: wiki}}, false}
+	return nil
+}
+
+// checkFormat fails for a -format that isn't one of the formats.
+func (wiki *Wiki) checkFormat(format string) error {
+	if _, ok := wiki.pageFormats[format]; !ok {
+		return fmt.Errorf("pages are written in one of %s, not %q", strings.Join(wiki.formatNames(), ", "), format)
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// FrontMatter is the block of YAML between "---" lines, or of TOML between
+// "+++" lines, a page body may start with: the title, tags, author and dates
+// the page gives itself, and Fields, any other keys, lists joined with ", ".
+// It's left out of the rendered page.
+//
+// Only flat keys are read, with strings, numbers, dates and lists of them as
+// values: a YAML list is [a, b] or its items on the lines below the key, each
+// "- item", a TOML one ["a", "b"]; a [table] of TOML prefixes its keys with its
+// name and a dot.
+type FrontMatter struct {
+	Title   string            `json:"title,omitempty"`
+	Tags    []string          `json:"tags,omitempty"`
+	Author  string            `json:"author,omitempty"`
+	Created string            `json:"created,omitempty"`
+	Updated string            `json:"updated,omitempty"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// splitFrontMatter returns the lines of the front matter the body starts with,
+// between its delimiters, and the body after it. A block that's never closed is
+// no front matter.
+func splitFrontMatter(body []byte) (delimiter string, lines []string, rest []byte) {
+	first, after, found := bytes.Cut(body, []byte("\n"))
+	delimiter = strings.TrimRight(string(first), "\r")
+	if !found || delimiter != "---" && delimiter != "+++" {
+		return "", nil, body
+	}
+	for len(after) > 0 {
+		var line []byte
+		line, after, _ = bytes.Cut(after, []byte("\n"))
+		if text := strings.TrimRight(string(line), "\r"); text != delimiter {
+			lines = append(lines, text)
+			continue
+		}
+		return delimiter, lines, after
+	}
+	return "", nil, body
+}
+
+// stripFrontMatter returns the body without its front matter.
+func stripFrontMatter(body []byte) []byte {
+	_, _, rest := splitFrontMatter(body)
+	return rest
+}
+
+// frontMatterLines is how many lines of the body its front matter takes, with
+// its delimiters.
+func frontMatterLines(body []byte) int {
+	delimiter, lines, _ := splitFrontMatter(body)
+	if delimiter == "" {
+		return 0
+	}
+	return len(lines) + 2
+}
+
+// FrontMatterError is a line of the front matter that couldn't be read.
+type FrontMatterError struct {
+	Line    int
+	Message string
+}
+
+func (err FrontMatterError) Error() string {
+	return fmt.Sprintf("line %d of the front matter: %s", err.Line, err.Message)
+}
+
+// parseFrontMatter reads the front matter the body starts with, nil when it has
+// none. The lines that can't be read are returned as errors, their Line counted
+// from the top of the body, and left out.
+func parseFrontMatter(body []byte) (*FrontMatter, []FrontMatterError) {
+	delimiter, lines, _ := splitFrontMatter(body)
+	if delimiter == "" {
+		return nil, nil
+	}
+	values, order := make(map[string][]string), []string(nil)
+	set := func(key string, value []string) {
+		if _, ok := values[key]; !ok {
+			order = append(order, key)
+		}
+		values[key] = value
+	}
+	var errs []FrontMatterError
+	table, listKey := "", ""
+	for idx, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		fail := func(message string) {
+			errs = append(errs, FrontMatterError{idx + 2, message})
+		}
+		if delimiter == "---" {
+			if item, ok := strings.CutPrefix(trimmed, "- "); ok && listKey != "" {
+				values[listKey] = append(values[listKey], unquoteYAML(item))
+				continue
+			}
+			key, value, ok := strings.Cut(trimmed, ":")
+			if !ok || strings.TrimSpace(key) == "" {
+				fail("expected key: value")
+				listKey = ""
+				continue
+			}
+			key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+			listKey = ""
+			switch {
+			case value == "":
+				listKey = key
+				set(key, nil)
+			case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+				var items []string
+				for _, item := range strings.Split(value[1:len(value)-1], ",") {
+					if item = strings.TrimSpace(item); item != "" {
+						items = append(items, unquoteYAML(item))
+					}
+				}
+				set(key, items)
+			default:
+				set(key, []string{unquoteYAML(value)})
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			table = strings.ToLower(strings.TrimSpace(strings.Trim(trimmed, "[]"))) + "."
+			continue
+		}
+		key, value, ok := strings.Cut(trimmed, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			fail("expected key = value")
+			continue
+		}
+		key, value = table+strings.ToLower(strings.Trim(strings.TrimSpace(key), `"`)), strings.TrimSpace(value)
+		if strings.HasPrefix(value, "[") {
+			if !strings.HasSuffix(value, "]") {
+				fail("the list of " + key + " isn't closed on its line")
+				continue
+			}
+			var items []string
+			for _, item := range strings.Split(value[1:len(value)-1], ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, unquoteTOML(item))
+				}
+			}
+			set(key, items)
+			continue
+		}
+		set(key, []string{unquoteTOML(value)})
+	}
+	front := &FrontMatter{}
+	for _, key := range order {
+		value := values[key]
+		joined := strings.Join(value, ", ")
+		switch key {
+		case "title":
+			front.Title = joined
+		case "tags":
+			front.Tags = parseTags(strings.Join(value, ","))
+		case "author":
+			front.Author = joined
+		case "created", "date":
+			front.Created = joined
+		case "updated":
+			front.Updated = joined
+		default:
+			if front.Fields == nil {
+				front.Fields = make(map[string]string)
+			}
+			front.Fields[key] = joined
+		}
+	}
+	return front, errs
+}
+
+// unquoteYAML returns the scalar without its quotes, or its comment.
+func unquoteYAML(value string) string {
+	switch {
+	case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			return unquoted
+		}
+		return value[1 : len(value)-1]
+	case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+	}
+	if comment := strings.Index(value, " #"); comment >= 0 {
+		value = strings.TrimSpace(value[:comment])
+	}
+	return value
+}
+
+// unquoteTOML returns the value without its quotes, or its comment.
+func unquoteTOML(value string) string {
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return value[1 : len(value)-1]
+	}
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			return unquoted
+		}
+	}
+	if comment := strings.Index(value, "#"); comment >= 0 {
+		value = strings.TrimSpace(value[:comment])
+	}
+	return value
+}
+
+// frontMatterStripper leaves the front matter out of the rendered page.
+type frontMatterStripper struct{}
+
+func (frontMatterStripper) Render(body []byte) []byte {
+	return stripFrontMatter(body)
+}
+
+// field returns the value of the key of the front matter: one of its own, the
+// tags joined with ", ", or one of Fields.
+func (front *FrontMatter) field(key string) (string, bool) {
+	switch key {
+	case "title":
+		return front.Title, front.Title != ""
+	case "tags":
+		return strings.Join(front.Tags, ", "), len(front.Tags) > 0
+	case "author":
+		return front.Author, front.Author != ""
+	case "created":
+		return front.Created, front.Created != ""
+	case "updated":
+		return front.Updated, front.Updated != ""
+	}
+	value, ok := front.Fields[key]
+	return value, ok
+}
+
+// matchesFrontMatter tells whether the front matter of the body has every
+// meta.{key}={value} of the query: the value of the key, or one of the tags for
+// meta.tags, which "*" matches whatever it is.
+func matchesFrontMatter(body []byte, query url.Values) bool {
+	front, _ := parseFrontMatter(body)
+	for name, wanted := range query {
+		key, ok := strings.CutPrefix(name, "meta.")
+		if !ok {
+			continue
+		}
+		if front == nil {
+			return false
+		}
+		key = strings.ToLower(key)
+		value, has := front.field(key)
+		for _, want := range wanted {
+			switch {
+			case !has:
+				return false
+			case want == "*":
+			case key == "tags":
+				if !slices.Contains(front.Tags, want) {
+					return false
+				}
+			case !strings.EqualFold(value, want):
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Problem is an inconsistency fsck found between the pages, their histories,
+// the attachments and the indexes. Repair fixes it, when fsck knows how.
+type Problem struct {
+	Kind    string
+	Subject string
+	Detail  string
+	repair  func() error
+}
+
+func (problem Problem) String() string {
+	return problem.Kind + ": " + problem.Subject + ": " + problem.Detail
+}
+
+// fsck cross-checks the wiki's state and returns what doesn't add up.
+func (wiki *Wiki) fsck() ([]Problem, error) {
+	var problems []Problem
+	for _, check := range []func() ([]Problem, error){wiki.checkPages, wiki.checkHistories, wiki.checkAttachments, wiki.checkIndexes} {
+		found, err := check()
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, found...)
+	}
+	return problems, nil
+}
+
+// checkPages reads every page, whose title must be one the wiki can serve, and
+// compares its body with its latest revision: a page changed outside the wiki
+// gets its body recorded as a revision.
+func (wiki *Wiki) checkPages() ([]Problem, error) {
+	var problems []Problem
+	for _, title := range wiki.titles.List() {
+		if !validTitle.MatchString(title) || isReserved(title) {
+			problems = append(problems, Problem{Kind: "page", Subject: title, Detail: "the title can't be served, rename the file"})
+			continue
+		}
+		page, err := wiki.peek(title)
+		if err != nil {
+			problems = append(problems, Problem{Kind: "page", Subject: title, Detail: "can't be read: " + err.Error()})
+			continue
+		}
+		revisions, err := wiki.loadRevisions(title)
+		if err != nil {
+			return nil, err
+		}
+		if len(revisions) > 0 && revisions[len(revisions)-1].Body != string(page.Body) {
+			problems = append(problems, Problem{Kind: "revisions", Subject: title, Detail: "the page differs from its latest revision, it was changed outside the wiki",
+				repair: func() error {
+					_, err := wiki.recordRevision(page, "", "changed outside the wiki, found by fsck")
+					return err
+				}})
+		}
+	}
+	return problems, nil
+}
+
+// historyTitles returns the titles of every history file.
+func (wiki *Wiki) historyTitles() ([]string, error) {
+	var histories []string
+	err := filepath.WalkDir(wiki.dataPath("history"), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(wiki.dataPath("history"), path)
+		if err != nil {
+			return err
+		}
+		if title, ok := strings.CutSuffix(filepath.ToSlash(name), ".jsonl"); ok {
+			histories = append(histories, title)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return histories, err
+}
+
+// isDeletion reports whether the revision is the one deletePage records.
+func isDeletion(revision Revision) bool {
+	return revision.Body == "" && revision.Comment == "deleted"
+}
+
+// checkHistories checks that every history can be read, numbers its revisions
+// 1, 2, 3, ... and belongs to a page, or to one that was deleted. The revisions
+// of a page that went missing otherwise end with a deletion.
+func (wiki *Wiki) checkHistories() ([]Problem, error) {
+	histories, err := wiki.historyTitles()
+	if err != nil {
+		return nil, err
+	}
+	var problems []Problem
+	for _, title := range histories {
+		revisions, err := wiki.loadRevisions(title)
+		if err != nil {
+			problems = append(problems, Problem{Kind: "revisions", Subject: title, Detail: "the history can't be read: " + err.Error()})
+			continue
+		}
+		for idx, revision := range revisions {
+			if revision.ID != idx+1 {
+				problems = append(problems, Problem{Kind: "revisions", Subject: title, Detail: fmt.Sprintf("revision %d is numbered %d", idx+1, revision.ID)})
+				break
+			}
+		}
+		if len(revisions) > 0 && !wiki.titles.Has(title) && !isDeletion(revisions[len(revisions)-1]) {
+			problems = append(problems, Problem{Kind: "revisions", Subject: title, Detail: "orphaned, the page is gone but its history doesn't say it was deleted",
+				repair: func() error {
+					_, err := wiki.recordRevision(&Page{Title: title}, "", "deleted")
+					return err
+				}})
+		}
+	}
+	return problems, nil
+}
+
+// checkAttachments checks the attachment index against the blobs: every
+// attachment's blob is stored, with the content its hash says and counting its
+// references, every stored blob is used, and the pages attached to exist, or
+// were deleted and may be restored.
+func (wiki *Wiki) checkAttachments() ([]Problem, error) {
+	wiki.attachments.Lock()
+	defer wiki.attachments.Unlock()
+	var problems []Problem
+	refs := make(map[string]int)
+	for title, named := range wiki.attachments.Pages {
+		gone := !wiki.titles.Has(title)
+		if gone {
+			revisions, err := wiki.loadRevisions(title)
+			if err != nil {
+				return nil, err
+			}
+			gone = len(revisions) == 0 || !isDeletion(revisions[len(revisions)-1])
+		}
+		for name, hash := range named {
+			title, name := title, name
+			subject := title + "/" + name
+			switch {
+			case wiki.attachments.Blobs[hash] == nil:
+				problems = append(problems, Problem{Kind: "attachment", Subject: subject, Detail: "dangling, its content " + hash + " isn't stored",
+					repair: func() error { return wiki.detachLocked(title, name) }})
+			case gone:
+				problems = append(problems, Problem{Kind: "attachment", Subject: subject, Detail: "dangling, there's no page " + title,
+					repair: func() error { return wiki.detachLocked(title, name) }})
+				refs[hash]++
+			default:
+				refs[hash]++
+			}
+		}
+	}
+	for hash, stored := range wiki.attachments.Blobs {
+		hash, stored := hash, stored
+		if sum, err := hashFile(wiki.blobFilename(hash)); err != nil {
+			problems = append(problems, Problem{Kind: "blob", Subject: hash, Detail: "can't be read: " + err.Error()})
+		} else if sum != hash {
+			problems = append(problems, Problem{Kind: "blob", Subject: hash, Detail: "the content is corrupt, it hashes to " + sum})
+		}
+		if stored.Refs != refs[hash] {
+			problems = append(problems, Problem{Kind: "blob", Subject: hash, Detail: fmt.Sprintf("counts %d references, %d attachments use it", stored.Refs, refs[hash]),
+				repair: func() error {
+					// the repairs of its attachments may have dropped some already
+					if stored.Refs = wiki.refsLocked(hash); stored.Refs == 0 {
+						stored.Refs = 1
+						return wiki.unrefLocked(hash)
+					}
+					return nil
+				}})
+		}
+	}
+	entries, err := os.ReadDir(wiki.dataPath("attachments", "blobs"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		hash := entry.Name()
+		if wiki.attachments.Blobs[hash] == nil && !strings.HasPrefix(hash, ".") {
+			problems = append(problems, Problem{Kind: "blob", Subject: hash, Detail: "orphaned, no attachment uses it",
+				repair: func() error { return os.Remove(wiki.blobFilename(hash)) }})
+		}
+	}
+	return problems, nil
+}
+
+// refsLocked counts the attachments using the blob. It must be called with
+// attachments locked.
+func (wiki *Wiki) refsLocked(hash string) int {
+	refs := 0
+	for _, named := range wiki.attachments.Pages {
+		for _, used := range named {
+			if used == hash {
+				refs++
+			}
+		}
+	}
+	return refs
+}
+
+func hashFile(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	digest := sha256.New()
+	if _, err := io.Copy(digest, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// checkIndexes checks that the link index and the search index have every page,
+// with its current links, and no page that's gone.
+func (wiki *Wiki) checkIndexes() ([]Problem, error) {
+	var problems []Problem
+	wiki.backlinks.mu.RLock()
+	indexedLinks := make(map[string][]string, len(wiki.backlinks.outgoing))
+	for title, links := range wiki.backlinks.outgoing {
+		indexedLinks[title] = links
+	}
+	wiki.backlinks.mu.RUnlock()
+	wiki.searchIndex.mu.RLock()
+	indexedWords := make(map[string]bool, len(wiki.searchIndex.words))
+	for title := range wiki.searchIndex.words {
+		indexedWords[title] = true
+	}
+	wiki.searchIndex.mu.RUnlock()
+
+	for _, title := range wiki.titles.List() {
+		page, err := wiki.peek(title)
+		if err != nil {
+			continue // checkPages reports it
+		}
+		if links, ok := indexedLinks[title]; !ok {
+			problems = append(problems, Problem{Kind: "link index", Subject: title, Detail: "missing", repair: func() error { wiki.backlinks.update(page); return nil }})
+		} else if !slices.Equal(links, wiki.linksOf(page)) {
+			problems = append(problems, Problem{Kind: "link index", Subject: title, Detail: "has outdated links", repair: func() error { wiki.backlinks.update(page); return nil }})
+		}
+		if !indexedWords[title] {
+			problems = append(problems, Problem{Kind: "search index", Subject: title, Detail: "missing", repair: func() error { wiki.searchIndex.update(page); return nil }})
+		}
+		delete(indexedLinks, title)
+		delete(indexedWords, title)
+	}
+	for title := range indexedLinks {
+		title := title
+		problems = append(problems, Problem{Kind: "link index", Subject: title, Detail: "has a page that's gone", repair: func() error { wiki.backlinks.remove(title); return nil }})
+	}
+	for title := range indexedWords {
+		title := title
+		problems = append(problems, Problem{Kind: "search index", Subject: title, Detail: "has a page that's gone", repair: func() error { wiki.searchIndex.remove(title); return nil }})
+	}
+	return problems, nil
+}
+
+// repair fixes the problems it can, and returns those left.
+func (wiki *Wiki) repair(problems []Problem) ([]Problem, error) {
+	var left []Problem
+	repairedAttachments := false
+	for _, problem := range problems {
+		if problem.repair == nil {
+			left = append(left, problem)
+			continue
+		}
+		locked := problem.Kind == "attachment" || problem.Kind == "blob"
+		if locked {
+			wiki.attachments.Lock()
+		}
+		err := problem.repair()
+		if locked {
+			wiki.attachments.Unlock()
+			repairedAttachments = true
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not repair %s: %w", problem, err)
+		}
+	}
+	if repairedAttachments {
+		wiki.attachments.Lock()
+		defer wiki.attachments.Unlock()
+		if err := wiki.saveAttachments(); err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+// runFsck is the fsck command: it reports the inconsistencies of the wiki's
+// state, and repairs what it can with -repair. It fails when problems are left.
+func (wiki *Wiki) runFsck(args []string) error {
+	flags := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	repairs := flags.Bool("repair", false, "repair the problems that can be, recording what it takes as revisions without an author")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	problems, err := wiki.fsck()
+	if err != nil {
+		return err
+	}
+	found := len(problems)
+	for _, problem := range problems {
+		note := ""
+		if problem.repair != nil {
+			note = " (repairable)"
+			if *repairs {
+				note = " (repaired)"
+			}
+		}
+		fmt.Println(problem.String() + note)
+	}
+	if *repairs {
+		if problems, err = wiki.repair(problems); err != nil {
+			return err
+		}
+	}
+	switch {
+	case len(problems) > 0:
+		return fmt.Errorf("%d problems found", len(problems))
+	case found > 0:
+		fmt.Println("the wiki is consistent now")
+	default:
+		fmt.Println("the wiki is consistent")
+	}
+	return nil
+}
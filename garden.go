@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// staleAge is how long after its last edit a page is stale.
+	staleAge = 180 * 24 * time.Hour
+	// shortWords is how few words make a page very short.
+	shortWords = 50
+	// gardenInterval is how often the scheduler compiles the reports again.
+	gardenInterval = time.Hour
+)
+
+// ReportRow is a page a report finds, with what it found about it.
+type ReportRow struct {
+	Title  string
+	Detail string
+}
+
+// ReportSection is one report of the hub: the pages there's cleanup work on, of
+// one kind.
+type ReportSection struct {
+	Name        string
+	Heading     string
+	Description string
+	Rows        []ReportRow
+}
+
+// GardenReport is the data of the reports template: every report, compiled at
+// Generated.
+type GardenReport struct {
+	Generated time.Time
+	Sections  []ReportSection
+}
+
+// compileGardenReport looks for the cleanup work on the pages listed for everyone.
+// Redirects only count as the double redirects they may be.
+func (wiki *Wiki) compileGardenReport() (*GardenReport, error) {
+	now := clock.Now()
+	orphans := ReportSection{Name: "orphans", Heading: "Orphans", Description: "No other page links to these."}
+	deadEnds := ReportSection{Name: "dead-ends", Heading: "Dead ends", Description: "These link to no other page."}
+	stale := ReportSection{Name: "stale", Heading: "Stale pages", Description: fmt.Sprintf("Nobody edited these in the last %d days.", int(staleAge.Hours()/24))}
+	broken := ReportSection{Name: "broken-links", Heading: "Broken links", Description: "These link to pages that don't exist."}
+	doubles := ReportSection{Name: "double-redirects", Heading: "Double redirects", Description: "These redirect to another redirect."}
+	untagged := ReportSection{Name: "untagged", Heading: "Untagged pages", Description: "These have no tags."}
+	short := ReportSection{Name: "short", Heading: "Very short pages", Description: fmt.Sprintf("These have fewer than %d words.", shortWords)}
+
+	for _, title := range wiki.titles.List() {
+		if !wiki.everyone.lists(title) {
+			continue
+		}
+		page, err := wiki.load(title)
+		if err != nil {
+			continue
+		}
+		if wiki.redirectTarget(page) != "" {
+			continue
+		}
+		if len(wiki.backlinksOf(title)) == 0 {
+			orphans.Rows = append(orphans.Rows, ReportRow{Title: title})
+		}
+		links := wiki.linksOf(page)
+		if len(links) == 0 {
+			deadEnds.Rows = append(deadEnds.Rows, ReportRow{Title: title})
+		}
+		for _, target := range links {
+			if !wiki.titles.Has(target) {
+				broken.Rows = append(broken.Rows, ReportRow{Title: title, Detail: target})
+			}
+		}
+		revisions, err := wiki.loadRevisions(title)
+		if err != nil {
+			return nil, err
+		}
+		edited := wiki.pageModTime(title)
+		if len(revisions) > 0 {
+			edited = revisions[len(revisions)-1].Time
+		}
+		if !edited.IsZero() && now.Sub(edited) > staleAge {
+			stale.Rows = append(stale.Rows, ReportRow{Title: title, Detail: "last edited " + edited.Format("2006-01-02")})
+		}
+		if len(wiki.tagsOf(page)) == 0 {
+			untagged.Rows = append(untagged.Rows, ReportRow{Title: title})
+		}
+		if words := len(strings.Fields(string(page.Body))); words < shortWords {
+			short.Rows = append(short.Rows, ReportRow{Title: title, Detail: fmt.Sprintf("%d words", words)})
+		}
+	}
+	for _, problem := range wiki.redirectReport().Double {
+		if wiki.everyone.lists(problem.Title) {
+			doubles.Rows = append(doubles.Rows, ReportRow{Title: problem.Title, Detail: strings.Join(problem.Chain, " → ")})
+		}
+	}
+	return &GardenReport{Generated: now, Sections: []ReportSection{orphans, deadEnds, stale, broken, doubles, untagged, short}}, nil
+}
+
+// gardenReport returns the reports compiled last, compiling them when the
+// scheduler hasn't yet.
+func (wiki *Wiki) gardenReport() (*GardenReport, error) {
+	wiki.garden.Lock()
+	defer wiki.garden.Unlock()
+	if wiki.garden.report == nil {
+		report, err := wiki.compileGardenReport()
+		if err != nil {
+			return nil, err
+		}
+		wiki.garden.report = report
+	}
+	return wiki.garden.report, nil
+}
+
+// runGardenReports compiles the reports every gardenInterval until ctx is done.
+func (wiki *Wiki) runGardenReports(ctx context.Context) {
+	ticker := time.NewTicker(gardenInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := wiki.compileGardenReport()
+			if err != nil {
+				log.Printf("could not compile the reports: %v", err)
+				continue
+			}
+			wiki.garden.Lock()
+			wiki.garden.report = report
+			wiki.garden.Unlock()
+		}
+	}
+}
+
+// reportsHandler serves /reports, the hub of the reports on the pages with their
+// counts, and /reports.csv, every row of them, or with ?section= those of one.
+func (wiki *Wiki) reportsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	report, err := wiki.gardenReport()
+	if err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	if r.URL.Path != "/reports.csv" {
+		wiki.renderTemplate(w, "reports.html", report)
+		return
+	}
+	section := r.URL.Query().Get("section")
+	filename := "reports.csv"
+	if section != "" {
+		if !slices.ContainsFunc(report.Sections, func(listed ReportSection) bool { return listed.Name == section }) {
+			wiki.serveError(w, notFound("there is no report "+section))
+			return
+		}
+		filename = section + ".csv"
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	out := csv.NewWriter(w)
+	out.Write([]string{"report", "title", "detail"})
+	for _, listed := range report.Sections {
+		if section != "" && listed.Name != section {
+			continue
+		}
+		for _, row := range listed.Rows {
+			out.Write([]string{listed.Name, row.Title, row.Detail})
+		}
+	}
+	out.Flush()
+}
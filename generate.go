@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+)
+
+// runGenerate is the generate command, the helper of load tests: it saves n
+// pages like those profile-render and the benchmarks generate into the wiki, as
+// revisions by -author, titled -prefix and their number, leaving the pages of
+// those titles the wiki has already as they are.
+func (wiki *Wiki) runGenerate(args []string) error {
+	flags := flag.NewFlagSet("generate", flag.ContinueOnError)
+	prefix := flags.String("prefix", "Load/Page", "the titles of the pages before their number, a namespace keeps them together")
+	seed := flags.Int64("seed", 1, "the seed of the pages")
+	author := flags.String("author", "admin", "the author of the revisions")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: gowiki generate [-prefix title] [-seed n] [-author name] <number of pages>")
+	}
+	n, err := strconv.Atoi(flags.Arg(0))
+	if err != nil || n < 1 {
+		return fmt.Errorf("%s is not a number of pages", flags.Arg(0))
+	}
+	if title := *prefix + "1"; !validTitle.MatchString(title) || isReserved(title) {
+		return fmt.Errorf("%s is not a title, use letters, digits and hyphens", title)
+	}
+	generated, skipped := 0, 0
+	for _, page := range generateCorpus(n, *seed, *prefix) {
+		if wiki.titles.Has(page.Title) {
+			skipped++
+			continue
+		}
+		if err := wiki.savePage(page, *author); err != nil {
+			return fmt.Errorf("generated %d pages, then %s failed: %w", generated, page.Title, err)
+		}
+		generated++
+	}
+	fmt.Printf("generated %d pages, skipped %d the wiki has already\n", generated, skipped)
+	return nil
+}
@@ -0,0 +1,130 @@
+//go:build git
+
+// The git backend keeps the pages as the files of a git repository, pages/ in the
+// data directory, and commits every save and delete with its author and message.
+// The repository is an ordinary one: git log and git diff show the history of the
+// pages, and git push backs it up to a remote. Pages changed in it out-of-band,
+// by a git pull, show once the wiki is restarted. It is only built with -tags
+// git, after fetching github.com/go-git/go-git/v5, so the default build keeps no
+// dependencies.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func init() {
+	storeBackends["git"] = newGitStore
+}
+
+// gitAuthor is the author of the changes nobody made in particular, like the
+// migration of a page.
+const gitAuthor = "gowiki"
+
+// gitIgnored are the files the file store keeps next to the pages while it saves
+// them, which don't belong in the repository.
+const gitIgnored = "*.txt.bak\n.*.tmp\n"
+
+// gitStore writes the pages through a file store in the work tree, whose saves
+// are atomic, and commits each one.
+type gitStore struct {
+	*fileStore
+	mu       sync.Mutex
+	worktree *git.Worktree
+}
+
+// newGitStore keeps the pages in the repository pages/ of the data directory,
+// which it creates when there is none.
+func newGitStore(dir string) (PageStore, error) {
+	files, err := newFileStore(filepath.Join(dir, "pages"))
+	if err != nil {
+		return nil, err
+	}
+	s := &gitStore{fileStore: files.(*fileStore)}
+	repo, err := git.PlainOpen(s.dir)
+	created := errors.Is(err, git.ErrRepositoryNotExists)
+	if created {
+		repo, err = git.PlainInit(s.dir, false)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open the repository %s: %w", s.dir, err)
+	}
+	if s.worktree, err = repo.Worktree(); err != nil {
+		return nil, err
+	}
+	if created {
+		if err := os.WriteFile(filepath.Join(s.dir, ".gitignore"), []byte(gitIgnored), 0600); err != nil {
+			return nil, err
+		}
+		if err := s.commit(".gitignore", false, "", "Start the wiki"); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// commit stages the file, or its removal, and commits it. Saving a page as it was
+// commits nothing.
+func (s *gitStore) commit(name string, removed bool, author, message string) error {
+	var err error
+	if removed {
+		_, err = s.worktree.Remove(name)
+	} else {
+		_, err = s.worktree.Add(name)
+	}
+	if errors.Is(err, index.ErrEntryNotFound) {
+		return nil // removing a page that was never committed
+	}
+	if err != nil {
+		return fmt.Errorf("could not stage %s: %w", name, err)
+	}
+	if author == "" {
+		author = gitAuthor
+	}
+	_, err = s.worktree.Commit(message, &git.CommitOptions{Author: &object.Signature{Name: author, When: clock.Now()}})
+	if errors.Is(err, git.ErrEmptyCommit) {
+		return nil
+	}
+	return err
+}
+
+func (s *gitStore) Save(p *Page) error {
+	return s.SaveAs(p, "", "")
+}
+
+func (s *gitStore) SaveAs(p *Page, author, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fileStore.Save(p); err != nil {
+		return err
+	}
+	if message == "" {
+		message = "Save " + p.Title
+	}
+	return s.commit(p.Title+".txt", false, author, message)
+}
+
+func (s *gitStore) Delete(title string) error {
+	return s.DeleteAs(title, "", "")
+}
+
+func (s *gitStore) DeleteAs(title, author, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fileStore.Delete(title); err != nil {
+		return err
+	}
+	if message == "" {
+		message = "Delete " + title
+	}
+	return s.commit(title+".txt", true, author, message)
+}
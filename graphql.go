@@ -0,0 +1,1136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The GraphQL of /graphql is the part of the language a client of the wiki
+// needs, with no dependency: queries and mutations with variables, aliases,
+// fragments, inline fragments and the @skip and @include directives, against the
+// object types of graphqlSchema. There are no interfaces, unions, input objects,
+// subscriptions or introspection; GET /graphql answers the schema instead.
+
+// graphqlMaxDepth bounds how deep the fields of a query nest, so that a query
+// following the links of the links of the pages can't walk the whole wiki.
+const graphqlMaxDepth = 10
+
+// A gqlDocument is a parsed request: its operations and fragments.
+type gqlDocument struct {
+	operations []*gqlOperation
+	fragments  map[string]*gqlFragment
+}
+
+// A gqlOperation is a query or a mutation.
+type gqlOperation struct {
+	kind       string
+	name       string
+	variables  []gqlVariableDefinition
+	selections []*gqlSelection
+	location   gqlLocation
+}
+
+type gqlVariableDefinition struct {
+	name       string
+	typ        string
+	defaultVal any
+	hasDefault bool
+}
+
+type gqlFragment struct {
+	name       string
+	on         string
+	selections []*gqlSelection
+	location   gqlLocation
+}
+
+// A gqlSelection is a field, with alias, arguments and selections, a spread of
+// the named fragment, or an inline fragment with its selections.
+type gqlSelection struct {
+	alias      string
+	name       string
+	arguments  []gqlArgument
+	directives []gqlDirective
+	selections []*gqlSelection
+	spread     string
+	inline     bool
+	on         string
+	location   gqlLocation
+}
+
+// key is the name the field's value has in the response.
+func (sel *gqlSelection) key() string {
+	if sel.alias != "" {
+		return sel.alias
+	}
+	return sel.name
+}
+
+type gqlArgument struct {
+	name  string
+	value any
+}
+
+type gqlDirective struct {
+	name      string
+	arguments []gqlArgument
+	location  gqlLocation
+}
+
+// The values of the arguments are what they are in Go: nil, bool, int, float64,
+// string, []any and map[string]any, and the variables and enum values written
+// in the request.
+type (
+	gqlVariable string
+	gqlEnum     string
+)
+
+type gqlLocation struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// gqlError is an error of the response's errors.
+type gqlError struct {
+	Message   string        `json:"message"`
+	Locations []gqlLocation `json:"locations,omitempty"`
+	Path      []any         `json:"path,omitempty"`
+}
+
+func (err *gqlError) Error() string {
+	if len(err.Locations) == 0 {
+		return err.Message
+	}
+	return fmt.Sprintf("%s (line %d, column %d)", err.Message, err.Locations[0].Line, err.Locations[0].Column)
+}
+
+func gqlErrorAt(location gqlLocation, format string, args ...any) *gqlError {
+	return &gqlError{Message: fmt.Sprintf(format, args...), Locations: []gqlLocation{location}}
+}
+
+// gqlToken is a token of the lexer: kind is 'n' for a name, 's' for a string,
+// 'i' and 'f' for an int and a float, '.' for a spread, the character of the
+// other punctuators, and 0 at the end.
+type gqlToken struct {
+	kind     rune
+	text     string
+	location gqlLocation
+}
+
+type gqlParser struct {
+	src       string
+	pos       int
+	line      int
+	lineStart int
+	tok       gqlToken
+}
+
+// parseGraphQL parses the request's document.
+func parseGraphQL(src string) (doc *gqlDocument, err error) {
+	p := &gqlParser{src: src, line: 1}
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			parseErr, ok := recovered.(*gqlError)
+			if !ok {
+				panic(recovered)
+			}
+			doc, err = nil, parseErr
+		}
+	}()
+	p.next()
+	doc = &gqlDocument{fragments: make(map[string]*gqlFragment)}
+	for p.tok.kind != 0 {
+		switch {
+		case p.tok.kind == '{':
+			doc.operations = append(doc.operations, &gqlOperation{kind: "query", location: p.tok.location, selections: p.selectionSet(0)})
+		case p.tok.kind == 'n' && (p.tok.text == "query" || p.tok.text == "mutation"):
+			doc.operations = append(doc.operations, p.operation())
+		case p.tok.kind == 'n' && p.tok.text == "subscription":
+			p.fail("subscriptions are not supported")
+		case p.tok.kind == 'n' && p.tok.text == "fragment":
+			fragment := p.fragment()
+			if doc.fragments[fragment.name] != nil {
+				panic(gqlErrorAt(fragment.location, "there are two fragments called %s", fragment.name))
+			}
+			doc.fragments[fragment.name] = fragment
+		default:
+			p.fail("expected a query, a mutation or a fragment, found %s", p.tok.describe())
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, &gqlError{Message: "the document has no query or mutation"}
+	}
+	return doc, nil
+}
+
+func (p *gqlParser) fail(format string, args ...any) {
+	panic(gqlErrorAt(p.tok.location, format, args...))
+}
+
+func (tok gqlToken) describe() string {
+	switch tok.kind {
+	case 0:
+		return "the end of the document"
+	case 'n':
+		return tok.text
+	case 's':
+		return "a string"
+	case 'i', 'f':
+		return "the number " + tok.text
+	case '.':
+		return "..."
+	}
+	return string(tok.kind)
+}
+
+// next reads the next token, skipping the white space, commas and comments.
+func (p *gqlParser) next() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '\n' {
+			p.pos++
+			p.line, p.lineStart = p.line+1, p.pos
+		} else if c == ' ' || c == '\t' || c == '\r' || c == ',' {
+			p.pos++
+		} else if strings.HasPrefix(p.src[p.pos:], "\uFEFF") {
+			p.pos += len("\uFEFF")
+		} else if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		} else {
+			break
+		}
+	}
+	p.tok = gqlToken{location: gqlLocation{Line: p.line, Column: p.pos - p.lineStart + 1}}
+	if p.pos >= len(p.src) {
+		return
+	}
+	start, c := p.pos, p.src[p.pos]
+	switch {
+	case strings.ContainsRune("!$()[]{}:=@|&", rune(c)):
+		p.tok.kind = rune(c)
+		p.pos++
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.tok.kind = '.'
+		p.pos += 3
+	case c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+		for p.pos < len(p.src) && isNameByte(p.src[p.pos]) {
+			p.pos++
+		}
+		p.tok.kind, p.tok.text = 'n', p.src[start:p.pos]
+	case c == '-' || '0' <= c && c <= '9':
+		p.number()
+	case strings.HasPrefix(p.src[p.pos:], `"""`):
+		p.blockString()
+	case c == '"':
+		p.string()
+	default:
+		r, _ := utf8.DecodeRuneInString(p.src[p.pos:])
+		p.fail("unexpected character %q", r)
+	}
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+func (p *gqlParser) digits() {
+	start := p.pos
+	for p.pos < len(p.src) && '0' <= p.src[p.pos] && p.src[p.pos] <= '9' {
+		p.pos++
+	}
+	if p.pos == start {
+		p.fail("a number needs digits")
+	}
+}
+
+func (p *gqlParser) number() {
+	start := p.pos
+	p.tok.kind = 'i'
+	if p.src[p.pos] == '-' {
+		p.pos++
+	}
+	p.digits()
+	if p.pos < len(p.src) && p.src[p.pos] == '.' {
+		p.tok.kind = 'f'
+		p.pos++
+		p.digits()
+	}
+	if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+		p.tok.kind = 'f'
+		p.pos++
+		if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+			p.pos++
+		}
+		p.digits()
+	}
+	if p.pos < len(p.src) && (isNameByte(p.src[p.pos]) || p.src[p.pos] == '.') {
+		p.fail("a number can't be followed by %q", p.src[p.pos])
+	}
+	p.tok.text = p.src[start:p.pos]
+}
+
+// string reads a quoted string with its escapes.
+func (p *gqlParser) string() {
+	var value strings.Builder
+	p.pos++
+	for {
+		if p.pos >= len(p.src) || p.src[p.pos] == '\n' || p.src[p.pos] == '\r' {
+			p.fail("the string isn't closed")
+		}
+		c := p.src[p.pos]
+		if c == '"' {
+			p.pos++
+			break
+		}
+		if c != '\\' {
+			r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+			value.WriteRune(r)
+			p.pos += size
+			continue
+		}
+		if p.pos+1 >= len(p.src) {
+			p.fail("the string isn't closed")
+		}
+		escape := p.src[p.pos+1]
+		p.pos += 2
+		switch escape {
+		case '"', '\\', '/':
+			value.WriteByte(escape)
+		case 'b':
+			value.WriteByte('\b')
+		case 'f':
+			value.WriteByte('\f')
+		case 'n':
+			value.WriteByte('\n')
+		case 'r':
+			value.WriteByte('\r')
+		case 't':
+			value.WriteByte('\t')
+		case 'u':
+			if p.pos+4 > len(p.src) {
+				p.fail("\\u needs four hex digits")
+			}
+			code, err := strconv.ParseUint(p.src[p.pos:p.pos+4], 16, 32)
+			if err != nil {
+				p.fail("\\u needs four hex digits")
+			}
+			value.WriteRune(rune(code))
+			p.pos += 4
+		default:
+			p.fail("unknown escape \\%c", escape)
+		}
+	}
+	p.tok.kind, p.tok.text = 's', value.String()
+}
+
+// blockString reads a """ string, which has no escapes but \""" and loses the
+// indentation its lines have in common, and its blank first and last lines.
+func (p *gqlParser) blockString() {
+	p.pos += 3
+	end := strings.Index(p.src[p.pos:], `"""`)
+	for end > 0 && p.src[p.pos+end-1] == '\\' {
+		next := strings.Index(p.src[p.pos+end+3:], `"""`)
+		if next < 0 {
+			end = -1
+			break
+		}
+		end += 3 + next
+	}
+	if end < 0 {
+		p.fail("the block string isn't closed")
+	}
+	raw := strings.ReplaceAll(p.src[p.pos:p.pos+end], `\"""`, `"""`)
+	p.line += strings.Count(p.src[p.pos:p.pos+end], "\n")
+	if newline := strings.LastIndex(p.src[:p.pos+end], "\n"); newline >= p.pos {
+		p.lineStart = newline + 1
+	}
+	p.pos += end + 3
+	lines := strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && (indent < 0 || len(line)-len(trimmed) < indent) {
+			indent = len(line) - len(trimmed)
+		}
+	}
+	for i := 1; i < len(lines) && indent > 0; i++ {
+		lines[i] = lines[i][min(indent, len(lines[i])):]
+	}
+	for len(lines) > 0 && strings.TrimLeft(lines[0], " \t") == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimLeft(lines[len(lines)-1], " \t") == "" {
+		lines = lines[:len(lines)-1]
+	}
+	p.tok.kind, p.tok.text = 's', strings.Join(lines, "\n")
+}
+
+func (p *gqlParser) expect(kind rune) gqlToken {
+	if p.tok.kind != kind {
+		p.fail("expected %c, found %s", kind, p.tok.describe())
+	}
+	tok := p.tok
+	p.next()
+	return tok
+}
+
+func (p *gqlParser) name() string {
+	if p.tok.kind != 'n' {
+		p.fail("expected a name, found %s", p.tok.describe())
+	}
+	return p.expect('n').text
+}
+
+func (p *gqlParser) operation() *gqlOperation {
+	op := &gqlOperation{kind: p.tok.text, location: p.tok.location}
+	p.next()
+	if p.tok.kind == 'n' {
+		op.name = p.name()
+	}
+	if p.tok.kind == '(' {
+		p.next()
+		for p.tok.kind != ')' {
+			p.expect('$')
+			variable := gqlVariableDefinition{name: p.name()}
+			p.expect(':')
+			variable.typ = p.typeRef()
+			if p.tok.kind == '=' {
+				p.next()
+				variable.defaultVal, variable.hasDefault = p.value(true), true
+			}
+			op.variables = append(op.variables, variable)
+		}
+		p.next()
+	}
+	if p.tok.kind == '@' {
+		p.fail("operations take no directives")
+	}
+	op.selections = p.selectionSet(0)
+	return op
+}
+
+// typeRef reads a type like String, [Page!] or Int!.
+func (p *gqlParser) typeRef() string {
+	var typ string
+	if p.tok.kind == '[' {
+		p.next()
+		typ = "[" + p.typeRef() + "]"
+		p.expect(']')
+	} else {
+		typ = p.name()
+	}
+	if p.tok.kind == '!' {
+		p.next()
+		typ += "!"
+	}
+	return typ
+}
+
+func (p *gqlParser) fragment() *gqlFragment {
+	fragment := &gqlFragment{location: p.tok.location}
+	p.next()
+	if fragment.name = p.name(); fragment.name == "on" {
+		p.fail("a fragment can't be called on")
+	}
+	if p.tok.kind != 'n' || p.tok.text != "on" {
+		p.fail("expected on and the type of the fragment, found %s", p.tok.describe())
+	}
+	p.next()
+	fragment.on = p.name()
+	fragment.selections = p.selectionSet(0)
+	return fragment
+}
+
+// selectionSet reads the selections in braces. depth only guards the parser's
+// stack; graphqlMaxDepth is checked as the query is run, fragments included.
+func (p *gqlParser) selectionSet(depth int) []*gqlSelection {
+	if depth > 4*graphqlMaxDepth {
+		p.fail("the selections nest too deep")
+	}
+	p.expect('{')
+	var selections []*gqlSelection
+	for p.tok.kind != '}' {
+		selections = append(selections, p.selection(depth))
+	}
+	if len(selections) == 0 {
+		p.fail("a selection set can't be empty")
+	}
+	p.next()
+	return selections
+}
+
+func (p *gqlParser) selection(depth int) *gqlSelection {
+	sel := &gqlSelection{location: p.tok.location}
+	if p.tok.kind == '.' {
+		p.next()
+		switch {
+		case p.tok.kind == 'n' && p.tok.text != "on":
+			sel.spread = p.name()
+			sel.directives = p.directives()
+			return sel
+		case p.tok.kind == 'n':
+			p.next()
+			sel.on = p.name()
+		}
+		sel.inline = true
+		sel.directives = p.directives()
+		sel.selections = p.selectionSet(depth + 1)
+		return sel
+	}
+	sel.name = p.name()
+	if p.tok.kind == ':' {
+		p.next()
+		sel.alias, sel.name = sel.name, p.name()
+	}
+	sel.arguments = p.arguments()
+	sel.directives = p.directives()
+	if p.tok.kind == '{' {
+		sel.selections = p.selectionSet(depth + 1)
+	}
+	return sel
+}
+
+func (p *gqlParser) arguments() []gqlArgument {
+	if p.tok.kind != '(' {
+		return nil
+	}
+	p.next()
+	var arguments []gqlArgument
+	for p.tok.kind != ')' {
+		arg := gqlArgument{name: p.name()}
+		p.expect(':')
+		arg.value = p.value(false)
+		arguments = append(arguments, arg)
+	}
+	if len(arguments) == 0 {
+		p.fail("the arguments in parentheses can't be empty")
+	}
+	p.next()
+	return arguments
+}
+
+func (p *gqlParser) directives() []gqlDirective {
+	var directives []gqlDirective
+	for p.tok.kind == '@' {
+		directive := gqlDirective{location: p.tok.location}
+		p.next()
+		directive.name = p.name()
+		directive.arguments = p.arguments()
+		directives = append(directives, directive)
+	}
+	return directives
+}
+
+// value reads the value of an argument, a constant one for the default of a
+// variable.
+func (p *gqlParser) value(constant bool) any {
+	tok := p.tok
+	switch tok.kind {
+	case '$':
+		if constant {
+			p.fail("a default can't be a variable")
+		}
+		p.next()
+		return gqlVariable(p.name())
+	case 'i':
+		p.next()
+		n, err := strconv.Atoi(tok.text)
+		if err != nil || n > math.MaxInt32 || n < math.MinInt32 {
+			panic(gqlErrorAt(tok.location, "%s is too large for an Int", tok.text))
+		}
+		return n
+	case 'f':
+		p.next()
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			panic(gqlErrorAt(tok.location, "%s is not a Float", tok.text))
+		}
+		return f
+	case 's':
+		p.next()
+		return tok.text
+	case 'n':
+		p.next()
+		switch tok.text {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return gqlEnum(tok.text)
+	case '[':
+		p.next()
+		list := []any{}
+		for p.tok.kind != ']' {
+			list = append(list, p.value(constant))
+		}
+		p.next()
+		return list
+	case '{':
+		p.next()
+		object := map[string]any{}
+		for p.tok.kind != '}' {
+			name := p.name()
+			p.expect(':')
+			object[name] = p.value(constant)
+		}
+		p.next()
+		return object
+	}
+	p.fail("expected a value, found %s", tok.describe())
+	return nil
+}
+
+// gqlType is an object type of the schema.
+type gqlType struct {
+	name   string
+	doc    string
+	fields []*gqlField
+}
+
+// gqlField is a field of an object type. Its type is written as in the schema:
+// String, Page!, [Tag!]!. Resolve returns the field's value from the object's,
+// []any for a list, nil for null; the value of an object is what the fields of
+// its type are resolved from.
+type gqlField struct {
+	name      string
+	doc       string
+	typ       string
+	arguments []gqlArgumentDefinition
+	resolve   func(req *graphqlRequest, source any, args map[string]any) (any, error)
+}
+
+type gqlArgumentDefinition struct {
+	name       string
+	typ        string
+	defaultVal any
+}
+
+func (t *gqlType) field(name string) *gqlField {
+	for _, field := range t.fields {
+		if field.name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+// namedType is the type a type is a list of or non-null of: Page of [Page!]!.
+func namedType(typ string) string {
+	return strings.Trim(typ, "[]!")
+}
+
+// gqlSchema is the types of a schema, by name, Query and Mutation the roots of
+// the operations.
+type gqlSchema struct {
+	types []*gqlType
+}
+
+func (s *gqlSchema) lookup(name string) *gqlType {
+	for _, t := range s.types {
+		if t.name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+var gqlScalars = map[string]bool{"String": true, "Int": true, "Float": true, "Boolean": true, "ID": true}
+
+// sdl writes the schema in the schema definition language.
+func (s *gqlSchema) sdl() string {
+	var out strings.Builder
+	for i, t := range s.types {
+		if i > 0 {
+			out.WriteString("\n")
+		}
+		if t.doc != "" {
+			fmt.Fprintf(&out, "%q\n", t.doc)
+		}
+		fmt.Fprintf(&out, "type %s {\n", t.name)
+		for _, field := range t.fields {
+			if field.doc != "" {
+				fmt.Fprintf(&out, "  %q\n", field.doc)
+			}
+			fmt.Fprintf(&out, "  %s", field.name)
+			if len(field.arguments) > 0 {
+				var arguments []string
+				for _, arg := range field.arguments {
+					definition := arg.name + ": " + arg.typ
+					if arg.defaultVal != nil {
+						value, _ := json.Marshal(arg.defaultVal)
+						definition += " = " + string(value)
+					}
+					arguments = append(arguments, definition)
+				}
+				fmt.Fprintf(&out, "(%s)", strings.Join(arguments, ", "))
+			}
+			fmt.Fprintf(&out, ": %s\n", field.typ)
+		}
+		out.WriteString("}\n")
+	}
+	return out.String()
+}
+
+// validate checks the document against the schema before anything of it is
+// run, so that a mistake in a mutation changes nothing: the fields and their
+// arguments exist, the objects have selections and the scalars none, and the
+// fragments and variables used are defined.
+func (s *gqlSchema) validate(doc *gqlDocument) error {
+	names := make(map[string]bool)
+	for _, op := range doc.operations {
+		if op.name == "" && len(doc.operations) > 1 {
+			return gqlErrorAt(op.location, "an operation needs a name when the document has several")
+		}
+		if names[op.name] {
+			return gqlErrorAt(op.location, "there are two operations called %s", op.name)
+		}
+		names[op.name] = true
+		root := s.lookup(strings.ToUpper(op.kind[:1]) + op.kind[1:])
+		if root == nil {
+			return gqlErrorAt(op.location, "the schema has no %ss", op.kind)
+		}
+		defined := make(map[string]bool)
+		for _, variable := range op.variables {
+			if defined[variable.name] {
+				return gqlErrorAt(op.location, "there are two variables called $%s", variable.name)
+			}
+			if !gqlScalars[namedType(variable.typ)] {
+				return gqlErrorAt(op.location, "$%s is of the type %s, but variables can only be scalars or lists of them", variable.name, variable.typ)
+			}
+			defined[variable.name] = true
+		}
+		if err := s.validateSelections(doc, root, op.selections, defined, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *gqlSchema) validateSelections(doc *gqlDocument, t *gqlType, selections []*gqlSelection, variables map[string]bool, spreading []string) error {
+	for _, sel := range selections {
+		for _, directive := range sel.directives {
+			if directive.name != "skip" && directive.name != "include" {
+				return gqlErrorAt(directive.location, "there's no directive @%s", directive.name)
+			}
+			if len(directive.arguments) != 1 || directive.arguments[0].name != "if" {
+				return gqlErrorAt(directive.location, "@%s takes the argument if", directive.name)
+			}
+			if err := validateValue(directive.arguments[0].value, variables, directive.location); err != nil {
+				return err
+			}
+		}
+		switch {
+		case sel.spread != "":
+			fragment := doc.fragments[sel.spread]
+			if fragment == nil {
+				return gqlErrorAt(sel.location, "there's no fragment %s", sel.spread)
+			}
+			if fragment.on != t.name {
+				return gqlErrorAt(sel.location, "the fragment %s is on %s, it can't be spread in %s", sel.spread, fragment.on, t.name)
+			}
+			for _, spread := range spreading {
+				if spread == sel.spread {
+					return gqlErrorAt(sel.location, "the fragment %s spreads itself", sel.spread)
+				}
+			}
+			if err := s.validateSelections(doc, t, fragment.selections, variables, append(spreading, sel.spread)); err != nil {
+				return err
+			}
+		case sel.inline:
+			if sel.on != "" && sel.on != t.name {
+				return gqlErrorAt(sel.location, "a fragment on %s can't be in %s", sel.on, t.name)
+			}
+			if err := s.validateSelections(doc, t, sel.selections, variables, spreading); err != nil {
+				return err
+			}
+		case sel.name == "__typename":
+			if len(sel.arguments) > 0 || sel.selections != nil {
+				return gqlErrorAt(sel.location, "__typename takes no arguments and has no fields")
+			}
+		default:
+			field := t.field(sel.name)
+			if field == nil {
+				return gqlErrorAt(sel.location, "%s has no field %s", t.name, sel.name)
+			}
+			given := make(map[string]bool)
+			for _, arg := range sel.arguments {
+				found := false
+				for _, definition := range field.arguments {
+					found = found || definition.name == arg.name
+				}
+				if !found {
+					return gqlErrorAt(sel.location, "%s.%s has no argument %s", t.name, sel.name, arg.name)
+				}
+				if given[arg.name] {
+					return gqlErrorAt(sel.location, "the argument %s of %s is given twice", arg.name, sel.name)
+				}
+				given[arg.name] = true
+				if err := validateValue(arg.value, variables, sel.location); err != nil {
+					return err
+				}
+			}
+			for _, definition := range field.arguments {
+				if strings.HasSuffix(definition.typ, "!") && definition.defaultVal == nil && !given[definition.name] {
+					return gqlErrorAt(sel.location, "%s.%s needs the argument %s", t.name, sel.name, definition.name)
+				}
+			}
+			if object := s.lookup(namedType(field.typ)); object != nil {
+				if sel.selections == nil {
+					return gqlErrorAt(sel.location, "%s is a %s, select its fields", sel.key(), object.name)
+				}
+				if err := s.validateSelections(doc, object, sel.selections, variables, spreading); err != nil {
+					return err
+				}
+			} else if sel.selections != nil {
+				return gqlErrorAt(sel.location, "%s is a %s, it has no fields", sel.key(), field.typ)
+			}
+		}
+	}
+	return nil
+}
+
+// validateValue checks that the variables of a value are defined.
+func validateValue(value any, variables map[string]bool, location gqlLocation) error {
+	switch value := value.(type) {
+	case gqlVariable:
+		if !variables[string(value)] {
+			return gqlErrorAt(location, "the variable $%s isn't defined", value)
+		}
+	case []any:
+		for _, item := range value {
+			if err := validateValue(item, variables, location); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		for _, item := range value {
+			if err := validateValue(item, variables, location); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// coerceValue gives a value of a request, or of its JSON variables, the Go type
+// of its GraphQL type.
+func coerceValue(typ string, value any) (any, error) {
+	if inner, nonNull := strings.CutSuffix(typ, "!"); nonNull {
+		if value == nil {
+			return nil, fmt.Errorf("a %s is needed, not null", inner)
+		}
+		return coerceValue(inner, value)
+	}
+	if value == nil {
+		return nil, nil
+	}
+	if strings.HasPrefix(typ, "[") {
+		inner := typ[1 : len(typ)-1]
+		items, ok := value.([]any)
+		if !ok {
+			items = []any{value}
+		}
+		list := make([]any, len(items))
+		for i, item := range items {
+			coerced, err := coerceValue(inner, item)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = coerced
+		}
+		return list, nil
+	}
+	switch typ {
+	case "String", "ID":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		if n, ok := value.(int); ok && typ == "ID" {
+			return strconv.Itoa(n), nil
+		}
+	case "Int":
+		switch n := value.(type) {
+		case int:
+			return n, nil
+		case float64:
+			if n == math.Trunc(n) && n <= math.MaxInt32 && n >= math.MinInt32 {
+				return int(n), nil
+			}
+		}
+	case "Float":
+		switch n := value.(type) {
+		case int:
+			return float64(n), nil
+		case float64:
+			return n, nil
+		}
+	case "Boolean":
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not of the type %s", gqlDescribe(value), typ)
+}
+
+func gqlDescribe(value any) string {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
+}
+
+// gqlObject is an object of the response, which keeps the order its fields were
+// selected in.
+type gqlObject struct {
+	keys   []string
+	values map[string]any
+}
+
+func (object *gqlObject) MarshalJSON() ([]byte, error) {
+	var out bytes.Buffer
+	out.WriteByte('{')
+	for i, key := range object.keys {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		out.Write(name)
+		out.WriteByte(':')
+		value, err := json.Marshal(object.values[key])
+		if err != nil {
+			return nil, err
+		}
+		out.Write(value)
+	}
+	out.WriteByte('}')
+	return out.Bytes(), nil
+}
+
+// gqlExecution is the run of an operation, which collects the errors of its
+// fields.
+type gqlExecution struct {
+	wiki      *Wiki
+	schema    *gqlSchema
+	doc       *gqlDocument
+	req       *graphqlRequest
+	variables map[string]any
+	errors    []*gqlError
+}
+
+// operation picks the operation of the name, or the only one.
+func (doc *gqlDocument) operation(name string) (*gqlOperation, error) {
+	for _, op := range doc.operations {
+		if op.name == name || name == "" && len(doc.operations) == 1 {
+			return op, nil
+		}
+	}
+	if name == "" {
+		return nil, &gqlError{Message: "give the operationName of the operation to run"}
+	}
+	return nil, &gqlError{Message: "there's no operation " + name}
+}
+
+// coerceVariables gives the operation's variables their values of the request,
+// or their defaults.
+func coerceVariables(op *gqlOperation, given map[string]any) (map[string]any, error) {
+	variables := make(map[string]any)
+	for _, definition := range op.variables {
+		value, ok := given[definition.name]
+		if !ok && definition.hasDefault {
+			value, ok = definition.defaultVal, true
+		}
+		if !ok && !strings.HasSuffix(definition.typ, "!") {
+			continue
+		}
+		coerced, err := coerceValue(definition.typ, value)
+		if err != nil {
+			return nil, gqlErrorAt(op.location, "$%s: %v", definition.name, err)
+		}
+		variables[definition.name] = coerced
+	}
+	return variables, nil
+}
+
+// execute runs the operation, the fields of a mutation one after the other,
+// and returns its data, nil when a field that can't be null failed.
+func (ex *gqlExecution) execute(op *gqlOperation) *gqlObject {
+	root := ex.schema.lookup(strings.ToUpper(op.kind[:1]) + op.kind[1:])
+	data, _ := ex.object(root, nil, op.selections, nil, 0)
+	return data
+}
+
+// resolveValue replaces the variables of an argument's value with theirs.
+func (ex *gqlExecution) resolveValue(value any) any {
+	switch value := value.(type) {
+	case gqlVariable:
+		return ex.variables[string(value)]
+	case gqlEnum:
+		return string(value)
+	case []any:
+		list := make([]any, len(value))
+		for i, item := range value {
+			list[i] = ex.resolveValue(item)
+		}
+		return list
+	case map[string]any:
+		object := make(map[string]any, len(value))
+		for name, item := range value {
+			object[name] = ex.resolveValue(item)
+		}
+		return object
+	}
+	return value
+}
+
+// included reports whether the directives of the selection leave it in.
+func (ex *gqlExecution) included(sel *gqlSelection) bool {
+	for _, directive := range sel.directives {
+		condition, _ := ex.resolveValue(directive.arguments[0].value).(bool)
+		if directive.name == "skip" && condition || directive.name == "include" && !condition {
+			return false
+		}
+	}
+	return true
+}
+
+// collect gathers the fields of the selections, those of their fragments
+// included, by the key of their value; a key selected twice has the selections
+// of both.
+func (ex *gqlExecution) collect(selections []*gqlSelection, keys []string, fields map[string][]*gqlSelection) []string {
+	for _, sel := range selections {
+		if !ex.included(sel) {
+			continue
+		}
+		switch {
+		case sel.spread != "":
+			keys = ex.collect(ex.doc.fragments[sel.spread].selections, keys, fields)
+		case sel.inline:
+			keys = ex.collect(sel.selections, keys, fields)
+		default:
+			if fields[sel.key()] == nil {
+				keys = append(keys, sel.key())
+			}
+			fields[sel.key()] = append(fields[sel.key()], sel)
+		}
+	}
+	return keys
+}
+
+// object resolves the selected fields of an object of the type. It's false when
+// a field that can't be null is, which makes the object null.
+func (ex *gqlExecution) object(t *gqlType, source any, selections []*gqlSelection, path []any, depth int) (*gqlObject, bool) {
+	fields := make(map[string][]*gqlSelection)
+	object := &gqlObject{keys: ex.collect(selections, nil, fields), values: make(map[string]any)}
+	for _, key := range object.keys {
+		sel := fields[key][0]
+		fieldPath := append(append([]any{}, path...), key)
+		if sel.name == "__typename" {
+			object.values[key] = t.name
+			continue
+		}
+		field := t.field(sel.name)
+		if depth >= graphqlMaxDepth {
+			ex.fail(sel, fieldPath, badRequest(fmt.Sprintf("the query nests deeper than %d fields", graphqlMaxDepth)))
+			return nil, false
+		}
+		args := make(map[string]any)
+		var err error
+		for _, definition := range field.arguments {
+			value, given := any(nil), false
+			for _, arg := range sel.arguments {
+				if arg.name == definition.name {
+					value, given = ex.resolveValue(arg.value), true
+				}
+				if variable, ok := arg.value.(gqlVariable); ok && arg.name == definition.name {
+					_, given = ex.variables[string(variable)]
+				}
+			}
+			if !given {
+				value = definition.defaultVal
+			}
+			if args[definition.name], err = coerceValue(definition.typ, value); err != nil {
+				err = badRequest(fmt.Sprintf("the argument %s: %v", definition.name, err))
+				break
+			}
+		}
+		var value any
+		if err == nil {
+			value, err = field.resolve(ex.req, source, args)
+		}
+		if err != nil {
+			ex.fail(sel, fieldPath, err)
+			if strings.HasSuffix(field.typ, "!") {
+				return nil, false
+			}
+			object.values[key] = nil
+			continue
+		}
+		var subselections []*gqlSelection
+		for _, same := range fields[key] {
+			subselections = append(subselections, same.selections...)
+		}
+		completed, ok := ex.complete(field.typ, value, sel, subselections, fieldPath, depth+1)
+		if !ok {
+			return nil, false
+		}
+		object.values[key] = completed
+	}
+	return object, true
+}
+
+// complete makes the value of the type of what a field resolved to. It's false
+// when the value is null where it can't be; a nullable value is null instead of
+// a field below it that failed.
+func (ex *gqlExecution) complete(typ string, value any, sel *gqlSelection, selections []*gqlSelection, path []any, depth int) (any, bool) {
+	inner, nonNull := strings.CutSuffix(typ, "!")
+	completed, ok := ex.completeNullable(inner, value, sel, selections, path, depth)
+	if !nonNull {
+		return completed, true
+	}
+	if ok && completed == nil {
+		ex.fail(sel, path, &HTTPError{Status: http.StatusInternalServerError, Message: sel.key() + " can't be null"})
+	}
+	return completed, ok && completed != nil
+}
+
+func (ex *gqlExecution) completeNullable(typ string, value any, sel *gqlSelection, selections []*gqlSelection, path []any, depth int) (any, bool) {
+	if value == nil {
+		return nil, true
+	}
+	if strings.HasPrefix(typ, "[") {
+		items, _ := value.([]any)
+		list := make([]any, len(items))
+		for i, item := range items {
+			completed, ok := ex.complete(typ[1:len(typ)-1], item, sel, selections, append(append([]any{}, path...), i), depth)
+			if !ok {
+				return nil, false
+			}
+			list[i] = completed
+		}
+		return list, true
+	}
+	if t := ex.schema.lookup(typ); t != nil {
+		object, ok := ex.object(t, value, selections, path, depth)
+		if !ok {
+			return nil, false
+		}
+		return object, true
+	}
+	return value, true
+}
+
+func (ex *gqlExecution) fail(sel *gqlSelection, path []any, err error) {
+	ex.errors = append(ex.errors, &gqlError{Message: ex.req.message(err, path), Locations: []gqlLocation{sel.location}, Path: path})
+}
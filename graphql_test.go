@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// postGraphQL POSTs the query to /graphql with the header, returning the status
+// and the decoded answer.
+func postGraphQL(t *testing.T, query string, header http.Header) (int, map[string]any) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(query))
+	r.Header = header.Clone()
+	if r.Header == nil {
+		r.Header = make(http.Header)
+	}
+	r.Header.Set("Content-Type", "application/graphql")
+	w := httptest.NewRecorder()
+	testWiki.graphqlHandler(w, r)
+	var answer map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &answer); err != nil {
+		t.Fatalf("%s: the answer %q is not JSON: %v", query, w.Body.String(), err)
+	}
+	return w.Code, answer
+}
+
+// firstError is the message of the first error of the answer, "" without one.
+func firstError(answer map[string]any) string {
+	errors, _ := answer["errors"].([]any)
+	if len(errors) == 0 {
+		return ""
+	}
+	message, _ := errors[0].(map[string]any)["message"].(string)
+	return message
+}
+
+func TestParseGraphQLErrors(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{``, "no query or mutation"},
+		{`{`, "expected"},
+		{`{ }`, "can't be empty"},
+		{`{ page(title: "a" }`, "expected"},
+		{`{ page(title: "a) { title } }`, "string"},
+		{`subscription { page(title: "a") { title } }`, "subscriptions are not supported"},
+		{`fragment F on Page { title } fragment F on Page { size }`, "two fragments called F"},
+		{`fragment F on Page { title }`, "no query or mutation"},
+		{`{ page(title: "a") { title } } garbage`, "expected a query, a mutation or a fragment"},
+		{`{ a` + strings.Repeat(` { a`, 4*graphqlMaxDepth+1), "nest too deep"},
+	}
+	for _, test := range tests {
+		_, err := parseGraphQL(test.query)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("parseGraphQL(%.40q) = %v, want an error containing %q", test.query, err, test.want)
+		}
+	}
+	if _, err := parseGraphQL(`query Q($t: String!) { page(title: $t) { ...F ... on Page { size } } } fragment F on Page { title }`); err != nil {
+		t.Errorf("parseGraphQL of a valid query: %v", err)
+	}
+}
+
+func TestGraphQLLimits(t *testing.T) {
+	savePages(t, map[string]string{"GraphQLPing": "links to [[GraphQLPong]]", "GraphQLPong": "links to [[GraphQLPing]]"})
+	nested := func(depth int) string {
+		return `{ page(title: "GraphQLPing") { ` + strings.Repeat(`links { page { `, depth) + `title` + strings.Repeat(` } }`, depth) + ` } }`
+	}
+	tests := []struct {
+		name, query string
+		status      int
+		want        string
+	}{
+		{"within the depth", nested(graphqlMaxDepth/2 - 1), http.StatusOK, ""},
+		{"past the depth", nested(graphqlMaxDepth / 2), http.StatusOK, "nests deeper than"},
+		{"past the size", `{ page(title: "` + strings.Repeat("a", int(testWiki.config.MaxPageBytes)+graphqlMaxQueryBytes) + `") { title } }`,
+			http.StatusRequestEntityTooLarge, "bytes it may have"},
+		{"unknown field", `{ page(title: "GraphQLPing") { secret } }`, http.StatusBadRequest, "secret"},
+	}
+	for _, test := range tests {
+		status, answer := postGraphQL(t, test.query, nil)
+		if message := firstError(answer); status != test.status || !strings.Contains(message, test.want) || (test.want == "") != (message == "") {
+			t.Errorf("%s: status %d, error %q, want %d and an error containing %q", test.name, status, message, test.status, test.want)
+		}
+	}
+}
+
+// graphqlTitles collects the values of the "title" fields of the answer, at any
+// depth.
+func graphqlTitles(value any) []string {
+	var found []string
+	switch value := value.(type) {
+	case map[string]any:
+		for key, field := range value {
+			if title, ok := field.(string); ok && key == "title" {
+				found = append(found, title)
+			} else {
+				found = append(found, graphqlTitles(field)...)
+			}
+		}
+	case []any:
+		for _, item := range value {
+			found = append(found, graphqlTitles(item)...)
+		}
+	}
+	return found
+}
+
+func TestGraphQLHidesUnreadablePages(t *testing.T) {
+	previous := testWiki.config.AdminToken
+	testWiki.config.AdminToken = "test-token"
+	defer func() { testWiki.config.AdminToken = previous }()
+	savePages(t, map[string]string{
+		aclTitle:         "gqlsecret editor alice\n",
+		"gqlsecret/Plan": "the zebrafinch plan links to [[GraphQLOpen]]",
+		"GraphQLOpen":    "the zebrafinch page anyone reads",
+	})
+	queries := map[string]string{
+		"page":          `{ page(title: "gqlsecret/Plan") { title } }`,
+		"search":        `{ search(q: "zebrafinch") { title } }`,
+		"recentChanges": `{ recentChanges(limit: 50) { title } }`,
+		"backlinks":     `{ page(title: "GraphQLOpen") { backlinks { title } } }`,
+	}
+	admin := http.Header{"X-Admin-Token": {"test-token"}}
+	for name, query := range queries {
+		status, answer := postGraphQL(t, query, nil)
+		if status != http.StatusOK || firstError(answer) != "" {
+			t.Errorf("%s: status %d, error %q", name, status, firstError(answer))
+		}
+		if slices.Contains(graphqlTitles(answer["data"]), "gqlsecret/Plan") {
+			t.Errorf("%s shows a visitor the page the ACL hides: %v", name, answer["data"])
+		}
+		if _, answer := postGraphQL(t, query, admin); !slices.Contains(graphqlTitles(answer["data"]), "gqlsecret/Plan") {
+			t.Errorf("%s doesn't show an admin the page: %v", name, answer["data"])
+		}
+	}
+}
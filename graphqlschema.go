@@ -0,0 +1,500 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// graphqlMaxQueryBytes is how long a request to /graphql may be besides the body
+// of a page it saves.
+const graphqlMaxQueryBytes = 64 << 10
+
+// graphqlRequest is what the resolvers of a request to /graphql share: who asks,
+// and the histories read for the bodies of the revisions.
+type graphqlRequest struct {
+	wiki      *Wiki
+	w         http.ResponseWriter
+	r         *http.Request
+	viewer    viewer
+	histories map[string][]Revision
+}
+
+// reads reports whether the viewer may read the page, as checkAccess lets them
+// read it at /view.
+func (req *graphqlRequest) reads(title string) bool {
+	return req.viewer.admin || req.wiki.roleOf(req.wiki.currentACL(), req.viewer.user, title) >= roleReader
+}
+
+// page returns the page of the title as a Page, nil when there's none the viewer
+// may read.
+func (req *graphqlRequest) page(title string) any {
+	title = req.wiki.titles.Canonical(title)
+	if !req.wiki.titles.Has(title) || !req.reads(title) {
+		return nil
+	}
+	return &graphqlPage{wiki: req.wiki, title: title}
+}
+
+// pages returns the pages of the titles, which the viewer is shown, as Pages.
+func (req *graphqlRequest) pages(titles []string) []any {
+	pages := make([]any, len(titles))
+	for i, title := range titles {
+		pages[i] = &graphqlPage{wiki: req.wiki, title: title}
+	}
+	return pages
+}
+
+// history returns the revisions of the page, read once for the request.
+func (req *graphqlRequest) history(title string) ([]Revision, error) {
+	if revisions, ok := req.histories[title]; ok {
+		return revisions, nil
+	}
+	revisions, err := req.wiki.loadRevisions(title)
+	if err != nil {
+		return nil, err
+	}
+	req.histories[title] = revisions
+	return revisions, nil
+}
+
+// message is what the client is told of the error of a field. The errors the
+// wiki makes for the visitor to read are told as they are; the others are
+// logged, as serveError logs them, and told by their status and the request ID.
+func (req *graphqlRequest) message(err error, path []any) string {
+	status, message, logged := classifyError(err)
+	if logged {
+		requestLogger(req.r).Error("could not resolve a GraphQL field", "path", fmt.Sprint(path), "error", err.Error())
+		return fmt.Sprintf("%s (request %s)", strings.ToLower(http.StatusText(status)), requestID(req.r))
+	}
+	if message == "" {
+		message = statusMessage(req.wiki.requestLocale(req.w), status)
+	}
+	return message
+}
+
+// graphqlPage is the value of a Page, which reads the page only for the fields
+// that need it.
+type graphqlPage struct {
+	wiki  *Wiki
+	title string
+	page  *Page
+}
+
+func (p *graphqlPage) load() (*Page, error) {
+	if p.page == nil {
+		page, err := p.wiki.load(p.title)
+		if err != nil {
+			return nil, err
+		}
+		p.page = page
+	}
+	return p.page, nil
+}
+
+func (p *graphqlPage) entry() PageEntry {
+	return p.wiki.pageIndex.entries([]string{p.title})[0]
+}
+
+// graphqlLink is the value of a Link, a link of a page to the title.
+type graphqlLink struct {
+	title string
+}
+
+// graphqlTime is a time as the schema has it, RFC 3339, null when it's unknown.
+func graphqlTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Format(time.RFC3339)
+}
+
+// graphqlString is a string that's null when it's empty.
+func graphqlString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// graphqlLimit is the limit argument, between 1 and as many changes as the API
+// lists at most.
+func graphqlLimit(args map[string]any) int {
+	limit, _ := args["limit"].(int)
+	return max(1, min(limit, maxChanges))
+}
+
+func graphqlList[T any](values []T) []any {
+	list := make([]any, len(values))
+	for i, value := range values {
+		list[i] = value
+	}
+	return list
+}
+
+// graphqlTags returns the tags of the pages the viewer is shown, sorted.
+func (wiki *Wiki) graphqlTags(v viewer) []string {
+	wiki.pageMetadata.RLock()
+	var tags []string
+	for tag := range wiki.pageMetadata.byTag {
+		tags = append(tags, tag)
+	}
+	wiki.pageMetadata.RUnlock()
+	slices.Sort(tags)
+	return slices.DeleteFunc(tags, func(tag string) bool { return len(wiki.taggedPages(v, tag)) == 0 })
+}
+
+// newGraphQLSchema returns the schema of /graphql of the wiki.
+func (wiki *Wiki) newGraphQLSchema() *gqlSchema {
+	return &gqlSchema{types: []*gqlType{
+		{name: "Query", doc: "What's read of the wiki.", fields: []*gqlField{
+			{name: "page", typ: "Page", doc: "The page of the title, null when there's none the viewer may read.",
+				arguments: []gqlArgumentDefinition{{name: "title", typ: "String!"}},
+				resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+					return req.page(args["title"].(string)), nil
+				}},
+			{name: "search", typ: "[SearchResult!]!", doc: "The pages matching the query, as /search finds them.",
+				arguments: []gqlArgumentDefinition{{name: "q", typ: "String!"}, {name: "limit", typ: "Int", defaultVal: 20}},
+				resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+					results := wiki.searchPages(args["q"].(string), req.viewer)
+					return graphqlList(results[:min(len(results), graphqlLimit(args))]), nil
+				}},
+			{name: "recentChanges", typ: "[Revision!]!", doc: "The latest revisions of the pages, newest first, only those by the author if it's given.",
+				arguments: []gqlArgumentDefinition{{name: "limit", typ: "Int", defaultVal: defaultChanges}, {name: "author", typ: "String"}},
+				resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+					author, _ := args["author"].(string)
+					changes, err := wiki.recentChanges(req.viewer, author, graphqlLimit(args))
+					return graphqlList(changes), err
+				}},
+			{name: "tag", typ: "Tag", doc: "The tag, null when none of the pages the viewer is shown has it.",
+				arguments: []gqlArgumentDefinition{{name: "name", typ: "String!"}},
+				resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+					if len(wiki.taggedPages(req.viewer, args["name"].(string))) == 0 {
+						return nil, nil
+					}
+					return args["name"].(string), nil
+				}},
+			{name: "tags", typ: "[Tag!]!", doc: "The tags of the pages, sorted by name.",
+				resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+					return graphqlList(wiki.graphqlTags(req.viewer)), nil
+				}},
+		}},
+		{name: "Mutation", doc: "What's changed of the wiki, with the login or admin token the editor needs.", fields: []*gqlField{
+			{name: "savePage", typ: "Page!", doc: "Creates or replaces the page.",
+				arguments: []gqlArgumentDefinition{{name: "title", typ: "String!"}, {name: "body", typ: "String!"}},
+				resolve:   wiki.resolveSavePage},
+			{name: "deletePage", typ: "Boolean!", doc: "Moves the page to the trash.",
+				arguments: []gqlArgumentDefinition{{name: "title", typ: "String!"}},
+				resolve:   wiki.resolveDeletePage},
+		}},
+		{name: "Page", doc: "A page of the wiki.", fields: []*gqlField{
+			{name: "title", typ: "String!", resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+				return source.(*graphqlPage).title, nil
+			}},
+			{name: "displayTitle", typ: "String!", doc: "The title shown for the page, its title when it has no display title.",
+				resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+					return wiki.displayTitle(source.(*graphqlPage).title), nil
+				}},
+			{name: "body", typ: "String!", doc: "The source of the page.", resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+				page, err := source.(*graphqlPage).load()
+				if err != nil {
+					return nil, err
+				}
+				return string(page.Body), nil
+			}},
+			{name: "html", typ: "String!", doc: "The page rendered to HTML, as the pages API renders it.",
+				resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+					page, err := source.(*graphqlPage).load()
+					if err != nil {
+						return nil, err
+					}
+					return string(wiki.rendererFor(page.Title, false).Render(wiki.embedAttachments(page.Title, page.Body))), nil
+				}},
+			{name: "modified", typ: "String", doc: "When the page was changed last, in RFC 3339.",
+				resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+					return graphqlTime(source.(*graphqlPage).entry().Modified), nil
+				}},
+			{name: "author", typ: "String", doc: "Who changed the page last.", resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+				return graphqlString(source.(*graphqlPage).entry().Author), nil
+			}},
+			{name: "size", typ: "Int!", doc: "The length of the body in bytes.", resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+				return source.(*graphqlPage).entry().Size, nil
+			}},
+			{name: "tags", typ: "[Tag!]!", resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+				return graphqlList(wiki.metadataOf(source.(*graphqlPage).title).Tags), nil
+			}},
+			{name: "links", typ: "[Link!]!", doc: "The titles the page links to, sorted, whether their pages exist or not.",
+				resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+					page, err := source.(*graphqlPage).load()
+					if err != nil {
+						return nil, err
+					}
+					var links []any
+					for _, title := range wiki.linksOf(page) {
+						links = append(links, graphqlLink{title: title})
+					}
+					return links, nil
+				}},
+			{name: "backlinks", typ: "[Page!]!", doc: "The pages linking to the page, sorted.",
+				resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+					return req.pages(req.viewer.filter(wiki.backlinksOf(source.(*graphqlPage).title))), nil
+				}},
+			{name: "revisions", typ: "[Revision!]!", doc: "The revisions of the page, newest first.",
+				arguments: []gqlArgumentDefinition{{name: "limit", typ: "Int", defaultVal: defaultChanges}},
+				resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+					changes, err := wiki.pageChanges(source.(*graphqlPage).title, "")
+					return graphqlList(changes[:min(len(changes), graphqlLimit(args))]), err
+				}},
+			{name: "revision", typ: "Revision", doc: "The revision of the number, null when the page has none.",
+				arguments: []gqlArgumentDefinition{{name: "id", typ: "Int!"}},
+				resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+					title := source.(*graphqlPage).title
+					revisions, err := req.history(title)
+					if err != nil {
+						return nil, err
+					}
+					for _, revision := range revisions {
+						if revision.ID == args["id"].(int) {
+							return changeOf(title, revision), nil
+						}
+					}
+					return nil, nil
+				}},
+		}},
+		{name: "Revision", doc: "A saved version of a page.", fields: []*gqlField{
+			{name: "id", typ: "Int!", doc: "The number of the revision, counted from 1 for every page.",
+				resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+					return source.(Change).Revision, nil
+				}},
+			{name: "title", typ: "String!", resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+				return source.(Change).Title, nil
+			}},
+			{name: "page", typ: "Page", doc: "The page, null when it was deleted since.", resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+				return req.page(source.(Change).Title), nil
+			}},
+			{name: "author", typ: "String", resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+				return graphqlString(source.(Change).Author), nil
+			}},
+			{name: "time", typ: "String", doc: "When the revision was saved, in RFC 3339.", resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+				return graphqlTime(source.(Change).Time), nil
+			}},
+			{name: "comment", typ: "String", resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+				return graphqlString(source.(Change).Comment), nil
+			}},
+			{name: "size", typ: "Int!", doc: "The length of the body in bytes.", resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+				return source.(Change).Size, nil
+			}},
+			{name: "body", typ: "String!", doc: "The source of the page as the revision saved it.",
+				resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+					change := source.(Change)
+					revisions, err := req.history(change.Title)
+					if err != nil {
+						return nil, err
+					}
+					for _, revision := range revisions {
+						if revision.ID == change.Revision {
+							return revision.Body, nil
+						}
+					}
+					return "", nil
+				}},
+		}},
+		{name: "Tag", doc: "A tag of the pages.", fields: []*gqlField{
+			{name: "name", typ: "String!", resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+				return source.(string), nil
+			}},
+			{name: "count", typ: "Int!", doc: "How many of the pages the viewer is shown have the tag.",
+				resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+					return len(wiki.taggedPages(req.viewer, source.(string))), nil
+				}},
+			{name: "pages", typ: "[Page!]!", doc: "The pages with the tag, in the order of the listings.",
+				resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+					return req.pages(wiki.taggedPages(req.viewer, source.(string))), nil
+				}},
+		}},
+		{name: "Link", doc: "A link of a page to a title of the wiki.", fields: []*gqlField{
+			{name: "title", typ: "String!", resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+				return source.(graphqlLink).title, nil
+			}},
+			{name: "exists", typ: "Boolean!", doc: "Whether the title has a page the viewer is shown.",
+				resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+					title := source.(graphqlLink).title
+					return wiki.titles.Has(title) && req.viewer.lists(title), nil
+				}},
+			{name: "page", typ: "Page", doc: "The page linked to, null when there's none the viewer may read.",
+				resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+					return req.page(source.(graphqlLink).title), nil
+				}},
+		}},
+		{name: "SearchResult", doc: "A page matching a search.", fields: []*gqlField{
+			{name: "title", typ: "String!", resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+				return source.(SearchResult).Title, nil
+			}},
+			{name: "page", typ: "Page", resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+				return req.page(source.(SearchResult).Title), nil
+			}},
+			{name: "score", typ: "Int!", doc: "How often the page mentions the terms.", resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+				return source.(SearchResult).Score, nil
+			}},
+			{name: "extract", typ: "String!", doc: "The start of the page in plain text.", resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+				return source.(SearchResult).Extract, nil
+			}},
+			{name: "snippet", typ: "String!", doc: "The HTML of the words around the terms, which are in <mark>.",
+				resolve: func(req *graphqlRequest, source any, args map[string]any) (any, error) {
+					return string(source.(SearchResult).Snippet), nil
+				}},
+		}},
+	}}
+}
+
+// resolveSavePage saves the page as a PUT to the pages API does.
+func (wiki *Wiki) resolveSavePage(req *graphqlRequest, source any, args map[string]any) (any, error) {
+	title, body := wiki.titles.Canonical(args["title"].(string)), args["body"].(string)
+	if !validTitle.MatchString(title) {
+		return nil, badRequest(title + " is not a title of the wiki")
+	}
+	if isReserved(title) {
+		return nil, reservedError(title)
+	}
+	if ok, reason := wiki.canEdit(req.r, title); !ok {
+		return nil, forbidden(reason)
+	}
+	if int64(len(body)) > wiki.config.MaxPageBytes {
+		return nil, &quotaError{fmt.Sprintf("the page is more than the %d bytes a page may have", wiki.config.MaxPageBytes)}
+	}
+	if err := wiki.checkPageSize(title, int64(len(body))); err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(title, aclTitle) {
+		if _, err := parseACL([]byte(body)); err != nil {
+			return nil, badRequest(err.Error())
+		}
+	}
+	page := &Page{Title: title, Body: []byte(body)}
+	if err := wiki.savePage(page, wiki.requestAuthor(req.r)); err != nil {
+		return nil, err
+	}
+	return &graphqlPage{wiki: wiki, title: title, page: page}, nil
+}
+
+// resolveDeletePage deletes the page as a DELETE to the pages API does.
+func (wiki *Wiki) resolveDeletePage(req *graphqlRequest, source any, args map[string]any) (any, error) {
+	title := wiki.titles.Canonical(args["title"].(string))
+	if ok, reason := wiki.canEdit(req.r, title); !ok {
+		return nil, forbidden(reason)
+	}
+	if !wiki.titles.Has(title) {
+		return nil, notFound("there is no page " + title)
+	}
+	if err := wiki.deletePage(title, wiki.requestAuthor(req.r)); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+// graphqlParams are the query, variables and operation name of a request.
+type graphqlParams struct {
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables"`
+	OperationName string         `json:"operationName"`
+}
+
+// graphqlResponse is the answer to a request that was run.
+type graphqlResponse struct {
+	Data   *gqlObject  `json:"data"`
+	Errors []*gqlError `json:"errors,omitempty"`
+}
+
+func writeGraphQLError(w http.ResponseWriter, status int, err error) {
+	gqlErr, ok := err.(*gqlError)
+	if !ok {
+		gqlErr = &gqlError{Message: err.Error()}
+	}
+	writeJSON(w, status, map[string][]*gqlError{"errors": {gqlErr}})
+}
+
+// graphqlHandler serves /graphql. POST runs the query or mutation of an
+// application/json body of its query, variables and operationName, or of an
+// application/graphql one; GET runs ?query= with ?variables= and
+// ?operationName=, but no mutations, and answers the schema without a query.
+// The viewer is the one of the login or admin token, who's shown the pages
+// they're shown on the wiki and may change those they may edit; a POST with the
+// cookies of the wiki has to carry their X-CSRF-Token, as the scripts' do.
+func (wiki *Wiki) graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	var params graphqlParams
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		query := r.URL.Query()
+		if !query.Has("query") {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			io.WriteString(w, wiki.graphqlSchema.sdl())
+			return
+		}
+		params.Query, params.OperationName = query.Get("query"), query.Get("operationName")
+		if variables := query.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &params.Variables); err != nil {
+				writeGraphQLError(w, http.StatusBadRequest, fmt.Errorf("variables is not a JSON object: %v", err))
+				return
+			}
+		}
+	case http.MethodPost:
+		reader := http.MaxBytesReader(w, r.Body, wiki.config.MaxPageBytes+graphqlMaxQueryBytes)
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		var err error
+		switch mediaType {
+		case "application/json":
+			err = json.NewDecoder(reader).Decode(&params)
+		case "application/graphql":
+			var query []byte
+			query, err = io.ReadAll(reader)
+			params.Query = string(query)
+		default:
+			writeGraphQLError(w, http.StatusUnsupportedMediaType, errors.New("send the query as application/json or application/graphql"))
+			return
+		}
+		if tooLarge(err) {
+			writeGraphQLError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("the request is more than the %d bytes it may have", wiki.config.MaxPageBytes+graphqlMaxQueryBytes))
+			return
+		} else if err != nil {
+			writeGraphQLError(w, http.StatusBadRequest, fmt.Errorf("invalid body: %v", err))
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		writeGraphQLError(w, http.StatusMethodNotAllowed, errors.New("use GET or POST"))
+		return
+	}
+	doc, err := parseGraphQL(params.Query)
+	if err == nil {
+		err = wiki.graphqlSchema.validate(doc)
+	}
+	var op *gqlOperation
+	if err == nil {
+		op, err = doc.operation(params.OperationName)
+	}
+	if err != nil {
+		writeGraphQLError(w, http.StatusBadRequest, err)
+		return
+	}
+	if op.kind == "mutation" && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeGraphQLError(w, http.StatusMethodNotAllowed, errors.New("mutations are POSTed"))
+		return
+	}
+	variables, err := coerceVariables(op, params.Variables)
+	if err != nil {
+		writeGraphQLError(w, http.StatusBadRequest, err)
+		return
+	}
+	req := &graphqlRequest{wiki: wiki, w: w, r: r, viewer: wiki.viewerOf(r), histories: make(map[string][]Revision)}
+	ex := &gqlExecution{wiki: wiki, schema: wiki.graphqlSchema, doc: doc, req: req, variables: variables}
+	data := ex.execute(op)
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, graphqlResponse{Data: data, Errors: ex.errors})
+}
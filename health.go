@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"os"
+)
+
+// healthzHandler serves /healthz, the liveness probe: the process is up and
+// serving requests.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// readinessCheck is one of the checks of /readyz, named in its answer.
+type readinessCheck struct {
+	name  string
+	check func() error
+}
+
+// Readiness is the answer of /readyz: whether the wiki is ready, and how every
+// check went, "ok" or its error.
+type Readiness struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+// readyzHandler serves /readyz, the readiness probe: it's answered 200 when the
+// data directory can be written to, the templates parse, the store answers and
+// the startup scan is done, and 503 otherwise, so a load balancer sends no
+// requests that would fail.
+func (wiki *Wiki) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	readiness := Readiness{Ready: true, Checks: make(map[string]string, len(wiki.readinessChecks))}
+	for _, check := range wiki.readinessChecks {
+		readiness.Checks[check.name] = "ok"
+		if err := check.check(); err != nil {
+			readiness.Ready, readiness.Checks[check.name] = false, err.Error()
+		}
+	}
+	status := http.StatusOK
+	if !readiness.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, readiness)
+}
+
+// checkDataWritable writes a file to the data directory and removes it again.
+func (wiki *Wiki) checkDataWritable() error {
+	file, err := os.CreateTemp(wiki.config.DataDir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// checkTemplates parses the templates in -dev mode, where they're read again on
+// every page; otherwise they were parsed at startup.
+func (wiki *Wiki) checkTemplates() error {
+	_, err := wiki.currentTemplates(wiki.config.Theme, wiki.config.Locale)
+	return err
+}
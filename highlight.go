@@ -0,0 +1,81 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// A Highlighter turns the code of a fenced block in a language into HTML,
+// spans with classes styled by static/highlight.css, which the sanitizer keeps.
+// It reports false for a language it doesn't know, and the block is shown as it
+// is.
+type Highlighter func(language, code string) (string, bool)
+
+// highlighters are the highlighters -highlight picks from by name. "none" turns
+// highlighting off; the others are built in with their tags, like chroma.
+var highlighters = map[string]Highlighter{
+	"none": nil,
+}
+
+// openHighlighter returns the named highlighter; the empty name picks the one
+// built in, if there is exactly one.
+func openHighlighter(name string) (Highlighter, error) {
+	if name == "" {
+		var built []Highlighter
+		for other, highlighter := range highlighters {
+			if other != "none" {
+				built = append(built, highlighter)
+			}
+		}
+		if len(built) == 1 {
+			return built[0], nil
+		}
+		return nil, nil
+	}
+	highlighter, ok := highlighters[name]
+	if !ok {
+		var names []string
+		for other := range highlighters {
+			names = append(names, other)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("%q is an unknown highlighter, expected one of %s", name, strings.Join(names, ", "))
+	}
+	return highlighter, nil
+}
+
+// highlightCode renders a fenced code block in the language, through the
+// highlighter if it knows the language.
+func (wiki *Wiki) highlightCode(language, code string) (string, bool) {
+	if wiki.highlight == nil || language == "" {
+		return "", false
+	}
+	return wiki.highlight(language, code)
+}
+
+// staticFiles are the files the wiki serves under /static/ as they are, like
+// the stylesheet of the highlighted code.
+//
+//go:embed static
+var staticFiles embed.FS
+
+// staticHandler serves /static/ from the files built into the binary. They only
+// change with it, so browsers keep them for a day.
+func staticHandler() http.Handler {
+	files := http.FileServer(http.FS(staticFiles))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		files.ServeHTTP(w, r)
+	})
+}
+
+// highlightCSS is the "highlightCSS" template function: the stylesheet of the
+// highlighted code, for the snapshots, which keep their styles inline.
+func highlightCSS() template.CSS {
+	data, _ := staticFiles.ReadFile("static/highlight.css")
+	return template.CSS(data)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// HistoryPage is the data of the history template.
+type HistoryPage struct {
+	Title     string
+	Author    string
+	Revisions []Revision // newest first
+}
+
+// DiffPage is the data of the diff template: the Lines of a diff by "line", or
+// the Words of one by "word", computed with the Algorithm.
+type DiffPage struct {
+	Title      string
+	From, To   int
+	By         string
+	Lines      []DiffLine
+	Words      []DiffLine
+	Algorithm  string
+	Algorithms []string
+}
+
+// historyHandler serves /history/{title}, the list of the page's revisions, with
+// ?author= only those of one author.
+func (wiki *Wiki) historyHandler(w http.ResponseWriter, r *http.Request, title string) {
+	revisions, err := wiki.loadRevisions(title)
+	if err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	if revisions == nil {
+		wiki.serveError(w, notFound(""))
+		return
+	}
+	history := HistoryPage{Title: title, Author: r.URL.Query().Get("author")}
+	for idx := len(revisions) - 1; idx >= 0; idx-- {
+		if history.Author == "" || revisions[idx].Author == history.Author {
+			history.Revisions = append(history.Revisions, revisions[idx])
+		}
+	}
+	wiki.renderTemplate(w, "history.html", history)
+}
+
+// revisionParam returns the revision numbered by the query or form value name,
+// or fallback when there is none.
+func revisionParam(r *http.Request, name string, revisions []Revision, fallback int) (Revision, error) {
+	id := fallback
+	if value := r.FormValue(name); value != "" {
+		var err error
+		if id, err = strconv.Atoi(value); err != nil {
+			return Revision{}, fmt.Errorf("%s should be a revision number", name)
+		}
+	}
+	if id < 1 || id > len(revisions) {
+		return Revision{}, fmt.Errorf("there is no revision %d", id)
+	}
+	return revisions[id-1], nil
+}
+
+// diffHandler serves /diff/{title}?from=N&to=M, the line diff between two
+// revisions, or with ?by=word their word diff, computed with the ?algorithm=. By
+// default it compares the latest revision with the one before it.
+func (wiki *Wiki) diffHandler(w http.ResponseWriter, r *http.Request, title string) {
+	revisions, err := wiki.loadRevisions(title)
+	if err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	if revisions == nil {
+		wiki.serveError(w, notFound(""))
+		return
+	}
+	to, err := revisionParam(r, "to", revisions, len(revisions))
+	if err != nil {
+		wiki.serveError(w, badRequest(err.Error()))
+		return
+	}
+	from, err := revisionParam(r, "from", revisions, max(to.ID-1, 1))
+	if err != nil {
+		wiki.serveError(w, badRequest(err.Error()))
+		return
+	}
+	diff := DiffPage{Title: title, From: from.ID, To: to.ID, By: r.FormValue("by"), Algorithm: r.FormValue("algorithm"), Algorithms: diffAlgorithmNames()}
+	if diff.By == "" {
+		diff.By = "line"
+	}
+	if diff.Algorithm == "" {
+		diff.Algorithm = defaultDiffAlgorithm
+	}
+	algorithm, ok := diffAlgorithms[diff.Algorithm]
+	if !ok {
+		http.Error(w, translate(wiki.requestLocale(w), "error.noDiffAlgorithm", diff.Algorithm), http.StatusBadRequest)
+		return
+	}
+	switch diff.By {
+	case "line":
+		diff.Lines = diffLinesWith(algorithm, from.Body, to.Body)
+	case "word":
+		diff.Words = diffWords(algorithm, from.Body, to.Body)
+	default:
+		http.Error(w, translate(wiki.requestLocale(w), "error.diffUnit"), http.StatusBadRequest)
+		return
+	}
+	wiki.renderTemplate(w, "diff.html", diff)
+}
+
+// revertHandler serves POST /revert/{title} with the form value "revision": the
+// page goes back to that revision's body, recorded as a new revision.
+func (wiki *Wiki) revertHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if ok, reason := wiki.canEdit(r, title); !ok {
+		wiki.serveError(w, forbidden(reason))
+		return
+	}
+	revisions, err := wiki.loadRevisions(title)
+	if err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	revision, err := revisionParam(r, "revision", revisions, 0)
+	if err != nil {
+		wiki.serveError(w, badRequest(err.Error()))
+		return
+	}
+	wiki.restoreRevision(w, r, title, revision.Body, fmt.Sprintf("revert to revision %d", revision.ID))
+}
+
+// restoreRevision saves body as the page's current version, records it as a new
+// revision with comment, and redirects to the page.
+func (wiki *Wiki) restoreRevision(w http.ResponseWriter, r *http.Request, title, body, comment string) {
+	page := &Page{Title: title, Body: []byte(body)}
+	if err := wiki.storePage(page, wiki.requestAuthor(r), comment); err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	revision, err := wiki.recordRevision(page, wiki.requestAuthor(r), comment)
+	if err == nil {
+		err = wiki.journalRevision("save", title, revision)
+	}
+	if err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/view/"+title, http.StatusFound)
+}
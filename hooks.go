@@ -0,0 +1,121 @@
+package main
+
+import "strings"
+
+// PageHook is told of the pages as they're saved and deleted, so what's kept of
+// them, like the indexes, follows them without the handlers knowing of it. The
+// hooks are called in the order they were registered, by whichever goroutine
+// saves the page.
+//
+// A PageHook that has the method AfterChange(JournalEntry) too is called with the
+// changes as the journal records them as well: saves and deletions with their
+// revision and comment, renames, and new users. It's called with the journal
+// locked, so it mustn't journal changes itself, and leaves what's slow, like
+// mailing, to a goroutine.
+type PageHook interface {
+	// BeforeSave may refuse the save of the page with an error, before it's
+	// stored.
+	BeforeSave(p *Page, author string) error
+	// AfterSave is called once the page is stored.
+	AfterSave(p *Page, author string)
+	// AfterDelete is called once the page is taken out of the store and the
+	// title registry.
+	AfterDelete(title, author string)
+}
+
+// changeHook is a PageHook that's told of the changes of the journal too.
+type changeHook interface {
+	AfterChange(entry JournalEntry)
+}
+
+// noPageHook does nothing, for a PageHook to embed the methods it has no use for.
+type noPageHook struct{}
+
+func (noPageHook) BeforeSave(p *Page, author string) error { return nil }
+func (noPageHook) AfterSave(p *Page, author string)        {}
+func (noPageHook) AfterDelete(title, author string)        {}
+
+// registerPageHook adds the hook to those called with every save and deletion.
+// Hooks are registered before the wiki is set up, by newWiki or setup.
+func (wiki *Wiki) registerPageHook(hook PageHook) {
+	wiki.pageHooks = append(wiki.pageHooks, hook)
+}
+
+// beforeSave asks the hooks whether the page may be saved.
+func (wiki *Wiki) beforeSave(p *Page, author string) error {
+	for _, hook := range wiki.pageHooks {
+		if err := hook.BeforeSave(p, author); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (wiki *Wiki) afterSave(p *Page, author string) {
+	for _, hook := range wiki.pageHooks {
+		hook.AfterSave(p, author)
+	}
+}
+
+func (wiki *Wiki) afterDelete(title, author string) {
+	for _, hook := range wiki.pageHooks {
+		hook.AfterDelete(title, author)
+	}
+}
+
+// afterChange tells the change hooks of the journal entry.
+func (wiki *Wiki) afterChange(entry JournalEntry) {
+	for _, hook := range wiki.pageHooks {
+		if observer, ok := hook.(changeHook); ok {
+			observer.AfterChange(entry)
+		}
+	}
+}
+
+// indexHook keeps the search index, the cached searches, the link index and the
+// front page's index up to date.
+type indexHook struct {
+	wiki *Wiki
+	noPageHook
+}
+
+func (hook indexHook) AfterSave(p *Page, author string) {
+	hook.wiki.searchIndex.update(p)
+	hook.wiki.invalidateSearchCache()
+	hook.wiki.backlinks.update(p)
+	hook.wiki.pageIndex.update(p, author)
+}
+
+func (hook indexHook) AfterDelete(title, author string) {
+	hook.wiki.searchIndex.remove(title)
+	hook.wiki.pageIndex.remove(title)
+	hook.wiki.invalidateSearchCache()
+	hook.wiki.backlinks.remove(title)
+	hook.wiki.backlinks.relink(title)
+}
+
+// aclHook refuses an access list page that doesn't parse, and applies the rules
+// of a saved or deleted one to the title registry.
+type aclHook struct {
+	wiki *Wiki
+}
+
+func (aclHook) BeforeSave(p *Page, author string) error {
+	if !strings.EqualFold(p.Title, aclTitle) {
+		return nil
+	}
+	_, err := parseACL(p.Body)
+	return err
+}
+
+func (hook aclHook) AfterSave(p *Page, author string) {
+	if strings.EqualFold(p.Title, aclTitle) {
+		hook.wiki.titles.Rebuild()
+	}
+}
+
+func (hook aclHook) AfterDelete(title, author string) {
+	if strings.EqualFold(title, aclTitle) {
+		hook.wiki.titles.Rebuild()
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// localeCookie keeps the language a visitor chose over their browser's.
+const localeCookie = "gowiki_locale"
+
+// embeddedLocales are the message catalogs built into the binary. en has every
+// message of the templates; the others may leave some out, which are shown in
+// English.
+//
+//go:embed locales
+var embeddedLocales embed.FS
+
+// Catalog is the file locales/{locale}.json: the name of the language, in it,
+// for the language switch, and the chrome of the pages by the keys the templates
+// give the "t" function. A message is a format of fmt, whose arguments are those
+// given after the key; %[2]s and the like put them in another order.
+type Catalog struct {
+	Language string            `json:"language"`
+	Messages map[string]string `json:"messages"`
+}
+
+// catalogs are the message catalogs by locale, a lowercase language tag such as
+// en or pt-br.
+var catalogs map[string]*Catalog
+
+// loadLocales reads the built in catalogs and the {locale}.json files of dir,
+// which replace the messages of the built in ones of the same locale and keep
+// the others.
+func (wiki *Wiki) loadLocales(dir string) error {
+	catalogs = make(map[string]*Catalog)
+	embedded, _ := fs.Sub(embeddedLocales, "locales")
+	if err := addLocales(embedded); err != nil {
+		return err
+	}
+	if dir != "" {
+		if err := addLocales(os.DirFS(dir)); err != nil {
+			return err
+		}
+	}
+	wiki.config.Locale = strings.ToLower(wiki.config.Locale)
+	if catalogs[wiki.config.Locale] == nil {
+		return fmt.Errorf("there's no locale %s, the locales are %s", wiki.config.Locale, strings.Join(localeCodes(), ", "))
+	}
+	return nil
+}
+
+func addLocales(locales fs.FS) error {
+	files, err := fs.Glob(locales, "*.json")
+	if err != nil {
+		return err
+	}
+	for _, filename := range files {
+		data, err := fs.ReadFile(locales, filename)
+		if err != nil {
+			return err
+		}
+		var catalog Catalog
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			return fmt.Errorf("the locale %s: %w", filename, err)
+		}
+		locale := strings.ToLower(strings.TrimSuffix(filename, path.Ext(filename)))
+		if known := catalogs[locale]; known != nil {
+			for key, message := range catalog.Messages {
+				known.Messages[key] = message
+			}
+			if catalog.Language != "" {
+				known.Language = catalog.Language
+			}
+			continue
+		}
+		if catalog.Messages == nil {
+			catalog.Messages = make(map[string]string)
+		}
+		catalogs[locale] = &catalog
+	}
+	return nil
+}
+
+// localeCodes returns the locales of the catalogs, sorted.
+func localeCodes() []string {
+	var codes []string
+	for code := range catalogs {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// LocaleChoice is a language of the language switch.
+type LocaleChoice struct {
+	Code     string
+	Language string
+}
+
+// localeChoices is the "locales" template function: the languages there are
+// catalogs for, by their locales.
+func localeChoices() []LocaleChoice {
+	var choices []LocaleChoice
+	for _, code := range localeCodes() {
+		choices = append(choices, LocaleChoice{code, catalogs[code].Language})
+	}
+	return choices
+}
+
+// translate returns the message of the key in the locale, or else in the
+// locale's language without its region, or else in English, formatted with the
+// arguments. A key no catalog has is returned as it is, so a missing message
+// shows what's missing.
+func translate(locale, key string, args ...interface{}) string {
+	candidates := []string{locale}
+	if language, _, ok := strings.Cut(locale, "-"); ok {
+		candidates = append(candidates, language)
+	}
+	for _, candidate := range append(candidates, "en") {
+		if catalog := catalogs[candidate]; catalog != nil {
+			if message, ok := catalog.Messages[key]; ok {
+				if len(args) == 0 {
+					return message
+				}
+				return fmt.Sprintf(message, args...)
+			}
+		}
+	}
+	return key
+}
+
+// negotiateLocale returns the locale of the catalog best matching the
+// Accept-Language header, by the weights of its languages, and "" when there's
+// none. A language matches its own catalog and then, without its region, that of
+// the language.
+func negotiateLocale(header string) string {
+	type weighted struct {
+		tag    string
+		weight float64
+	}
+	var accepted []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag, weight := strings.ToLower(strings.TrimSpace(tag)), 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(q, 64); err == nil {
+				weight = parsed
+			}
+		}
+		if tag != "" && tag != "*" && weight > 0 {
+			accepted = append(accepted, weighted{tag, weight})
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].weight > accepted[j].weight })
+	for _, language := range accepted {
+		if catalogs[language.tag] != nil {
+			return language.tag
+		}
+		if base, _, ok := strings.Cut(language.tag, "-"); ok && catalogs[base] != nil {
+			return base
+		}
+	}
+	return ""
+}
+
+// localeWriter carries the locale the pages of a request are rendered in, for
+// renderTemplate to find as it finds the theme.
+type localeWriter struct {
+	http.ResponseWriter
+	locale string
+}
+
+func (lw *localeWriter) Flush() {
+	if flusher, ok := lw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (lw *localeWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
+
+// requestLocale returns the locale the page is rendered in for the request, that
+// of -locale when it isn't rendered for one.
+func (wiki *Wiki) requestLocale(w http.ResponseWriter) string {
+	for {
+		switch writer := w.(type) {
+		case *localeWriter:
+			return writer.locale
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = writer.Unwrap()
+		default:
+			return wiki.config.Locale
+		}
+	}
+}
+
+// withLocale renders the pages of the request in the language of the visitor's
+// cookie, or else the one their browser prefers of those there are catalogs for,
+// or else that of -locale.
+func (wiki *Wiki) withLocale(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locale := wiki.config.Locale
+		if cookie, err := r.Cookie(localeCookie); err == nil && catalogs[cookie.Value] != nil {
+			locale = cookie.Value
+		} else if negotiated := negotiateLocale(r.Header.Get("Accept-Language")); negotiated != "" {
+			locale = negotiated
+		}
+		w.Header().Add("Vary", "Accept-Language")
+		next.ServeHTTP(&localeWriter{ResponseWriter: w, locale: locale}, r)
+	})
+}
+
+// localeHandler serves POST /locale, which chooses the language of the "locale"
+// form value for the visitor and sends them back to the page they chose it on.
+func (wiki *Wiki) localeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	locale := r.FormValue("locale")
+	if catalogs[locale] == nil {
+		http.Error(w, translate(wiki.requestLocale(w), "error.noLocale", locale), http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: localeCookie, Value: locale, Path: "/", MaxAge: int(themeCookieTTL.Seconds()),
+		HttpOnly: true, Secure: isSecure(r), SameSite: http.SameSiteLaxMode})
+	next := "/"
+	if referer, err := url.Parse(r.Referer()); err == nil && strings.EqualFold(referer.Host, r.Host) {
+		next = localRedirect(referer.RequestURI())
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// maxIncludeDepth bounds how deep the included pages may include others.
+const maxIncludeDepth = 5
+
+var includeMacro = regexp.MustCompile(`\{\{include:(` + titlePattern + `)\}\}`)
+
+// includeRenderer replaces every {{include:Title}} macro in the Markdown body of
+// the page with the body of the other page, evaluated when the page is rendered,
+// so that a snippet kept on a page of its own shows on all the pages including
+// it. The included pages' own macros are expanded as if they were the page's.
+type includeRenderer struct {
+	wiki  *Wiki
+	title string
+}
+
+func (renderer includeRenderer) Render(body []byte) []byte {
+	return renderer.wiki.expandIncludes(body, []string{renderer.title}, renderer.wiki.currentACL())
+}
+
+// expandIncludes expands the includes of the body of the last of including,
+// the pages that include each other down to it. A page that would include itself
+// again, or be included too deep, is left out with a note in its place, and the
+// front matter of the included pages is left out.
+func (wiki *Wiki) expandIncludes(body []byte, including []string, acl accessList) []byte {
+	return includeMacro.ReplaceAllFunc(body, func(macro []byte) []byte {
+		title := wiki.titles.Canonical(string(includeMacro.FindSubmatch(macro)[1]))
+		switch {
+		case slices.Contains(including, title):
+			return []byte(fmt.Sprintf("*%s is left out, it includes this page itself.*", title))
+		case len(including) > maxIncludeDepth:
+			return []byte(fmt.Sprintf("*%s is left out, the pages include each other more than %d deep.*", title, maxIncludeDepth))
+		case !wiki.includable(acl, including[0], title):
+			return []byte(fmt.Sprintf("*%s is left out, not everybody who reads this page may read it.*", title))
+		}
+		page, err := wiki.load(title)
+		if err != nil {
+			return []byte(fmt.Sprintf("*There is no page [[%s]] to include.*", title))
+		}
+		return wiki.expandIncludes(wiki.embedAttachments(title, stripFrontMatter(page.Body)), append(including[:len(including):len(including)], title), acl)
+	})
+}
+
+// includable tells whether the page may show the included one to its readers:
+// when everybody reads the included page, or the same lines of the ACL page rule
+// both, or none do and both go by the roles of the users.
+func (wiki *Wiki) includable(acl accessList, title, included string) bool {
+	if wiki.roleOf(acl, "", included) >= roleReader {
+		return true
+	}
+	if strings.EqualFold(title, aclTitle) || strings.EqualFold(included, aclTitle) {
+		return false
+	}
+	namespace, ruled := acl.ruling(title)
+	includedNamespace, includedRuled := acl.ruling(included)
+	return namespace == includedNamespace && ruled == includedRuled
+}
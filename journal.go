@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultJournal is how many entries the journal API returns, and
+	// maxJournal how many a client may ask for.
+	defaultJournal = 500
+	maxJournal     = 5000
+)
+
+// JournalEntry is a change to the wiki as the journal records it. Op is "save",
+// "delete", "rename" or "user", a user added or given a new password, which lets
+// them edit. Seq numbers the entries 1, 2, 3, ... in the order they were made.
+type JournalEntry struct {
+	Seq    uint64    `json:"seq"`
+	Time   time.Time `json:"time"`
+	Op     string    `json:"op"`
+	Title  string    `json:"title,omitempty"`
+	Author string    `json:"author,omitempty"`
+	// Revision is the revision a save or delete recorded.
+	Revision int    `json:"revision,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+	// To is where a rename moved the page, Rewritten the pages whose links it
+	// pointed there and Redirect whether it left a redirect behind.
+	To        string   `json:"to,omitempty"`
+	Rewritten []string `json:"rewritten,omitempty"`
+	Redirect  bool     `json:"redirect,omitempty"`
+	User      string   `json:"user,omitempty"`
+}
+
+func (wiki *Wiki) journalFilename() string {
+	return wiki.dataPath("journal.jsonl")
+}
+
+// readJournal calls entry with every entry of the journal, in order, until it
+// returns false.
+func (wiki *Wiki) readJournal(entry func(JournalEntry) bool) error {
+	file, err := os.Open(wiki.journalFilename())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var read JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &read); err != nil {
+			return err
+		}
+		if !entry(read) {
+			break
+		}
+	}
+	return scanner.Err()
+}
+
+// journalChange appends the change to the journal, numbering and timing it,
+// sends it to the browsers viewing the page and tells the page hooks of it.
+func (wiki *Wiki) journalChange(entry JournalEntry) error {
+	wiki.journalFile.Lock()
+	defer wiki.journalFile.Unlock()
+	file, err := os.OpenFile(wiki.journalFilename(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() != wiki.journalFile.size {
+		wiki.journalFile.seq = 0
+		if err := wiki.readJournal(func(read JournalEntry) bool { wiki.journalFile.seq = read.Seq; return true }); err != nil {
+			return err
+		}
+	}
+	entry.Seq, entry.Time = wiki.journalFile.seq+1, clock.Now().UTC()
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	wiki.journalFile.seq, wiki.journalFile.size = entry.Seq, info.Size()+int64(len(line))+1
+	wiki.liveUpdates.publish(entry)
+	wiki.afterChange(entry)
+	return nil
+}
+
+// journalRevision journals a save, or with op "delete" a deletion, that recorded
+// the revision.
+func (wiki *Wiki) journalRevision(op, title string, revision Revision) error {
+	return wiki.journalChange(JournalEntry{Op: op, Title: title, Author: revision.Author, Revision: revision.ID, Comment: revision.Comment})
+}
+
+// journalAPIHandler serves GET /api/v1/journal, the entries of the journal after
+// ?since=seq, oldest first, up to ?limit= of them. A consumer asks again with the
+// last seq it got for the entries made since. Like every listing, it leaves out
+// the changes to the pages the caller isn't listed, and the users' to all but
+// the admins.
+func (wiki *Wiki) journalAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET to read the journal")
+		return
+	}
+	var since uint64
+	if value := r.URL.Query().Get("since"); value != "" {
+		var err error
+		if since, err = strconv.ParseUint(value, 10, 64); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "since is the seq of an entry, not "+strconv.Quote(value))
+			return
+		}
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit < 1 {
+		limit = defaultJournal
+	}
+	limit = min(limit, maxJournal)
+	v, acl := wiki.viewerOf(r), wiki.currentACL()
+	entries := []JournalEntry{}
+	err = wiki.readJournal(func(entry JournalEntry) bool {
+		if entry.Seq > since && v.journals(acl, &entry) {
+			entries = append(entries, entry)
+		}
+		return len(entries) < limit
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"entries": entries, "more": len(entries) == limit})
+}
+
+// journals reports whether the entry is shown to the viewer, leaving out of a
+// rename the pages it rewrote that the viewer isn't listed.
+func (v viewer) journals(acl accessList, entry *JournalEntry) bool {
+	if v.admin {
+		return true
+	}
+	if entry.Op == "user" || !v.listsWith(acl, entry.Title) || (entry.To != "" && !v.listsWith(acl, entry.To)) {
+		return false
+	}
+	var rewritten []string
+	for _, title := range entry.Rewritten {
+		if v.listsWith(acl, title) {
+			rewritten = append(rewritten, title)
+		}
+	}
+	entry.Rewritten = rewritten
+	return true
+}
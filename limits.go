@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// requestLimits maps a group of requests to how many of them are served at once:
+// "views" read pages, "saves" change something, "matrix" computes with the matrix
+// module, and "total" counts every request. 0 lifts the limit. It is set with
+// repeated -limit group=n flags.
+type requestLimits map[string]int
+
+func (l requestLimits) String() string {
+	var set []string
+	for group, n := range l {
+		set = append(set, group+"="+strconv.Itoa(n))
+	}
+	sort.Strings(set)
+	return strings.Join(set, ",")
+}
+
+func (l requestLimits) Set(value string) error {
+	group, count, found := strings.Cut(value, "=")
+	if _, known := l[group]; !found || !known {
+		return fmt.Errorf("%q should look like group=n, for the group total, views, saves or matrix", value)
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return fmt.Errorf("the limit of %s is a number of requests, not %q", group, count)
+	}
+	l[group] = n
+	return nil
+}
+
+var limits = requestLimits{"total": 256, "views": 192, "saves": 32, "matrix": 4}
+
+// requestGroup returns the group of the request, "" for one no limit applies to:
+// the event stream of a matrix job lasts as long as the job, which runs in the
+// background either way.
+func requestGroup(r *http.Request) string {
+	prefix := mounts["matrix"]
+	if prefix != "" && (r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/")) || strings.HasPrefix(r.URL.Path, "/api/v1/matrix/") || r.URL.Path == matmulAlias {
+		if strings.HasSuffix(r.URL.Path, "/events") {
+			return ""
+		}
+		return "matrix"
+	}
+	if r.URL.Path == "/ws" {
+		// the live updates of a page are open as long as the page, not a request
+		return ""
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return "views"
+	}
+	return "saves"
+}
+
+// limitConcurrency serves at most the limit of every group at once. A request
+// over the limit of its group, or the total, waits up to config.QueueWait for
+// one in flight to finish and is answered 503 with a Retry-After otherwise, so
+// that the matrix computations or a burst of saves can't starve page views.
+func (wiki *Wiki) limitConcurrency(next http.Handler) http.Handler {
+	slots := make(map[string]chan struct{})
+	for group, n := range limits {
+		if n > 0 {
+			slots[group] = make(chan struct{}, n)
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		group := requestGroup(r)
+		if group == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		timer := time.NewTimer(wiki.config.QueueWait)
+		defer timer.Stop()
+		for _, name := range []string{"total", group} {
+			held, limited := slots[name]
+			if !limited {
+				continue
+			}
+			if !acquire(held, timer, r) {
+				if r.Context().Err() == nil {
+					w.Header().Set("Retry-After", strconv.Itoa(max(1, int(wiki.config.QueueWait.Round(time.Second)/time.Second))))
+					http.Error(w, translate(wiki.requestLocale(w), "error.tooManyRequests"), http.StatusServiceUnavailable)
+				}
+				return
+			}
+			defer func() { <-held }()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquire takes a slot, waiting for one until the timer fires or the client gives up.
+func acquire(slots chan struct{}, timer *time.Timer, r *http.Request) bool {
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+// DanglingLink is a title pages link to that's no page: the pages linking to it.
+type DanglingLink struct {
+	Title string
+	From  []string
+}
+
+// LinkReport is the data of the link report template: the pages no other page
+// links to, and the missing pages linked to, most wanted first.
+type LinkReport struct {
+	Orphans  []string
+	Dangling []DanglingLink
+}
+
+// report walks the link graph for the orphans and the dangling links among the
+// pages listed for the viewer. Unlike the hourly reports it's read from the
+// index as it is, so a fix shows at once.
+func (idx *linkIndex) report(v viewer) LinkReport {
+	var report LinkReport
+	listed, acl := idx.wiki.titles.List(), idx.wiki.currentACL()
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	for _, title := range listed {
+		if v.listsWith(acl, title) && len(idx.incoming[title]) == 0 {
+			report.Orphans = append(report.Orphans, title)
+		}
+	}
+	for target, linking := range idx.incoming {
+		if idx.wiki.titles.Has(target) {
+			continue
+		}
+		dangling := DanglingLink{Title: target}
+		for other := range linking {
+			if v.listsWith(acl, other) {
+				dangling.From = append(dangling.From, other)
+			}
+		}
+		if len(dangling.From) == 0 {
+			continue
+		}
+		sort.Strings(dangling.From)
+		report.Dangling = append(report.Dangling, dangling)
+	}
+	sort.Strings(report.Orphans)
+	sort.Slice(report.Dangling, func(i, j int) bool {
+		if len(report.Dangling[i].From) != len(report.Dangling[j].From) {
+			return len(report.Dangling[i].From) > len(report.Dangling[j].From)
+		}
+		return report.Dangling[i].Title < report.Dangling[j].Title
+	})
+	return report
+}
+
+// linkReportHandler serves /admin/links, the orphan pages and the dangling
+// links, for the gardeners to link the ones and create the others.
+func (wiki *Wiki) linkReportHandler(w http.ResponseWriter, r *http.Request) {
+	if !wiki.isAdmin(r) {
+		wiki.serveError(w, forbidden("only admins can see the link report"))
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	wiki.renderTemplate(w, "links.html", wiki.backlinks.report(wiki.viewerOf(r)))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// linksOf returns the distinct titles the rendered page links to, sorted.
+func (wiki *Wiki) linksOf(p *Page) []string {
+	seen := make(map[string]bool)
+	var links []string
+	for _, match := range wiki.viewLinkPattern.FindAllStringSubmatch(string(wiki.rendererFor(p.Title, false).Render(p.Body)), -1) {
+		if target := match[1]; !seen[target] && target != p.Title {
+			seen[target] = true
+			links = append(links, target)
+		}
+	}
+	sort.Strings(links)
+	return links
+}
+
+// linkIndex is the reverse of the links between pages: it knows every page linking
+// to a title. It's built at startup and updated on every save; creating, deleting
+// or giving a display title to a page changes what the other pages auto-link, so
+// those re-index the pages that mention it.
+type linkIndex struct {
+	wiki     *Wiki
+	mu       sync.RWMutex
+	outgoing map[string][]string        // title -> the titles it links to
+	incoming map[string]map[string]bool // title -> the titles linking to it
+}
+
+// update replaces the links of the page with those of its current body.
+func (idx *linkIndex) update(p *Page) {
+	links := idx.wiki.linksOf(p)
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(p.Title)
+	for _, target := range links {
+		if idx.incoming[target] == nil {
+			idx.incoming[target] = make(map[string]bool)
+		}
+		idx.incoming[target][p.Title] = true
+	}
+	idx.outgoing[p.Title] = links
+}
+
+// remove forgets the links of a deleted page; the links to it are kept, they
+// point at it again once it's recreated.
+func (idx *linkIndex) remove(title string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(title)
+}
+
+func (idx *linkIndex) removeLocked(title string) {
+	for _, target := range idx.outgoing[title] {
+		delete(idx.incoming[target], title)
+		if len(idx.incoming[target]) == 0 {
+			delete(idx.incoming, target)
+		}
+	}
+	delete(idx.outgoing, title)
+}
+
+// relink re-indexes the pages that mention the title or its display title, or
+// link to it, after it's been created, deleted or retitled.
+func (idx *linkIndex) relink(title string) {
+	mentions := []string{title}
+	if display := idx.wiki.displayTitle(title); display != title {
+		mentions = append(mentions, display)
+	}
+	linking := idx.lookup(title)
+	for _, other := range idx.wiki.titles.List() {
+		page, err := idx.wiki.load(other)
+		if err != nil || other == title {
+			continue
+		}
+		if slices.Contains(linking, other) || slices.ContainsFunc(mentions, func(mention string) bool { return bytes.Contains(page.Body, []byte(mention)) }) {
+			idx.update(page)
+		}
+	}
+}
+
+// lookup returns the titles of the pages linking to title, sorted.
+func (idx *linkIndex) lookup(title string) []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	linking := make([]string, 0, len(idx.incoming[title]))
+	for other := range idx.incoming[title] {
+		linking = append(linking, other)
+	}
+	sort.Strings(linking)
+	return linking
+}
+
+// backlinksOf returns the titles of the pages linking to title, sorted.
+func (wiki *Wiki) backlinksOf(title string) []string {
+	return wiki.backlinks.lookup(title)
+}
+
+// backlinksHandler serves /backlinks/{title}, "What links here": every page whose
+// body links to the title, whether the page exists or not.
+func (wiki *Wiki) backlinksHandler(w http.ResponseWriter, r *http.Request, title string) {
+	wiki.renderTemplate(w, "backlinks.html", BacklinksPage{Title: title, Backlinks: wiki.viewerOf(r).filter(wiki.backlinksOf(title)), Exists: wiki.titles.Has(title)})
+}
+
+// BacklinksPage is the data of the backlinks template.
+type BacklinksPage struct {
+	Title     string
+	Backlinks []string
+	Exists    bool
+}
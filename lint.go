@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// maxLineLength is the line length above which the linter warns.
+const maxLineLength = 500
+
+// maxLintBytes bounds the text POSTed to /api/v1/lint.
+const maxLintBytes = 1 << 20
+
+// LintWarning is one issue found in a page body. Line is 1-based, 0 when the warning
+// is about the page as a whole.
+type LintWarning struct {
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+// wikiLinkPattern matches [[Title]] links and hrefs to /view/Title in page bodies.
+var wikiLinkPattern = regexp.MustCompile(`\[\[([^\]|#]+)[^\]]*\]\]|/view/([\p{L}\p{M}\p{N}/~-]+)`)
+
+// loadDictionary reads the words the linter knows, one a line, from the file.
+func (wiki *Wiki) loadDictionary(filename string) error {
+	if filename == "" {
+		return nil
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	words := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if word := strings.TrimSpace(scanner.Text()); word != "" && !strings.HasPrefix(word, "#") {
+			words[strings.ToLower(word)] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	wiki.dictionary = words
+	return nil
+}
+
+var (
+	// unspelled matches what isn't prose in a line: inline code, URLs, links,
+	// macros and mentions.
+	unspelled   = regexp.MustCompile("`[^`]*`|https?://\\S+|\\[\\[[^\\]]*\\]\\]|\\{\\{.*?\\}\\}|/view/\\S+|@\\w+")
+	wordPattern = regexp.MustCompile(`\p{L}+(?:'\p{L}+)*`)
+)
+
+// misspellings returns the words of the line that aren't in the dictionary.
+// Words with capitals past their first letter, like page titles and acronyms,
+// are names the dictionary doesn't know, and aren't checked.
+func (wiki *Wiki) misspellings(line string) []string {
+	var unknown []string
+	for _, word := range wordPattern.FindAllString(unspelled.ReplaceAllString(line, " "), -1) {
+		if strings.IndexFunc(word[1:], unicode.IsUpper) >= 0 {
+			continue
+		}
+		lower := strings.ToLower(word)
+		if !wiki.dictionary[lower] && !wiki.dictionary[strings.TrimSuffix(lower, "'s")] {
+			unknown = append(unknown, word)
+		}
+	}
+	return unknown
+}
+
+// lintPage checks the body of a page for common mistakes: links to pages that
+// don't exist yet, macros ({{...}}) or links ([[...]]) that are never closed,
+// extremely long lines, trailing whitespace, words that aren't in the
+// -dictionary, a body that doesn't start with a heading, and lines of the front
+// matter that can't be read. The lines of the front matter are checked for
+// nothing else. The warnings are
+// shown on the view page the save redirects to; with -lint-strict they block
+// the save instead.
+func (wiki *Wiki) lintPage(p *Page) []LintWarning {
+	var warnings []LintWarning
+	lines := strings.Split(strings.ReplaceAll(string(p.Body), "\r\n", "\n"), "\n")
+	_, errs := parseFrontMatter(p.Body)
+	for _, err := range errs {
+		warnings = append(warnings, LintWarning{err.Line, err.Message + " in the front matter"})
+	}
+	skipped := frontMatterLines(p.Body)
+
+	firstLine := ""
+	for _, line := range lines[skipped:] {
+		if strings.TrimSpace(line) != "" {
+			firstLine = strings.TrimSpace(line)
+			break
+		}
+	}
+	if !strings.HasPrefix(firstLine, "#") {
+		warnings = append(warnings, LintWarning{Message: fmt.Sprintf("the page doesn't start with a title heading like \"# %s\"", p.Title)})
+	}
+
+	fenced := false
+	for idx, line := range lines[skipped:] {
+		lineNo := skipped + idx + 1
+		if length := len([]rune(line)); length > maxLineLength {
+			warnings = append(warnings, LintWarning{lineNo, fmt.Sprintf("line is %d characters long, more than %d", length, maxLineLength)})
+		}
+		if strings.TrimRight(line, " \t") != line {
+			warnings = append(warnings, LintWarning{lineNo, "line ends in whitespace"})
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			fenced = !fenced
+		} else if wiki.dictionary != nil && !fenced {
+			if unknown := wiki.misspellings(line); len(unknown) > 0 {
+				warnings = append(warnings, LintWarning{lineNo, "not in the dictionary: " + strings.Join(unknown, ", ")})
+			}
+		}
+		for _, delimiters := range [][2]string{{"{{", "}}"}, {"[[", "]]"}} {
+			if opened, closed := strings.Count(line, delimiters[0]), strings.Count(line, delimiters[1]); opened > closed {
+				warnings = append(warnings, LintWarning{lineNo, fmt.Sprintf("%s is opened but never closed with %s", delimiters[0], delimiters[1])})
+			}
+		}
+		for _, match := range wikiLinkPattern.FindAllStringSubmatch(line, -1) {
+			target := wiki.linkTarget(match[1] + match[2])
+			if !wiki.titles.Has(target) && target != p.Title {
+				warnings = append(warnings, LintWarning{lineNo, fmt.Sprintf("link to %q, which doesn't exist", target)})
+			}
+		}
+	}
+	return warnings
+}
+
+// renderLintRejection shows the edit form again, as it was sent, with the lint
+// warnings that keep it from being saved.
+func (wiki *Wiki) renderLintRejection(w http.ResponseWriter, r *http.Request, title, body string, metadata PageMetadata, warnings []LintWarning) {
+	editPage := EditTemplatePage{Title: title, DisplayTitle: r.Form.Get("displayTitle"), Body: []byte(body), Metadata: metadata,
+		Format: wiki.formatOf(title), Formats: wiki.formatNames(), Base: r.Form.Get("base"), EditToken: r.Form.Get("editToken"), Warnings: warnings}
+	if metadata.Format != "" {
+		editPage.Format = metadata.Format
+	}
+	if editPage.Exists = wiki.titles.Has(title); editPage.Exists {
+		editPage.Attachments = wiki.attachmentsOf(title)
+	}
+	if editPage.DisplayTitle == "" {
+		editPage.DisplayTitle = wiki.displayTitle(title)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	wiki.renderTemplate(w, "edit.html", editPage)
+}
+
+// lintAPIHandler serves GET /api/v1/lint/{title} with the warnings for the saved
+// page, and POST /api/v1/lint/{title} with the warnings for the "body" form value,
+// so editors can lint before saving; POST /api/v1/lint is lintTextHandler's. The
+// same are served under /api/lint, where editor integrations look for them.
+func (wiki *Wiki) lintAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/v1/lint" || r.URL.Path == "/api/lint" {
+		wiki.lintTextHandler(w, r)
+		return
+	}
+	title, ok := lintAPITitle(r.URL.Path)
+	if !ok || !validTitle.MatchString(title) {
+		http.NotFound(w, r)
+		return
+	}
+	var page *Page
+	switch r.Method {
+	case http.MethodGet:
+		var err error
+		if page, err = wiki.load(title); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+	case http.MethodPost:
+		page = &Page{Title: title, Body: []byte(r.FormValue("body"))}
+	default:
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	wiki.writeLintWarnings(w, page)
+}
+
+// lintAPITitle returns the title of a path under /api/v1/lint/ or /api/lint/.
+func lintAPITitle(path string) (string, bool) {
+	if title, ok := strings.CutPrefix(path, "/api/v1/lint/"); ok {
+		return title, true
+	}
+	return strings.CutPrefix(path, "/api/lint/")
+}
+
+// lintTextHandler serves POST /api/v1/lint and /api/lint, for editors linting text that's no
+// page yet: the body is the text as is, or a JSON object {"title": ..., "body":
+// ...}, the title being the page it's for, to check the links against.
+func (wiki *Wiki) lintTextHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	var request struct {
+		Title string `json:"title"`
+		Body  string `json:"body"`
+	}
+	body := http.MaxBytesReader(w, r.Body, maxLintBytes)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(body).Decode(&request); err != nil {
+			http.Error(w, translate(wiki.requestLocale(w), "error.invalidJSON", err), http.StatusBadRequest)
+			return
+		}
+	} else {
+		text, err := io.ReadAll(body)
+		if err != nil {
+			wiki.serveError(w, err)
+			return
+		}
+		request.Body = string(text)
+	}
+	wiki.writeLintWarnings(w, &Page{Title: request.Title, Body: []byte(request.Body)})
+}
+
+func (wiki *Wiki) writeLintWarnings(w http.ResponseWriter, page *Page) {
+	warnings := wiki.lintPage(page)
+	if warnings == nil {
+		warnings = []LintWarning{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"title": page.Title, "warnings": warnings})
+}
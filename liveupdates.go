@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// livePingInterval is how often an idle socket is pinged, so proxies don't
+// take it for dead and a browser gone without closing is noticed.
+const livePingInterval = 30 * time.Second
+
+// liveViewer is a browser viewing a page, sent the changes of it over a socket.
+type liveViewer struct {
+	ws *wsConn
+	// updates is what's still to be sent; a viewer too slow to take them is let go.
+	updates chan []byte
+}
+
+// liveRegistry keeps the viewers of the pages, for the saves to tell them.
+type liveRegistry struct {
+	sync.Mutex
+	viewers map[string]map[*liveViewer]bool
+}
+
+func (reg *liveRegistry) subscribe(title string, viewer *liveViewer) {
+	reg.Lock()
+	defer reg.Unlock()
+	if reg.viewers[title] == nil {
+		reg.viewers[title] = make(map[*liveViewer]bool)
+	}
+	reg.viewers[title][viewer] = true
+}
+
+func (reg *liveRegistry) unsubscribe(title string, viewer *liveViewer) {
+	reg.Lock()
+	defer reg.Unlock()
+	delete(reg.viewers[title], viewer)
+	if len(reg.viewers[title]) == 0 {
+		delete(reg.viewers, title)
+	}
+}
+
+// publish sends the journal entry of a change to the viewers of its page. It
+// never waits on one: a viewer with its updates full is closed instead.
+func (reg *liveRegistry) publish(entry JournalEntry) {
+	if entry.Title == "" {
+		return
+	}
+	reg.Lock()
+	defer reg.Unlock()
+	if len(reg.viewers[entry.Title]) == 0 {
+		return
+	}
+	message, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	for viewer := range reg.viewers[entry.Title] {
+		select {
+		case viewer.updates <- message:
+		default:
+			go viewer.ws.Close()
+		}
+	}
+}
+
+// closeAll closes every socket, as the server shuts down.
+func (reg *liveRegistry) closeAll() {
+	reg.Lock()
+	var all []*liveViewer
+	for _, viewers := range reg.viewers {
+		for viewer := range viewers {
+			all = append(all, viewer)
+		}
+	}
+	reg.Unlock()
+	for _, viewer := range all {
+		viewer.ws.Close()
+	}
+}
+
+// liveUpdatesHandler serves /ws?title=, a WebSocket sent the journal entry of
+// every save, delete and rename of the page while it's open, for the view to
+// offer a reload. It needs a reader's role with the page, as viewing it does.
+func (wiki *Wiki) liveUpdatesHandler(w http.ResponseWriter, r *http.Request) {
+	title := wiki.titles.Canonical(r.URL.Query().Get("title"))
+	if !validTitle.MatchString(title) {
+		http.Error(w, translate(wiki.requestLocale(w), "error.liveUpdatesTitle"), http.StatusBadRequest)
+		return
+	}
+	if wiki.roleOf(wiki.currentACL(), wiki.currentUser(r), title) < roleReader && !wiki.isAdmin(r) {
+		http.Error(w, translate(wiki.requestLocale(w), "error.status.403"), http.StatusForbidden)
+		return
+	}
+	ws := wiki.upgradeWebSocket(w, r)
+	if ws == nil {
+		return
+	}
+	defer ws.Close()
+	viewer := &liveViewer{ws: ws, updates: make(chan []byte, 16)}
+	wiki.liveUpdates.subscribe(title, viewer)
+	defer wiki.liveUpdates.unsubscribe(title, viewer)
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			opcode, payload, err := ws.readFrame()
+			if err != nil {
+				return
+			}
+			switch opcode {
+			case wsPing:
+				ws.writeFrame(wsPong, payload)
+			case wsClose:
+				return
+			}
+		}
+	}()
+	ping := time.NewTicker(livePingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case message := <-viewer.updates:
+			if err := ws.writeFrame(wsText, message); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := ws.writeFrame(wsPing, nil); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
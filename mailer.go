@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// Mailer sends e-mail; the wiki mails the activity digests with it.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// smtpMailer sends through an SMTP server, logging in with GOWIKI_SMTP_USER and
+// GOWIKI_SMTP_PASSWORD when they're set.
+type smtpMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func newMailer(addr, from string) (Mailer, error) {
+	if addr == "" {
+		return nil, nil
+	}
+	if from == "" {
+		return nil, errors.New("set the sender of the mail with -mail-from")
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	sender := smtpMailer{addr: addr, from: from}
+	if user := os.Getenv("GOWIKI_SMTP_USER"); user != "" {
+		sender.auth = smtp.PlainAuth("", user, os.Getenv("GOWIKI_SMTP_PASSWORD"), host)
+	}
+	return sender, nil
+}
+
+func (sender smtpMailer) Send(to, subject, body string) error {
+	var message strings.Builder
+	message.WriteString("From: " + sender.from + "\r\n")
+	message.WriteString("To: " + to + "\r\n")
+	message.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	message.WriteString("Date: " + clock.Now().Format(time.RFC1123Z) + "\r\n")
+	message.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	return smtp.SendMail(sender.addr, sender.auth, sender.from, []string{to}, []byte(message.String()))
+}
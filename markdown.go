@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// markdownRenderer renders page bodies written in Markdown: headings, paragraphs,
+// emphasis, code spans and fenced code blocks, links, images, block quotes,
+// horizontal rules and (nested) lists. Fenced code blocks with a language are
+// highlighted by the -highlight highlighter. Raw HTML in the source is escaped, so a
+// page can't inject markup into the view page, unless -raw-html lets it through.
+type markdownRenderer struct {
+	wiki *Wiki
+}
+
+func (renderer markdownRenderer) Render(body []byte) []byte {
+	lines := strings.Split(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n")
+	return uniqueHeadingIDs([]byte(renderer.wiki.renderBlocks(lines)))
+}
+
+var (
+	atxHeading     = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	horizontalRule = regexp.MustCompile(`^ {0,3}((\*\s*){3,}|(-\s*){3,}|(_\s*){3,})$`)
+	listItem       = regexp.MustCompile(`^( *)([-*+]|\d{1,9}[.)])\s+(.*)$`)
+	codeFence      = regexp.MustCompile("^ {0,3}(```+|~~~+)\\s*(\\S*)")
+	quoteLine      = regexp.MustCompile(`^ {0,3}> ?(.*)$`)
+)
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// startsBlock reports whether line starts a block other than a paragraph, which
+// ends the paragraph before it.
+func startsBlock(line string) bool {
+	return atxHeading.MatchString(line) || horizontalRule.MatchString(line) || codeFence.MatchString(line) ||
+		quoteLine.MatchString(line) || listItem.MatchString(line)
+}
+
+func (wiki *Wiki) renderBlocks(lines []string) string {
+	var out strings.Builder
+	for idx := 0; idx < len(lines); {
+		line := lines[idx]
+		switch {
+		case isBlank(line):
+			idx++
+		case codeFence.MatchString(line):
+			match := codeFence.FindStringSubmatch(line)
+			fence, language := match[1], match[2]
+			idx++
+			var code []string
+			for ; idx < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[idx]), fence); idx++ {
+				code = append(code, lines[idx])
+			}
+			idx++ // the closing fence
+			class := ""
+			if language != "" {
+				class = fmt.Sprintf(` class="language-%s"`, html.EscapeString(language))
+			}
+			if highlighted, ok := wiki.highlightCode(language, strings.Join(code, "\n")); ok {
+				fmt.Fprintf(&out, "<pre><code class=\"language-%s chroma\">%s</code></pre>\n", html.EscapeString(language), highlighted)
+				break
+			}
+			fmt.Fprintf(&out, "<pre><code%s>%s</code></pre>\n", class, html.EscapeString(strings.Join(code, "\n")))
+		case atxHeading.MatchString(line):
+			match := atxHeading.FindStringSubmatch(line)
+			level := len(match[1])
+			fmt.Fprintf(&out, "<h%d id=\"%s\">%s</h%d>\n", level, headingAnchor(match[2]), wiki.renderInline(match[2]), level)
+			idx++
+		case horizontalRule.MatchString(line):
+			out.WriteString("<hr>\n")
+			idx++
+		case quoteLine.MatchString(line):
+			var quoted []string
+			for ; idx < len(lines) && quoteLine.MatchString(lines[idx]); idx++ {
+				quoted = append(quoted, quoteLine.FindStringSubmatch(lines[idx])[1])
+			}
+			fmt.Fprintf(&out, "<blockquote>\n%s</blockquote>\n", wiki.renderBlocks(quoted))
+		case listItem.MatchString(line):
+			var list string
+			list, idx = wiki.renderList(lines, idx)
+			out.WriteString(list)
+		default:
+			var paragraph []string
+			for ; idx < len(lines) && !isBlank(lines[idx]) && (len(paragraph) == 0 || !startsBlock(lines[idx])); idx++ {
+				paragraph = append(paragraph, lines[idx])
+			}
+			fmt.Fprintf(&out, "<p>%s</p>\n", wiki.renderInline(strings.Join(paragraph, "\n")))
+		}
+	}
+	return out.String()
+}
+
+// renderList renders the list starting at lines[start] and returns the index of
+// the first line after it. Lines indented deeper than the list's markers belong to
+// the item above them, so nested lists are rendered recursively.
+func (wiki *Wiki) renderList(lines []string, start int) (string, int) {
+	first := listItem.FindStringSubmatch(lines[start])
+	indent := len(first[1])
+	ordered := first[2][0] >= '0' && first[2][0] <= '9'
+	tag := "ul"
+	var out strings.Builder
+	if ordered {
+		tag = "ol"
+		if number, _ := strconv.Atoi(strings.TrimRight(first[2], ".)")); number != 1 {
+			fmt.Fprintf(&out, "<ol start=\"%d\">\n", number)
+		} else {
+			out.WriteString("<ol>\n")
+		}
+	} else {
+		out.WriteString("<ul>\n")
+	}
+
+	idx := start
+	for idx < len(lines) {
+		match := listItem.FindStringSubmatch(lines[idx])
+		if match == nil || len(match[1]) != indent || (match[2][0] >= '0' && match[2][0] <= '9') != ordered {
+			break
+		}
+		contentIndent := indent + len(match[2]) + 1
+		item := []string{match[3]}
+		idx++
+		for idx < len(lines) {
+			line := lines[idx]
+			if isBlank(line) {
+				// A blank line continues the item only if an indented line follows.
+				if idx+1 < len(lines) && leadingSpaces(lines[idx+1]) > indent {
+					item = append(item, "")
+					idx++
+					continue
+				}
+				break
+			}
+			if leadingSpaces(line) <= indent {
+				if listItem.MatchString(line) || startsBlock(line) {
+					break
+				}
+				// A lazy continuation of the item's paragraph.
+				item = append(item, strings.TrimSpace(line))
+				idx++
+				continue
+			}
+			item = append(item, line[min(contentIndent, leadingSpaces(line)):])
+			idx++
+		}
+		content := wiki.renderBlocks(item)
+		// Items of a single paragraph are rendered without the <p>.
+		if strings.Count(content, "<p>") == 1 && strings.HasPrefix(content, "<p>") {
+			content = strings.Replace(strings.Replace(content, "<p>", "", 1), "</p>\n", "\n", 1)
+		}
+		fmt.Fprintf(&out, "<li>%s</li>\n", strings.TrimSuffix(content, "\n"))
+		if idx < len(lines) && isBlank(lines[idx]) {
+			if idx+1 < len(lines) && listItem.MatchString(lines[idx+1]) && leadingSpaces(lines[idx+1]) == indent {
+				idx++
+			}
+		}
+	}
+	fmt.Fprintf(&out, "</%s>\n", tag)
+	return out.String(), idx
+}
+
+func leadingSpaces(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+var (
+	codeSpan     = regexp.MustCompile("(`+)(.+?)(`+)")
+	strongText   = regexp.MustCompile(`\*\*([^*\s](?:[^*]*[^*\s])?)\*\*|__([^_\s](?:[^_]*[^_\s])?)__`)
+	emphasisText = regexp.MustCompile(`\*([^*\s](?:[^*]*[^*\s])?)\*|\b_([^_\s](?:[^_]*[^_\s])?)_\b`)
+	hardBreak    = regexp.MustCompile(`( {2,}|\\)\n`)
+	rawHTMLTag   = regexp.MustCompile(`<!--.*?-->|</?[a-zA-Z][a-zA-Z0-9-]*(?:\s[^<>]*)?/?>`)
+	safeURL      = regexp.MustCompile(`^(?i:https?://|mailto:|/|#|\./|\.\./|[^:/?#]+(?:[/?#]|$))`)
+)
+
+// replaceInlineLinks replaces the inline links of the escaped text, or its
+// images for the opener "![", with what render makes of their text, destination
+// and title.
+func replaceInlineLinks(text, opener string, render func(label, dest, title string) string) string {
+	var out strings.Builder
+	for {
+		at := strings.Index(text, opener)
+		if at < 0 {
+			break
+		}
+		out.WriteString(text[:at])
+		start := at + len(opener) - 1
+		label, dest, title, n := parseInlineLink(text[start:], opener == "![")
+		if n == 0 {
+			out.WriteString(opener)
+			text = text[at+len(opener):]
+			continue
+		}
+		out.WriteString(render(label, dest, title))
+		text = text[start+n:]
+	}
+	out.WriteString(text)
+	return out.String()
+}
+
+// parseInlineLink parses the link the escaped text starts with, its "[" on:
+// the text in brackets, only an image's may be empty, then in parentheses the
+// destination and a title in double quotes. As in CommonMark, the destination
+// may have parentheses of its own if they're balanced, or escaped with a
+// backslash. It returns the length of the link, 0 for no link.
+func parseInlineLink(text string, image bool) (label, dest, title string, n int) {
+	end := strings.IndexByte(text, ']')
+	if end < 0 || end == 1 && !image || !strings.HasPrefix(text[end+1:], "(") {
+		return "", "", "", 0
+	}
+	label, rest := text[1:end], text[end+2:]
+	depth, i := 0, 0
+scan:
+	for ; i < len(rest); i++ {
+		switch rest[i] {
+		case '\\':
+			if i+1 < len(rest) && (rest[i+1] == '(' || rest[i+1] == ')') {
+				i++
+			}
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				break scan
+			}
+			depth--
+		case ' ', '\t', '\n':
+			break scan
+		}
+	}
+	if i == 0 || i == len(rest) || depth > 0 {
+		return "", "", "", 0
+	}
+	dest = strings.NewReplacer(`\(`, "(", `\)`, ")").Replace(rest[:i])
+	rest = rest[i:]
+	if trimmed := strings.TrimLeft(rest, " \t\n"); trimmed != rest {
+		quoted, ok := strings.CutPrefix(trimmed, "&#34;")
+		closing := strings.Index(quoted, "&#34;)")
+		if !ok || closing < 0 || strings.Contains(quoted[:closing], "&") {
+			return "", "", "", 0
+		}
+		title, rest = quoted[:closing], quoted[closing+len("&#34;"):]
+	}
+	return label, dest, title, len(text) - len(rest) + 1
+}
+
+// renderInline renders the inline markup of one block. Code spans are cut out
+// first, so nothing inside them is interpreted.
+func (wiki *Wiki) renderInline(text string) string {
+	var spans []string
+	text = codeSpan.ReplaceAllStringFunc(text, func(span string) string {
+		match := codeSpan.FindStringSubmatch(span)
+		if match[1] != match[3] {
+			return span
+		}
+		spans = append(spans, "<code>"+html.EscapeString(strings.TrimSpace(match[2]))+"</code>")
+		return fmt.Sprintf("\x00%d\x00", len(spans)-1)
+	})
+	if wiki.config.RawHTML {
+		text = rawHTMLTag.ReplaceAllStringFunc(text, func(tag string) string {
+			spans = append(spans, tag)
+			return fmt.Sprintf("\x00%d\x00", len(spans)-1)
+		})
+	}
+
+	text = html.EscapeString(text)
+	text = replaceInlineLinks(text, "![", func(alt, src, title string) string {
+		if !safeURL.MatchString(html.UnescapeString(src)) {
+			return alt
+		}
+		if title != "" {
+			title = ` title="` + title + `"`
+		}
+		return fmt.Sprintf(`<img src="%s" alt="%s"%s>`, wiki.underBasePath(src), alt, title)
+	})
+	text = replaceInlineLinks(text, "[", func(label, href, title string) string {
+		if !safeURL.MatchString(html.UnescapeString(href)) {
+			return label
+		}
+		if title != "" {
+			title = ` title="` + title + `"`
+		}
+		return fmt.Sprintf(`<a href="%s"%s>%s</a>`, wiki.underBasePath(href), title, label)
+	})
+	text = strongText.ReplaceAllString(text, "<strong>$1$2</strong>")
+	text = emphasisText.ReplaceAllString(text, "<em>$1$2</em>")
+	text = hardBreak.ReplaceAllString(text, "<br>\n")
+
+	for idx, span := range spans {
+		text = strings.Replace(text, fmt.Sprintf("\x00%d\x00", idx), span, 1)
+	}
+	return text
+}
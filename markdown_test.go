@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestMarkdownRenderer(t *testing.T) {
+	tests := []struct {
+		name, markdown, want string
+	}{
+		{"heading", "# Title", "<h1 id=\"title\">Title</h1>\n"},
+		{"headings of a text", "## Same\n\n## Same", "<h2 id=\"same\">Same</h2>\n<h2 id=\"same-2\">Same</h2>\n"},
+		{"emphasis", "a *b* **c** _d_ `<e>`", "<p>a <em>b</em> <strong>c</strong> <em>d</em> <code>&lt;e&gt;</code></p>\n"},
+		{"paragraphs", "line one\nline two\n\nnext", "<p>line one\nline two</p>\n<p>next</p>\n"},
+		{"hard break", "hard  \nbreak", "<p>hard<br>\nbreak</p>\n"},
+		{"raw HTML", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"nested list", "- a\n- b\n  - c\n- d", "<ul>\n<li>a</li>\n<li>b\n<ul>\n<li>c</li>\n</ul></li>\n<li>d</li>\n</ul>\n"},
+		{"ordered list", "3. x\n4. y", "<ol start=\"3\">\n<li>x</li>\n<li>y</li>\n</ol>\n"},
+		{"block quote", "> quoted\n> more", "<blockquote>\n<p>quoted\nmore</p>\n</blockquote>\n"},
+		{"rule", "---", "<hr>\n"},
+		{"fenced code", "```go\nfmt.Println(\"<x>\")\n```", "<pre><code class=\"language-go\">fmt.Println(&#34;&lt;x&gt;&#34;)</code></pre>\n"},
+		{"fenced code without a language", "```\n<b>\n```", "<pre><code>&lt;b&gt;</code></pre>\n"},
+		{"code span", "`[not](a link)`", "<p><code>[not](a link)</code></p>\n"},
+		{"link", "[a link](/view/Page)", "<p><a href=\"/view/Page\">a link</a></p>\n"},
+		{"link with a title", "[Go](https://go.dev \"The Go site\")", "<p><a href=\"https://go.dev\" title=\"The Go site\">Go</a></p>\n"},
+		{"image", "![logo](/static/icon.svg)", "<p><img src=\"/static/icon.svg\" alt=\"logo\"></p>\n"},
+		{"empty link text", "[](empty)", "<p>[](empty)</p>\n"},
+		{"script link", "[bad](javascript:alert(1))", "<p>bad</p>\n"},
+		{"script image", "![bad](javascript:alert(1))", "<p>bad</p>\n"},
+	}
+	for _, test := range tests {
+		if got := string(markdownRenderer{wiki: testWiki}.Render([]byte(test.markdown))); got != test.want {
+			t.Errorf("%s: Render(%q) = %q, want %q", test.name, test.markdown, got, test.want)
+		}
+	}
+}
+
+func TestMarkdownLinkParentheses(t *testing.T) {
+	tests := []struct {
+		markdown, want string
+	}{
+		{"[wiki](https://en.wikipedia.org/wiki/Go_(programming_language))",
+			"<p><a href=\"https://en.wikipedia.org/wiki/Go_(programming_language)\">wiki</a></p>\n"},
+		{"[nested](https://example.com/a_(b_(c)))", "<p><a href=\"https://example.com/a_(b_(c))\">nested</a></p>\n"},
+		{"[escaped](https://example.com/a\\(b)", "<p><a href=\"https://example.com/a(b\">escaped</a></p>\n"},
+		{"[unbalanced](https://example.com/a(b)", "<p>[unbalanced](https://example.com/a(b)</p>\n"},
+		{"([in parentheses](/view/Page))", "<p>(<a href=\"/view/Page\">in parentheses</a>)</p>\n"},
+		{"![chart](/files/a_(1).png \"A (chart)\")", "<p><img src=\"/files/a_(1).png\" alt=\"chart\" title=\"A (chart)\"></p>\n"},
+	}
+	for _, test := range tests {
+		if got := string(markdownRenderer{wiki: testWiki}.Render([]byte(test.markdown))); got != test.want {
+			t.Errorf("Render(%q) = %q, want %q", test.markdown, got, test.want)
+		}
+	}
+}
+
+func TestMarkdownLinksUnderBasePath(t *testing.T) {
+	previous := testWiki.config.BasePath
+	testWiki.config.BasePath = "/w/docs"
+	defer func() { testWiki.config.BasePath = previous }()
+	markdown := "[page](/view/Page) [site](https://go.dev) [relative](Page) ![logo](/static/icon.svg)"
+	want := "<p><a href=\"/w/docs/view/Page\">page</a> <a href=\"https://go.dev\">site</a> <a href=\"Page\">relative</a> <img src=\"/w/docs/static/icon.svg\" alt=\"logo\"></p>\n"
+	if got := string(markdownRenderer{wiki: testWiki}.Render([]byte(markdown))); got != want {
+		t.Errorf("Render(%q) = %q, want %q", markdown, got, want)
+	}
+}
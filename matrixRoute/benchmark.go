@@ -0,0 +1,212 @@
+package matrixRoute
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultBenchmarkRounds is how many times every worker count is timed; the
+	// fastest round is reported.
+	defaultBenchmarkRounds = 3
+	maxBenchmarkWorkers    = 1024
+)
+
+// benchmarkRow is the timing of one worker count, compared to a single thread.
+type benchmarkRow struct {
+	Workers    int     `json:"workers"`
+	Seconds    float64 `json:"seconds"`
+	Speedup    float64 `json:"speedup"`
+	Efficiency float64 `json:"efficiency"` // speedup per worker
+}
+
+type benchmarkReport struct {
+	MatASize   [2]int         `json:"matASize"`
+	MatBSize   [2]int         `json:"matBSize"`
+	Seed       int64          `json:"seed"`
+	Rounds     int            `json:"rounds"`
+	GOMAXPROCS int            `json:"gomaxprocs"`
+	Results    []benchmarkRow `json:"results"`
+}
+
+// benchmarkPage is what the matrixBenchmark.html template renders.
+type benchmarkPage struct {
+	Error  string
+	Report *benchmarkReport
+}
+
+// multiplyWithWorkers computes the product with a pool of workers goroutines
+// taking rows of the result off a channel. A single worker computes everything on
+// the calling goroutine, which is the sequential baseline.
+func multiplyWithWorkers(mat1, mat2T [][]float64, workers int) [][]float64 {
+	var product = make([][]float64, len(mat1))
+	computeRow := func(rowIdx int) {
+		product[rowIdx] = make([]float64, len(mat2T))
+		for colIdx, mat2Col := range mat2T {
+			var result float64
+			for idx, value := range mat1[rowIdx] {
+				result += value * mat2Col[idx]
+			}
+			product[rowIdx][colIdx] = result
+		}
+	}
+	if workers == 1 {
+		for rowIdx := range mat1 {
+			computeRow(rowIdx)
+		}
+		return product
+	}
+
+	rowCh := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for worker := 0; worker < workers; worker++ {
+		go func() {
+			defer wg.Done()
+			for rowIdx := range rowCh {
+				computeRow(rowIdx)
+			}
+		}()
+	}
+	for rowIdx := range mat1 {
+		rowCh <- rowIdx
+	}
+	close(rowCh)
+	wg.Wait()
+	return product
+}
+
+// fastestOf times rounds runs of fn and returns the fastest, in seconds.
+func fastestOf(rounds int, fn func()) float64 {
+	fastest := 0.0
+	for round := 0; round < rounds; round++ {
+		start := time.Now()
+		fn()
+		if elapsed := time.Since(start).Seconds(); round == 0 || elapsed < fastest {
+			fastest = elapsed
+		}
+	}
+	return fastest
+}
+
+// defaultWorkerCounts doubles from 1 up to GOMAXPROCS, ending with GOMAXPROCS
+// itself.
+func defaultWorkerCounts() []int {
+	maxProcs := runtime.GOMAXPROCS(0)
+	var workerCounts []int
+	for workers := 1; workers < maxProcs; workers *= 2 {
+		workerCounts = append(workerCounts, workers)
+	}
+	return append(workerCounts, maxProcs)
+}
+
+// processWorkerCounts reads the optional comma or space-separated "workers" list.
+// The single-threaded baseline is always run first.
+func processWorkerCounts(request *http.Request) ([]int, string, bool) {
+	userInputString := strings.TrimSpace(request.Form.Get("workers"))
+	if userInputString == "" {
+		return defaultWorkerCounts(), "", true
+	}
+	workerCounts := []int{1}
+	for _, stringValue := range strings.Fields(strings.Replace(userInputString, ",", " ", -1)) {
+		workers, err := strconv.Atoi(stringValue)
+		if err != nil || workers < 1 || workers > maxBenchmarkWorkers {
+			return nil, fmt.Sprintf("%s is an invalid worker count, expected 1 to %d", stringValue, maxBenchmarkWorkers), false
+		}
+		if workers != 1 {
+			workerCounts = append(workerCounts, workers)
+		}
+	}
+	return workerCounts, "", true
+}
+
+func processRounds(request *http.Request) (int, string, bool) {
+	userInputString := strings.TrimSpace(request.Form.Get("rounds"))
+	if userInputString == "" {
+		return defaultBenchmarkRounds, "", true
+	}
+	rounds, err := strconv.Atoi(userInputString)
+	if err != nil || rounds < 1 {
+		return 0, userInputString + " is an invalid number of rounds", false
+	}
+	return rounds, "", true
+}
+
+// runBenchmark multiplies the same seeded matrices with every worker count and
+// reports the fastest of rounds runs for each, with the speedup over one worker.
+func runBenchmark(matAsize, matBsize [2]int, seed int64, rounds int, workerCounts []int) *benchmarkReport {
+	rng := rand.New(rand.NewSource(seed))
+	mat1 := createMat[float64](rng, matAsize)
+	mat2T := transpose(context.Background(), createMat[float64](rng, matBsize))
+
+	report := &benchmarkReport{MatASize: matAsize, MatBSize: matBsize, Seed: seed, Rounds: rounds, GOMAXPROCS: runtime.GOMAXPROCS(0)}
+	var sequentialTime float64
+	for _, workers := range workerCounts {
+		fastest := fastestOf(rounds, func() { multiplyWithWorkers(mat1, mat2T, workers) })
+		if workers == 1 {
+			sequentialTime = fastest
+		}
+		speedup := sequentialTime / fastest
+		report.Results = append(report.Results, benchmarkRow{workers, fastest, speedup, speedup / float64(workers)})
+	}
+	return report
+}
+
+// benchmark parses and validates the form, then runs the benchmark.
+func benchmark(writer http.ResponseWriter, request *http.Request) (*benchmarkReport, string) {
+	if errorMessage, status := parseForm(writer, request); status != http.StatusOK {
+		return nil, errorMessage
+	}
+	matrixSizes, errorMessage, ok := processRequest(request, multiplication.matrices, make([][][]float64, len(multiplication.matrices)))
+	if !ok {
+		return nil, errorMessage
+	}
+	if ok, errorMessage := canMultiply(matrixSizes[0], matrixSizes[1]); !ok {
+		return nil, errorMessage
+	}
+	opts, errorMessage, ok := processOptions(request)
+	if !ok {
+		return nil, errorMessage
+	}
+	rounds, errorMessage, ok := processRounds(request)
+	if !ok {
+		return nil, errorMessage
+	}
+	workerCounts, errorMessage, ok := processWorkerCounts(request)
+	if !ok {
+		return nil, errorMessage
+	}
+	return runBenchmark(matrixSizes[0], matrixSizes[1], opts.seed, rounds, workerCounts), ""
+}
+
+// serveBenchmarkPage renders the comparison as an HTML table.
+func serveBenchmarkPage(writer http.ResponseWriter, request *http.Request, render Renderer) {
+	var data benchmarkPage
+	if request.Method == http.MethodPost {
+		data.Report, data.Error = benchmark(writer, request)
+		if data.Error != "" {
+			writer.WriteHeader(http.StatusBadRequest)
+		}
+	}
+	render(writer, "matrixBenchmark.html", data)
+}
+
+// serveBenchmarkAPI returns the comparison as JSON, taking the same parameters as
+// the page from the query string or a form body.
+func serveBenchmarkAPI(writer http.ResponseWriter, request *http.Request) {
+	report, errorMessage := benchmark(writer, request)
+	if errorMessage != "" {
+		writeJSONError(writer, http.StatusBadRequest, errorMessage)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(report)
+}
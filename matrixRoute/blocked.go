@@ -0,0 +1,123 @@
+package matrixRoute
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// blockedMultiply computes the product tile by tile so that the rows of mat1 and
+// mat2 touched by the inner loops stay in cache. The block rows of the result are
+// computed concurrently by the shared worker pool; within a tile the i-k-j loop
+// order walks mat2 and the result row-wise.
+func blockedMultiply[T element](ctx context.Context, mat1, mat2 [][]T, blockSize int, blockRowDone func()) [][]T {
+	rowsOfMat1, colsOfMat1, colsOfMat2 := len(mat1), len(mat2), len(mat2[0])
+	var product = make([][]T, rowsOfMat1)
+	for rowIdx := range product {
+		product[rowIdx] = make([]T, colsOfMat2)
+	}
+
+	noOfBlockRows := (rowsOfMat1 + blockSize - 1) / blockSize
+	forEachRow(ctx, noOfBlockRows, func(blockRowIdx int) {
+		rowStart := blockRowIdx * blockSize
+		rowEnd := min(rowStart+blockSize, rowsOfMat1)
+		for kStart := 0; kStart < colsOfMat1; kStart += blockSize {
+			kEnd := min(kStart+blockSize, colsOfMat1)
+			for colStart := 0; colStart < colsOfMat2; colStart += blockSize {
+				colEnd := min(colStart+blockSize, colsOfMat2)
+				for rowIdx := rowStart; rowIdx < rowEnd; rowIdx++ {
+					productRow := product[rowIdx]
+					for k := kStart; k < kEnd; k++ {
+						a := mat1[rowIdx][k]
+						mat2Row := mat2[k]
+						for colIdx := colStart; colIdx < colEnd; colIdx++ {
+							productRow[colIdx] += a * mat2Row[colIdx]
+						}
+					}
+				}
+			}
+		}
+		blockRowDone()
+	})
+	return product
+}
+
+// tiledMultiply computes the product tile by tile too, but takes mat2 transposed
+// (mat2T), like matrixMultiply: within a tile every cell of the result is a dot
+// product of a stretch of a row of mat1 and a row of mat2T, both walked
+// row-wise, accumulated over the tiles of k.
+func tiledMultiply[T element](ctx context.Context, mat1, mat2T [][]T, blockSize int, blockRowDone func()) [][]T {
+	rowsOfMat1, colsOfMat1, colsOfMat2 := len(mat1), len(mat2T[0]), len(mat2T)
+	product := newProduct[T](rowsOfMat1, colsOfMat2)
+
+	noOfBlockRows := (rowsOfMat1 + blockSize - 1) / blockSize
+	forEachRow(ctx, noOfBlockRows, func(blockRowIdx int) {
+		rowStart := blockRowIdx * blockSize
+		rowEnd := min(rowStart+blockSize, rowsOfMat1)
+		for colStart := 0; colStart < colsOfMat2; colStart += blockSize {
+			colEnd := min(colStart+blockSize, colsOfMat2)
+			for kStart := 0; kStart < colsOfMat1; kStart += blockSize {
+				kEnd := min(kStart+blockSize, colsOfMat1)
+				for rowIdx := rowStart; rowIdx < rowEnd; rowIdx++ {
+					mat1Row, productRow := mat1[rowIdx][kStart:kEnd], product[rowIdx]
+					for colIdx := colStart; colIdx < colEnd; colIdx++ {
+						mat2Col := mat2T[colIdx][kStart:kEnd]
+						var sum T
+						for k := range mat1Row {
+							sum += mat1Row[k] * mat2Col[k]
+						}
+						productRow[colIdx] += sum
+					}
+				}
+			}
+		}
+		blockRowDone()
+	})
+	return product
+}
+
+func createMatAndMultiplyBlocked[T element](matAsize, matBsize [2]int, opts options, rng *rand.Rand) computation {
+	return multiplyInBlocks(matAsize, matBsize, opts, rng, blockedMultiply[T])
+}
+
+// createMatAndMultiplyTiled times the transposition of B with the product, as it's
+// part of the work of every repetition.
+func createMatAndMultiplyTiled[T element](matAsize, matBsize [2]int, opts options, rng *rand.Rand) computation {
+	return multiplyInBlocks(matAsize, matBsize, opts, rng, func(ctx context.Context, mat1, mat2 [][]T, blockSize int, blockRowDone func()) [][]T {
+		return tiledMultiply(ctx, mat1, transpose(ctx, mat2), blockSize, blockRowDone)
+	})
+}
+
+// multiplyInBlocks generates the inputs and multiplies them with multiply, one
+// of the algorithms computing the result a block row of opts.blockSize at a time.
+func multiplyInBlocks[T element](matAsize, matBsize [2]int, opts options, rng *rand.Rand, multiply func(ctx context.Context, mat1, mat2 [][]T, blockSize int, blockRowDone func()) [][]T) computation {
+	start := time.Now()
+	mat1 := inputMat[T](rng, opts, 0, matAsize)
+	mat2 := inputMat[T](rng, opts, 1, matBsize)
+	generationTime := time.Since(start).Seconds()
+
+	noOfBlockRows := (matAsize[0] + opts.blockSize - 1) / opts.blockSize
+	var blockRowsDone atomic.Int64
+	blockRowDone := func() {
+		opts.reportProgress(int(blockRowsDone.Add(1)), noOfBlockRows*opts.repetitions)
+	}
+
+	start = time.Now()
+	var product [][]T
+	// Like the naive algorithm, extra repetitions only add work for benchmarking.
+	for k := 0; k < opts.repetitions; k++ {
+		product = multiply(opts.ctx, mat1, mat2, opts.blockSize, blockRowDone)
+	}
+	computeTime := time.Since(start).Seconds()
+
+	// The pool's workers, busy with a block row of the result at a time.
+	goroutines := min(poolSize(), noOfBlockRows)
+	result := computation{value: sumOfElements(product), matrix: fullResult(opts, product), notes: []string{
+		benchmarkNote(matAsize, matBsize, opts.repetitions, generationTime, computeTime, goroutines),
+	}}
+	if opts.verify != "" {
+		result.notes = append(result.notes, verifyProduct(mat1, mat2, product, opts, rng))
+	}
+	return result
+}
@@ -0,0 +1,79 @@
+package matrixRoute
+
+import (
+	"container/list"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// maxCachedResults bounds the number of results kept by the result cache.
+const maxCachedResults = 128
+
+type cachedResult struct {
+	key       string
+	result    computation
+	timeTaken float64
+}
+
+// resultCache is a least-recently-used cache of finished computations, so that
+// repeated identical requests (e.g. classroom demos) return instantly.
+type resultCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	byID    map[string]*list.Element // the same entries, by resultID of their key
+	order   *list.List               // most recently used at the front
+}
+
+var results = &resultCache{entries: make(map[string]*list.Element), byID: make(map[string]*list.Element), order: list.New()}
+
+// cacheKey identifies a computation by everything that influences its result and
+// timing: the operation, the input sizes, the seed and the tuning options. Without
+// a seed from the user any earlier random run of the same sizes is a valid answer.
+func cacheKey(op operation, matrixSizes [][2]int, opts options) string {
+	seed := "random"
+	if opts.seedGiven {
+		seed = strconv.FormatInt(opts.seed, 10)
+	}
+	return fmt.Sprintf("%s|%v|%s|%d|%s|%d|%s|%s|%t|%t|%s", op.heading, matrixSizes, seed, opts.repetitions, opts.algorithm, opts.blockSize, opts.precision, opts.verify, opts.full, opts.compareLayouts, opts.valuesKey)
+}
+
+func (c *resultCache) get(key string) (cachedResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, found := c.entries[key]
+	if !found {
+		return cachedResult{}, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(cachedResult), true
+}
+
+func (c *resultCache) getByID(id string) (cachedResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, found := c.byID[id]
+	if !found {
+		return cachedResult{}, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(cachedResult), true
+}
+
+func (c *resultCache) add(key string, result computation, timeTaken float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, found := c.entries[key]; found {
+		element.Value = cachedResult{key, result, timeTaken}
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(cachedResult{key, result, timeTaken})
+	c.byID[resultID(key)] = c.entries[key]
+	if c.order.Len() > maxCachedResults {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(cachedResult).key)
+		delete(c.byID, resultID(oldest.Value.(cachedResult).key))
+	}
+}
@@ -0,0 +1,69 @@
+//go:build gonum
+
+// The gonum backend delegates the multiplication to gonum's mat package, which
+// uses an optimized BLAS implementation. It is only built with -tags gonum, after
+// fetching gonum.org/v1/gonum, so the default build keeps no dependencies.
+
+package matrixRoute
+
+import (
+	"math/rand"
+	"time"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+func init() {
+	algorithms = append(algorithms, algorithm{"gonum", "gonum (BLAS-backed)", createMatAndMultiplyGonum})
+}
+
+func toDense(matrix [][]float64) *mat.Dense {
+	noOfRows, noOfCols := len(matrix), len(matrix[0])
+	var data = make([]float64, 0, noOfRows*noOfCols)
+	for _, row := range matrix {
+		data = append(data, row...)
+	}
+	return mat.NewDense(noOfRows, noOfCols, data)
+}
+
+func fromDense(dense *mat.Dense) [][]float64 {
+	noOfRows, _ := dense.Dims()
+	var matrix = make([][]float64, noOfRows)
+	for rowIdx := range matrix {
+		matrix[rowIdx] = dense.RawRowView(rowIdx)
+	}
+	return matrix
+}
+
+// createMatAndMultiplyGonum generates the same seeded inputs as the other
+// algorithms, so their results and timings can be compared. gonum computes in
+// float64 whatever the requested precision.
+func createMatAndMultiplyGonum(matAsize, matBsize [2]int, opts options, rng *rand.Rand) computation {
+	start := time.Now()
+	mat1 := inputMat[float64](rng, opts, 0, matAsize)
+	mat2 := inputMat[float64](rng, opts, 1, matBsize)
+	dense1, dense2 := toDense(mat1), toDense(mat2)
+	generationTime := time.Since(start).Seconds()
+
+	start = time.Now()
+	var product mat.Dense
+	// Like the other algorithms, extra repetitions only add work for benchmarking.
+	for k := 0; k < opts.repetitions; k++ {
+		product.Reset()
+		product.Mul(dense1, dense2)
+		opts.reportProgress(k+1, opts.repetitions)
+	}
+	computeTime := time.Since(start).Seconds()
+
+	// gonum parallelizes inside BLAS; no goroutines are started here.
+	result := computation{value: mat.Sum(&product), matrix: fullResult(opts, fromDense(&product)), notes: []string{
+		benchmarkNote(matAsize, matBsize, opts.repetitions, generationTime, computeTime, 0),
+	}}
+	if opts.precision == "float32" {
+		result.notes = append(result.notes, "gonum computed in float64")
+	}
+	if opts.verify != "" {
+		result.notes = append(result.notes, verifyProduct(mat1, mat2, fromDense(&product), opts, rng))
+	}
+	return result
+}
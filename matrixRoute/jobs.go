@@ -0,0 +1,263 @@
+package matrixRoute
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// asyncThreshold is the estimated number of floating point operations above
+	// which a computation is queued as a job instead of holding the request open.
+	asyncThreshold = 1e9
+	// maxConcurrentJobs bounds how many jobs compute at the same time, and
+	// maxPendingJobs how many are queued or computing: each holds the values
+	// pasted into its form, up to maxFormBytes of them. Past it a job is
+	// refused, to be submitted again after jobsRetryAfter.
+	maxConcurrentJobs = 2
+	maxPendingJobs    = 16
+	jobsRetryAfter    = 10 * time.Second
+	// maxRetainedJobs bounds how many jobs are remembered; the oldest finished
+	// jobs are forgotten first.
+	maxRetainedJobs = 100
+	// stoppedJobMessage is the error of the jobs stopped as the server shuts down.
+	stoppedJobMessage = "the computation was stopped, the server is shutting down"
+	// progressInterval is how often the event stream checks a job for changes.
+	progressInterval = 200 * time.Millisecond
+)
+
+// job is a computation running in the background.
+type job struct {
+	mu         sync.Mutex
+	id         string
+	operation  string
+	resultName string
+	status     string // queued, running, done or failed
+	progress   float64
+	result     computation
+	timeTaken  float64
+	err        string
+	csvURL     string // the download of the full result, if it was asked for
+}
+
+// jobStatus is the JSON representation of a job served by the jobs API.
+type jobStatus struct {
+	ID         string   `json:"id"`
+	Operation  string   `json:"operation"`
+	Status     string   `json:"status"`
+	Progress   float64  `json:"progress"`
+	Percent    int      `json:"percent"`
+	ResultName string   `json:"resultName,omitempty"`
+	Result     *float64 `json:"result,omitempty"`
+	TimeTaken  float64  `json:"timeTaken,omitempty"`
+	Notes      []string `json:"notes,omitempty"`
+	CSVURL     string   `json:"csvURL,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+func (j *job) snapshot() jobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status := jobStatus{ID: j.id, Operation: j.operation, Status: j.status, Progress: j.progress, Percent: int(100 * j.progress), Error: j.err}
+	if j.status == "done" {
+		value := j.result.value
+		status.ResultName, status.Result, status.TimeTaken, status.Notes = j.resultName, &value, j.timeTaken, j.result.notes
+		if j.result.matrix != nil {
+			status.CSVURL = j.csvURL
+		}
+	}
+	return status
+}
+
+func (j *job) isFinished() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status == "done" || j.status == "failed"
+}
+
+type jobQueue struct {
+	mu      sync.Mutex
+	jobs    map[string]*job
+	order   []string // job ids, oldest first
+	pending int      // of the jobs, those not finished
+	slots   chan struct{}
+	newID   func() string
+	// ctx is the lifetime of the server, the jobs are stopped once it's done.
+	ctx context.Context
+}
+
+var jobs = newJobQueue()
+
+func newJobQueue() *jobQueue {
+	return &jobQueue{jobs: make(map[string]*job), slots: make(chan struct{}, maxConcurrentJobs), newID: randomJobID, ctx: context.Background()}
+}
+
+func randomJobID() string {
+	var id [8]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// submit queues the computation and returns immediately, or returns false when
+// maxPendingJobs are pending already. The job waits for one of the
+// maxConcurrentJobs slots before it starts computing, and its result is added
+// to the result cache under cacheKey, where csvURL downloads its full result.
+func (q *jobQueue) submit(op operation, matrixSizes [][2]int, opts options, cacheKey, csvURL string) (*job, bool) {
+	j := &job{id: q.newID(), operation: op.heading, resultName: op.resultName, status: "queued", csvURL: csvURL}
+	// The job outlives the request that submitted it, and its timeout, but not
+	// the server.
+	opts.ctx = q.ctx
+	opts.progress = func(done, total int) {
+		j.mu.Lock()
+		j.progress = float64(done) / float64(total)
+		j.mu.Unlock()
+	}
+
+	q.mu.Lock()
+	if q.pending >= maxPendingJobs {
+		q.mu.Unlock()
+		return nil, false
+	}
+	q.pending++
+	q.jobs[j.id] = j
+	q.order = append(q.order, j.id)
+	q.forgetOldJobs()
+	q.mu.Unlock()
+
+	go func() {
+		defer func() {
+			q.mu.Lock()
+			q.pending--
+			q.mu.Unlock()
+		}()
+		select {
+		case q.slots <- struct{}{}:
+		case <-q.ctx.Done():
+			j.mu.Lock()
+			j.status, j.err = "failed", stoppedJobMessage
+			j.mu.Unlock()
+			return
+		}
+		defer func() { <-q.slots }()
+
+		j.mu.Lock()
+		j.status = "running"
+		j.mu.Unlock()
+
+		compute := func(matrixSizes [][2]int) (computation, string) {
+			return op.computeSeeded(matrixSizes, opts)
+		}
+		result, errorMessage, timeTaken := timeit(compute)(matrixSizes)
+		if q.ctx.Err() != nil {
+			errorMessage = stoppedJobMessage
+		}
+		if errorMessage == "" {
+			results.add(cacheKey, result, timeTaken)
+		}
+
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		if errorMessage != "" {
+			j.status, j.err = "failed", errorMessage
+			return
+		}
+		j.status, j.progress, j.result, j.timeTaken = "done", 1, result, timeTaken
+	}()
+	return j, true
+}
+
+// forgetOldJobs must be called with q.mu held.
+func (q *jobQueue) forgetOldJobs() {
+	for idx := 0; len(q.order) > maxRetainedJobs && idx < len(q.order); {
+		id := q.order[idx]
+		if !q.jobs[id].isFinished() {
+			idx++
+			continue
+		}
+		delete(q.jobs, id)
+		q.order = append(q.order[:idx], q.order[idx+1:]...)
+	}
+}
+
+func (q *jobQueue) get(id string) *job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.jobs[id]
+}
+
+// serveJob serves GET {jobsPath}{id} with the status and result of a background
+// computation, and GET {jobsPath}{id}/events with the same status streamed as
+// server-sent events until the job finishes.
+func serveJob(writer http.ResponseWriter, request *http.Request, jobsPath string) {
+	if request.Method != http.MethodGet {
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, isEventStream := strings.CutSuffix(strings.TrimPrefix(request.URL.Path, jobsPath), "/events")
+	j := jobs.get(id)
+	if j == nil {
+		http.NotFound(writer, request)
+		return
+	}
+	if isEventStream {
+		streamJob(writer, request, j)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(j.snapshot())
+}
+
+// serveProgress serves GET {progressPath}{id}, the page's stream of the
+// progress of a background computation: the events of {jobsPath}{id}/events,
+// the status with the percentage done, and the result once it's done.
+func serveProgress(writer http.ResponseWriter, request *http.Request, progressPath string) {
+	if request.Method != http.MethodGet {
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	j := jobs.get(strings.TrimPrefix(request.URL.Path, progressPath))
+	if j == nil {
+		http.NotFound(writer, request)
+		return
+	}
+	streamJob(writer, request, j)
+}
+
+func streamJob(writer http.ResponseWriter, request *http.Request, j *job) {
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		http.Error(writer, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	// The stream lasts as long as the job, longer than the server's write timeout.
+	http.NewResponseController(writer).SetWriteDeadline(time.Time{})
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	var lastSent jobStatus
+	for {
+		status := j.snapshot()
+		if status.Status != lastSent.Status || status.Progress != lastSent.Progress {
+			data, _ := json.Marshal(status)
+			fmt.Fprintf(writer, "data: %s\n\n", data)
+			flusher.Flush()
+			lastSent = status
+		}
+		if status.Status == "done" || status.Status == "failed" {
+			return
+		}
+		select {
+		case <-request.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package matrixRoute
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// waitFinished waits for the job to finish, failing the test after a second.
+func waitFinished(t *testing.T, j *job) jobStatus {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !j.isFinished(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s didn't finish", j.id)
+		}
+	}
+	return j.snapshot()
+}
+
+func TestJobQueueBoundsPendingJobs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q := newJobQueue()
+	q.ctx = ctx
+	// with the slots taken, the jobs wait in the queue
+	for slot := 0; slot < maxConcurrentJobs; slot++ {
+		q.slots <- struct{}{}
+	}
+	var queued []*job
+	for idx := 0; idx < maxPendingJobs; idx++ {
+		j, ok := q.submit(transposition, [][2]int{{2, 2}}, options{}, "", "")
+		if !ok {
+			t.Fatalf("job %d of %d was refused", idx+1, maxPendingJobs)
+		}
+		queued = append(queued, j)
+	}
+	if _, ok := q.submit(transposition, [][2]int{{2, 2}}, options{}, "", ""); ok {
+		t.Fatalf("a job past the %d pending ones was queued", maxPendingJobs)
+	}
+
+	// a slot frees up, and the queue drains through it
+	<-q.slots
+	for _, j := range queued {
+		if status := waitFinished(t, j); status.Status != "done" {
+			t.Fatalf("job %s is %s, want done", j.id, status.Status)
+		}
+	}
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if _, ok := q.submit(transposition, [][2]int{{2, 2}}, options{}, "", ""); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("a job was refused after the pending ones finished")
+		}
+	}
+}
+
+func TestJobQueueStopsJobsWithTheServer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := newJobQueue()
+	q.ctx = ctx
+	for slot := 0; slot < maxConcurrentJobs; slot++ {
+		q.slots <- struct{}{}
+	}
+	j, ok := q.submit(transposition, [][2]int{{2, 2}}, options{}, "", "")
+	if !ok {
+		t.Fatal("the job was refused")
+	}
+	cancel()
+	if status := waitFinished(t, j); status.Status != "failed" || status.Error != stoppedJobMessage {
+		t.Errorf("the job is %s with %q once the server stopped, want failed with %q", status.Status, status.Error, stoppedJobMessage)
+	}
+}
@@ -0,0 +1,133 @@
+package matrixRoute
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// maxMatmulRequestBytes bounds the size of a pair of matrices posted to matmul.
+const maxMatmulRequestBytes = 32 << 20
+
+// matmulRequest is the body of the matmul API: the two matrices as arrays of
+// their rows.
+type matmulRequest struct {
+	A [][]float64 `json:"a"`
+	B [][]float64 `json:"b"`
+}
+
+// matmulDimensions are the rows and columns of the matrices of a product.
+type matmulDimensions struct {
+	A       [2]int `json:"a"`
+	B       [2]int `json:"b"`
+	Product [2]int `json:"product"`
+}
+
+type matmulResponse struct {
+	Product    [][]float64      `json:"product"`
+	Dimensions matmulDimensions `json:"dimensions"`
+	TimeTaken  float64          `json:"timeTaken"`
+	// GFLOPS counts 2 floating point operations per multiply-add.
+	GFLOPS float64 `json:"gflops"`
+}
+
+// matmulError is a rejected request: what is wrong, and where when it's in a
+// matrix, the matrix ("a" or "b") and the row from 0.
+type matmulError struct {
+	Error  string `json:"error"`
+	Matrix string `json:"matrix,omitempty"`
+	Row    *int   `json:"row,omitempty"`
+}
+
+func writeMatmulError(writer http.ResponseWriter, status int, matmulErr matmulError) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(matmulErr)
+}
+
+// validateMatrix checks that mat has rows, all as long as the first and not
+// empty, and returns its size.
+func validateMatrix(name string, mat [][]float64) ([2]int, *matmulError) {
+	if len(mat) == 0 {
+		return [2]int{}, &matmulError{Error: fmt.Sprintf("matrix %s is missing or has no rows", name), Matrix: name}
+	}
+	if errorMessage := checkSize([2]int{len(mat), len(mat[0])}); len(mat[0]) > 0 && errorMessage != "" {
+		return [2]int{}, &matmulError{Error: fmt.Sprintf("matrix %s: %s", name, errorMessage), Matrix: name}
+	}
+	for rowIdx, row := range mat {
+		if len(row) == 0 || len(row) != len(mat[0]) {
+			rowIdx := rowIdx
+			return [2]int{}, &matmulError{Error: fmt.Sprintf("row %d of matrix %s has %d columns, expected %d", rowIdx, name, len(row), max(len(mat[0]), 1)), Matrix: name, Row: &rowIdx}
+		}
+	}
+	return [2]int{len(mat), len(mat[0])}, nil
+}
+
+// MatmulHandler serves POST /api/v1/matrix/matmul, and /api/matmul where the
+// wiki mounts it too: it multiplies the two matrices of the JSON body,
+// {"a": [[1, 2], [3, 4]], "b": [[5], [6]]}, with the blocked algorithm and
+// returns the product with the dimensions, how long it took and the GFLOPS that
+// makes.
+// Invalid matrices are answered with the error, the matrix and the row at fault.
+func MatmulHandler(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		writeMatmulError(writer, http.StatusMethodNotAllowed, matmulError{Error: "use POST with a JSON body"})
+		return
+	}
+	var body matmulRequest
+	if err := json.NewDecoder(http.MaxBytesReader(writer, request.Body, maxMatmulRequestBytes)).Decode(&body); err != nil {
+		writeMatmulError(writer, http.StatusBadRequest, matmulError{Error: "invalid JSON body: " + err.Error()})
+		return
+	}
+	var response matmulResponse
+	var invalid *matmulError
+	if response.Dimensions.A, invalid = validateMatrix("a", body.A); invalid != nil {
+		writeMatmulError(writer, http.StatusBadRequest, *invalid)
+		return
+	}
+	if response.Dimensions.B, invalid = validateMatrix("b", body.B); invalid != nil {
+		writeMatmulError(writer, http.StatusBadRequest, *invalid)
+		return
+	}
+	if ok, errorMessage := canMultiply(response.Dimensions.A, response.Dimensions.B); !ok {
+		writeMatmulError(writer, http.StatusUnprocessableEntity, matmulError{Error: errorMessage})
+		return
+	}
+	if errorMessage := checkSize([2]int{len(body.A), len(body.B[0])}); errorMessage != "" {
+		writeMatmulError(writer, http.StatusUnprocessableEntity, matmulError{Error: "the product: " + errorMessage})
+		return
+	}
+
+	ctx, cancel := withTimeout(request.Context())
+	defer cancel()
+	noOfBlockRows := (len(body.A) + defaultBlockSize - 1) / defaultBlockSize
+	var blockRowsDone atomic.Int64
+	start := time.Now()
+	response.Product = blockedMultiply(ctx, body.A, body.B, defaultBlockSize, func() { blockRowsDone.Add(1) })
+	response.TimeTaken = time.Since(start).Seconds()
+	if ctx.Err() != nil {
+		message := fmt.Sprintf("%s, %d of %d block rows done", stoppedMessage(ctx), blockRowsDone.Load(), noOfBlockRows)
+		writeMatmulError(writer, http.StatusServiceUnavailable, matmulError{Error: message})
+		return
+	}
+	response.Dimensions.Product = [2]int{len(response.Product), len(response.Product[0])}
+	if response.TimeTaken > 0 {
+		flops := 2 * float64(response.Dimensions.A[0]) * float64(response.Dimensions.A[1]) * float64(response.Dimensions.B[1])
+		response.GFLOPS = flops / response.TimeTaken / 1e9
+	}
+	for rowIdx, row := range response.Product {
+		for _, value := range row {
+			if math.IsInf(value, 0) || math.IsNaN(value) {
+				rowIdx := rowIdx
+				writeMatmulError(writer, http.StatusUnprocessableEntity, matmulError{Error: fmt.Sprintf("row %d of the product overflows", rowIdx), Row: &rowIdx})
+				return
+			}
+		}
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(response)
+}
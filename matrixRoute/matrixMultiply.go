@@ -1,86 +1,158 @@
 package matrixRoute
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
-const (
-	pageTop = `<!DOCTYPE HTML><html><head>
-<style>.error{color:#FF0000;}.result{color:#0000FF}</style></head><title>Statistics</title>
-<body><h3>Matrix multiplication</h3>
-<p>Computes matrix multiplication b/w 2 matrices.</p>`
-	form = `<form action="/homeC" method="POST">
-<label for="matASize">Size of matrix A  (comma or space-separated) </label><br />
-<input type="text" name="matASize" size="30"><br />
-<label for="matBSize">Size of matrix B  (comma or space-separated) </label><br />
-<input type="text" name="matBSize" size="30"><br />
-<input type="submit" value="Calculate">
-</form>`
-	pageBottom = `</body></html>`
-	anError    = `<p class="error">%s</p>`
-)
-
-func formatResult(result, timeTaken float64) string {
-	return fmt.Sprintf(`<h4 class="result">The result is %f, time taken is %f</h4>`, result, timeTaken)
+// element is the precision a matrix is computed in.
+type element interface {
+	float32 | float64
 }
 
-func createMat(matrixSize [2]int) [][]float64 {
+// createMat fills a matrix with values drawn from rng, so a seeded rng always
+// produces the same matrix.
+func createMat[T element](rng *rand.Rand, matrixSize [2]int) [][]T {
 	noOfRows, noOfCols := matrixSize[0], matrixSize[1]
-	var mat = make([][]float64, noOfRows)
+	var mat = make([][]T, noOfRows)
 	for rowIdx := range mat {
-		mat[rowIdx] = make([]float64, noOfCols)
+		mat[rowIdx] = make([]T, noOfCols)
 		for colIdx := range mat[rowIdx] {
-			mat[rowIdx][colIdx] = rand.Float64() * 1e3
+			mat[rowIdx][colIdx] = T(rng.Float64() * 1e3)
 		}
 	}
 	// fmt.Println(mat)
 	return mat
 }
 
-func matrixMultiply(mat1 [][]float64, mat2 [][]float64, sumCh chan float64) float64 {
-	rowsOfMat1 := len(mat1)
-	colsOfMat2 := len(mat2[0])
+// rowsPerWorkUnit is how many consecutive rows of the product a worker of the pool
+// computes at a time: enough to amortize taking the unit, few enough to keep every
+// worker busy until the end.
+const rowsPerWorkUnit = 8
 
-	for mat1RowIdx := 0; mat1RowIdx < rowsOfMat1; mat1RowIdx++ {
-		for mat2ColIdx := 0; mat2ColIdx < colsOfMat2; mat2ColIdx++ {
-			go dotProduct(mat1, mat2, mat1RowIdx, mat2ColIdx, sumCh)
-		}
+func newProduct[T element](noOfRows, noOfCols int) [][]T {
+	var product = make([][]T, noOfRows)
+	for rowIdx := range product {
+		product[rowIdx] = make([]T, noOfCols)
 	}
+	return product
+}
+
+// matrixMultiply takes the second matrix already transposed (mat2T), so that every
+// dot product walks both operands row-wise instead of striding down mat2's columns.
+// The rows of the product are computed by the worker pool, rowsPerWorkUnit at a
+// time, so the number of goroutines stays bounded by GOMAXPROCS whatever the size.
+func matrixMultiply[T element](ctx context.Context, mat1 [][]T, mat2T [][]T, repetitions int, progress func(rowsDone int)) [][]T {
+	product := newProduct[T](len(mat1), len(mat2T))
+	var rowsDone atomic.Int64
+	forEachBlock(ctx, len(mat1), rowsPerWorkUnit, func(rowStart, rowEnd int) {
+		for rowIdx := rowStart; rowIdx < rowEnd; rowIdx++ {
+			for colIdx, mat2Col := range mat2T {
+				product[rowIdx][colIdx] = dotProduct(mat1[rowIdx], mat2Col, repetitions)
+			}
+		}
+		progress(int(rowsDone.Add(int64(rowEnd - rowStart))))
+	})
+	return product
+}
 
-	var sum float64
+// dotProduct computes one cell of the product from a row of mat1 and a row of the
+// transposed mat2. Repetitions > 1 only add work for benchmarking: every pass
+// recomputes the same value, so the result stays correct.
+func dotProduct[T element](mat1Row, mat2Col []T, repetitions int) T {
+	var result T
 
-	for i := 0; i < rowsOfMat1; i++ {
-		for j := 0; j < colsOfMat2; j++ {
-			sum += <-sumCh
+	for k := 0; k < repetitions; k++ {
+		result = 0
+		for idx := range mat1Row {
+			result += mat1Row[idx] * mat2Col[idx]
 		}
 	}
+	return result
+}
 
-	return sum
+// matrixMultiplyColumnWise is the original layout, walking mat2 column-wise. It is
+// only kept to measure the speedup of the transposed layout, on the same pool,
+// with the compareLayouts option.
+func matrixMultiplyColumnWise[T element](ctx context.Context, mat1 [][]T, mat2 [][]T, repetitions int, progress func(rowsDone int)) [][]T {
+	product := newProduct[T](len(mat1), len(mat2[0]))
+	var rowsDone atomic.Int64
+	forEachBlock(ctx, len(mat1), rowsPerWorkUnit, func(rowStart, rowEnd int) {
+		for rowIdx := rowStart; rowIdx < rowEnd; rowIdx++ {
+			for colIdx := range product[rowIdx] {
+				var result T
+				for k := 0; k < repetitions; k++ {
+					result = 0
+					for mat1ColIdx := range mat1[rowIdx] {
+						result += mat1[rowIdx][mat1ColIdx] * mat2[mat1ColIdx][colIdx]
+					}
+				}
+				product[rowIdx][colIdx] = result
+			}
+		}
+		progress(int(rowsDone.Add(int64(rowEnd - rowStart))))
+	})
+	return product
 }
 
-func dotProduct(mat1, mat2 [][]float64, mat1RowIdx, mat2ColIdx int, sumCh chan float64) {
-	var result float64
+func createMatAndMultiply[T element](matAsize, matBsize [2]int, opts options, rng *rand.Rand) computation {
+	repetitions := opts.repetitions
+	start := time.Now()
+	mat1 := inputMat[T](rng, opts, 0, matAsize)
+	mat2 := inputMat[T](rng, opts, 1, matBsize)
+	generationTime := time.Since(start).Seconds()
 
-	for k := 0; k < 100; k++ {
-		for mat1ColIdx := range mat1[mat1RowIdx] {
-			result += mat1[mat1RowIdx][mat1ColIdx] * mat2[mat1ColIdx][mat2ColIdx]
-		}
+	// Compared, both layouts compute every row once, so each accounts for half
+	// of the progress.
+	rowsOfMat1, rowsDoneBefore, rowsInAll := matAsize[0], 0, matAsize[0]
+	var columnWiseTime float64
+	if opts.compareLayouts {
+		rowsDoneBefore, rowsInAll = rowsOfMat1, 2*rowsOfMat1
+		start = time.Now()
+		matrixMultiplyColumnWise(opts.ctx, mat1, mat2, repetitions, func(rowsDone int) {
+			opts.reportProgress(rowsDone, rowsInAll)
+		})
+		columnWiseTime = time.Since(start).Seconds()
 	}
-	// fmt.Println(result)
-	sumCh <- result
+
+	start = time.Now()
+	product := matrixMultiply(opts.ctx, mat1, transpose(opts.ctx, mat2), repetitions, func(rowsDone int) {
+		opts.reportProgress(rowsDoneBefore+rowsDone, rowsInAll)
+	})
+	transposedTime := time.Since(start).Seconds()
+
+	// The pool's workers, busy with work units of rows.
+	goroutines := min(poolSize(), (matAsize[0]+rowsPerWorkUnit-1)/rowsPerWorkUnit)
+	result := computation{value: sumOfElements(product), matrix: fullResult(opts, product)}
+	if opts.compareLayouts {
+		result.notes = append(result.notes, fmt.Sprintf(
+			"column-wise walk of B took %f, transposed B took %f (transpose included), speedup %.2fx",
+			columnWiseTime, transposedTime, columnWiseTime/transposedTime,
+		))
+	}
+	result.notes = append(result.notes, benchmarkNote(matAsize, matBsize, repetitions, generationTime, transposedTime, goroutines))
+	if opts.verify != "" {
+		result.notes = append(result.notes, verifyProduct(mat1, mat2, product, opts, rng))
+	}
+	return result
 }
 
-func createMatAndMultiply(matAsize, matBsize [2]int) float64 {
-	mat1 := createMat(matAsize)
-	mat2 := createMat(matBsize)
-	sumCh := make(chan float64, matAsize[0]*matBsize[1])
-	sum := matrixMultiply(mat1, mat2, sumCh)
-	return sum
+// benchmarkNote reports the per-phase timings, the achieved GFLOPS of the
+// compute phase (2 floating point operations per multiply-add) and how many
+// goroutines did the work.
+func benchmarkNote(matAsize, matBsize [2]int, repetitions int, generationTime, computeTime float64, goroutines int) string {
+	flops := 2 * float64(matAsize[0]) * float64(matAsize[1]) * float64(matBsize[1]) * float64(repetitions)
+	return fmt.Sprintf(
+		"generation took %f, compute took %f, %.3f GFLOPS, %d goroutines",
+		generationTime, computeTime, flops/computeTime/1e9, goroutines,
+	)
 }
 
 func canMultiply(matAsize, matBsize [2]int) (bool, string) {
@@ -90,60 +162,211 @@ func canMultiply(matAsize, matBsize [2]int) (bool, string) {
 	return false, fmt.Sprintf("matrix with size %d * %d cannot be multiplied with matrix of size %d * %d", matAsize[0], matAsize[1], matBsize[0], matBsize[1])
 }
 
-// MatrixHandler returns the home page with the requested computation
-func MatrixHandler(writer http.ResponseWriter, request *http.Request) {
-	err := request.ParseForm() // Must be called before writing response
-	fmt.Fprint(writer, pageTop, form)
-	if err != nil {
-		fmt.Fprintf(writer, anError, err)
-	} else {
-		if len(request.Form) == 0 {
-			fmt.Println("page requested for first time")
-		} else {
-			if matrixSizes, errorMessage, ok := processRequest(request); ok {
-				if isTrue, errorMessage := canMultiply(matrixSizes[0], matrixSizes[1]); isTrue {
-					result, timeTaken := timeit(createMatAndMultiply)(matrixSizes[0], matrixSizes[1])
-					fmt.Fprint(writer, formatResult(result, timeTaken))
-				} else {
-					fmt.Fprintf(writer, anError, errorMessage)
-				}
-			} else {
-				fmt.Fprintf(writer, anError, errorMessage)
-			}
+var multiplication = operation{
+	heading:     "Matrix multiplication",
+	description: "Computes matrix multiplication b/w 2 matrices.",
+	matrices:    []string{"matASize", "matBSize"},
+	repeatable:  true,
+	selectable:  true,
+	resultName:  "result",
+	resultSize: func(matrixSizes [][2]int) [2]int {
+		return [2]int{matrixSizes[0][0], matrixSizes[1][1]}
+	},
+	validate: func(matrixSizes [][2]int) (bool, string) {
+		return canMultiply(matrixSizes[0], matrixSizes[1])
+	},
+	cost: func(matrixSizes [][2]int, opts options) float64 {
+		flops := 2 * float64(matrixSizes[0][0]) * float64(matrixSizes[0][1]) * float64(matrixSizes[1][1])
+		passes := opts.repetitions
+		if opts.compareLayouts && opts.algorithm == "naive" {
+			// the column-wise walk computes the product as many times again
+			passes *= 2
+		}
+		if opts.verify == verifyFull {
+			// the reference is computed once more, on one goroutine
+			passes++
+		}
+		return flops * float64(passes)
+	},
+	compute: func(matrixSizes [][2]int, opts options, rng *rand.Rand) (computation, string) {
+		alg, _ := findAlgorithm(opts.algorithm)
+		return alg.multiply(matrixSizes[0], matrixSizes[1], opts, rng), ""
+	},
+}
+
+// algorithm is a selectable way of computing the product. Optional backends, like
+// gonum, append themselves to algorithms when built with their build tag.
+type algorithm struct {
+	Value    string
+	Label    string
+	multiply func(matAsize, matBsize [2]int, opts options, rng *rand.Rand) computation
+}
+
+var algorithms = []algorithm{
+	{"naive", "naive (worker pool of row blocks)", func(matAsize, matBsize [2]int, opts options, rng *rand.Rand) computation {
+		if opts.precision == "float32" {
+			return createMatAndMultiply[float32](matAsize, matBsize, opts, rng)
+		}
+		return createMatAndMultiply[float64](matAsize, matBsize, opts, rng)
+	}},
+	{"blocked", "blocked (cache tiles)", func(matAsize, matBsize [2]int, opts options, rng *rand.Rand) computation {
+		if opts.precision == "float32" {
+			return createMatAndMultiplyBlocked[float32](matAsize, matBsize, opts, rng)
+		}
+		return createMatAndMultiplyBlocked[float64](matAsize, matBsize, opts, rng)
+	}},
+	{"tiled", "tiled (cache tiles, transposed B)", func(matAsize, matBsize [2]int, opts options, rng *rand.Rand) computation {
+		if opts.precision == "float32" {
+			return createMatAndMultiplyTiled[float32](matAsize, matBsize, opts, rng)
+		}
+		return createMatAndMultiplyTiled[float64](matAsize, matBsize, opts, rng)
+	}},
+}
+
+func findAlgorithm(name string) (algorithm, bool) {
+	for _, alg := range algorithms {
+		if alg.Value == name {
+			return alg, true
+		}
+	}
+	return algorithm{}, false
+}
+
+const (
+	// maxDimension bounds the rows and the columns of a matrix, and
+	// maxMatrixCells the cells of the inputs and of the result alike: 32MB of
+	// float64s. A naive multiplication keeps at most seven such matrices alive,
+	// the inputs, a transposed copy of each and three products with the
+	// column-wise walk and the full verification, which is 224MB: under a
+	// gigabyte for the 4 requests the wiki computes with at once by default.
+	maxDimension   = 20000
+	maxMatrixCells = 1 << 22
+	// maxFormBytes bounds the submitted form, pasted values included.
+	maxFormBytes = 8 << 20
+)
+
+// parseForm parses the form of the request, its body up to maxFormBytes. When it
+// can't, it returns why with the status to answer, 413 for a body too large.
+func parseForm(writer http.ResponseWriter, request *http.Request) (string, int) {
+	if request.Body != nil {
+		request.Body = http.MaxBytesReader(writer, request.Body, maxFormBytes)
+	}
+	if err := request.ParseForm(); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return fmt.Sprintf("the form is larger than %d MB", maxFormBytes>>20), http.StatusRequestEntityTooLarge
 		}
+		return err.Error(), http.StatusBadRequest
+	}
+	return "", http.StatusOK
+}
+
+// checkSize returns what is wrong with the size of an input matrix, "" when
+// nothing is.
+func checkSize(size [2]int) string {
+	switch {
+	case size[0] < 1 || size[1] < 1:
+		return fmt.Sprintf("%d * %d is not a size, the rows and the columns are at least 1", size[0], size[1])
+	case size[0] > maxDimension || size[1] > maxDimension:
+		return fmt.Sprintf("%d * %d is too big, a matrix has at most %d rows and %d columns", size[0], size[1], maxDimension, maxDimension)
+	case size[0]*size[1] > maxMatrixCells:
+		return fmt.Sprintf("%d * %d is too big, a matrix has at most %d cells", size[0], size[1], maxMatrixCells)
 	}
-	fmt.Fprint(writer, pageBottom)
+	return ""
 }
 
-func processRequest(request *http.Request) ([2][2]int, string, bool) {
-	var matSizes [2][2]int
-	for matID, matName := range []string{"matASize", "matBSize"} {
-		slice, found := request.Form[matName]
-		userInputString := slice[0]
-		if found && len(userInputString) > 0 {
-			var sizeValues = strings.Fields(strings.Replace(userInputString, ",", " ", -1))
-			if len(sizeValues) < 2 {
-				return matSizes, "2 numbers needed, only 1 received", false
+// processRequest reads the size of every input matrix. The size of a matrix whose
+// values were pasted is taken from the values.
+func processRequest(request *http.Request, matNames []string, values [][][]float64) ([][2]int, string, bool) {
+	var matSizes = make([][2]int, len(matNames))
+	for matID, matName := range matNames {
+		if mat := values[matID]; mat != nil {
+			matSizes[matID] = [2]int{len(mat), len(mat[0])}
+		} else {
+			sizeValues := strings.Fields(strings.Replace(request.Form.Get(matName), ",", " ", -1))
+			if len(sizeValues) != 2 {
+				return matSizes, fmt.Sprintf("matrix %s: 2 numbers needed, its rows and columns, %d received", matrixLetters[matID], len(sizeValues)), false
 			}
 			for idx, stringValue := range sizeValues {
-				if intValue, err := strconv.Atoi(stringValue); err != nil {
-					return matSizes, stringValue + " is an invalid input", false
-				} else {
-					matSizes[matID][idx] = intValue
+				intValue, err := strconv.Atoi(stringValue)
+				if err != nil {
+					return matSizes, fmt.Sprintf("matrix %s: %s is an invalid input", matrixLetters[matID], stringValue), false
 				}
+				matSizes[matID][idx] = intValue
 			}
-		} else {
-			return matSizes, "2 numbers needed, 0 received", false
+		}
+		if errorMessage := checkSize(matSizes[matID]); errorMessage != "" {
+			return matSizes, fmt.Sprintf("matrix %s: %s", matrixLetters[matID], errorMessage), false
 		}
 	}
 	return matSizes, "", true
 }
 
-func timeit(function func([2]int, [2]int) float64) func([2]int, [2]int) (float64, float64) {
-	return func(arg1, arg2 [2]int) (float64, float64) {
+// defaultBlockSize keeps three float64 tiles (A, B and C) within a typical 256KB L2 cache.
+const defaultBlockSize = 64
+
+// processOptions reads the optional tuning parameters. Repetitions default to a
+// single pass, which gives the plain matrix product, the algorithm to naive and the
+// precision to float64. Without a seed a random one is picked, and echoed with the
+// result so the run can be reproduced.
+func processOptions(request *http.Request) (options, string, bool) {
+	opts := options{repetitions: 1, algorithm: "naive", blockSize: defaultBlockSize, precision: "float64"}
+	if userInputString := strings.TrimSpace(request.Form.Get("repetitions")); userInputString != "" {
+		repetitions, err := strconv.Atoi(userInputString)
+		if err != nil || repetitions < 1 {
+			return opts, userInputString + " is an invalid number of repetitions", false
+		}
+		opts.repetitions = repetitions
+	}
+	if userInputString := strings.TrimSpace(request.Form.Get("algorithm")); userInputString != "" {
+		if _, found := findAlgorithm(userInputString); !found {
+			return opts, userInputString + " is an unknown algorithm", false
+		}
+		opts.algorithm = userInputString
+	}
+	if userInputString := strings.TrimSpace(request.Form.Get("blockSize")); userInputString != "" {
+		blockSize, err := strconv.Atoi(userInputString)
+		if err != nil || blockSize < 1 {
+			return opts, userInputString + " is an invalid block size", false
+		}
+		opts.blockSize = blockSize
+	}
+	if userInputString := strings.TrimSpace(request.Form.Get("precision")); userInputString != "" {
+		if userInputString != "float64" && userInputString != "float32" {
+			return opts, userInputString + " is an unknown precision", false
+		}
+		opts.precision = userInputString
+	}
+	if userInputString := strings.TrimSpace(request.Form.Get("seed")); userInputString != "" {
+		seed, err := strconv.ParseInt(userInputString, 10, 64)
+		if err != nil {
+			return opts, userInputString + " is an invalid seed", false
+		}
+		opts.seed, opts.seedGiven = seed, true
+	} else {
+		opts.seed = rand.Int63()
+	}
+	opts.ctx = request.Context()
+	opts.async = request.Form.Get("async") != ""
+	switch verify := request.Form.Get("verify"); verify {
+	case "":
+	case "1", verifySample:
+		opts.verify = verifySample
+	case verifyFull:
+		opts.verify = verifyFull
+	default:
+		return opts, verify + " is an unknown verification", false
+	}
+	opts.full = request.Form.Get("full") != ""
+	opts.compareLayouts = request.Form.Get("compareLayouts") != ""
+	return opts, "", true
+}
+
+func timeit(function func([][2]int) (computation, string)) func([][2]int) (computation, string, float64) {
+	return func(matrixSizes [][2]int) (computation, string, float64) {
 		start := time.Now()
-		result := function(arg1, arg2)
+		result, errorMessage := function(matrixSizes)
 		timeTaken := time.Now().Sub(start).Seconds()
-		return result, timeTaken
+		return result, errorMessage, timeTaken
 	}
 }
@@ -0,0 +1,119 @@
+package matrixRoute
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// benchmarkSizes are the sizes of the square matrices the benchmarks multiply.
+var benchmarkSizes = []int{64, 256}
+
+// seededOperands returns two size*size matrices of the seed 1, the second one
+// transposed, as the naive algorithm takes them.
+func seededOperands(size int) (mat1, mat2T [][]float64) {
+	rng := rand.New(rand.NewSource(1))
+	mat1 = createMat[float64](rng, [2]int{size, size})
+	mat2T = transpose(context.Background(), createMat[float64](rng, [2]int{size, size}))
+	return mat1, mat2T
+}
+
+// BenchmarkMatrixMultiply times the naive algorithm computing the whole product
+// on the worker pool.
+func BenchmarkMatrixMultiply(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run("size="+strconv.Itoa(size), func(b *testing.B) {
+			mat1, mat2T := seededOperands(size)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				matrixMultiply(context.Background(), mat1, mat2T, 1, func(int) {})
+			}
+		})
+	}
+}
+
+// multiplyPerCell is how the naive algorithm used to schedule its work, one
+// goroutine per cell of the product, kept to compare the worker pool against.
+func multiplyPerCell(mat1, mat2T [][]float64) [][]float64 {
+	product := newProduct[float64](len(mat1), len(mat2T))
+	var wg sync.WaitGroup
+	wg.Add(len(mat1) * len(mat2T))
+	for rowIdx := range mat1 {
+		for colIdx := range mat2T {
+			go func(rowIdx, colIdx int) {
+				defer wg.Done()
+				product[rowIdx][colIdx] = dotProduct(mat1[rowIdx], mat2T[colIdx], 1)
+			}(rowIdx, colIdx)
+		}
+	}
+	wg.Wait()
+	return product
+}
+
+// BenchmarkMultiplyScheduling compares one goroutine per cell against the worker
+// pool of row blocks on products large enough for the goroutines to tell: a
+// 512*512 product is 262144 of them.
+func BenchmarkMultiplyScheduling(b *testing.B) {
+	for _, size := range []int{256, 512} {
+		mat1, mat2T := seededOperands(size)
+		b.Run("perCell/size="+strconv.Itoa(size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				multiplyPerCell(mat1, mat2T)
+			}
+		})
+		b.Run("workerPool/size="+strconv.Itoa(size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				matrixMultiply(context.Background(), mat1, mat2T, 1, func(int) {})
+			}
+		})
+	}
+}
+
+// TestCompareLayouts checks the column-wise walk only runs when asked, and is
+// counted in the cost then.
+func TestCompareLayouts(t *testing.T) {
+	sizes := [][2]int{{4, 3}, {3, 5}}
+	for _, compare := range []bool{false, true} {
+		opts := options{repetitions: 1, algorithm: "naive", ctx: context.Background(), compareLayouts: compare}
+		result := createMatAndMultiply[float64](sizes[0], sizes[1], opts, rand.New(rand.NewSource(1)))
+		noted := false
+		for _, note := range result.notes {
+			noted = noted || strings.Contains(note, "speedup")
+		}
+		if noted != compare {
+			t.Errorf("compareLayouts %t: speedup noted %t, notes %q", compare, noted, result.notes)
+		}
+	}
+	plain := options{repetitions: 3, algorithm: "naive"}
+	compared := plain
+	compared.compareLayouts = true
+	if got, want := multiplication.cost(sizes, compared), 2*multiplication.cost(sizes, plain); got != want {
+		t.Errorf("cost with compareLayouts = %v, want %v", got, want)
+	}
+}
+
+// TestCheckSize checks the matrices too big to be kept are refused.
+func TestCheckSize(t *testing.T) {
+	for _, test := range []struct {
+		size [2]int
+		ok   bool
+	}{
+		{[2]int{1, 1}, true},
+		{[2]int{2048, 2048}, true},
+		{[2]int{20000, 200}, true},
+		{[2]int{0, 3}, false},
+		{[2]int{20001, 1}, false},
+		{[2]int{4096, 4096}, false},
+		{[2]int{20000, 20000}, false},
+	} {
+		if ok := checkSize(test.size) == ""; ok != test.ok {
+			t.Errorf("checkSize(%v) allowed %t, want %t", test.size, ok, test.ok)
+		}
+	}
+}
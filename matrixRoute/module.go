@@ -0,0 +1,76 @@
+package matrixRoute
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Module mounts every page and API of this package into a mux. The pages live
+// below Prefix (multiplication at Prefix itself) and are rendered with Render, so
+// they share the site's templates; the JSON APIs live below APIPrefix.
+type Module struct {
+	Prefix    string
+	APIPrefix string
+	Render    Renderer
+	// NewID names the jobs, random hex strings unless it's set.
+	NewID func() string
+	// Timeout stops a computation that holds its request open for longer, 0
+	// lets it take as long as it takes. The jobs computing in the background
+	// aren't stopped by it, but once Context, the lifetime of the server, is
+	// done.
+	Timeout time.Duration
+	Context context.Context
+}
+
+// New returns the module with its pages below prefix and its APIs below
+// /api/v1/matrix.
+func New(prefix string, render Renderer) *Module {
+	return &Module{Prefix: strings.TrimSuffix(prefix, "/"), APIPrefix: "/api/v1/matrix", Render: render}
+}
+
+// Name identifies the module in the server's configuration.
+func (m *Module) Name() string {
+	return "matrix"
+}
+
+// Mount registers the module's handlers in mux.
+func (m *Module) Mount(mux *http.ServeMux) {
+	jobsPath, resultsPath := m.APIPrefix+"/jobs/", m.APIPrefix+"/results/"
+	progressPath := m.Prefix + "/progress/"
+	if m.NewID != nil {
+		jobs.newID = m.NewID
+	}
+	computeTimeout = m.Timeout
+	if m.Context != nil {
+		jobs.ctx = m.Context
+	}
+	for path, op := range map[string]operation{
+		"":             multiplication,
+		"/add":         addition,
+		"/transpose":   transposition,
+		"/determinant": determination,
+		"/inverse":     inversion,
+	} {
+		op := op
+		mux.HandleFunc(m.Prefix+path, func(writer http.ResponseWriter, request *http.Request) {
+			op.serve(writer, request, jobsPath, progressPath, resultsPath, m.Render)
+		})
+	}
+	mux.HandleFunc(jobsPath, func(writer http.ResponseWriter, request *http.Request) {
+		serveJob(writer, request, jobsPath)
+	})
+	mux.HandleFunc(progressPath, func(writer http.ResponseWriter, request *http.Request) {
+		serveProgress(writer, request, progressPath)
+	})
+	mux.HandleFunc(resultsPath, func(writer http.ResponseWriter, request *http.Request) {
+		serveResult(writer, request, resultsPath)
+	})
+	mux.HandleFunc(m.Prefix+"/benchmark", func(writer http.ResponseWriter, request *http.Request) {
+		serveBenchmarkPage(writer, request, m.Render)
+	})
+	mux.HandleFunc(m.APIPrefix+"/sparse", SparseHandler)
+	mux.HandleFunc(m.APIPrefix+"/matmul", MatmulHandler)
+	mux.HandleFunc(m.APIPrefix+"/benchmark", serveBenchmarkAPI)
+}
@@ -0,0 +1,528 @@
+package matrixRoute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// operation describes one computation served by this module. Every operation
+// shares the same form parsing, validation flow, worker pool and result formatting;
+// only the number of input matrices, the validation and the computation differ.
+type operation struct {
+	heading     string
+	description string
+	matrices    []string // form field names holding the size of each input matrix
+	repeatable  bool     // whether the "repetitions" benchmarking knob applies
+	selectable  bool     // whether the multiplication algorithm can be chosen
+	resultName  string
+	// resultSize is the size of the result matrix, nil for operations whose
+	// result is a single value.
+	resultSize func(matrixSizes [][2]int) [2]int
+	validate   func(matrixSizes [][2]int) (bool, string)
+	compute    func(matrixSizes [][2]int, opts options, rng *rand.Rand) (computation, string)
+	// cost estimates the number of floating point operations, which decides
+	// whether the computation runs in the background as a job.
+	cost func(matrixSizes [][2]int, opts options) float64
+}
+
+// computation is the outcome of an operation: the headline value plus optional
+// notes (timings, comparisons) shown below it.
+type computation struct {
+	value float64
+	notes []string
+	// matrix is the full result, kept when options.full is set.
+	matrix [][]float64
+}
+
+// options holds the optional tuning parameters of a computation.
+type options struct {
+	repetitions int
+	algorithm   string
+	blockSize   int
+	precision   string
+	async       bool
+	// seed drives the generation of the input matrices: the same seed and sizes
+	// always yield identical matrices, and therefore identical results.
+	seed      int64
+	seedGiven bool
+	// verify is how the product is checked, verifySample or verifyFull, "" for not at all.
+	verify string
+	// values are the input matrices pasted by the user, nil for those drawn at
+	// random; valuesKey is their digest.
+	values    [][][]float64
+	valuesKey string
+	// full keeps the full result matrix, to show or download it.
+	full bool
+	// compareLayouts times the naive algorithm walking B column-wise too, for
+	// the speedup of the transposed layout, which is twice the work.
+	compareLayouts bool
+	// progress, when set, is told how much of the computation is done.
+	progress func(done, total int)
+	// ctx stops the computation early once it's done: the worker pool hands out
+	// no more work, and the result is thrown away.
+	ctx context.Context
+}
+
+func (opts options) reportProgress(done, total int) {
+	if opts.progress != nil {
+		opts.progress(done, total)
+	}
+}
+
+// Renderer executes one of the wiki's templates with data. The matrix pages are
+// rendered by the "matrix.html" template.
+type Renderer func(writer http.ResponseWriter, templateFilename string, data interface{})
+
+// matrixInput is the size field of one input matrix in an operation's form.
+type matrixInput struct {
+	Name       string
+	Letter     string
+	ValuesName string
+}
+
+// pageData is what the matrix.html template renders: the operation's form plus, for
+// a submitted form, either an error, a result or the background job computing it.
+type pageData struct {
+	Heading     string
+	Description string
+	Matrices    []matrixInput
+	Repeatable  bool
+	Selectable  bool
+	Algorithms  []algorithm
+	FullResult  bool
+	Error       string
+	Result      *resultView
+	Job         *jobView
+}
+
+type resultView struct {
+	Name      string
+	Value     float64
+	TimeTaken float64
+	Notes     []string
+	// Matrix is the full result when it's small enough for a table, CSVURL its
+	// download whenever the full result was asked for.
+	Matrix     [][]float64
+	Rows, Cols int
+	CSVURL     string
+}
+
+type jobView struct {
+	ID        string
+	URL       string
+	EventsURL string
+}
+
+var matrixLetters = []string{"A", "B"}
+
+func (op operation) pageData() pageData {
+	data := pageData{Heading: op.heading, Description: op.description, Repeatable: op.repeatable, Selectable: op.selectable, Algorithms: algorithms, FullResult: op.resultSize != nil}
+	for matID, matName := range op.matrices {
+		data.Matrices = append(data.Matrices, matrixInput{matName, matrixLetters[matID], valuesField(matName)})
+	}
+	return data
+}
+
+// serve renders the operation's page and, for a submitted form, its result. Links
+// to background jobs point below jobsPath, their progress streams below
+// progressPath and downloads of full results below resultsPath.
+func (op operation) serve(writer http.ResponseWriter, request *http.Request, jobsPath, progressPath, resultsPath string, render Renderer) {
+	data := op.pageData()
+	errorMessage, status := parseForm(writer, request)
+	if status != http.StatusOK {
+		data.Error = errorMessage
+	} else if len(request.Form) == 0 {
+		fmt.Println("page requested for first time")
+	} else {
+		ctx, cancel := withTimeout(request.Context())
+		defer cancel()
+		data.Result, data.Job, data.Error, status = op.run(writer, request.WithContext(ctx), jobsPath, progressPath, resultsPath)
+	}
+	if status != http.StatusOK {
+		writer.WriteHeader(status)
+	}
+	render(writer, "matrix.html", data)
+}
+
+func newResultView(resultName string, result computation, timeTaken float64, csvURL string) *resultView {
+	view := &resultView{Name: resultName, Value: result.value, TimeTaken: timeTaken, Notes: result.notes}
+	if result.matrix != nil {
+		view.Rows, view.Cols, view.CSVURL = len(result.matrix), len(result.matrix[0]), csvURL
+		if view.Rows*view.Cols <= maxTableCells {
+			view.Matrix = result.matrix
+		}
+	}
+	return view
+}
+
+// run validates the submitted form and computes the result, or starts a job
+// computing it in the background. It returns an error message for the user when the
+// input can't be computed, with the status to answer: 400 for an invalid input,
+// 422 for one the operation can't compute, 503 for a computation that was stopped
+// or a job the queue has no room for, with a Retry-After.
+func (op operation) run(writer http.ResponseWriter, request *http.Request, jobsPath, progressPath, resultsPath string) (*resultView, *jobView, string, int) {
+	values, valuesKey, errorMessage, ok := processValues(request, op.matrices)
+	if !ok {
+		return nil, nil, errorMessage, http.StatusBadRequest
+	}
+	matrixSizes, errorMessage, ok := processRequest(request, op.matrices, values)
+	if !ok {
+		return nil, nil, errorMessage, http.StatusBadRequest
+	}
+	opts, errorMessage, ok := processOptions(request)
+	if !ok {
+		return nil, nil, errorMessage, http.StatusBadRequest
+	}
+	opts.values, opts.valuesKey = values, valuesKey
+	opts.full = opts.full && op.resultSize != nil
+	if ok, errorMessage := op.validate(matrixSizes); !ok {
+		return nil, nil, errorMessage, http.StatusUnprocessableEntity
+	}
+	if op.resultSize != nil {
+		if errorMessage := checkSize(op.resultSize(matrixSizes)); errorMessage != "" {
+			return nil, nil, "the result: " + errorMessage, http.StatusUnprocessableEntity
+		}
+	}
+	if opts.full {
+		if size := op.resultSize(matrixSizes); size[0]*size[1] > maxFullResultCells {
+			return nil, nil, fmt.Sprintf("the full result has %d * %d cells, at most %d can be kept", size[0], size[1], maxFullResultCells), http.StatusUnprocessableEntity
+		}
+	}
+	key := cacheKey(op, matrixSizes, opts)
+	csvURL := resultsPath + resultID(key) + ".csv"
+	if cached, found := results.get(key); found {
+		cached.result.notes = append([]string{"served from the result cache"}, cached.result.notes...)
+		return newResultView(op.resultName, cached.result, cached.timeTaken, csvURL), nil, "", http.StatusOK
+	}
+	if opts.async || op.cost(matrixSizes, opts) > asyncThreshold {
+		j, ok := jobs.submit(op, matrixSizes, opts, key, csvURL)
+		if !ok {
+			writer.Header().Set("Retry-After", strconv.Itoa(int(jobsRetryAfter/time.Second)))
+			return nil, nil, fmt.Sprintf("%d computations are queued already, try again later", maxPendingJobs), http.StatusServiceUnavailable
+		}
+		return nil, &jobView{ID: j.id, URL: jobsPath + j.id, EventsURL: progressPath + j.id}, "", http.StatusOK
+	}
+	var meter progressMeter
+	opts.progress = meter.report
+	compute := func(matrixSizes [][2]int) (computation, string) {
+		return op.computeSeeded(matrixSizes, opts)
+	}
+	result, errorMessage, timeTaken := timeit(compute)(matrixSizes)
+	if opts.ctx.Err() != nil {
+		if done, reported := meter.fraction(); reported {
+			return nil, nil, fmt.Sprintf("%s, %.0f%% done", stoppedMessage(opts.ctx), 100*done), http.StatusServiceUnavailable
+		}
+		return nil, nil, stoppedMessage(opts.ctx), http.StatusServiceUnavailable
+	}
+	if errorMessage != "" {
+		return nil, nil, errorMessage, http.StatusUnprocessableEntity
+	}
+	results.add(key, result, timeTaken)
+	return newResultView(op.resultName, result, timeTaken, csvURL), nil, "", http.StatusOK
+}
+
+// computeTimeout is how long a computation holds a request open before it's
+// stopped, 0 for as long as it takes. It's set from Module.Timeout.
+var computeTimeout time.Duration
+
+// withTimeout returns the context of a computation for a request: it's done when
+// the client goes away, or after computeTimeout.
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if computeTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, computeTimeout)
+}
+
+// stoppedMessage says why the computation of ctx was stopped.
+func stoppedMessage(ctx context.Context) string {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Sprintf("the computation was stopped after %v, try smaller matrices or the async option", computeTimeout)
+	}
+	return "the computation was stopped, the client went away"
+}
+
+// progressMeter remembers how much of a computation is done, for the message of
+// one that was stopped. Not every operation reports its progress.
+type progressMeter struct {
+	mu       sync.Mutex
+	done     float64
+	reported bool
+}
+
+func (p *progressMeter) report(done, total int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done, p.reported = float64(done)/float64(total), true
+}
+
+func (p *progressMeter) fraction() (float64, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.done, p.reported
+}
+
+// computeSeeded generates the inputs from a local random source seeded with
+// opts.seed and echoes the seed with the result, so the run can be reproduced.
+func (op operation) computeSeeded(matrixSizes [][2]int, opts options) (computation, string) {
+	result, errorMessage := op.compute(matrixSizes, opts, rand.New(rand.NewSource(opts.seed)))
+	result.notes = append(result.notes, fmt.Sprintf("seed %d (the same seed and sizes yield identical results)", opts.seed))
+	return result, errorMessage
+}
+
+// poolSize is the number of workers of the worker pool: one per thread the
+// scheduler runs goroutines on.
+func poolSize() int {
+	return runtime.GOMAXPROCS(0)
+}
+
+// forEachBlock is the worker pool shared by every operation: the indices in
+// [0, noOfIndices) are cut into work units of blockSize consecutive indices, and
+// at most poolSize workers take the units in order and run fn on them.
+// forEachBlock returns once all of them are done, or as soon as the units in
+// progress are when ctx is done, leaving the others undone.
+func forEachBlock(ctx context.Context, noOfIndices, blockSize int, fn func(start, end int)) {
+	noOfBlocks := (noOfIndices + blockSize - 1) / blockSize
+	workers := min(poolSize(), noOfBlocks)
+	var nextBlock atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for worker := 0; worker < workers; worker++ {
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				blockIdx := int(nextBlock.Add(1)) - 1
+				if blockIdx >= noOfBlocks {
+					return
+				}
+				start := blockIdx * blockSize
+				fn(start, min(start+blockSize, noOfIndices))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// forEachRow runs fn on the worker pool for every row index in [0, noOfRows).
+func forEachRow(ctx context.Context, noOfRows int, fn func(rowIdx int)) {
+	forEachBlock(ctx, noOfRows, 1, func(rowIdx, _ int) {
+		fn(rowIdx)
+	})
+}
+
+func sumOfElements[T element](mat [][]T) float64 {
+	var sum float64
+	for _, row := range mat {
+		for _, value := range row {
+			sum += float64(value)
+		}
+	}
+	return sum
+}
+
+func copyMat(mat [][]float64) [][]float64 {
+	var matCopy = make([][]float64, len(mat))
+	for rowIdx, row := range mat {
+		matCopy[rowIdx] = append([]float64(nil), row...)
+	}
+	return matCopy
+}
+
+func isSquare(matrixSizes [][2]int) (bool, string) {
+	if matrixSizes[0][0] == matrixSizes[0][1] {
+		return true, ""
+	}
+	return false, fmt.Sprintf("matrix with size %d * %d is not square", matrixSizes[0][0], matrixSizes[0][1])
+}
+
+func canAdd(matAsize, matBsize [2]int) (bool, string) {
+	if matAsize == matBsize {
+		return true, ""
+	}
+	return false, fmt.Sprintf("matrix with size %d * %d cannot be added to matrix of size %d * %d", matAsize[0], matAsize[1], matBsize[0], matBsize[1])
+}
+
+func matrixAdd(ctx context.Context, mat1, mat2 [][]float64) [][]float64 {
+	var sumMat = make([][]float64, len(mat1))
+	forEachRow(ctx, len(mat1), func(rowIdx int) {
+		sumMat[rowIdx] = make([]float64, len(mat1[rowIdx]))
+		for colIdx := range mat1[rowIdx] {
+			sumMat[rowIdx][colIdx] = mat1[rowIdx][colIdx] + mat2[rowIdx][colIdx]
+		}
+	})
+	return sumMat
+}
+
+func transpose[T element](ctx context.Context, mat [][]T) [][]T {
+	if len(mat) == 0 {
+		return nil
+	}
+	noOfRows, noOfCols := len(mat), len(mat[0])
+	var transposed = make([][]T, noOfCols)
+	forEachRow(ctx, noOfCols, func(rowIdx int) {
+		transposed[rowIdx] = make([]T, noOfRows)
+		for colIdx := range transposed[rowIdx] {
+			transposed[rowIdx][colIdx] = mat[colIdx][rowIdx]
+		}
+	})
+	return transposed
+}
+
+// determinant uses Gaussian elimination with partial pivoting. The rows below the
+// pivot are eliminated concurrently.
+func determinant(ctx context.Context, mat [][]float64) float64 {
+	size := len(mat)
+	lu := copyMat(mat)
+	det := 1.0
+	for pivotIdx := 0; pivotIdx < size && ctx.Err() == nil; pivotIdx++ {
+		maxRowIdx := pivotIdx
+		for rowIdx := pivotIdx + 1; rowIdx < size; rowIdx++ {
+			if math.Abs(lu[rowIdx][pivotIdx]) > math.Abs(lu[maxRowIdx][pivotIdx]) {
+				maxRowIdx = rowIdx
+			}
+		}
+		if lu[maxRowIdx][pivotIdx] == 0 {
+			return 0
+		}
+		if maxRowIdx != pivotIdx {
+			lu[pivotIdx], lu[maxRowIdx] = lu[maxRowIdx], lu[pivotIdx]
+			det = -det
+		}
+		det *= lu[pivotIdx][pivotIdx]
+		forEachRow(ctx, size-pivotIdx-1, func(idx int) {
+			rowIdx := pivotIdx + 1 + idx
+			factor := lu[rowIdx][pivotIdx] / lu[pivotIdx][pivotIdx]
+			for colIdx := pivotIdx; colIdx < size; colIdx++ {
+				lu[rowIdx][colIdx] -= factor * lu[pivotIdx][colIdx]
+			}
+		})
+	}
+	return det
+}
+
+// inverse uses Gauss-Jordan elimination on the matrix augmented with the identity.
+// Every other row is eliminated concurrently for each pivot. ok is false when the
+// matrix is singular.
+func inverse(ctx context.Context, mat [][]float64) (inv [][]float64, ok bool) {
+	size := len(mat)
+	work := copyMat(mat)
+	inv = make([][]float64, size)
+	for rowIdx := range inv {
+		inv[rowIdx] = make([]float64, size)
+		inv[rowIdx][rowIdx] = 1
+	}
+	for pivotIdx := 0; pivotIdx < size && ctx.Err() == nil; pivotIdx++ {
+		maxRowIdx := pivotIdx
+		for rowIdx := pivotIdx + 1; rowIdx < size; rowIdx++ {
+			if math.Abs(work[rowIdx][pivotIdx]) > math.Abs(work[maxRowIdx][pivotIdx]) {
+				maxRowIdx = rowIdx
+			}
+		}
+		if work[maxRowIdx][pivotIdx] == 0 {
+			return nil, false
+		}
+		work[pivotIdx], work[maxRowIdx] = work[maxRowIdx], work[pivotIdx]
+		inv[pivotIdx], inv[maxRowIdx] = inv[maxRowIdx], inv[pivotIdx]
+
+		pivot := work[pivotIdx][pivotIdx]
+		for colIdx := 0; colIdx < size; colIdx++ {
+			work[pivotIdx][colIdx] /= pivot
+			inv[pivotIdx][colIdx] /= pivot
+		}
+		forEachRow(ctx, size, func(rowIdx int) {
+			if rowIdx == pivotIdx {
+				return
+			}
+			factor := work[rowIdx][pivotIdx]
+			for colIdx := 0; colIdx < size; colIdx++ {
+				work[rowIdx][colIdx] -= factor * work[pivotIdx][colIdx]
+				inv[rowIdx][colIdx] -= factor * inv[pivotIdx][colIdx]
+			}
+		})
+	}
+	return inv, true
+}
+
+var addition = operation{
+	heading:     "Matrix addition",
+	description: "Computes the sum of 2 matrices of the same size.",
+	matrices:    []string{"matASize", "matBSize"},
+	resultName:  "sum of the result's elements",
+	resultSize: func(matrixSizes [][2]int) [2]int {
+		return matrixSizes[0]
+	},
+	validate: func(matrixSizes [][2]int) (bool, string) {
+		return canAdd(matrixSizes[0], matrixSizes[1])
+	},
+	cost: func(matrixSizes [][2]int, opts options) float64 {
+		return float64(matrixSizes[0][0]) * float64(matrixSizes[0][1])
+	},
+	compute: func(matrixSizes [][2]int, opts options, rng *rand.Rand) (computation, string) {
+		sum := matrixAdd(opts.ctx, inputMat[float64](rng, opts, 0, matrixSizes[0]), inputMat[float64](rng, opts, 1, matrixSizes[1]))
+		return computation{value: sumOfElements(sum), matrix: fullResult(opts, sum)}, ""
+	},
+}
+
+var transposition = operation{
+	heading:     "Matrix transpose",
+	description: "Computes the transpose of a matrix.",
+	matrices:    []string{"matASize"},
+	resultName:  "sum of the result's elements",
+	resultSize: func(matrixSizes [][2]int) [2]int {
+		return [2]int{matrixSizes[0][1], matrixSizes[0][0]}
+	},
+	validate: func(matrixSizes [][2]int) (bool, string) {
+		return true, ""
+	},
+	cost: func(matrixSizes [][2]int, opts options) float64 {
+		return float64(matrixSizes[0][0]) * float64(matrixSizes[0][1])
+	},
+	compute: func(matrixSizes [][2]int, opts options, rng *rand.Rand) (computation, string) {
+		transposed := transpose(opts.ctx, inputMat[float64](rng, opts, 0, matrixSizes[0]))
+		return computation{value: sumOfElements(transposed), matrix: fullResult(opts, transposed)}, ""
+	},
+}
+
+var determination = operation{
+	heading:     "Matrix determinant",
+	description: "Computes the determinant of a square matrix.",
+	matrices:    []string{"matASize"},
+	resultName:  "determinant",
+	validate:    isSquare,
+	cost: func(matrixSizes [][2]int, opts options) float64 {
+		return math.Pow(float64(matrixSizes[0][0]), 3) * 2 / 3
+	},
+	compute: func(matrixSizes [][2]int, opts options, rng *rand.Rand) (computation, string) {
+		return computation{value: determinant(opts.ctx, inputMat[float64](rng, opts, 0, matrixSizes[0]))}, ""
+	},
+}
+
+var inversion = operation{
+	heading:     "Matrix inverse",
+	description: "Computes the inverse of a square matrix.",
+	matrices:    []string{"matASize"},
+	resultName:  "sum of the result's elements",
+	resultSize: func(matrixSizes [][2]int) [2]int {
+		return matrixSizes[0]
+	},
+	validate: isSquare,
+	cost: func(matrixSizes [][2]int, opts options) float64 {
+		return math.Pow(float64(matrixSizes[0][0]), 3) * 2
+	},
+	compute: func(matrixSizes [][2]int, opts options, rng *rand.Rand) (computation, string) {
+		inv, ok := inverse(opts.ctx, inputMat[float64](rng, opts, 0, matrixSizes[0]))
+		if !ok {
+			return computation{}, "the matrix is singular and has no inverse"
+		}
+		return computation{value: sumOfElements(inv), matrix: fullResult(opts, inv)}, ""
+	},
+}
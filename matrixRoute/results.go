@@ -0,0 +1,144 @@
+package matrixRoute
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxTableCells is the largest full result shown as a table on the page;
+	// bigger ones are only offered as a CSV download.
+	maxTableCells = 400
+	// maxFullResultCells bounds the full results kept in the result cache for
+	// download.
+	maxFullResultCells = 1 << 22
+)
+
+// valuesField is the name of the form field holding the pasted values of the
+// matrix whose size is in sizeField.
+func valuesField(sizeField string) string {
+	return strings.TrimSuffix(sizeField, "Size") + "Values"
+}
+
+// parseValues reads a pasted matrix: one row per line (or separated by
+// semicolons), values separated by commas or spaces.
+func parseValues(userInputString string) ([][]float64, error) {
+	var mat [][]float64
+	for _, line := range strings.FieldsFunc(userInputString, func(r rune) bool { return r == '\n' || r == ';' }) {
+		fields := strings.Fields(strings.Replace(line, ",", " ", -1))
+		if len(fields) == 0 {
+			continue
+		}
+		row := make([]float64, len(fields))
+		for colIdx, field := range fields {
+			value, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s is an invalid value", field)
+			}
+			row[colIdx] = value
+		}
+		if len(mat) > 0 && len(row) != len(mat[0]) {
+			return nil, fmt.Errorf("row %d has %d values, expected %d like the first row", len(mat)+1, len(row), len(mat[0]))
+		}
+		mat = append(mat, row)
+	}
+	return mat, nil
+}
+
+// processValues reads the pasted values of every input matrix, nil for those left
+// empty, and a digest of them for the result cache.
+func processValues(request *http.Request, matNames []string) ([][][]float64, string, string, bool) {
+	var values = make([][][]float64, len(matNames))
+	digest := sha256.New()
+	var given bool
+	for matID, matName := range matNames {
+		userInputString := strings.TrimSpace(request.Form.Get(valuesField(matName)))
+		if userInputString == "" {
+			continue
+		}
+		mat, err := parseValues(userInputString)
+		if err != nil {
+			return nil, "", fmt.Sprintf("matrix %s: %s", matrixLetters[matID], err), false
+		}
+		values[matID], given = mat, true
+		fmt.Fprintf(digest, "%d:%v;", matID, mat)
+	}
+	if !given {
+		return values, "", "", true
+	}
+	return values, hex.EncodeToString(digest.Sum(nil)[:8]), "", true
+}
+
+// inputMat is the input matrix matID of a computation: the values the user pasted,
+// or else values drawn from rng.
+func inputMat[T element](rng *rand.Rand, opts options, matID int, matrixSize [2]int) [][]T {
+	if matID >= len(opts.values) || opts.values[matID] == nil {
+		return createMat[T](rng, matrixSize)
+	}
+	var mat = make([][]T, len(opts.values[matID]))
+	for rowIdx, row := range opts.values[matID] {
+		mat[rowIdx] = make([]T, len(row))
+		for colIdx, value := range row {
+			mat[rowIdx][colIdx] = T(value)
+		}
+	}
+	return mat
+}
+
+// fullResult is the result matrix to keep with a computation: nil unless the user
+// asked for the full result.
+func fullResult[T element](opts options, mat [][]T) [][]float64 {
+	if !opts.full {
+		return nil
+	}
+	if mat64, ok := any(mat).([][]float64); ok {
+		return mat64
+	}
+	var converted = make([][]float64, len(mat))
+	for rowIdx, row := range mat {
+		converted[rowIdx] = make([]float64, len(row))
+		for colIdx, value := range row {
+			converted[rowIdx][colIdx] = float64(value)
+		}
+	}
+	return converted
+}
+
+// resultID identifies a cached result in the URL of its download.
+func resultID(cacheKey string) string {
+	digest := sha256.Sum256([]byte(cacheKey))
+	return hex.EncodeToString(digest[:8])
+}
+
+// serveResult serves GET {resultsPath}{id}.csv, the full result matrix of a
+// computation, for as long as it stays in the result cache.
+func serveResult(writer http.ResponseWriter, request *http.Request, resultsPath string) {
+	if request.Method != http.MethodGet {
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, isCSV := strings.CutSuffix(strings.TrimPrefix(request.URL.Path, resultsPath), ".csv")
+	cached, found := results.getByID(id)
+	if !isCSV || !found || cached.result.matrix == nil {
+		http.Error(writer, "no such result, it may have expired from the cache: compute it again", http.StatusNotFound)
+		return
+	}
+	writer.Header().Set("Content-Type", "text/csv")
+	writer.Header().Set("Content-Disposition", `attachment; filename="result-`+id+`.csv"`)
+	csvWriter := csv.NewWriter(writer)
+	record := make([]string, 0, len(cached.result.matrix[0]))
+	for _, row := range cached.result.matrix {
+		record = record[:0]
+		for _, value := range row {
+			record = append(record, strconv.FormatFloat(value, 'g', -1, 64))
+		}
+		csvWriter.Write(record)
+	}
+	csvWriter.Flush()
+}
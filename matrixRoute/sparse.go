@@ -0,0 +1,290 @@
+package matrixRoute
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// maxSparseRequestBytes bounds the size of an uploaded pair of matrices.
+const maxSparseRequestBytes = 32 << 20
+
+// matrixSpec is a matrix as accepted and returned by the sparse API. Format is
+// "dense" (Data), "coo" (Entries of [row, col, value]) or "csr" (RowPtr, ColIdx,
+// Values).
+type matrixSpec struct {
+	Format  string       `json:"format"`
+	Rows    int          `json:"rows"`
+	Cols    int          `json:"cols"`
+	Data    [][]float64  `json:"data,omitempty"`
+	Entries [][3]float64 `json:"entries,omitempty"`
+	RowPtr  []int        `json:"rowPtr,omitempty"`
+	ColIdx  []int        `json:"colIdx,omitempty"`
+	Values  []float64    `json:"values,omitempty"`
+}
+
+type sparseRequest struct {
+	A matrixSpec `json:"a"`
+	B matrixSpec `json:"b"`
+}
+
+type sparseResponse struct {
+	Product   matrixSpec `json:"product"`
+	Path      string     `json:"path"`
+	NonZeros  int        `json:"nonZeros"`
+	TimeTaken float64    `json:"timeTaken"`
+}
+
+// csrMatrix is a matrix in compressed sparse row form: the non-zero values of row
+// i are values[rowPtr[i]:rowPtr[i+1]], in the columns colIdx[rowPtr[i]:rowPtr[i+1]].
+type csrMatrix struct {
+	rows, cols int
+	rowPtr     []int
+	colIdx     []int
+	values     []float64
+}
+
+// parseMatrixSpec validates spec and returns either a dense matrix or a sparse one.
+func parseMatrixSpec(spec matrixSpec) ([][]float64, *csrMatrix, error) {
+	if errorMessage := checkSize([2]int{spec.Rows, spec.Cols}); errorMessage != "" {
+		return nil, nil, errors.New(errorMessage)
+	}
+	switch spec.Format {
+	case "dense":
+		if len(spec.Data) != spec.Rows {
+			return nil, nil, fmt.Errorf("dense matrix has %d rows, expected %d", len(spec.Data), spec.Rows)
+		}
+		for rowIdx, row := range spec.Data {
+			if len(row) != spec.Cols {
+				return nil, nil, fmt.Errorf("row %d of dense matrix has %d columns, expected %d", rowIdx, len(row), spec.Cols)
+			}
+		}
+		return spec.Data, nil, nil
+	case "coo":
+		mat, err := cooToCSR(spec.Rows, spec.Cols, spec.Entries)
+		return nil, mat, err
+	case "csr":
+		mat := &csrMatrix{spec.Rows, spec.Cols, spec.RowPtr, spec.ColIdx, spec.Values}
+		return nil, mat, mat.validate()
+	}
+	return nil, nil, fmt.Errorf("%q is an unknown matrix format, expected dense, coo or csr", spec.Format)
+}
+
+// cooToCSR sorts the coordinate entries by position and sums duplicates.
+func cooToCSR(rows, cols int, entries [][3]float64) (*csrMatrix, error) {
+	type entry struct {
+		rowIdx, colIdx int
+		value          float64
+	}
+	var sorted = make([]entry, 0, len(entries))
+	for _, e := range entries {
+		rowIdx, colIdx := int(e[0]), int(e[1])
+		if float64(rowIdx) != e[0] || float64(colIdx) != e[1] || rowIdx < 0 || rowIdx >= rows || colIdx < 0 || colIdx >= cols {
+			return nil, fmt.Errorf("entry at (%g, %g) is outside the %d * %d matrix", e[0], e[1], rows, cols)
+		}
+		sorted = append(sorted, entry{rowIdx, colIdx, e[2]})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].rowIdx != sorted[j].rowIdx {
+			return sorted[i].rowIdx < sorted[j].rowIdx
+		}
+		return sorted[i].colIdx < sorted[j].colIdx
+	})
+
+	mat := &csrMatrix{rows: rows, cols: cols, rowPtr: make([]int, rows+1)}
+	for idx, e := range sorted {
+		if idx > 0 && e.rowIdx == sorted[idx-1].rowIdx && e.colIdx == sorted[idx-1].colIdx {
+			mat.values[len(mat.values)-1] += e.value
+			continue
+		}
+		mat.colIdx = append(mat.colIdx, e.colIdx)
+		mat.values = append(mat.values, e.value)
+		mat.rowPtr[e.rowIdx+1] = len(mat.values)
+	}
+	// Rows without entries end where the previous row ended.
+	for rowIdx := 1; rowIdx <= rows; rowIdx++ {
+		mat.rowPtr[rowIdx] = max(mat.rowPtr[rowIdx], mat.rowPtr[rowIdx-1])
+	}
+	return mat, nil
+}
+
+func (mat *csrMatrix) validate() error {
+	if len(mat.rowPtr) != mat.rows+1 || mat.rowPtr[0] != 0 {
+		return fmt.Errorf("csr rowPtr must have %d entries starting at 0", mat.rows+1)
+	}
+	if len(mat.colIdx) != len(mat.values) || mat.rowPtr[mat.rows] != len(mat.values) {
+		return fmt.Errorf("csr colIdx and values must both have rowPtr[%d] entries", mat.rows)
+	}
+	for rowIdx := 0; rowIdx < mat.rows; rowIdx++ {
+		if mat.rowPtr[rowIdx] > mat.rowPtr[rowIdx+1] {
+			return fmt.Errorf("csr rowPtr must not decrease (row %d)", rowIdx)
+		}
+	}
+	for _, colIdx := range mat.colIdx {
+		if colIdx < 0 || colIdx >= mat.cols {
+			return fmt.Errorf("csr column %d is outside the %d columns", colIdx, mat.cols)
+		}
+	}
+	return nil
+}
+
+func (mat *csrMatrix) spec() matrixSpec {
+	return matrixSpec{Format: "csr", Rows: mat.rows, Cols: mat.cols, RowPtr: mat.rowPtr, ColIdx: mat.colIdx, Values: mat.values}
+}
+
+// sparseDenseMultiply only visits the non-zeros of mat1: row i of the product is the
+// sum of the rows of mat2 picked out by the non-zeros of row i of mat1.
+func sparseDenseMultiply(ctx context.Context, mat1 *csrMatrix, mat2 [][]float64) [][]float64 {
+	colsOfMat2 := len(mat2[0])
+	var product = make([][]float64, mat1.rows)
+	forEachRow(ctx, mat1.rows, func(rowIdx int) {
+		product[rowIdx] = make([]float64, colsOfMat2)
+		for idx := mat1.rowPtr[rowIdx]; idx < mat1.rowPtr[rowIdx+1]; idx++ {
+			a, mat2Row := mat1.values[idx], mat2[mat1.colIdx[idx]]
+			for colIdx, b := range mat2Row {
+				product[rowIdx][colIdx] += a * b
+			}
+		}
+	})
+	return product
+}
+
+// denseSparseMultiply skips the zeros of every row of mat1 and walks only the
+// non-zeros of the matching rows of mat2.
+func denseSparseMultiply(ctx context.Context, mat1 [][]float64, mat2 *csrMatrix) [][]float64 {
+	var product = make([][]float64, len(mat1))
+	forEachRow(ctx, len(mat1), func(rowIdx int) {
+		product[rowIdx] = make([]float64, mat2.cols)
+		for k, a := range mat1[rowIdx] {
+			if a == 0 {
+				continue
+			}
+			for idx := mat2.rowPtr[k]; idx < mat2.rowPtr[k+1]; idx++ {
+				product[rowIdx][mat2.colIdx[idx]] += a * mat2.values[idx]
+			}
+		}
+	})
+	return product
+}
+
+// sparseSparseMultiply is Gustavson's row-by-row algorithm: every row of the product
+// is accumulated concurrently in a map, then the rows are assembled into CSR.
+func sparseSparseMultiply(ctx context.Context, mat1, mat2 *csrMatrix) *csrMatrix {
+	type sparseRow struct {
+		colIdx []int
+		values []float64
+	}
+	var rows = make([]sparseRow, mat1.rows)
+	forEachRow(ctx, mat1.rows, func(rowIdx int) {
+		accumulator := make(map[int]float64)
+		for idx := mat1.rowPtr[rowIdx]; idx < mat1.rowPtr[rowIdx+1]; idx++ {
+			a, k := mat1.values[idx], mat1.colIdx[idx]
+			for idx2 := mat2.rowPtr[k]; idx2 < mat2.rowPtr[k+1]; idx2++ {
+				accumulator[mat2.colIdx[idx2]] += a * mat2.values[idx2]
+			}
+		}
+		row := sparseRow{colIdx: make([]int, 0, len(accumulator))}
+		for colIdx := range accumulator {
+			row.colIdx = append(row.colIdx, colIdx)
+		}
+		sort.Ints(row.colIdx)
+		for _, colIdx := range row.colIdx {
+			row.values = append(row.values, accumulator[colIdx])
+		}
+		rows[rowIdx] = row
+	})
+
+	product := &csrMatrix{rows: mat1.rows, cols: mat2.cols, rowPtr: make([]int, mat1.rows+1)}
+	for rowIdx, row := range rows {
+		product.colIdx = append(product.colIdx, row.colIdx...)
+		product.values = append(product.values, row.values...)
+		product.rowPtr[rowIdx+1] = len(product.values)
+	}
+	return product
+}
+
+func countNonZeros(mat [][]float64) int {
+	var nonZeros int
+	for _, row := range mat {
+		for _, value := range row {
+			if value != 0 {
+				nonZeros++
+			}
+		}
+	}
+	return nonZeros
+}
+
+func writeJSONError(writer http.ResponseWriter, status int, message string) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(map[string]string{"error": message})
+}
+
+// SparseHandler serves POST /api/v1/matrix/sparse: it multiplies the two matrices
+// of the JSON body, each in dense, coo or csr format, picking the sparse-dense,
+// dense-sparse or sparse-sparse path from the formats. Sparse-sparse products are
+// returned in csr format, all others dense.
+func SparseHandler(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		writeJSONError(writer, http.StatusMethodNotAllowed, "use POST with a JSON body")
+		return
+	}
+	var body sparseRequest
+	if err := json.NewDecoder(http.MaxBytesReader(writer, request.Body, maxSparseRequestBytes)).Decode(&body); err != nil {
+		writeJSONError(writer, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	denseA, sparseA, err := parseMatrixSpec(body.A)
+	if err != nil {
+		writeJSONError(writer, http.StatusBadRequest, "matrix a: "+err.Error())
+		return
+	}
+	denseB, sparseB, err := parseMatrixSpec(body.B)
+	if err != nil {
+		writeJSONError(writer, http.StatusBadRequest, "matrix b: "+err.Error())
+		return
+	}
+	if ok, errorMessage := canMultiply([2]int{body.A.Rows, body.A.Cols}, [2]int{body.B.Rows, body.B.Cols}); !ok {
+		writeJSONError(writer, http.StatusUnprocessableEntity, errorMessage)
+		return
+	}
+	if errorMessage := checkSize([2]int{body.A.Rows, body.B.Cols}); errorMessage != "" {
+		writeJSONError(writer, http.StatusUnprocessableEntity, "the product: "+errorMessage)
+		return
+	}
+
+	ctx, cancel := withTimeout(request.Context())
+	defer cancel()
+	var response sparseResponse
+	start := time.Now()
+	switch {
+	case sparseA != nil && sparseB != nil:
+		product := sparseSparseMultiply(ctx, sparseA, sparseB)
+		response.Path, response.Product, response.NonZeros = "sparse-sparse", product.spec(), len(product.values)
+	case sparseA != nil:
+		product := sparseDenseMultiply(ctx, sparseA, denseB)
+		response.Path, response.NonZeros = "sparse-dense", countNonZeros(product)
+		response.Product = matrixSpec{Format: "dense", Rows: len(product), Cols: body.B.Cols, Data: product}
+	case sparseB != nil:
+		product := denseSparseMultiply(ctx, denseA, sparseB)
+		response.Path, response.NonZeros = "dense-sparse", countNonZeros(product)
+		response.Product = matrixSpec{Format: "dense", Rows: len(product), Cols: body.B.Cols, Data: product}
+	default:
+		product := blockedMultiply(ctx, denseA, denseB, defaultBlockSize, func() {})
+		response.Path, response.NonZeros = "dense-dense", countNonZeros(product)
+		response.Product = matrixSpec{Format: "dense", Rows: len(product), Cols: body.B.Cols, Data: product}
+	}
+	response.TimeTaken = time.Since(start).Seconds()
+	if ctx.Err() != nil {
+		writeJSONError(writer, http.StatusServiceUnavailable, stoppedMessage(ctx))
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(response)
+}
@@ -0,0 +1,71 @@
+package matrixRoute
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// verifySamples is how many cells of a product the verification pass recomputes.
+const verifySamples = 64
+
+// referenceCell is the simplest possible single-threaded dot product, in float64
+// whatever the precision of the inputs, used as the reference to verify against.
+func referenceCell[T element](mat1, mat2 [][]T, rowIdx, colIdx int) float64 {
+	var result float64
+	for k := range mat1[rowIdx] {
+		result += float64(mat1[rowIdx][k]) * float64(mat2[k][colIdx])
+	}
+	return result
+}
+
+// Verification modes: verifySample recomputes verifySamples random cells of the
+// product, verifyFull the whole product, one cell after the other.
+const (
+	verifySample = "sample"
+	verifyFull   = "full"
+)
+
+// verifyProduct checks the product against referenceCell, in the mode of
+// opts.verify, and reports the maximum absolute and relative deviation. The full
+// verification reports the checksums, the sums of the elements, of both as well.
+func verifyProduct[T element](mat1, mat2, product [][]T, opts options, rng *rand.Rand) string {
+	if len(product) == 0 || len(product[0]) == 0 {
+		return "nothing to verify in an empty product"
+	}
+	noOfRows, noOfCols := len(product), len(product[0])
+	var maxDeviation, maxRelativeDeviation float64
+	compare := func(rowIdx, colIdx int) float64 {
+		expected := referenceCell(mat1, mat2, rowIdx, colIdx)
+		deviation := math.Abs(float64(product[rowIdx][colIdx]) - expected)
+		maxDeviation = math.Max(maxDeviation, deviation)
+		if expected != 0 {
+			maxRelativeDeviation = math.Max(maxRelativeDeviation, deviation/math.Abs(expected))
+		}
+		return expected
+	}
+
+	if opts.verify == verifyFull {
+		var checksum float64
+		for rowIdx := 0; rowIdx < noOfRows; rowIdx++ {
+			if opts.ctx != nil && opts.ctx.Err() != nil {
+				return fmt.Sprintf("the verification stopped after %d of %d rows", rowIdx, noOfRows)
+			}
+			for colIdx := 0; colIdx < noOfCols; colIdx++ {
+				checksum += compare(rowIdx, colIdx)
+			}
+		}
+		return fmt.Sprintf(
+			"verified all %d cells against a single-threaded reference: max deviation %g (relative %g), checksum %.17g, the reference's %.17g",
+			noOfRows*noOfCols, maxDeviation, maxRelativeDeviation, sumOfElements(product), checksum,
+		)
+	}
+	samples := min(verifySamples, noOfRows*noOfCols)
+	for sample := 0; sample < samples; sample++ {
+		compare(rng.Intn(noOfRows), rng.Intn(noOfCols))
+	}
+	return fmt.Sprintf(
+		"verified %d sampled cells against a single-threaded reference: max deviation %g (relative %g)",
+		samples, maxDeviation, maxRelativeDeviation,
+	)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxNotifications bounds how many notifications are kept per user; the oldest
+// are dropped first.
+const maxNotifications = 100
+
+// mentionPattern matches @name, but not the middle of an e-mail address.
+var mentionPattern = regexp.MustCompile(`(^|[^a-zA-Z0-9@/.])@([a-zA-Z0-9]+)`)
+
+// mentionsOf returns the distinct users with an account mentioned in text.
+func (wiki *Wiki) mentionsOf(text string) []string {
+	seen := make(map[string]bool)
+	var mentioned []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		if name := match[2]; !seen[name] && wiki.hasUser(name) {
+			seen[name] = true
+			mentioned = append(mentioned, name)
+		}
+	}
+	return mentioned
+}
+
+// mentionLinker links the @mentions of users in rendered HTML to their profile.
+// Like titleLinker it leaves links and code alone, and names without an account.
+type mentionLinker struct {
+	wiki *Wiki
+}
+
+func (renderer mentionLinker) Render(body []byte) []byte {
+	return replaceInText(body, func(text string) string {
+		return mentionPattern.ReplaceAllStringFunc(text, func(match string) string {
+			submatch := mentionPattern.FindStringSubmatch(match)
+			if !renderer.wiki.hasUser(submatch[2]) {
+				return match
+			}
+			return submatch[1] + `<a class="mention" href="` + renderer.wiki.wikiPath("/users/"+submatch[2]) + `">@` + submatch[2] + `</a>`
+		})
+	})
+}
+
+// Notification tells a user about something that concerns them, like a mention.
+type Notification struct {
+	Time    time.Time `json:"time"`
+	From    string    `json:"from"`
+	Page    string    `json:"page"`
+	Message string    `json:"message"`
+	Read    bool      `json:"read"`
+}
+
+func (wiki *Wiki) loadNotifications() error {
+	data, err := os.ReadFile(wiki.dataPath("notifications.json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &wiki.notifications.byUser)
+}
+
+// saveNotifications must be called with notifications locked.
+func (wiki *Wiki) saveNotifications() error {
+	data, err := json.MarshalIndent(wiki.notifications.byUser, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(wiki.dataPath("notifications.json"), data, 0600)
+}
+
+func (wiki *Wiki) notify(user string, notification Notification) error {
+	wiki.notifications.Lock()
+	defer wiki.notifications.Unlock()
+	pending := append(wiki.notifications.byUser[user], notification)
+	if len(pending) > maxNotifications {
+		pending = pending[len(pending)-maxNotifications:]
+	}
+	wiki.notifications.byUser[user] = pending
+	return wiki.saveNotifications()
+}
+
+// notificationsOf returns the user's notifications, newest first.
+func (wiki *Wiki) notificationsOf(user string) []Notification {
+	wiki.notifications.Lock()
+	defer wiki.notifications.Unlock()
+	pending := wiki.notifications.byUser[user]
+	newestFirst := make([]Notification, len(pending))
+	for idx, notification := range pending {
+		newestFirst[len(pending)-1-idx] = notification
+	}
+	return newestFirst
+}
+
+func (wiki *Wiki) unreadNotifications(user string) int {
+	wiki.notifications.Lock()
+	defer wiki.notifications.Unlock()
+	var unread int
+	for _, notification := range wiki.notifications.byUser[user] {
+		if !notification.Read {
+			unread++
+		}
+	}
+	return unread
+}
+
+func (wiki *Wiki) markNotificationsRead(user string) error {
+	wiki.notifications.Lock()
+	defer wiki.notifications.Unlock()
+	for idx := range wiki.notifications.byUser[user] {
+		wiki.notifications.byUser[user][idx].Read = true
+	}
+	return wiki.saveNotifications()
+}
+
+// notifyMentions notifies the users a new revision of a page mentions, in its body
+// or its comment, except those the previous body already mentioned and the author.
+// It's called with revisionsMu held.
+func (wiki *Wiki) notifyMentions(title, author, previousBody string, revision Revision) error {
+	already := make(map[string]bool)
+	for _, name := range wiki.mentionsOf(previousBody) {
+		already[name] = true
+	}
+	message := author + " mentioned you in " + title
+	for _, name := range wiki.mentionsOf(revision.Body + "\n" + revision.Comment) {
+		if already[name] || name == author {
+			continue
+		}
+		if err := wiki.notify(name, Notification{Time: revision.Time, From: author, Page: title, Message: message}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ProfilePage is the data of the user profile template.
+type ProfilePage struct {
+	Name          string
+	Mentions      []string
+	Own           bool
+	Notifications []Notification
+	Digest        DigestPreferences
+	Frequencies   []string
+	Identities    []LinkedIdentity
+	Providers     []OAuthLink
+	Error         string
+}
+
+// profileHandler serves /users/{name}: the pages that mention the user and, to the
+// user themself, their notifications, which they POST to mark as read, their
+// digest preferences, which they POST with action=digest, and the identities
+// they log in with, one of which they POST with action=unlink to remove.
+func (wiki *Wiki) profileHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/users/")
+	if !wiki.hasUser(name) {
+		wiki.serveError(w, notFound(""))
+		return
+	}
+	profile := ProfilePage{Name: name, Own: wiki.currentUser(r) == name}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if !profile.Own {
+			wiki.serveError(w, forbidden("only "+name+" can read their notifications"))
+			return
+		}
+		if r.FormValue("action") == "digest" {
+			if err := wiki.setDigestPreferences(name, r.FormValue("email"), r.FormValue("frequency")); err != nil {
+				profile.Error = err.Error()
+				break
+			}
+		} else if r.FormValue("action") == "unlink" {
+			if err := wiki.unlinkIdentity(name, r.FormValue("identity")); err != nil {
+				profile.Error = err.Error()
+				break
+			}
+		} else if err := wiki.markNotificationsRead(name); err != nil {
+			wiki.serveError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/users/"+name, http.StatusFound)
+		return
+	default:
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	for _, title := range wiki.titles.List() {
+		page, err := wiki.load(title)
+		if err != nil {
+			continue
+		}
+		for _, mentioned := range wiki.mentionsOf(string(page.Body)) {
+			if mentioned == name {
+				profile.Mentions = append(profile.Mentions, title)
+				break
+			}
+		}
+	}
+	if profile.Own {
+		profile.Notifications = wiki.notificationsOf(name)
+		profile.Digest = wiki.digestPreferencesOf(name)
+		profile.Frequencies = slices.Sorted(maps.Keys(digestFrequencies))
+		profile.Identities = wiki.identitiesOf(name)
+		profile.Providers = wiki.oauthLinks()
+	}
+	wiki.renderTemplate(w, "profile.html", profile)
+}
+
+// notificationsHandler serves /api/v1/notifications: GET returns the logged in
+// user's notifications, newest first, and POST marks them all as read.
+func (wiki *Wiki) notificationsHandler(w http.ResponseWriter, r *http.Request) {
+	user := wiki.currentUser(r)
+	if user == "" {
+		writeJSONError(w, http.StatusUnauthorized, "log in to see your notifications")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, wiki.notificationsOf(user))
+	case http.MethodPost:
+		if err := wiki.markNotificationsRead(user); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET or POST")
+	}
+}
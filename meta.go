@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PageMeta aggregates what's known about a page, for the info panel of the view
+// page and GET /api/v1/pages/{title}/meta.
+type PageMeta struct {
+	Title      string     `json:"title"`
+	Revisions  int        `json:"revisions"`
+	LastEditor string     `json:"lastEditor,omitempty"`
+	LastEdited *time.Time `json:"lastEdited,omitempty"`
+	Backlinks  int        `json:"backlinks"`
+	Tags       []string   `json:"tags"`
+	Words      int        `json:"words"`
+	Views      int64      `json:"views"`
+	// FrontMatter is what the page says of itself at the top of its body.
+	FrontMatter *FrontMatter `json:"frontMatter,omitempty"`
+}
+
+// tagsOf returns the tags of the page.
+func (wiki *Wiki) tagsOf(p *Page) []string {
+	if tags := wiki.metadataOf(p.Title).Tags; tags != nil {
+		return tags
+	}
+	return []string{}
+}
+
+func (wiki *Wiki) pageMeta(p *Page) (*PageMeta, error) {
+	revisions, err := wiki.loadRevisions(p.Title)
+	if err != nil {
+		return nil, err
+	}
+	meta := &PageMeta{
+		Title:     p.Title,
+		Revisions: len(revisions),
+		Backlinks: len(wiki.backlinksOf(p.Title)),
+		Tags:      wiki.tagsOf(p),
+		Words:     len(strings.Fields(string(stripFrontMatter(p.Body)))),
+		Views:     wiki.viewsOf(p.Title),
+	}
+	meta.FrontMatter, _ = parseFrontMatter(p.Body)
+	if len(revisions) > 0 {
+		last := revisions[len(revisions)-1]
+		meta.LastEditor, meta.LastEdited = last.Author, &last.Time
+	}
+	return meta, nil
+}
+
+// metaHandler serves GET /api/v1/pages/{title}/meta.
+func (wiki *Wiki) metaHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	page, err := wiki.load(title)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	meta, err := wiki.pageMeta(page)
+	if err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(meta)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PageMetadata is what's known about a page besides its body, kept in a sidecar
+// file meta/<title>.json in the data directory.
+type PageMetadata struct {
+	// Weight orders curated listings: pages with a weight come first, lightest
+	// first, followed by the pages without one in alphabetical order.
+	Weight int `json:"weight,omitempty"`
+	// Review is the state of the page in the optional review workflow.
+	Review PageReview `json:"review,omitzero"`
+	// DisplayTitle is the human-readable title shown instead of the slug.
+	DisplayTitle string `json:"displayTitle,omitempty"`
+	// Format is the markup the body is written in, one of pageFormats; without
+	// it the page is rendered in the wiki's -format.
+	Format string `json:"format,omitempty"`
+	// Tags are the slugs the page is listed under at /tag/{name}, sorted.
+	Tags []string `json:"tags,omitempty"`
+}
+
+// isZero reports whether the metadata says nothing about the page.
+func (m PageMetadata) isZero() bool {
+	return m.Weight == 0 && m.Review == (PageReview{}) && m.DisplayTitle == "" && m.Format == "" && len(m.Tags) == 0
+}
+
+// loadMetadata reads every sidecar file at startup, those of sandbox pages in the
+// sandbox's subdirectory included.
+func (wiki *Wiki) loadMetadata() error {
+	err := filepath.WalkDir(wiki.dataPath("meta"), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(wiki.dataPath("meta"), path)
+		if err != nil {
+			return err
+		}
+		title, ok := strings.CutSuffix(filepath.ToSlash(name), ".json")
+		if !ok {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var metadata PageMetadata
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			return err
+		}
+		wiki.indexTagsLocked(title, metadata.Tags)
+		wiki.pageMetadata.byTitle[title] = metadata
+		wiki.titles.SetDisplay(title, metadata.DisplayTitle)
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (wiki *Wiki) metadataFilename(title string) string {
+	return wiki.dataPath("meta", filepath.FromSlash(title)+".json")
+}
+
+func (wiki *Wiki) metadataOf(title string) PageMetadata {
+	wiki.pageMetadata.RLock()
+	defer wiki.pageMetadata.RUnlock()
+	return wiki.pageMetadata.byTitle[title]
+}
+
+// setMetadata stores the metadata of a page; empty metadata removes the sidecar.
+func (wiki *Wiki) setMetadata(title string, metadata PageMetadata) error {
+	// a new display title changes the mentions that are linked to the page
+	if wiki.metadataOf(title).DisplayTitle != metadata.DisplayTitle {
+		defer wiki.backlinks.relink(title)
+	}
+	wiki.pageMetadata.Lock()
+	defer wiki.pageMetadata.Unlock()
+	wiki.titles.SetDisplay(title, metadata.DisplayTitle)
+	wiki.indexTagsLocked(title, metadata.Tags)
+	if metadata.isZero() {
+		delete(wiki.pageMetadata.byTitle, title)
+		if err := os.Remove(wiki.metadataFilename(title)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(wiki.metadataFilename(title)), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(wiki.metadataFilename(title), data, 0600); err != nil {
+		return err
+	}
+	wiki.pageMetadata.byTitle[title] = metadata
+	return nil
+}
+
+// sortListing orders titles for a listing: by weight first, then alphabetically.
+func (wiki *Wiki) sortListing(titles []string) {
+	wiki.pageMetadata.RLock()
+	defer wiki.pageMetadata.RUnlock()
+	sort.SliceStable(titles, func(i, j int) bool {
+		wi, wj := wiki.pageMetadata.byTitle[titles[i]].Weight, wiki.pageMetadata.byTitle[titles[j]].Weight
+		switch {
+		case wi != wj && wi != 0 && wj != 0:
+			return wi < wj
+		case (wi != 0) != (wj != 0):
+			return wi != 0
+		}
+		return titles[i] < titles[j]
+	})
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+const (
+	requestIDHeader = "X-Request-Id"
+	// maxLoggedError bounds how much of the body of a failed response is logged.
+	maxLoggedError = 512
+)
+
+// A middleware wraps a handler with behaviour shared by every request.
+type middleware func(http.Handler) http.Handler
+
+// chain wraps handler in the middlewares, the first outermost.
+func chain(handler http.Handler, middlewares ...middleware) http.Handler {
+	for idx := len(middlewares) - 1; idx >= 0; idx-- {
+		handler = middlewares[idx](handler)
+	}
+	return handler
+}
+
+// setupLogging makes log/slog, and the log package writing through it, log in
+// the configured format: "text" for key=value lines, "json" for JSON lines.
+func setupLogging(format string) error {
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, nil)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, nil)
+	default:
+		return fmt.Errorf("logs are written as text or json, not %q", format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+type requestIDKey struct{}
+
+// validRequestID accepts the request IDs a proxy in front of the wiki may set.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestID returns the ID the request was given by withRequestID.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestLogger returns the logger for a request, which tags every record with
+// the request's ID.
+func requestLogger(r *http.Request) *slog.Logger {
+	return slog.With("requestID", requestID(r))
+}
+
+// withRequestID gives every request an ID, the one in its X-Request-Id header if
+// it has a valid one, and returns it in the same header of the response.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = ids.ID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// withForwardedClient takes the client's address from the last hop of the
+// X-Forwarded-For of the reverse proxy, with -trust-proxy, for the authors, the
+// rate limits and the log to tell the clients apart.
+func (wiki *Wiki) withForwardedClient(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if forwarded := r.Header.Values("X-Forwarded-For"); wiki.config.TrustProxy && len(forwarded) > 0 {
+			hops := strings.Split(forwarded[len(forwarded)-1], ",")
+			if client := net.ParseIP(strings.TrimSpace(hops[len(hops)-1])); client != nil {
+				r = r.Clone(r.Context())
+				r.RemoteAddr = net.JoinHostPort(client.String(), "0")
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type authorKey struct{}
+
+// withAuthor identifies who makes the request once, before any handler runs: the
+// revisions it saves, the reviews it makes and its log record are attributed to
+// that author, see requestAuthor.
+func (wiki *Wiki) withAuthor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authorKey{}, wiki.identify(r))))
+	})
+}
+
+// statusRecorder remembers the status and size of a response, and the start of
+// its body when it's an error, for the request log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+	failed strings.Builder
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(data []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if rec.status >= http.StatusInternalServerError && rec.failed.Len() < maxLoggedError {
+		rec.failed.Write(data[:min(len(data), maxLoggedError-rec.failed.Len())])
+	}
+	n, err := rec.ResponseWriter.Write(data)
+	rec.size += n
+	return n, err
+}
+
+// Flush lets the event streams of the matrix jobs through the recorder.
+func (rec *statusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController the underlying writer.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// logRequests logs every request once it's served: its method, path, status,
+// latency and client, and for a server error what the response said.
+func (wiki *Wiki) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			attrs := []any{
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", rec.status,
+				"latency", time.Since(start),
+				"remoteAddr", r.RemoteAddr,
+				"author", wiki.requestAuthor(r),
+				"bytes", rec.size,
+			}
+			level := slog.LevelInfo
+			switch {
+			case rec.status >= http.StatusInternalServerError:
+				level = slog.LevelError
+				attrs = append(attrs, "error", strings.TrimSpace(rec.failed.String()))
+			case rec.status >= http.StatusBadRequest:
+				level = slog.LevelWarn
+			}
+			requestLogger(r).Log(r.Context(), level, "request", attrs...)
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// recoverPanics turns a panicking handler into a 500 response, logging the panic
+// with its stack, instead of letting it drop the connection.
+func (wiki *Wiki) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if err, ok := recovered.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(recovered)
+			}
+			requestLogger(r).Error("handler panicked", "panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+			wiki.serveError(w, &HTTPError{Status: http.StatusInternalServerError})
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// migratingStore moves a wiki to a new store while it's being served. The pages
+// are read from the new store, and a page it doesn't have yet from the legacy
+// layout, a flat directory of <title>.txt files; that page is copied into the new
+// store as it's read, so the wiki migrates page by page without downtime. The
+// legacy pages are never written to, but deleted along with their copies so that
+// they don't come back.
+type migratingStore struct {
+	PageStore
+	legacy *fileStore
+}
+
+// newMigratingStore wraps primary to read through to the legacy layout in dir.
+func newMigratingStore(primary PageStore, dir string) (PageStore, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return &migratingStore{PageStore: primary, legacy: &fileStore{dir: dir}}, nil
+}
+
+func (s *migratingStore) Load(title string) (*Page, error) {
+	page, migrated, err := s.read(title)
+	if err != nil || migrated {
+		return page, err
+	}
+	if err := s.PageStore.Save(page); err != nil {
+		return nil, fmt.Errorf("could not migrate the page %s: %w", title, err)
+	}
+	log.Printf("migrated the page %s from %s", title, s.legacy.dir)
+	return page, nil
+}
+
+// read reads the page from the store that has it, telling whether that's the new one.
+func (s *migratingStore) read(title string) (*Page, bool, error) {
+	page, err := s.PageStore.Load(title)
+	if !errors.Is(err, os.ErrNotExist) {
+		return page, true, err
+	}
+	page, err = s.legacy.Load(title)
+	return page, false, err
+}
+
+// peek reads a page without migrating it. The indexes built at startup read every
+// page, which would otherwise migrate the whole wiki at once. The title is taken
+// as it is, in its case, like those the store lists.
+func (wiki *Wiki) peek(title string) (*Page, error) {
+	if migrating, ok := wiki.store.(*migratingStore); ok {
+		page, _, err := migrating.read(title)
+		return page, err
+	}
+	return wiki.store.Load(title)
+}
+
+// List returns the pages of both layouts, the ones not migrated yet included.
+func (s *migratingStore) List() ([]string, error) {
+	migrated, err := s.PageStore.List()
+	if err != nil {
+		return nil, err
+	}
+	legacy, err := s.legacy.List()
+	if err != nil {
+		return nil, err
+	}
+	titles := append(migrated, legacy...)
+	slices.Sort(titles)
+	return slices.Compact(titles), nil
+}
+
+func (s *migratingStore) Delete(title string) error {
+	return s.DeleteAs(title, "", "")
+}
+
+func (s *migratingStore) SaveAs(p *Page, author, message string) error {
+	return saveAs(s.PageStore, p, author, message)
+}
+
+func (s *migratingStore) DeleteAs(title, author, message string) error {
+	err := deleteAs(s.PageStore, title, author, message)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if legacyErr := s.legacy.Delete(title); !errors.Is(legacyErr, os.ErrNotExist) {
+		return legacyErr
+	}
+	return err
+}
+
+// ModTime returns when a page was last saved, by the new store if it tells and
+// has the page, or else by the legacy layout.
+func (s *migratingStore) ModTime(title string) (time.Time, error) {
+	if timed, ok := s.PageStore.(interface {
+		ModTime(string) (time.Time, error)
+	}); ok {
+		if modTime, err := timed.ModTime(title); !errors.Is(err, os.ErrNotExist) {
+			return modTime, err
+		}
+	}
+	return s.legacy.ModTime(title)
+}
+
+// Ping checks the new store, which every save goes to.
+func (s *migratingStore) Ping() error {
+	return pingStore(s.PageStore)
+}
+
+// Snapshot hands a backup the new store's open file, if it has one.
+func (s *migratingStore) Snapshot(write func(name string, size int64, content io.WriterTo) error) error {
+	return snapshotStore(s.PageStore, write)
+}
+
+// Close closes the new store, when it holds files open.
+func (s *migratingStore) Close() error {
+	if closer, ok := s.PageStore.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// checkMigration refuses to migrate the file store from its own directory.
+func checkMigration(backend, dataDir, legacyDir string) error {
+	if backend != "file" {
+		return nil
+	}
+	same, err := sameDir(dataDir, legacyDir)
+	if err != nil {
+		return err
+	}
+	if same {
+		return errors.New("the file store already reads the pages of " + legacyDir + ", there's nothing to migrate")
+	}
+	return nil
+}
+
+func sameDir(a, b string) (bool, error) {
+	absA, err := filepath.Abs(a)
+	if err != nil {
+		return false, err
+	}
+	absB, err := filepath.Abs(b)
+	if err != nil {
+		return false, err
+	}
+	return absA == absB, nil
+}
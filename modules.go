@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/shksa/gowiki/matrixRoute"
+)
+
+// RouteModule is an optional feature module, like matrixRoute, that the wiki
+// server mounts into its mux next to the wiki's own handlers.
+type RouteModule interface {
+	Name() string
+	Mount(mux *http.ServeMux)
+}
+
+// modulePrefixes maps a module name to the path prefix it is mounted under. An
+// empty prefix leaves the module unmounted. It is set with repeated
+// -mount name=prefix flags.
+type modulePrefixes map[string]string
+
+func (m modulePrefixes) String() string {
+	var mounts []string
+	for name, prefix := range m {
+		mounts = append(mounts, name+"="+prefix)
+	}
+	return strings.Join(mounts, ",")
+}
+
+func (m modulePrefixes) Set(value string) error {
+	name, prefix, found := strings.Cut(value, "=")
+	if !found || name == "" {
+		return fmt.Errorf("%q should look like name=/prefix", value)
+	}
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		return fmt.Errorf("prefix %q of module %s should start with /", prefix, name)
+	}
+	m[name] = prefix
+	return nil
+}
+
+var mounts = modulePrefixes{"matrix": "/tools/matmul"}
+
+// legacyMatrixPrefix is where the matrix pages were mounted before they moved
+// below /tools; its links are redirected to wherever they are now.
+const legacyMatrixPrefix = "/mm"
+
+// routeModules builds the optional modules that are mounted, with their prefixes,
+// for the lifetime of ctx.
+func (wiki *Wiki) routeModules(ctx context.Context) []RouteModule {
+	var modules []RouteModule
+	if prefix := mounts["matrix"]; prefix != "" {
+		matrix := matrixRoute.New(prefix, wiki.renderTemplate)
+		matrix.NewID = ids.ID
+		matrix.Timeout = wiki.config.MatrixTimeout
+		matrix.Context = ctx
+		modules = append(modules, matrix)
+	}
+	return modules
+}
+
+// matmulAlias serves the matrix multiplication API at the short path clients
+// post to, next to its place below /api/v1/matrix.
+const matmulAlias = "/api/matmul"
+
+func (wiki *Wiki) mountModules(ctx context.Context, mux *http.ServeMux) {
+	for _, module := range wiki.routeModules(ctx) {
+		module.Mount(mux)
+	}
+	if mounts["matrix"] != "" {
+		mux.HandleFunc(matmulAlias, matrixRoute.MatmulHandler)
+	}
+	if prefix := mounts["matrix"]; prefix != "" && prefix != legacyMatrixPrefix {
+		redirect := func(w http.ResponseWriter, r *http.Request) {
+			target := prefix + strings.TrimPrefix(r.URL.Path, legacyMatrixPrefix)
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+		}
+		mux.HandleFunc(legacyMatrixPrefix, redirect)
+		mux.HandleFunc(legacyMatrixPrefix+"/", redirect)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Titles with slashes are in namespaces, like projects/gowiki/design in
+// projects/gowiki, and the file store keeps them in the matching directories of
+// the data directory. The wiki keeps its own state in some of those directories,
+// so no namespace may take their names.
+var reservedNamespaces = map[string]bool{"attachments": true, "comments": true, "drafts": true, "history": true, "meta": true, "trash": true}
+
+// isReserved reports whether the title is in a namespace the wiki keeps its state in.
+func isReserved(title string) bool {
+	top, _, ok := strings.Cut(title, "/")
+	return ok && reservedNamespaces[strings.ToLower(top)]
+}
+
+func reservedError(title string) error {
+	top, _, _ := strings.Cut(title, "/")
+	return badRequest(fmt.Sprintf("the wiki keeps its own files in %s/, pages can't go there", top))
+}
+
+// validNamespace matches the namespaces the index of /view/{namespace}/ lists.
+var validNamespace = regexp.MustCompile("^(?:~[a-zA-Z0-9]+|" + titlePattern + ")$")
+
+// Breadcrumb is a namespace on the way to a page, linking to its index.
+type Breadcrumb struct {
+	Name string
+	Path string
+}
+
+// breadcrumbs returns the namespaces a title is in, outermost first, so
+// projects/gowiki/design has projects and projects/gowiki.
+func breadcrumbs(title string) []Breadcrumb {
+	var crumbs []Breadcrumb
+	segments := strings.Split(title, "/")
+	for idx := range segments[:len(segments)-1] {
+		crumbs = append(crumbs, Breadcrumb{Name: segments[idx], Path: "/view/" + strings.Join(segments[:idx+1], "/") + "/"})
+	}
+	return crumbs
+}
+
+// NamespaceIndex is the data of the namespace template: the pages right in the
+// namespace and the namespaces in it.
+type NamespaceIndex struct {
+	Namespace   string
+	Breadcrumbs []Breadcrumb
+	Pages       []string
+	Namespaces  []string
+}
+
+// namespaceIndex lists what's in a namespace, the pages of it listed for the viewer.
+func (wiki *Wiki) namespaceIndex(namespace string, v viewer) NamespaceIndex {
+	index := NamespaceIndex{Namespace: namespace, Breadcrumbs: breadcrumbs(namespace)}
+	seen := map[string]bool{}
+	for _, title := range wiki.titles.List() {
+		rest, ok := strings.CutPrefix(title, namespace+"/")
+		if !ok {
+			continue
+		}
+		if inner, _, nested := strings.Cut(rest, "/"); nested {
+			if inner = namespace + "/" + inner; !seen[inner] {
+				seen[inner] = true
+				index.Namespaces = append(index.Namespaces, inner)
+			}
+			continue
+		}
+		if v.lists(title) {
+			index.Pages = append(index.Pages, title)
+		}
+	}
+	return index
+}
+
+// withNamespaceIndex serves the index of a namespace at /view/{namespace}/, and
+// leaves the pages to viewHandler. The root namespace's index is the front page.
+func (wiki *Wiki) withNamespaceIndex(view http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace, isIndex := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/view/"), "/")
+		switch {
+		case !isIndex:
+			view(w, r)
+		case namespace == "":
+			http.Redirect(w, r, "/", http.StatusFound)
+		case !validNamespace.MatchString(namespace) || isReserved(namespace+"/"):
+			wiki.serveError(w, notFound(""))
+		default:
+			wiki.renderTemplate(w, "namespace.html", wiki.namespaceIndex(namespace, wiki.viewerOf(r)))
+		}
+	}
+}
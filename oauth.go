@@ -0,0 +1,511 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	oauthCookie = "gowiki_oauth"
+	// oauthTTL is how long a login has at the identity provider.
+	oauthTTL = 10 * time.Minute
+	// oauthTimeout bounds every request the wiki makes to an identity provider.
+	oauthTimeout = 10 * time.Second
+	// maxOAuthResponseBytes bounds what an identity provider answers.
+	maxOAuthResponseBytes = 1 << 20
+)
+
+// oauthEndpoints are where an identity provider lets its users log in, hands
+// out their access tokens and says who they are.
+type oauthEndpoints struct {
+	Authorization string `json:"authorization_endpoint"`
+	Token         string `json:"token_endpoint"`
+	UserInfo      string `json:"userinfo_endpoint"`
+}
+
+// oauthTypes are the identity providers the wiki knows the endpoints and the
+// scopes of; every other OpenID Connect provider is of type oidc, and has them
+// discovered from its issuer.
+var oauthTypes = map[string]struct {
+	endpoints oauthEndpoints
+	scope     string
+}{
+	"google": {oauthEndpoints{
+		Authorization: "https://accounts.google.com/o/oauth2/v2/auth",
+		Token:         "https://oauth2.googleapis.com/token",
+		UserInfo:      "https://openidconnect.googleapis.com/v1/userinfo",
+	}, "openid email profile"},
+	"github": {oauthEndpoints{
+		Authorization: "https://github.com/login/oauth/authorize",
+		Token:         "https://github.com/login/oauth/access_token",
+		UserInfo:      "https://api.github.com/user",
+	}, "read:user"},
+	"oidc": {scope: "openid email profile"},
+}
+
+// An OAuthProvider is an identity provider the users log in with instead of a
+// password, from the file of -oauth-providers. Its Type is google, github or
+// oidc, its Name when that's empty; an oidc provider names its Issuer. The
+// ClientSecret may be left out of the file for GOWIKI_OAUTH_SECRET_{NAME}.
+//
+// The first time someone logs in with it, their identity is linked to the user
+// logged in already, or with Signup to a new user of Role, reader or editor,
+// named after their login there.
+type OAuthProvider struct {
+	Name         string `json:"name"`
+	Label        string `json:"label,omitempty"`
+	Type         string `json:"type,omitempty"`
+	Issuer       string `json:"issuer,omitempty"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	Signup       bool   `json:"signup,omitempty"`
+	Role         string `json:"role,omitempty"`
+
+	wiki      *Wiki
+	mu        sync.Mutex
+	endpoints oauthEndpoints
+}
+
+// loadOAuthProviders reads the identity providers from the JSON list of the
+// file, and the identities linked to users so far.
+func (wiki *Wiki) loadOAuthProviders(filename string) error {
+	if err := wiki.loadIdentities(); err != nil {
+		return err
+	}
+	if filename == "" {
+		return nil
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var providers []*OAuthProvider
+	if err := json.Unmarshal(data, &providers); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	for _, provider := range providers {
+		if !validName.MatchString(provider.Name) {
+			return fmt.Errorf("%s: %q is no name for an identity provider, it's letters and digits", filename, provider.Name)
+		}
+		if wiki.oauthProviders[provider.Name] != nil {
+			return fmt.Errorf("%s: there are two identity providers named %s", filename, provider.Name)
+		}
+		provider.wiki = wiki
+		if provider.Type == "" {
+			provider.Type = provider.Name
+		}
+		known, ok := oauthTypes[provider.Type]
+		if !ok {
+			return fmt.Errorf("%s: %s is of type %q, expected google, github or oidc", filename, provider.Name, provider.Type)
+		}
+		if provider.Type == "oidc" && provider.Issuer == "" {
+			return fmt.Errorf("%s: %s needs the issuer to discover its endpoints from", filename, provider.Name)
+		}
+		if provider.ClientSecret == "" {
+			provider.ClientSecret = os.Getenv("GOWIKI_OAUTH_SECRET_" + strings.ToUpper(provider.Name))
+		}
+		if provider.ClientID == "" || provider.ClientSecret == "" {
+			return fmt.Errorf("%s: %s needs a client_id and a client_secret", filename, provider.Name)
+		}
+		if assigned, err := parseRole(provider.Role); err != nil || assigned > roleEditor {
+			return fmt.Errorf("%s: %s signs users up as readers or editors, not %q", filename, provider.Name, provider.Role)
+		}
+		if provider.Label == "" {
+			provider.Label = provider.Name
+		}
+		if provider.Scope == "" {
+			provider.Scope = known.scope
+		}
+		provider.endpoints = known.endpoints
+		wiki.oauthProviders[provider.Name] = provider
+		wiki.oauthProviderNames = append(wiki.oauthProviderNames, provider.Name)
+	}
+	return nil
+}
+
+// OAuthLink is an identity provider as the templates list it.
+type OAuthLink struct {
+	Name  string
+	Label string
+}
+
+// oauthLinks lists the identity providers in the order of -oauth-providers.
+func (wiki *Wiki) oauthLinks() []OAuthLink {
+	var links []OAuthLink
+	for _, name := range wiki.oauthProviderNames {
+		links = append(links, OAuthLink{Name: name, Label: wiki.oauthProviders[name].Label})
+	}
+	return links
+}
+
+// discover returns the endpoints of the provider, asking an oidc provider's
+// issuer for them the first time.
+func (p *OAuthProvider) discover(ctx context.Context) (oauthEndpoints, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.endpoints.Authorization != "" {
+		return p.endpoints, nil
+	}
+	var discovered oauthEndpoints
+	if err := oauthGet(ctx, strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", "", &discovered); err != nil {
+		return discovered, err
+	}
+	if discovered.Authorization == "" || discovered.Token == "" || discovered.UserInfo == "" {
+		return discovered, fmt.Errorf("%s doesn't say where to log in, get tokens and user info", p.Issuer)
+	}
+	p.endpoints = discovered
+	return discovered, nil
+}
+
+// redirectURI is where the provider sends the users back to, which it has to
+// have registered for the client.
+func (p *OAuthProvider) redirectURI() string {
+	return strings.TrimSuffix(p.wiki.config.BaseURL, "/") + "/login/oauth/" + p.Name + "/callback"
+}
+
+// oauthGet asks the provider for JSON, with the access token if there's one.
+func oauthGet(ctx context.Context, location, accessToken string, into any) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return err
+	}
+	if accessToken != "" {
+		request.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	return oauthDo(request, into)
+}
+
+func oauthDo(request *http.Request, into any) error {
+	request.Header.Set("Accept", "application/json")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", request.URL.Redacted(), response.Status)
+	}
+	decoder := json.NewDecoder(io.LimitReader(response.Body, maxOAuthResponseBytes))
+	decoder.UseNumber()
+	if err := decoder.Decode(into); err != nil {
+		return fmt.Errorf("%s answered with invalid JSON: %v", request.URL.Redacted(), err)
+	}
+	return nil
+}
+
+// exchange trades the code the provider sent the user back with for an access
+// token, proving with the verifier it's the wiki that sent them.
+func (p *OAuthProvider) exchange(ctx context.Context, endpoints oauthEndpoints, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.redirectURI()},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+		"code_verifier": {verifier},
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoints.Token, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var token struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := oauthDo(request, &token); err != nil {
+		return "", err
+	}
+	// GitHub answers 200 with the error
+	if token.Error != "" {
+		return "", fmt.Errorf("%s refused the code: %s %s", p.Label, token.Error, token.ErrorDescription)
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("%s handed out no access token", p.Label)
+	}
+	return token.AccessToken, nil
+}
+
+// identify returns who the user of the access token is at the provider: the
+// subject that stays theirs, and the name they go by, for the name of a new
+// user. GitHub has no OpenID Connect user info, its users are its numeric ids.
+func (p *OAuthProvider) identify(ctx context.Context, endpoints oauthEndpoints, accessToken string) (subject, nickname string, err error) {
+	var info map[string]any
+	if err := oauthGet(ctx, endpoints.UserInfo, accessToken, &info); err != nil {
+		return "", "", err
+	}
+	claim := func(name string) string {
+		switch value := info[name].(type) {
+		case string:
+			return value
+		case json.Number:
+			return value.String()
+		}
+		return ""
+	}
+	subject = claim("sub")
+	if p.Type == "github" {
+		subject = claim("id")
+	}
+	if subject == "" {
+		return "", "", fmt.Errorf("%s didn't say who the user is", p.Label)
+	}
+	for _, name := range []string{"preferred_username", "login", "nickname", "email", "name"} {
+		if nickname = claim(name); nickname != "" {
+			break
+		}
+	}
+	nickname, _, _ = strings.Cut(nickname, "@")
+	return subject, nickname, nil
+}
+
+// pendingOAuth is a login sent to an identity provider, by its state.
+type pendingOAuth struct {
+	provider string
+	verifier string
+	next     string
+	expires  time.Time
+}
+
+func (wiki *Wiki) loadIdentities() error {
+	data, err := os.ReadFile(wiki.dataPath("identities.json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &wiki.identities.byKey)
+}
+
+// saveIdentities must be called with identities locked.
+func (wiki *Wiki) saveIdentities() error {
+	data, err := json.MarshalIndent(wiki.identities.byKey, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(wiki.dataPath("identities.json"), data, 0600)
+}
+
+// LinkedIdentity is an identity linked to a user, for their profile.
+type LinkedIdentity struct {
+	Key      string
+	Provider string
+	Subject  string
+}
+
+// identitiesOf returns the identities linked to the user.
+func (wiki *Wiki) identitiesOf(user string) []LinkedIdentity {
+	wiki.identities.Lock()
+	defer wiki.identities.Unlock()
+	var linked []LinkedIdentity
+	for key, linkedTo := range wiki.identities.byKey {
+		if linkedTo != user {
+			continue
+		}
+		name, subject, _ := strings.Cut(key, ":")
+		label := name
+		if provider := wiki.oauthProviders[name]; provider != nil {
+			label = provider.Label
+		}
+		linked = append(linked, LinkedIdentity{Key: key, Provider: label, Subject: subject})
+	}
+	sort.Slice(linked, func(i, j int) bool { return linked[i].Key < linked[j].Key })
+	return linked
+}
+
+// unlinkIdentity removes the identity from the user, who logs in with it no more.
+func (wiki *Wiki) unlinkIdentity(user, key string) error {
+	wiki.identities.Lock()
+	defer wiki.identities.Unlock()
+	if wiki.identities.byKey[key] != user {
+		return fmt.Errorf("%s isn't linked to %s", key, user)
+	}
+	delete(wiki.identities.byKey, key)
+	if err := wiki.saveIdentities(); err != nil {
+		return err
+	}
+	return wiki.journalChange(JournalEntry{Op: "user", User: user, Comment: "unlinked " + key})
+}
+
+// errNoLinkedUser is returned for an identity linked to no user, by a provider
+// that doesn't sign them up.
+var errNoLinkedUser = errors.New("no user of the wiki is linked to that identity; log in with your password and link it from your profile, or ask an admin for an account")
+
+// linkedUser returns the user the identity logs in as. An identity seen the
+// first time is linked to the user logged in, or signed up as a new one.
+func (p *OAuthProvider) linkedUser(subject, nickname, current string) (string, error) {
+	key := p.Name + ":" + subject
+	p.wiki.identities.Lock()
+	defer p.wiki.identities.Unlock()
+	if user, ok := p.wiki.identities.byKey[key]; ok && p.wiki.hasUser(user) {
+		if current != "" && current != user {
+			return "", fmt.Errorf("that identity is linked to %s already", user)
+		}
+		return user, nil
+	}
+	user, comment := current, "linked "+key
+	if user == "" {
+		if !p.Signup {
+			return "", errNoLinkedUser
+		}
+		var err error
+		if user, err = p.signUp(nickname); err != nil {
+			return "", err
+		}
+		comment = "signed up with " + key
+	}
+	p.wiki.identities.byKey[key] = user
+	if err := p.wiki.saveIdentities(); err != nil {
+		return "", err
+	}
+	return user, p.wiki.journalChange(JournalEntry{Op: "user", User: user, Comment: comment})
+}
+
+// signUp adds a user with the provider's role and no password, named after the
+// nickname, with the letters and digits of it and a number if it's taken.
+func (p *OAuthProvider) signUp(nickname string) (string, error) {
+	base := strings.Map(func(r rune) rune {
+		if r < 128 && validName.MatchString(string(r)) {
+			return r
+		}
+		return -1
+	}, nickname)
+	if len(base) > 32 {
+		base = base[:32]
+	}
+	if base == "" {
+		base = "user"
+	}
+	assigned, _ := parseRole(p.Role)
+	p.wiki.usersMu.Lock()
+	defer p.wiki.usersMu.Unlock()
+	name := base
+	for i := 2; ; i++ {
+		if _, taken := p.wiki.users[name]; !taken {
+			break
+		}
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+	p.wiki.users[name] = User{Name: name, Role: assigned.String()}
+	if err := p.wiki.storeUsers(); err != nil {
+		delete(p.wiki.users, name)
+		return "", err
+	}
+	return name, nil
+}
+
+// oauthHandler serves /login/oauth/{provider}, which sends the user to log in
+// at the identity provider, and /login/oauth/{provider}/callback, where it
+// sends them back with a code for their identity. The state ties the callback
+// to the browser that was sent, and PKCE the code to the wiki.
+func (wiki *Wiki) oauthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	name, callback, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/login/oauth/"), "/")
+	provider := wiki.oauthProviders[name]
+	if provider == nil || callback != "" && callback != "callback" {
+		wiki.serveError(w, notFound(""))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), oauthTimeout)
+	defer cancel()
+	endpoints, err := provider.discover(ctx)
+	if err != nil {
+		wiki.serveError(w, &HTTPError{Status: http.StatusBadGateway, Message: "could not reach " + provider.Label, Cause: err})
+		return
+	}
+	if callback == "" {
+		wiki.startOAuth(w, r, provider, endpoints)
+		return
+	}
+	page := LoginPage{Next: "/", Providers: wiki.oauthLinks()}
+	cookie, err := r.Cookie(oauthCookie)
+	state := r.FormValue("state")
+	wiki.oauthLogins.Lock()
+	pending, ok := wiki.oauthLogins.byState[state]
+	delete(wiki.oauthLogins.byState, state)
+	wiki.oauthLogins.Unlock()
+	http.SetCookie(w, &http.Cookie{Name: oauthCookie, Value: "", Path: "/login/oauth/", MaxAge: -1, HttpOnly: true, Secure: isSecure(r)})
+	switch {
+	case err != nil || !ok || cookie.Value != state || pending.provider != provider.Name || clock.Now().After(pending.expires):
+		page.Error = translate(wiki.requestLocale(w), "login.oauthExpired", provider.Label)
+	case r.FormValue("error") != "":
+		page.Error = translate(wiki.requestLocale(w), "login.oauthRefused", provider.Label, r.FormValue("error"))
+	default:
+		page.Next = pending.next
+		var user string
+		if user, err = provider.callback(ctx, endpoints, r.FormValue("code"), pending.verifier, wiki.currentUser(r)); err == nil {
+			wiki.logIn(w, r, user)
+			http.Redirect(w, r, page.Next, http.StatusFound)
+			return
+		}
+		page.Error = err.Error()
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+	wiki.renderTemplate(w, "login.html", page)
+}
+
+// startOAuth sends the user to log in at the provider.
+func (wiki *Wiki) startOAuth(w http.ResponseWriter, r *http.Request, provider *OAuthProvider, endpoints oauthEndpoints) {
+	state, verifier := ids.Token(16), ids.Token(32)
+	wiki.oauthLogins.Lock()
+	now := clock.Now()
+	for pendingState, pending := range wiki.oauthLogins.byState {
+		if now.After(pending.expires) {
+			delete(wiki.oauthLogins.byState, pendingState)
+		}
+	}
+	wiki.oauthLogins.byState[state] = pendingOAuth{provider: provider.Name, verifier: verifier, next: localRedirect(r.FormValue("next")), expires: now.Add(oauthTTL)}
+	wiki.oauthLogins.Unlock()
+	// the provider sends the user back from its site, a Lax cookie comes along
+	http.SetCookie(w, &http.Cookie{Name: oauthCookie, Value: state, Path: "/login/oauth/", MaxAge: int(oauthTTL.Seconds()),
+		HttpOnly: true, Secure: isSecure(r), SameSite: http.SameSiteLaxMode})
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {provider.ClientID},
+		"redirect_uri":          {provider.redirectURI()},
+		"scope":                 {provider.Scope},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(endpoints.Authorization, "?") {
+		separator = "&"
+	}
+	http.Redirect(w, r, endpoints.Authorization+separator+query.Encode(), http.StatusFound)
+}
+
+// callback returns the user the code the provider sent back logs in as.
+func (p *OAuthProvider) callback(ctx context.Context, endpoints oauthEndpoints, code, verifier, current string) (string, error) {
+	if code == "" {
+		return "", fmt.Errorf("%s sent no code", p.Label)
+	}
+	accessToken, err := p.exchange(ctx, endpoints, code, verifier)
+	if err != nil {
+		return "", err
+	}
+	subject, nickname, err := p.identify(ctx, endpoints, accessToken)
+	if err != nil {
+		return "", err
+	}
+	return p.linkedUser(subject, nickname, current)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// frontPageSize is how many pages a page of the front page's index lists.
+const frontPageSize = 50
+
+// PageEntry is a page of the index on the front page: when it was changed last,
+// by whom, and how long it is in bytes.
+type PageEntry struct {
+	Title    string
+	Modified time.Time
+	Author   string
+	Size     int
+}
+
+// pageEntries knows the entry of every page. It's built at startup and updated on
+// every save and delete, so listing the pages reads neither them nor their
+// histories. version counts the changes, for what's made from the entries and
+// kept until they change, like the sitemap.
+type pageEntries struct {
+	wiki    *Wiki
+	mu      sync.RWMutex
+	byTitle map[string]PageEntry
+	version uint64
+}
+
+// update records that the page was just saved by author.
+func (idx *pageEntries) update(p *Page, author string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.byTitle[p.Title] = PageEntry{Title: p.Title, Modified: clock.Now(), Author: author, Size: len(p.Body)}
+	idx.version++
+}
+
+func (idx *pageEntries) remove(title string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	delete(idx.byTitle, title)
+	idx.version++
+}
+
+// currentVersion returns the number of changes to the entries so far.
+func (idx *pageEntries) currentVersion() uint64 {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.version
+}
+
+// all returns the entry of every page, sorted by title.
+func (idx *pageEntries) all() []PageEntry {
+	idx.mu.RLock()
+	entries := make([]PageEntry, 0, len(idx.byTitle))
+	for _, entry := range idx.byTitle {
+		entries = append(entries, entry)
+	}
+	idx.mu.RUnlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Title < entries[j].Title })
+	return entries
+}
+
+// entries returns the entries of the titles, in their order.
+func (idx *pageEntries) entries(titles []string) []PageEntry {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	entries := make([]PageEntry, len(titles))
+	for i, title := range titles {
+		entries[i] = idx.byTitle[title]
+		entries[i].Title = title
+	}
+	return entries
+}
+
+// indexEntry makes the entry of a page the startup scan read: the time and
+// author of its last revision, or the time the store has for a page without a
+// history.
+func (idx *pageEntries) indexEntry(page *Page) {
+	entry := PageEntry{Title: page.Title, Modified: idx.wiki.pageModTime(page.Title), Size: len(page.Body)}
+	if revisions, err := idx.wiki.loadRevisions(page.Title); err == nil && len(revisions) > 0 {
+		last := revisions[len(revisions)-1]
+		entry.Modified, entry.Author = last.Time, last.Author
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.byTitle[page.Title] = entry
+	idx.version++
+}
+
+// pageSorts are the columns the index sorts by, each ascending. Sorting by title
+// keeps the weights of the pages first.
+var pageSorts = map[string]func(a, b PageEntry) bool{
+	"modified": func(a, b PageEntry) bool { return a.Modified.Before(b.Modified) },
+	"author":   func(a, b PageEntry) bool { return a.Author < b.Author },
+	"size":     func(a, b PageEntry) bool { return a.Size < b.Size },
+}
+
+// PageListing is the index of the front page: a page of the entries, sorted, or
+// all of them grouped by the first letter of their title in the A–Z view.
+type PageListing struct {
+	Entries []PageEntry
+	Groups  []PageGroup
+	Total   int
+	Sort    string
+	Desc    bool
+	Grouped bool
+	// Page is the page of the entries shown, from 1 to Pages, between Prev and
+	// Next, 0 past the first and the last.
+	Page, Pages int
+	Prev, Next  int
+}
+
+// PageGroup are the pages of the A–Z view starting with Letter, "#" for those
+// that don't start with a letter.
+type PageGroup struct {
+	Letter  string
+	Entries []PageEntry
+}
+
+// listPages makes the index of the titles, which are in the order of the
+// listings, as the query asks for it: ?sort= modified, author, size or title,
+// ?order=desc, ?page= and ?view=az.
+func (wiki *Wiki) listPages(listed []string, query url.Values) PageListing {
+	listing := PageListing{Total: len(listed), Sort: "title", Desc: query.Get("order") == "desc", Grouped: query.Get("view") == "az"}
+	entries := wiki.pageIndex.entries(listed)
+	if less, ok := pageSorts[query.Get("sort")]; ok {
+		listing.Sort = query.Get("sort")
+		sort.SliceStable(entries, func(i, j int) bool { return less(entries[i], entries[j]) })
+	}
+	if listing.Desc {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+	if listing.Grouped {
+		listing.Groups = wiki.groupByLetter(entries)
+		return listing
+	}
+	listing.Pages = max(1, (len(entries)+frontPageSize-1)/frontPageSize)
+	listing.Page, _ = strconv.Atoi(query.Get("page"))
+	listing.Page = min(max(listing.Page, 1), listing.Pages)
+	if listing.Page > 1 {
+		listing.Prev = listing.Page - 1
+	}
+	if listing.Page < listing.Pages {
+		listing.Next = listing.Page + 1
+	}
+	start := (listing.Page - 1) * frontPageSize
+	listing.Entries = entries[start:min(start+frontPageSize, len(entries))]
+	return listing
+}
+
+// groupByLetter groups the entries, sorted by their display titles, by the
+// first letter of those.
+func (wiki *Wiki) groupByLetter(entries []PageEntry) []PageGroup {
+	displays := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		displays[entry.Title] = wiki.displayTitle(entry.Title)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return strings.ToLower(displays[entries[i].Title]) < strings.ToLower(displays[entries[j].Title])
+	})
+	var groups []PageGroup
+	for _, entry := range entries {
+		first, _ := utf8.DecodeRuneInString(displays[entry.Title])
+		letter := "#"
+		if unicode.IsLetter(first) {
+			letter = string(unicode.ToUpper(first))
+		}
+		if len(groups) == 0 || groups[len(groups)-1].Letter != letter {
+			groups = append(groups, PageGroup{Letter: letter})
+		}
+		groups[len(groups)-1].Entries = append(groups[len(groups)-1].Entries, entry)
+	}
+	return groups
+}
+
+// SortURL links the index sorted by the column, in the other order when it is
+// sorted by it already.
+func (l PageListing) SortURL(column string) string {
+	query := url.Values{"sort": {column}}
+	if column == l.Sort && !l.Desc {
+		query.Set("order", "desc")
+	}
+	return "/?" + query.Encode()
+}
+
+// PageURL links the nth page of the index as it's sorted now.
+func (l PageListing) PageURL(n int) string {
+	query := url.Values{"sort": {l.Sort}, "page": {strconv.Itoa(n)}}
+	if l.Desc {
+		query.Set("order", "desc")
+	}
+	return "/?" + query.Encode()
+}
+
+// frontPageListing is the index of the pages the request's viewer is shown,
+// the user's own sandbox pages left out, as they're listed apart.
+func (wiki *Wiki) frontPageListing(r *http.Request) PageListing {
+	var listed []string
+	v, acl := wiki.viewerOf(r), wiki.currentACL()
+	for _, title := range wiki.titles.List() {
+		if !isSandbox(title) && v.listsWith(acl, title) {
+			listed = append(listed, title)
+		}
+	}
+	wiki.sortListing(listed)
+	return wiki.listPages(listed, r.URL.Query())
+}
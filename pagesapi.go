@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// PageResource is the JSON representation of a page in the pages API.
+type PageResource struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	HTML  string `json:"html,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// preferredType returns the first of offered that the Accept header of the
+// request allows, in the order the client lists them, or offered[0] when the
+// client accepts anything. It returns "" when nothing offered is acceptable.
+func preferredType(r *http.Request, offered ...string) string {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return offered[0]
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		for _, candidate := range offered {
+			major, _, _ := strings.Cut(candidate, "/")
+			if mediaType == candidate || mediaType == "*/*" || mediaType == major+"/*" {
+				return candidate
+			}
+		}
+	}
+	return ""
+}
+
+// pagesListHandler serves GET /api/v1/pages, the titles of every page listed for
+// the requester in listing order. With meta.{key}={value} parameters it lists
+// only the pages whose front matter has them, as matchesFrontMatter does:
+// ?meta.author=alice&meta.tags=draft.
+func (wiki *Wiki) pagesListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET to list the pages")
+		return
+	}
+	query := r.URL.Query()
+	filtered := false
+	for name := range query {
+		filtered = filtered || strings.HasPrefix(name, "meta.")
+	}
+	v, listed := wiki.viewerOf(r), []string{}
+	for _, title := range wiki.titles.List() {
+		if !v.lists(title) {
+			continue
+		}
+		if filtered {
+			page, err := wiki.load(title)
+			if err != nil || !matchesFrontMatter(page.Body, query) {
+				continue
+			}
+		}
+		listed = append(listed, title)
+	}
+	wiki.sortListing(listed)
+	writeJSON(w, http.StatusOK, map[string][]string{"titles": listed})
+}
+
+// pageResourceHandler serves /api/v1/pages/{title}: GET returns the page as JSON,
+// or its Markdown source or rendered HTML if the Accept header asks for
+// text/markdown or text/html. PUT creates or replaces the page from a JSON
+// {"body": ...} or a text/markdown or text/plain body, and DELETE removes it.
+// Writing needs the same login or admin token as the editor.
+func (wiki *Wiki) pageResourceHandler(w http.ResponseWriter, r *http.Request, title string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		page, err := wiki.load(title)
+		if err != nil {
+			writeJSONError(w, http.StatusNotFound, "there is no page "+title)
+			return
+		}
+		w.Header().Set("Vary", "Accept")
+		switch preferredType(r, "application/json", "text/markdown", "text/plain", "text/html") {
+		case "application/json":
+			writeJSON(w, http.StatusOK, PageResource{Title: title, Body: string(page.Body), HTML: string(wiki.rendererFor(title, false).Render(wiki.embedAttachments(title, page.Body)))})
+		case "text/markdown", "text/plain":
+			w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+			w.Write(page.Body)
+		case "text/html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(wiki.rendererFor(title, false).Render(wiki.embedAttachments(title, page.Body)))
+		default:
+			writeJSONError(w, http.StatusNotAcceptable, "pages are served as application/json, text/markdown or text/html")
+		}
+	case http.MethodPut:
+		if ok, reason := wiki.canEdit(r, title); !ok {
+			writeJSONError(w, http.StatusForbidden, reason)
+			return
+		}
+		body, err := wiki.readPageBody(w, r)
+		if err != nil {
+			writeJSONError(w, statusOf(err), err.Error())
+			return
+		}
+		if err := wiki.checkPageSize(title, int64(len(body))); err != nil {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		existed := wiki.titles.Has(title)
+		if err := wiki.savePage(&Page{Title: title, Body: []byte(body)}, wiki.requestAuthor(r)); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		status := http.StatusOK
+		if !existed {
+			status = http.StatusCreated
+			w.Header().Set("Location", "/api/v1/pages/"+title)
+		}
+		writeJSON(w, status, PageResource{Title: title, Body: body})
+	case http.MethodDelete:
+		if ok, reason := wiki.canEdit(r, title); !ok {
+			writeJSONError(w, http.StatusForbidden, reason)
+			return
+		}
+		if !wiki.titles.Has(title) {
+			writeJSONError(w, http.StatusNotFound, "there is no page "+title)
+			return
+		}
+		if err := wiki.deletePage(title, wiki.requestAuthor(r)); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET, PUT or DELETE")
+	}
+}
+
+// apiError is an error with the HTTP status it should be answered with.
+type apiError struct {
+	status  int
+	message string
+}
+
+func (err apiError) Error() string {
+	return err.message
+}
+
+func statusOf(err error) int {
+	if apiErr, ok := err.(apiError); ok {
+		return apiErr.status
+	}
+	return http.StatusInternalServerError
+}
+
+// readPageBody reads the new body of a page from a PUT request.
+func (wiki *Wiki) readPageBody(w http.ResponseWriter, r *http.Request) (string, error) {
+	reader := http.MaxBytesReader(w, r.Body, wiki.config.MaxPageBytes)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		var resource PageResource
+		if err := json.NewDecoder(reader).Decode(&resource); tooLarge(err) {
+			return "", apiError{http.StatusRequestEntityTooLarge, fmt.Sprintf("the page is more than the %d bytes a page may have", wiki.config.MaxPageBytes)}
+		} else if err != nil {
+			return "", apiError{http.StatusBadRequest, "invalid JSON body: " + err.Error()}
+		}
+		return resource.Body, nil
+	case "text/markdown", "text/plain":
+		body, err := io.ReadAll(reader)
+		if tooLarge(err) {
+			return "", apiError{http.StatusRequestEntityTooLarge, fmt.Sprintf("the page is more than the %d bytes a page may have", wiki.config.MaxPageBytes)}
+		}
+		if err != nil {
+			return "", apiError{http.StatusBadRequest, err.Error()}
+		}
+		return string(body), nil
+	}
+	return "", apiError{http.StatusUnsupportedMediaType, "send the page as application/json, text/markdown or text/plain"}
+}
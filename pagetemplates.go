@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Page templates scaffold new pages: every <name>.txt file of the pagetemplates
+// directory next to the html templates is offered on the edit page of a page that
+// doesn't exist yet, with {{Title}} and {{Date}} expanded.
+const pageTemplateDir = "pagetemplates"
+
+// pageTemplateNames returns the names of the page templates, sorted.
+func (wiki *Wiki) pageTemplateNames() []string {
+	entries, err := fs.ReadDir(templateFS(wiki.config.TemplateDir), pageTemplateDir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), ".txt"); ok && !entry.IsDir() && validName.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// expandPageTemplate returns the body of the named page template for a new page
+// with the display title.
+func (wiki *Wiki) expandPageTemplate(name, display string) ([]byte, error) {
+	if !validName.MatchString(name) {
+		return nil, os.ErrNotExist
+	}
+	body, err := fs.ReadFile(templateFS(wiki.config.TemplateDir), pageTemplateDir+"/"+name+".txt")
+	if err != nil {
+		return nil, err
+	}
+	placeholders := strings.NewReplacer("{{Title}}", display, "{{Date}}", clock.Now().Format("2006-01-02"))
+	return []byte(placeholders.Replace(string(body))), nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import "net/http"
+
+// previewHandler serves POST /preview/{title}: the "body" of the edit form
+// rendered as the page would be once saved, in the "format" it picked, as an HTML
+// fragment. Nothing is saved. Like editing, previewing needs a login.
+func (wiki *Wiki) previewHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if wiki.currentUser(r) == "" && !wiki.isAdmin(r) {
+		wiki.serveError(w, forbidden("log in to preview "+title))
+		return
+	}
+	format := r.FormValue("format")
+	if _, ok := wiki.pageFormats[format]; !ok {
+		format = wiki.formatOf(title)
+	}
+	rendered := wiki.formatRenderer(format, wiki.isSafeMode(r)).Render(wiki.embedAttachments(title, []byte(r.FormValue("body"))))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(rendered)
+}
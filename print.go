@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// pdfTimeout bounds how long -pdf-command may take over one page.
+const pdfTimeout = time.Minute
+
+// canExportPDF is the "canExportPDF" template function: whether -pdf-command
+// makes the PDFs of /export/{title}.pdf.
+func (wiki *Wiki) canExportPDF() bool {
+	return wiki.config.PDFCommand != ""
+}
+
+// printHandler serves /print/{title}: the page alone, without the wiki around it,
+// laid out for paper, for the browser to print or save as a PDF.
+func (wiki *Wiki) printHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	out, err := wiki.renderSnapshot(r, title, "print.html", false)
+	if errors.Is(err, fs.ErrNotExist) {
+		wiki.serveError(w, notFound(""))
+		return
+	}
+	if err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(out)
+}
+
+// pdfHandler serves GET /export/{title}.pdf: the print view of the page, its
+// images inlined, turned into a PDF by -pdf-command, which reads the HTML from
+// its standard input and writes the PDF to its standard output, like
+// "wkhtmltopdf --quiet - -".
+func (wiki *Wiki) pdfHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if !wiki.canExportPDF() {
+		http.Error(w, translate(wiki.requestLocale(w), "error.noPDF", title), http.StatusNotImplemented)
+		return
+	}
+	out, err := wiki.renderSnapshot(r, title, "print.html", true)
+	if errors.Is(err, fs.ErrNotExist) {
+		wiki.serveError(w, notFound(""))
+		return
+	}
+	if err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), pdfTimeout)
+	defer cancel()
+	pdf, err := wiki.convertToPDF(ctx, out)
+	if err != nil {
+		wiki.serveError(w, &HTTPError{Status: http.StatusInternalServerError, Message: "could not make the PDF", Cause: err})
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+strings.ReplaceAll(title, "/", "_")+`.pdf"`)
+	w.Write(pdf)
+}
+
+// convertToPDF runs -pdf-command over the HTML.
+func (wiki *Wiki) convertToPDF(ctx context.Context, page []byte) ([]byte, error) {
+	argv := strings.Fields(wiki.config.PDFCommand)
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(page), &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %v: %s", argv[0], err, strings.TrimSpace(stderr.String()))
+	}
+	if !bytes.HasPrefix(stdout.Bytes(), []byte("%PDF-")) {
+		return nil, fmt.Errorf("%s wrote no PDF", argv[0])
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// loadPrivateAccess reads the networks of allowIPs, addresses and CIDR prefixes
+// separated by commas, and the file of basic users, whose lines are name:password
+// or name:sha256:{hex digest of the password}, with # comments.
+func (wiki *Wiki) loadPrivateAccess(allowIPs, basicAuth, scope string) error {
+	wiki.privateAccess.networks, wiki.privateAccess.credentials = nil, nil
+	for _, network := range strings.Split(allowIPs, ",") {
+		if network = strings.TrimSpace(network); network == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(network)
+			if addrErr != nil {
+				return fmt.Errorf("%s of -allow-ips is neither an address nor a CIDR prefix", network)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		wiki.privateAccess.networks = append(wiki.privateAccess.networks, prefix.Masked())
+	}
+	if basicAuth != "" {
+		file, err := os.Open(basicAuth)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		wiki.privateAccess.credentials = make(map[string][]byte)
+		lines := bufio.NewScanner(file)
+		for number := 1; lines.Scan(); number++ {
+			line := strings.TrimSpace(lines.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			name, password, ok := strings.Cut(line, ":")
+			if !ok || name == "" {
+				return fmt.Errorf("line %d of %s isn't name:password", number, basicAuth)
+			}
+			if hexDigest, hashed := strings.CutPrefix(password, "sha256:"); hashed {
+				digest, err := hex.DecodeString(hexDigest)
+				if err != nil || len(digest) != sha256.Size {
+					return fmt.Errorf("line %d of %s has no SHA-256 digest after sha256:", number, basicAuth)
+				}
+				wiki.privateAccess.credentials[name] = digest
+				continue
+			}
+			digest := sha256.Sum256([]byte(password))
+			wiki.privateAccess.credentials[name] = digest[:]
+		}
+		if err := lines.Err(); err != nil {
+			return err
+		}
+	}
+	switch scope {
+	case "all", "":
+		wiki.privateAccess.writesOnly = false
+	case "writes":
+		wiki.privateAccess.writesOnly = true
+	default:
+		return fmt.Errorf("-private-scope is all or writes, not %s", scope)
+	}
+	return nil
+}
+
+// allowedClient tells whether the request comes from a network of -allow-ips.
+func (wiki *Wiki) allowedClient(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	for _, network := range wiki.privateAccess.networks {
+		if network.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// basicAuthorized tells whether the request has the credentials of a user of
+// -basic-auth.
+func (wiki *Wiki) basicAuthorized(r *http.Request) bool {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	// an unknown name has no digest, which no password's matches
+	digest := sha256.Sum256([]byte(password))
+	return subtle.ConstantTimeCompare(digest[:], wiki.privateAccess.credentials[name]) == 1
+}
+
+// restrictAccess answers the requests of a private wiki that don't come from
+// an allowed network 401, asking for the credentials of -basic-auth, or 403
+// when there are none to give. The health checks are answered to anybody.
+func (wiki *Wiki) restrictAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		guarded := len(wiki.privateAccess.networks) > 0 || wiki.privateAccess.credentials != nil
+		switch {
+		case !guarded, r.URL.Path == "/healthz", r.URL.Path == "/readyz":
+		case wiki.privateAccess.writesOnly && (r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions):
+		case wiki.allowedClient(r), wiki.privateAccess.credentials != nil && wiki.basicAuthorized(r):
+		case wiki.privateAccess.credentials != nil:
+			w.Header().Set("WWW-Authenticate", `Basic realm="gowiki", charset="UTF-8"`)
+			http.Error(w, translate(wiki.requestLocale(w), "error.private"), http.StatusUnauthorized)
+			return
+		default:
+			wiki.serveError(w, forbidden("the wiki is private, and your address isn't one that may reach it"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// discardResponse is the response the profiled pages are rendered to.
+type discardResponse struct {
+	header http.Header
+	status int
+}
+
+func (d *discardResponse) Header() http.Header            { return d.header }
+func (d *discardResponse) Write(data []byte) (int, error) { return len(data), nil }
+func (d *discardResponse) WriteHeader(status int)         { d.status = status }
+
+// renderProfile is how rendering the corpus did with one size of the title
+// registry: the fastest of the rounds, and the allocations of one of them, per
+// page.
+type renderProfile struct {
+	Titles      int
+	Body        time.Duration
+	Page        time.Duration
+	Allocs      uint64
+	AllocBytes  uint64
+	CorpusPages int
+}
+
+// generateCorpus writes n pages with the seed, titled prefix and their number:
+// paragraphs, headings and lists of words that mention the other pages of the
+// corpus a few times each, like a wiki that grew for a while.
+func generateCorpus(n int, seed int64, prefix string) []*Page {
+	rng := rand.New(rand.NewSource(seed))
+	words := strings.Fields("the a wiki page of and to in is that for it with as on was by this are be from at or an have not which but you they his")
+	pages := make([]*Page, n)
+	for idx := range pages {
+		pages[idx] = &Page{Title: prefix + strconv.Itoa(idx+1)}
+	}
+	for _, page := range pages {
+		var body strings.Builder
+		for paragraph := 0; paragraph < 3+rng.Intn(8); paragraph++ {
+			switch rng.Intn(4) {
+			case 0:
+				fmt.Fprintf(&body, "## %s %s\n\n", words[rng.Intn(len(words))], pages[rng.Intn(n)].Title)
+			case 1:
+				for item := 0; item < 2+rng.Intn(4); item++ {
+					fmt.Fprintf(&body, "- %s %s\n", words[rng.Intn(len(words))], pages[rng.Intn(n)].Title)
+				}
+				body.WriteString("\n")
+			}
+			for word := 0; word < 40+rng.Intn(80); word++ {
+				if rng.Intn(25) == 0 {
+					body.WriteString(pages[rng.Intn(n)].Title)
+				} else {
+					body.WriteString(words[rng.Intn(len(words))])
+				}
+				body.WriteString(" ")
+			}
+			body.WriteString("\n\n")
+		}
+		page.Body = []byte(body.String())
+	}
+	return pages
+}
+
+// registryOf returns a title registry of size titles: those of the corpus, and
+// made up ones after them that its pages never mention.
+func (wiki *Wiki) registryOf(corpus []*Page, size int) *TitleRegistry {
+	registry := NewTitleRegistry(wiki)
+	registered := make([]string, 0, size)
+	for _, page := range corpus {
+		if len(registered) < size {
+			registered = append(registered, page.Title)
+		}
+	}
+	for idx := 1; len(registered) < size; idx++ {
+		registered = append(registered, "Unmentioned"+strconv.Itoa(idx))
+	}
+	registry.Add(registered...)
+	return registry
+}
+
+// profileRender renders every page of the corpus rounds times with a registry
+// of size titles, its body alone and then the whole view page, labelling the
+// samples of a CPU profile with the size.
+func (wiki *Wiki) profileRender(corpus []*Page, size, rounds int) (renderProfile, error) {
+	previous := wiki.titles
+	wiki.titles = wiki.registryOf(corpus, size)
+	defer func() { wiki.titles = previous }()
+
+	profile := renderProfile{Titles: size, CorpusPages: len(corpus)}
+	var failed error
+	pprof.Do(context.Background(), pprof.Labels("titles", strconv.Itoa(size)), func(context.Context) {
+		for round := 0; round < rounds; round++ {
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			start := time.Now()
+			for _, page := range corpus {
+				wiki.rendererFor(page.Title, false).Render(page.Body)
+			}
+			body := time.Since(start)
+			start = time.Now()
+			for _, page := range corpus {
+				response := &discardResponse{header: make(http.Header), status: http.StatusOK}
+				wiki.renderViewTemplate(response, wiki.everyone, "view.html", page, nil, false, false)
+				if response.status != http.StatusOK && failed == nil {
+					failed = fmt.Errorf("could not render %s, status %d", page.Title, response.status)
+				}
+			}
+			rendered := time.Since(start)
+			runtime.ReadMemStats(&after)
+			if round == 0 || body < profile.Body {
+				profile.Body = body
+			}
+			if round == 0 || rendered < profile.Page {
+				profile.Page = rendered
+			}
+			profile.Allocs, profile.AllocBytes = after.Mallocs-before.Mallocs, after.TotalAlloc-before.TotalAlloc
+		}
+	})
+	return profile, failed
+}
+
+// parseSizes reads a list of registry sizes separated by commas.
+func parseSizes(list string) ([]int, error) {
+	var sizes []int
+	for _, field := range strings.Split(list, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("%q is not a number of titles", field)
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes, nil
+}
+
+// runProfileRender is the profile-render command: it renders a corpus, the pages
+// of the wiki or generated ones, with title registries of every size, prints how
+// long a page took and what it allocated, and writes CPU and allocation profiles
+// to compare a change to the linker or the templates against.
+func (wiki *Wiki) runProfileRender(args []string) error {
+	flags := flag.NewFlagSet("profile-render", flag.ContinueOnError)
+	generate := flags.Int("pages", 0, "render this many generated pages instead of those of the wiki")
+	seed := flags.Int64("seed", 1, "the seed of the generated pages")
+	sizeList := flags.String("titles", "100,1000,10000", "the sizes of the title registry to render with, separated by commas")
+	rounds := flags.Int("rounds", 3, "how many times to render the corpus with each size, the fastest counts")
+	cpuProfile := flags.String("cpuprofile", "", "write a CPU profile to this file, its samples labelled titles=size")
+	memProfile := flags.String("memprofile", "", "write an allocation profile to this file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	sizes, err := parseSizes(*sizeList)
+	if err != nil {
+		return err
+	}
+	if *rounds < 1 {
+		return fmt.Errorf("-rounds is at least 1, not %d", *rounds)
+	}
+	var corpus []*Page
+	if *generate > 0 {
+		corpus = generateCorpus(*generate, *seed, "Page")
+	} else {
+		for _, title := range wiki.titles.List() {
+			if page, err := wiki.peek(title); err == nil {
+				corpus = append(corpus, page)
+			}
+		}
+	}
+	if len(corpus) == 0 {
+		return fmt.Errorf("the wiki has no pages, generate some with -pages")
+	}
+	if *cpuProfile != "" {
+		file, err := os.Create(*cpuProfile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if err := pprof.StartCPUProfile(file); err != nil {
+			return err
+		}
+		defer pprof.StopCPUProfile()
+	}
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(out, "titles\tpages\tbody/page\tview/page\tallocs/page\tbytes/page\t")
+	for _, size := range sizes {
+		profile, err := wiki.profileRender(corpus, size, *rounds)
+		if err != nil {
+			return err
+		}
+		pages := uint64(profile.CorpusPages)
+		fmt.Fprintf(out, "%d\t%d\t%v\t%v\t%d\t%d\t\n", profile.Titles, profile.CorpusPages,
+			profile.Body/time.Duration(pages), profile.Page/time.Duration(pages), profile.Allocs/pages, profile.AllocBytes/pages)
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+
+	if *memProfile != "" {
+		file, err := os.Create(*memProfile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if err := pprof.Lookup("allocs").WriteTo(file, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
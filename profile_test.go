@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// BenchmarkProfileRender renders the corpus as the profile-render command does,
+// its bodies and then its view pages, with title registries of every size, and
+// reports what a page took of both: -cpuprofile and -memprofile of go test then
+// profile the linker and the templates like the command's own flags.
+func BenchmarkProfileRender(b *testing.B) {
+	for _, size := range []int{100, 1000, 10000} {
+		b.Run("titles="+strconv.Itoa(size), func(b *testing.B) {
+			var body, page time.Duration
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				profile, err := testWiki.profileRender(benchmarkCorpus, size, 1)
+				if err != nil {
+					b.Fatal(err)
+				}
+				body, page = body+profile.Body, page+profile.Page
+			}
+			pages := float64(b.N * len(benchmarkCorpus))
+			b.ReportMetric(float64(body.Nanoseconds())/pages, "body-ns/page")
+			b.ReportMetric(float64(page.Nanoseconds())/pages, "view-ns/page")
+		})
+	}
+}
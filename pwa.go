@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// WebAppManifest is the web app manifest of the wiki, which browsers install it
+// by as an app of its own.
+type WebAppManifest struct {
+	Name            string       `json:"name"`
+	ShortName       string       `json:"short_name"`
+	StartURL        string       `json:"start_url"`
+	Scope           string       `json:"scope"`
+	Display         string       `json:"display"`
+	BackgroundColor string       `json:"background_color"`
+	ThemeColor      string       `json:"theme_color"`
+	Icons           []WebAppIcon `json:"icons"`
+}
+
+// WebAppIcon is an icon of the web app manifest.
+type WebAppIcon struct {
+	Src   string `json:"src"`
+	Sizes string `json:"sizes"`
+	Type  string `json:"type"`
+}
+
+// manifestHandler serves /manifest.webmanifest, named in the visitor's language.
+func (wiki *Wiki) manifestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	locale := wiki.requestLocale(w)
+	// the name follows the visitor's language
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("Content-Type", "application/manifest+json")
+	json.NewEncoder(w).Encode(WebAppManifest{Name: translate(locale, "app.name"), ShortName: translate(locale, "app.shortName"),
+		StartURL: wiki.wikiPath("/"), Scope: wiki.wikiPath("/"), Display: "standalone", BackgroundColor: "#ffffff", ThemeColor: "#00add8",
+		Icons: []WebAppIcon{{Src: wiki.wikiPath("/static/icon.svg"), Sizes: "any", Type: "image/svg+xml"}}})
+}
+
+// serviceWorkerHandler serves /sw.js, the service worker of static/sw.js, from
+// the root of the wiki for it to see every page, after the BASE it finds the
+// wiki's paths under. Browsers check it for a new version as they load the
+// pages, so it isn't cached.
+func (wiki *Wiki) serviceWorkerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	script, err := staticFiles.ReadFile("static/sw.js")
+	if err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	base, _ := json.Marshal(wiki.config.BasePath)
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprintf(w, "const BASE = %s;\n", base)
+	w.Write(script)
+}
+
+// offlineHandler serves /offline, which the service worker shows for the pages
+// it doesn't have when the wiki can't be reached, listing those it has.
+func (wiki *Wiki) offlineHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	wiki.renderTemplate(w, "offline.html", nil)
+}
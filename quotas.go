@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// formOverheadBytes is what the edit form sends besides the body: the display
+// title, the tags, the tokens of the form and their encoding.
+const formOverheadBytes = 64 << 10
+
+// largestPagesShown is how many of the pages taking the most bytes the storage
+// report lists.
+const largestPagesShown = 20
+
+// quotaError is a write that would take the page or the wiki past one of its
+// bounds, answered 413 Request Entity Too Large.
+type quotaError struct {
+	message string
+}
+
+func (err *quotaError) Error() string {
+	return err.message
+}
+
+// tooLarge tells whether err is a body read past the bound of a MaxBytesReader.
+func tooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
+}
+
+func (s Settings) maxPageBytes(wikiMax int64) int64 {
+	if s.MaxPageBytes > 0 {
+		return min(s.MaxPageBytes, wikiMax)
+	}
+	return wikiMax
+}
+
+// limitBodies answers the saves of the edit form with a body past -max-page-bytes
+// 413 before anything reads them, the CSRF check included, so one giant paste
+// neither fills the memory nor the disk.
+func (wiki *Wiki) limitBodies(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, "/save/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		limit := wiki.config.MaxPageBytes + formOverheadBytes
+		if r.ContentLength > limit {
+			http.Error(w, translate(wiki.requestLocale(w), "error.pageTooLarge", wiki.config.MaxPageBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" {
+			if err := r.ParseForm(); tooLarge(err) {
+				http.Error(w, translate(wiki.requestLocale(w), "error.pageTooLarge", wiki.config.MaxPageBytes), http.StatusRequestEntityTooLarge)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// storageUsage returns the bytes the bodies of the pages take, and those the
+// attachments do, each content stored once.
+func (wiki *Wiki) storageUsage() (pages, attached int64) {
+	wiki.pageIndex.mu.RLock()
+	for _, entry := range wiki.pageIndex.byTitle {
+		pages += int64(entry.Size)
+	}
+	wiki.pageIndex.mu.RUnlock()
+	wiki.attachments.Lock()
+	for _, stored := range wiki.attachments.Blobs {
+		attached += stored.Size
+	}
+	wiki.attachments.Unlock()
+	return pages, attached
+}
+
+// pageUsage returns the bytes the body of the page takes, and those its
+// attachments do, but for the one named except.
+func (wiki *Wiki) pageUsage(title, except string) (body, attached int64) {
+	wiki.pageIndex.mu.RLock()
+	body = int64(wiki.pageIndex.byTitle[title].Size)
+	wiki.pageIndex.mu.RUnlock()
+	wiki.attachments.Lock()
+	for name, hash := range wiki.attachments.Pages[title] {
+		if stored := wiki.attachments.Blobs[hash]; stored != nil && name != except {
+			attached += stored.Size
+		}
+	}
+	wiki.attachments.Unlock()
+	return body, attached
+}
+
+// checkPageSize checks that the page may be saved with a body of size bytes: no
+// larger than its settings allow, within the quota of the page with its
+// attachments, and the wiki's -storage-quota.
+func (wiki *Wiki) checkPageSize(title string, size int64) error {
+	settings := wiki.settingsFor(title)
+	if limit := settings.maxPageBytes(wiki.config.MaxPageBytes); size > limit {
+		return &quotaError{fmt.Sprintf("the page is %d bytes, more than the %d bytes pages of %s may have", size, limit, title)}
+	}
+	body, attached := wiki.pageUsage(title, "")
+	if quota := settings.PageQuotaBytes; quota > 0 && size+attached > quota {
+		return &quotaError{fmt.Sprintf("the page and its attachments would take %d bytes, more than the %d bytes of its quota", size+attached, quota)}
+	}
+	if quota := wiki.config.StorageQuota; quota > 0 && size > body {
+		if pages, attachedAll := wiki.storageUsage(); pages+attachedAll-body+size > quota {
+			return &quotaError{fmt.Sprintf("the wiki would take %d bytes, more than the %d bytes of its quota", pages+attachedAll-body+size, quota)}
+		}
+	}
+	return nil
+}
+
+// checkAttachmentQuota checks that the page may have an attachment of size
+// bytes called name, replacing the one it has of that name.
+func (wiki *Wiki) checkAttachmentQuota(title, name string, size int64) error {
+	body, attached := wiki.pageUsage(title, name)
+	if quota := wiki.settingsFor(title).PageQuotaBytes; quota > 0 && body+attached+size > quota {
+		return &quotaError{fmt.Sprintf("the page and its attachments would take %d bytes, more than the %d bytes of its quota", body+attached+size, quota)}
+	}
+	if quota := wiki.config.StorageQuota; quota > 0 {
+		if pages, attachedAll := wiki.storageUsage(); pages+attachedAll+size > quota {
+			return &quotaError{fmt.Sprintf("the wiki would take %d bytes, more than the %d bytes of its quota", pages+attachedAll+size, quota)}
+		}
+	}
+	return nil
+}
+
+// StorageReport is how many bytes the wiki takes on disk, for admins.
+type StorageReport struct {
+	Pages         int
+	PageBytes     int64
+	AttachedBytes int64
+	HistoryBytes  int64
+	// Total is what counts for the Quota, the pages and the attachments;
+	// Used is how much of the quota, in percent, when there's one.
+	Total int64
+	Quota int64
+	Used  int
+	// Largest are the pages taking the most bytes with their attachments.
+	Largest []PageStorage
+}
+
+// PageStorage is how many bytes a page takes, and its attachments.
+type PageStorage struct {
+	Title         string
+	Bytes         int64
+	AttachedBytes int64
+	Quota         int64
+}
+
+// storageReportHandler serves /reports/storage to admins.
+func (wiki *Wiki) storageReportHandler(w http.ResponseWriter, r *http.Request) {
+	if !wiki.isAdmin(r) {
+		wiki.serveError(w, forbidden("only admins can see the storage of the wiki"))
+		return
+	}
+	report := StorageReport{Quota: wiki.config.StorageQuota}
+	report.PageBytes, report.AttachedBytes = wiki.storageUsage()
+	report.Total = report.PageBytes + report.AttachedBytes
+	if report.Quota > 0 {
+		report.Used = int(report.Total * 100 / report.Quota)
+	}
+	filepath.WalkDir(wiki.dataPath("history"), func(_ string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			if info, err := entry.Info(); err == nil {
+				report.HistoryBytes += info.Size()
+			}
+		}
+		return nil
+	})
+	for _, title := range wiki.titles.List() {
+		body, attached := wiki.pageUsage(title, "")
+		report.Pages++
+		report.Largest = append(report.Largest, PageStorage{title, body, attached, wiki.settingsFor(title).PageQuotaBytes})
+	}
+	sort.Slice(report.Largest, func(i, j int) bool {
+		return report.Largest[i].Bytes+report.Largest[i].AttachedBytes > report.Largest[j].Bytes+report.Largest[j].AttachedBytes
+	})
+	if len(report.Largest) > largestPagesShown {
+		report.Largest = report.Largest[:largestPagesShown]
+	}
+	wiki.renderTemplate(w, "storage.html", report)
+}
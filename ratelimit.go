@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// writeBucket is the token bucket of a client: it holds up to config.WriteBurst
+// writes, and refills at config.WriteRate a minute.
+type writeBucket struct {
+	tokens float64
+	filled time.Time
+}
+
+// isRateLimited reports whether the request is a write the rate limit applies to:
+// a save, an API request that changes something, or a login, whose password is
+// hashed with hundreds of thousands of PBKDF2 iterations to be checked.
+func isRateLimited(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return strings.HasPrefix(r.URL.Path, "/save/") || strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/login"
+}
+
+// takeWrite takes a token from the client's bucket at now. When it's empty, it
+// returns how long the client waits for the next one.
+func (wiki *Wiki) takeWrite(client string, now time.Time) (bool, time.Duration) {
+	perSecond := wiki.config.WriteRate / 60
+	burst := float64(max(wiki.config.WriteBurst, 1))
+	wiki.writeBuckets.Lock()
+	defer wiki.writeBuckets.Unlock()
+	for other, bucket := range wiki.writeBuckets.byClient {
+		if bucket.tokens+now.Sub(bucket.filled).Seconds()*perSecond >= burst {
+			delete(wiki.writeBuckets.byClient, other)
+		}
+	}
+	bucket, ok := wiki.writeBuckets.byClient[client]
+	if !ok {
+		bucket = &writeBucket{tokens: burst, filled: now}
+		wiki.writeBuckets.byClient[client] = bucket
+	}
+	bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.filled).Seconds()*perSecond)
+	bucket.filled = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / perSecond * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// RateLimited is the data of the page of a client over the rate limit.
+type RateLimited struct {
+	RetryAfter int
+}
+
+// limitWrites answers the saves, API writes and logins of a client over the rate
+// limit 429 Too Many Requests, with a Retry-After, so a misbehaving client can't
+// hammer the disk, spam pages or guess passwords at the cost of the CPU, whatever
+// the -limit on writes in flight at once. The clients are told apart by their
+// address. A -write-rate of 0 lifts the limit.
+func (wiki *Wiki) limitWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wiki.config.WriteRate <= 0 || !isRateLimited(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		allowed, wait := wiki.takeWrite(client, clock.Now())
+		if allowed {
+			next.ServeHTTP(w, r)
+			return
+		}
+		retryAfter := max(1, int(math.Ceil(wait.Seconds())))
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			writeJSONError(w, http.StatusTooManyRequests, "too many writes, try again in "+strconv.Itoa(retryAfter)+"s")
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusTooManyRequests)
+		wiki.renderTemplate(w, "rateLimited.html", RateLimited{RetryAfter: retryAfter})
+	})
+}
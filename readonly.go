@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// isReadOnly is the "readOnly" template function.
+func (wiki *Wiki) isReadOnly() bool {
+	return wiki.readOnly.Load()
+}
+
+// ReadOnlyPage is the data of the read-only template: the page the visitor
+// tried to change, or the toggle of the admins.
+type ReadOnlyPage struct {
+	Title    string
+	Admin    bool
+	ReadOnly bool
+}
+
+// renderReadOnly refuses to change the page with 403 and a page saying the wiki
+// is read-only.
+func (wiki *Wiki) renderReadOnly(w http.ResponseWriter, title string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	wiki.renderTemplate(w, "readOnly.html", ReadOnlyPage{Title: title, ReadOnly: true})
+}
+
+// readOnlyHandler serves /admin/read-only to admins: whether the wiki is
+// read-only, and POST readOnly=true or false to turn it on or off.
+func (wiki *Wiki) readOnlyHandler(w http.ResponseWriter, r *http.Request) {
+	if !wiki.isAdmin(r) {
+		wiki.serveError(w, forbidden("only admins can make the wiki read-only"))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		on, err := strconv.ParseBool(r.FormValue("readOnly"))
+		if err != nil {
+			http.Error(w, translate(wiki.requestLocale(w), "error.readOnlyValue"), http.StatusBadRequest)
+			return
+		}
+		wiki.readOnly.Store(on)
+	default:
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	wiki.renderTemplate(w, "readOnly.html", ReadOnlyPage{Admin: true, ReadOnly: wiki.readOnly.Load()})
+}
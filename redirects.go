@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// maxRedirectHops bounds how far a chain of redirects is followed.
+const maxRedirectHops = 10
+
+// redirectLine is the first line of a redirect page: "#REDIRECT Title", where the
+// title may be a display title.
+var redirectLine = regexp.MustCompile(`^#REDIRECT\s+\[*([^\]]+?)\]*\s*$`)
+
+// redirectTarget returns the title a redirect page points to, or "" if the page
+// isn't a redirect.
+func (wiki *Wiki) redirectTarget(p *Page) string {
+	firstLine, _, _ := strings.Cut(strings.TrimSpace(string(p.Body)), "\n")
+	if match := redirectLine.FindStringSubmatch(strings.TrimSpace(firstLine)); match != nil {
+		return wiki.linkTarget(match[1])
+	}
+	return ""
+}
+
+// followRedirects follows the redirect of the page of the title to target, and
+// on through the redirects it leads to, returning the page it ends at and the
+// pages it went through, target first. It loops when it comes back to a page it
+// went through, or takes more than maxRedirectHops. A target that doesn't exist
+// ends it, for the reader to create the page.
+func (wiki *Wiki) followRedirects(title, target string) (string, []string, bool) {
+	seen := map[string]bool{wiki.titles.Canonical(title): true}
+	var chain []string
+	for hop := 0; ; hop++ {
+		target = wiki.titles.Canonical(target)
+		chain = append(chain, target)
+		if seen[target] || hop == maxRedirectHops {
+			return target, chain, true
+		}
+		seen[target] = true
+		page, err := wiki.load(target)
+		if err != nil {
+			return target, chain, false
+		}
+		next := wiki.redirectTarget(page)
+		if next == "" {
+			return target, chain, false
+		}
+		target = next
+	}
+}
+
+// RedirectProblem is a redirect that's broken (its target doesn't exist) or
+// double (its target is a redirect too). Chain lists the pages it goes through.
+type RedirectProblem struct {
+	Title  string
+	Chain  []string
+	Broken bool
+	Loop   bool
+}
+
+// RedirectReport is the data of the redirects template.
+type RedirectReport struct {
+	Broken []RedirectProblem
+	Double []RedirectProblem
+	Fixed  int
+}
+
+// findRedirects returns the target of every redirect page.
+func (wiki *Wiki) findRedirects() map[string]string {
+	redirects := make(map[string]string)
+	for _, title := range wiki.titles.List() {
+		page, err := wiki.load(title)
+		if err != nil {
+			continue
+		}
+		if target := wiki.redirectTarget(page); target != "" {
+			redirects[title] = target
+		}
+	}
+	return redirects
+}
+
+// redirectReport follows every redirect to the end of its chain.
+func (wiki *Wiki) redirectReport() RedirectReport {
+	redirects := wiki.findRedirects()
+	var report RedirectReport
+	for title, target := range redirects {
+		problem := RedirectProblem{Title: title, Chain: []string{target}}
+		seen := map[string]bool{title: true}
+		for hop := 0; ; hop++ {
+			next, isRedirect := redirects[target]
+			if !isRedirect {
+				break
+			}
+			if seen[target] || hop == maxRedirectHops {
+				problem.Loop = true
+				break
+			}
+			seen[target] = true
+			target = next
+			problem.Chain = append(problem.Chain, target)
+		}
+		problem.Broken = !problem.Loop && !wiki.titles.Has(target)
+		switch {
+		case problem.Broken || problem.Loop:
+			report.Broken = append(report.Broken, problem)
+		case len(problem.Chain) > 1:
+			report.Double = append(report.Double, problem)
+		}
+	}
+	for _, problems := range [][]RedirectProblem{report.Broken, report.Double} {
+		sort.Slice(problems, func(i, j int) bool { return problems[i].Title < problems[j].Title })
+	}
+	return report
+}
+
+// fixDoubleRedirects points every double redirect straight at the end of its
+// chain, recording each change as a revision by author.
+func (wiki *Wiki) fixDoubleRedirects(author string) (int, error) {
+	report := wiki.redirectReport()
+	for _, problem := range report.Double {
+		final := problem.Chain[len(problem.Chain)-1]
+		page, err := wiki.load(problem.Title)
+		if err != nil {
+			return 0, err
+		}
+		_, rest, _ := strings.Cut(string(page.Body), "\n")
+		page.Body = []byte(strings.TrimRight("#REDIRECT "+final+"\n"+rest, "\n") + "\n")
+		if err := wiki.recordBaseline(page.Title); err != nil {
+			return 0, err
+		}
+		comment := fmt.Sprintf("point the redirect straight at %s", final)
+		if err := wiki.storePage(page, author, comment); err != nil {
+			return 0, err
+		}
+		revision, err := wiki.recordRevision(page, author, comment)
+		if err != nil {
+			return 0, err
+		}
+		if err := wiki.journalRevision("save", page.Title, revision); err != nil {
+			return 0, err
+		}
+	}
+	return len(report.Double), nil
+}
+
+// redirectsReportHandler serves /reports/redirects with the broken and double
+// redirects. Admins POST to it to point the double redirects at their final
+// targets; broken redirects need a person to decide where they should go.
+func (wiki *Wiki) redirectsReportHandler(w http.ResponseWriter, r *http.Request) {
+	var fixed int
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if !wiki.isAdmin(r) {
+			wiki.serveError(w, forbidden("only admins can fix redirects"))
+			return
+		}
+		var err error
+		if fixed, err = wiki.fixDoubleRedirects(wiki.requestAuthor(r)); err != nil {
+			wiki.serveError(w, err)
+			return
+		}
+	default:
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	report := wiki.redirectReport()
+	report.Fixed = fixed
+	wiki.renderTemplate(w, "redirects.html", report)
+}
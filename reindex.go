@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// ReindexReport is what a reindex did: the pages it indexed, and what it found
+// out of step with the store, which it fixed.
+type ReindexReport struct {
+	Indexed  int
+	Problems []Problem
+}
+
+// reindex builds the title registry, the search, page and link indexes and the
+// tag index again from the store and the metadata files, which can drift from
+// what's in memory when the data directory is changed outside the wiki. Files
+// whose names aren't titles the wiki can serve are left out, and reported, as
+// is every index entry of a page that has no file.
+func (wiki *Wiki) reindex() (ReindexReport, error) {
+	var report ReindexReport
+	if !wiki.reindexing.CompareAndSwap(false, true) {
+		return report, conflict("the wiki is being reindexed already")
+	}
+	defer wiki.reindexing.Store(false)
+	stored, err := wiki.store.List()
+	if err != nil {
+		return report, err
+	}
+	found := func(kind, subject, detail string) {
+		report.Problems = append(report.Problems, Problem{Kind: kind, Subject: subject, Detail: detail})
+	}
+
+	pages := make(map[string]bool, len(stored))
+	for _, title := range stored {
+		if !validTitle.MatchString(title) || isReserved(title) {
+			found("file", title, "the name isn't a title the wiki can serve, rename the file")
+			continue
+		}
+		pages[title] = true
+	}
+	for _, title := range wiki.titles.List() {
+		if !pages[title] {
+			found("title registry", title, "has no file")
+			wiki.titles.Remove(title)
+		}
+	}
+	for title := range pages {
+		if !wiki.titles.Has(title) {
+			found("title registry", title, "was missing")
+			wiki.titles.Add(title)
+		}
+	}
+
+	wiki.searchIndex.mu.RLock()
+	var searched []string
+	for title := range wiki.searchIndex.words {
+		searched = append(searched, title)
+	}
+	wiki.searchIndex.mu.RUnlock()
+	for _, title := range searched {
+		if !pages[title] {
+			found("search index", title, "has no file")
+			wiki.searchIndex.remove(title)
+		}
+	}
+	wiki.backlinks.mu.RLock()
+	var linking []string
+	for title := range wiki.backlinks.outgoing {
+		linking = append(linking, title)
+	}
+	wiki.backlinks.mu.RUnlock()
+	for _, title := range linking {
+		if !pages[title] {
+			found("link index", title, "has no file")
+			wiki.backlinks.remove(title)
+		}
+	}
+	for _, entry := range wiki.pageIndex.all() {
+		if !pages[entry.Title] {
+			found("page index", entry.Title, "has no file")
+			wiki.pageIndex.remove(entry.Title)
+		}
+	}
+
+	// the registry is right now, the links are found with the titles it has
+	for _, title := range wiki.titles.List() {
+		if wiki.pageCache != nil {
+			wiki.pageCache.forget(title)
+		}
+		page, err := wiki.peek(title)
+		if err != nil {
+			found("page", title, "can't be read: "+err.Error())
+			wiki.searchIndex.remove(title)
+			wiki.pageIndex.remove(title)
+			wiki.backlinks.remove(title)
+			continue
+		}
+		wiki.searchIndex.update(page)
+		wiki.pageIndex.indexEntry(page)
+		wiki.backlinks.update(page)
+		report.Indexed++
+	}
+	wiki.invalidateSearchCache()
+
+	err = wiki.reindexTags(found)
+	sort.SliceStable(report.Problems, func(i, j int) bool {
+		if report.Problems[i].Kind != report.Problems[j].Kind {
+			return report.Problems[i].Kind < report.Problems[j].Kind
+		}
+		return report.Problems[i].Subject < report.Problems[j].Subject
+	})
+	return report, err
+}
+
+// reindexTags reads the metadata files again, reporting the tags of the index
+// they don't give the page and those they give it the index didn't have.
+func (wiki *Wiki) reindexTags(found func(kind, subject, detail string)) error {
+	wiki.pageMetadata.Lock()
+	defer wiki.pageMetadata.Unlock()
+	indexed := wiki.pageMetadata.byTag
+	wiki.pageMetadata.byTitle, wiki.pageMetadata.byTag = make(map[string]PageMetadata), make(map[string]map[string]bool)
+	if err := wiki.loadMetadata(); err != nil {
+		return fmt.Errorf("could not read the page metadata: %w", err)
+	}
+	var tags []string
+	for tag := range indexed {
+		tags = append(tags, tag)
+	}
+	for tag := range wiki.pageMetadata.byTag {
+		if indexed[tag] == nil {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		for title := range indexed[tag] {
+			if !wiki.pageMetadata.byTag[tag][title] {
+				found("tag index", title, "is tagged "+tag+" in the index but not in its metadata")
+			}
+		}
+		for title := range wiki.pageMetadata.byTag[tag] {
+			if !indexed[tag][title] {
+				found("tag index", title, "is tagged "+tag+" in its metadata but wasn't in the index")
+			}
+		}
+	}
+	return nil
+}
+
+// ReindexPage is the data of the reindex template, with the report of the
+// reindex once it ran.
+type ReindexPage struct {
+	Report *ReindexReport
+}
+
+// reindexHandler serves /admin/reindex to admins, which POST to reindex the wiki
+// and are shown what it found.
+func (wiki *Wiki) reindexHandler(w http.ResponseWriter, r *http.Request) {
+	if !wiki.isAdmin(r) {
+		wiki.serveError(w, forbidden("only admins can reindex the wiki"))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		wiki.renderTemplate(w, "reindex.html", ReindexPage{})
+	case http.MethodPost:
+		report, err := wiki.reindex()
+		if err != nil {
+			wiki.serveError(w, err)
+			return
+		}
+		wiki.renderTemplate(w, "reindex.html", ReindexPage{Report: &report})
+	default:
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Rename moves the page From to the title To, with its history, metadata,
+// attachments and comments. With RewriteLinks the links of other pages to From
+// are pointed at To, and with Redirect From is left as a redirect to To;
+// otherwise it's gone.
+type Rename struct {
+	From         string
+	To           string
+	DisplayTitle string
+	RewriteLinks bool
+	Redirect     bool
+}
+
+// renameJournal is a rename in progress: every step pushes how to undo it, so a
+// failing step rolls the rename back to where it started. A step can fail half
+// done, so its undo is pushed before it's run and has to cope with either.
+type renameJournal struct {
+	wiki *Wiki
+	undo []func() error
+}
+
+func (journal *renameJournal) do(step, undo func() error) error {
+	journal.undo = append(journal.undo, undo)
+	return step()
+}
+
+// rollback undoes the steps made, newest first. It carries on past a failing
+// undo, to leave as little behind as it can, and returns the errors.
+func (journal *renameJournal) rollback() error {
+	var errs []error
+	for idx := len(journal.undo) - 1; idx >= 0; idx-- {
+		errs = append(errs, journal.undo[idx]())
+	}
+	return errors.Join(errs...)
+}
+
+// write stores the page as a revision by author, and pushes the undo that puts
+// the previous body back, or removes the page if it's new, and cuts its history
+// back to what it was. A page without a history gets one starting with its
+// previous body when baseline is set.
+func (journal *renameJournal) write(p *Page, author, comment string, baseline bool) error {
+	previous, err := journal.wiki.load(p.Title)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	restoreHistory, err := journal.wiki.historyCheckpoint(p.Title)
+	if err != nil {
+		return err
+	}
+	return journal.do(func() error {
+		if baseline {
+			if err := journal.wiki.recordBaseline(p.Title); err != nil {
+				return err
+			}
+		}
+		if err := journal.wiki.storePage(p, author, comment); err != nil {
+			return err
+		}
+		if _, err := journal.wiki.recordRevision(p, author, comment); err != nil {
+			return err
+		}
+		if previous == nil {
+			journal.wiki.titles.Add(p.Title)
+			journal.wiki.backlinks.relink(p.Title)
+		}
+		return nil
+	}, func() error {
+		if previous == nil {
+			if err := journal.wiki.store.Delete(p.Title); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			journal.wiki.titles.Remove(p.Title)
+			journal.wiki.afterDelete(p.Title, author)
+		} else if current, err := journal.wiki.load(p.Title); err != nil || !bytes.Equal(current.Body, previous.Body) {
+			if err := journal.wiki.storePage(previous, "", ""); err != nil {
+				return err
+			}
+		}
+		return restoreHistory()
+	})
+}
+
+// historyCheckpoint returns a function that cuts the page's history back to the
+// revisions it has now.
+func (wiki *Wiki) historyCheckpoint(title string) (func() error, error) {
+	filename := wiki.historyFilename(title)
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return func() error {
+			if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() error { return os.Truncate(filename, info.Size()) }, nil
+}
+
+// moveHistory renames the history file of a page, keeping its revisions.
+func (wiki *Wiki) moveHistory(from, to string) error {
+	if _, err := os.Stat(wiki.historyFilename(from)); os.IsNotExist(err) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(wiki.historyFilename(to)), 0700); err != nil {
+		return err
+	}
+	return os.Rename(wiki.historyFilename(from), wiki.historyFilename(to))
+}
+
+// rewriteLinks points the links of body to the page from at to: the [[from]],
+// [[from#Heading]] and [[from|text]] wiki links, Markdown links to /view/from, and a #REDIRECT to
+// it. Links by the display title keep working as they are, the display title
+// moves with the page.
+func (wiki *Wiki) rewriteLinks(body, from, to string) string {
+	display := wiki.metadataOf(from).DisplayTitle
+	body = wikiLink.ReplaceAllStringFunc(body, func(link string) string {
+		match := wikiLink.FindStringSubmatch(link)
+		label := strings.TrimSpace(match[1])
+		if (display != "" && label == display) || wiki.linkTarget(label) != from {
+			return link
+		}
+		rewritten := "[[" + to
+		if match[2] != "" {
+			rewritten += "#" + match[2]
+		}
+		if match[3] != "" {
+			rewritten += "|" + match[3]
+		}
+		return rewritten + "]]"
+	})
+	viewLink := regexp.MustCompile(`\(/view/` + regexp.QuoteMeta(from) + `([#)?])`)
+	body = viewLink.ReplaceAllString(body, "(/view/"+to+"$1")
+	if wiki.redirectTarget(&Page{Body: []byte(body)}) == from {
+		_, rest, _ := strings.Cut(body, "\n")
+		body = "#REDIRECT " + to + "\n" + rest
+	}
+	return body
+}
+
+// LinkRewrite is a page whose links rename rewrites.
+type LinkRewrite struct {
+	Title string
+	body  string
+}
+
+// planRename checks the rename and returns the pages whose links it rewrites.
+func (wiki *Wiki) planRename(rename Rename) ([]LinkRewrite, error) {
+	if !wiki.titles.Has(rename.From) {
+		return nil, fmt.Errorf("there's no page %s", rename.From)
+	}
+	if !validTitle.MatchString(rename.To) {
+		return nil, fmt.Errorf("%s is not a title, use letters, digits and hyphens", rename.To)
+	}
+	if isReserved(rename.To) {
+		return nil, reservedError(rename.To)
+	}
+	if rename.To == rename.From {
+		return nil, errors.New("the page is called " + rename.To + " already")
+	}
+	if wiki.titles.Has(rename.To) {
+		return nil, fmt.Errorf("there's a page %s already", rename.To)
+	}
+	if !rename.RewriteLinks {
+		return nil, nil
+	}
+	var rewrites []LinkRewrite
+	for _, title := range wiki.backlinksOf(rename.From) {
+		if title == rename.From {
+			continue
+		}
+		page, err := wiki.load(title)
+		if err != nil {
+			return nil, err
+		}
+		if body := wiki.rewriteLinks(string(page.Body), rename.From, rename.To); body != string(page.Body) {
+			rewrites = append(rewrites, LinkRewrite{title, body})
+		}
+	}
+	return rewrites, nil
+}
+
+// applyRename makes the rename by author. Either every step succeeds, or the ones
+// made are undone and the wiki is left as it was.
+func (wiki *Wiki) applyRename(rename Rename, author string, rewrites []LinkRewrite) (err error) {
+	page, err := wiki.load(rename.From)
+	if err != nil {
+		return err
+	}
+	metadata := wiki.metadataOf(rename.From)
+	if rename.DisplayTitle != "" {
+		metadata.DisplayTitle = rename.DisplayTitle
+	}
+	journal := renameJournal{wiki: wiki}
+	defer func() {
+		// a rename the journal doesn't have is undone like one that failed
+		if err == nil {
+			err = wiki.journalChange(JournalEntry{Op: "rename", Title: rename.From, To: rename.To, Author: author, Rewritten: titlesOf(rewrites), Redirect: rename.Redirect})
+		}
+		if err != nil {
+			if rollbackErr := journal.rollback(); rollbackErr != nil {
+				err = fmt.Errorf("%w, and undoing the rename failed too: %v", err, rollbackErr)
+			}
+		}
+	}()
+	if err := journal.do(func() error { return wiki.moveHistory(rename.From, rename.To) },
+		func() error { return wiki.moveHistory(rename.To, rename.From) }); err != nil {
+		return err
+	}
+	if err := journal.write(&Page{Title: rename.To, Body: page.Body}, author, "renamed from "+rename.From, false); err != nil {
+		return err
+	}
+	if err := journal.do(func() error { return wiki.setMetadata(rename.To, metadata) },
+		func() error { return wiki.setMetadata(rename.To, PageMetadata{}) }); err != nil {
+		return err
+	}
+	previous := wiki.metadataOf(rename.From)
+	if err := journal.do(func() error { return wiki.setMetadata(rename.From, PageMetadata{}) },
+		func() error { return wiki.setMetadata(rename.From, previous) }); err != nil {
+		return err
+	}
+	if err := journal.do(func() error { return wiki.moveAttachments(rename.From, rename.To) },
+		func() error { return wiki.moveAttachments(rename.To, rename.From) }); err != nil {
+		return err
+	}
+	if err := journal.do(func() error { return wiki.moveComments(rename.From, rename.To) },
+		func() error { return wiki.moveComments(rename.To, rename.From) }); err != nil {
+		return err
+	}
+	for _, rewrite := range rewrites {
+		if err := journal.write(&Page{Title: rewrite.Title, Body: []byte(rewrite.body)}, author, fmt.Sprintf("point the links to %s at %s", rename.From, rename.To), true); err != nil {
+			return err
+		}
+	}
+	// the history went with the page, the redirect starts one of its own
+	if rename.Redirect {
+		return journal.write(&Page{Title: rename.From, Body: []byte("#REDIRECT " + rename.To + "\n")}, author, "renamed to "+rename.To, false)
+	}
+	return journal.do(func() error {
+		if err := deleteAs(wiki.store, rename.From, author, "renamed to "+rename.To); err != nil {
+			return err
+		}
+		wiki.titles.Remove(rename.From)
+		wiki.afterDelete(rename.From, author)
+		return nil
+	}, func() error {
+		if err := wiki.storePage(page, "", ""); err != nil {
+			return err
+		}
+		wiki.titles.Add(rename.From)
+		wiki.backlinks.relink(rename.From)
+		return nil
+	})
+}
+
+// RenamePage is the data of the rename template.
+type RenamePage struct {
+	Title     string
+	To        string
+	Backlinks []string
+	Error     string
+}
+
+// renameHandler serves /rename/{title}: GET shows the form, POST renames the page
+// to the title "to", rewriting the links to it with rewrite=1 and leaving a
+// redirect with redirect=1. Renaming needs the right to edit the page, its new
+// title and every page whose links change.
+func (wiki *Wiki) renameHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if !wiki.titles.Has(title) {
+		wiki.serveError(w, notFound(""))
+		return
+	}
+	if ok, reason := wiki.canEdit(r, title); !ok {
+		wiki.serveError(w, forbidden(reason))
+		return
+	}
+	data := RenamePage{Title: title, Backlinks: wiki.backlinksOf(title)}
+	switch r.Method {
+	case http.MethodGet:
+		wiki.renderTemplate(w, "rename.html", data)
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	data.To = strings.TrimSpace(r.FormValue("to"))
+	rename := Rename{From: title, To: data.To, RewriteLinks: r.FormValue("rewrite") != "", Redirect: r.FormValue("redirect") != ""}
+	// a title typed with spaces and punctuation is the display title of its slug
+	if !validTitle.MatchString(rename.To) {
+		rename.To, rename.DisplayTitle = slugify(rename.To), strings.Join(strings.Fields(rename.To), " ")
+	}
+	wiki.editsMu.Lock()
+	defer wiki.editsMu.Unlock()
+	rewrites, err := wiki.planRename(rename)
+	if err != nil {
+		data.Error = err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		wiki.renderTemplate(w, "rename.html", data)
+		return
+	}
+	for _, checked := range append([]string{rename.To}, titlesOf(rewrites)...) {
+		if ok, reason := wiki.canEdit(r, checked); !ok {
+			wiki.serveError(w, forbidden(reason))
+			return
+		}
+	}
+	if err := wiki.applyRename(rename, wiki.requestAuthor(r), rewrites); err != nil {
+		wiki.serveError(w, &HTTPError{Status: http.StatusInternalServerError, Message: "could not rename " + title, Cause: err})
+		return
+	}
+	http.Redirect(w, r, "/view/"+rename.To, http.StatusFound)
+}
+
+func titlesOf(rewrites []LinkRewrite) []string {
+	var rewritten []string
+	for _, rewrite := range rewrites {
+		rewritten = append(rewritten, rewrite.Title)
+	}
+	return rewritten
+}
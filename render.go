@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Renderer is one stage of the pipeline that turns a page body into the HTML of
+// the view page.
+type Renderer interface {
+	Render(body []byte) []byte
+}
+
+// renderPipeline runs its stages in order, each on the output of the previous one.
+type renderPipeline []Renderer
+
+func (pipeline renderPipeline) Render(body []byte) []byte {
+	for _, stage := range pipeline {
+		body = stage.Render(body)
+	}
+	return body
+}
+
+// isSafeMode tells whether a request wants safe rendering: ?safe=1 turns it on,
+// ?safe=0 off, and without the parameter the -safe flag decides.
+func (wiki *Wiki) isSafeMode(r *http.Request) bool {
+	if safe, err := strconv.ParseBool(r.URL.Query().Get("safe")); err == nil {
+		return safe
+	}
+	return wiki.config.SafeMode
+}
+
+var htmlTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// titleLinker links every mention of an available wiki title in rendered HTML. It
+// only touches text, never tags or attributes, and leaves text that is already a
+// link or code alone.
+type titleLinker struct {
+	wiki *Wiki
+}
+
+func (renderer titleLinker) Render(body []byte) []byte {
+	return replaceInText(body, func(text string) string {
+		mentions := renderer.wiki.titles.Mentions(text)
+		if len(mentions) == 0 {
+			return text
+		}
+		var out strings.Builder
+		last := 0
+		for _, mention := range mentions {
+			match := text[mention[0]:mention[1]]
+			out.WriteString(text[last:mention[0]])
+			out.WriteString(`<a href="` + renderer.wiki.wikiPath("/view/"+renderer.wiki.titles.Resolve(match)) + `">` + match + `</a>`)
+			last = mention[1]
+		}
+		out.WriteString(text[last:])
+		return out.String()
+	})
+}
+
+// autoLinker is the titleLinker of Markdown pages, which link the other pages with
+// [[Title]] and may do without linking every mention of them: -auto-link=false
+// turns it off. Common words that are titles too make a page hard to read once
+// the wiki has many.
+type autoLinker struct {
+	wiki *Wiki
+}
+
+func (renderer autoLinker) Render(body []byte) []byte {
+	if !renderer.wiki.config.AutoLink {
+		return body
+	}
+	return titleLinker{wiki: renderer.wiki}.Render(body)
+}
+
+// replaceInText rewrites the text of rendered HTML with replace, skipping tags,
+// attributes and the text of links and code.
+func replaceInText(body []byte, replace func(text string) string) []byte {
+	var out strings.Builder
+	source := string(body)
+	skipDepth := 0 // > 0 inside <a>, <code> and <pre>
+	replaceText := func(text string) {
+		if skipDepth > 0 {
+			out.WriteString(text)
+			return
+		}
+		out.WriteString(replace(text))
+	}
+	last := 0
+	for _, tag := range htmlTagPattern.FindAllStringIndex(source, -1) {
+		replaceText(source[last:tag[0]])
+		element := source[tag[0]:tag[1]]
+		name := strings.ToLower(strings.Trim(strings.Fields(element[1:len(element)-1] + " ")[0], "/"))
+		if name == "a" || name == "code" || name == "pre" {
+			if strings.HasPrefix(element, "</") {
+				skipDepth--
+			} else {
+				skipDepth++
+			}
+		}
+		out.WriteString(element)
+		last = tag[1]
+	}
+	replaceText(source[last:])
+	return []byte(out.String())
+}
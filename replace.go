@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// errStalePreview is returned when the replacement would no longer make the
+// changes that were previewed and confirmed, since a page changed in between.
+var errStalePreview = errors.New("the pages changed since the preview, see what the replacement changes now before applying it")
+
+// Replacement is a find-and-replace across pages: Find is a literal text, or a
+// regexp whose Replace may refer to its groups as $1, when Regexp is set. It
+// applies to the pages listed in Titles, or else every page of Namespace.
+type Replacement struct {
+	Find      string
+	Replace   string
+	Regexp    bool
+	Namespace string
+	Titles    []string
+	Summary   string
+}
+
+// ReplacedPage is a page the replacement changes, with the lines it changes.
+type ReplacedPage struct {
+	Title   string
+	Matches int
+	Lines   []DiffLine
+	body    string
+}
+
+func (rep Replacement) pattern() (*regexp.Regexp, error) {
+	if rep.Find == "" {
+		return nil, errors.New("there's nothing to find")
+	}
+	if !rep.Regexp {
+		return regexp.MustCompile(regexp.QuoteMeta(rep.Find)), nil
+	}
+	return regexp.Compile(rep.Find)
+}
+
+func (rep Replacement) summary() string {
+	if rep.Summary != "" {
+		return rep.Summary
+	}
+	return fmt.Sprintf("replace %q with %q", rep.Find, rep.Replace)
+}
+
+// previewReplacement returns the pages the replacement changes, in title order.
+func (wiki *Wiki) previewReplacement(rep Replacement) ([]ReplacedPage, error) {
+	pattern, err := rep.pattern()
+	if err != nil {
+		return nil, err
+	}
+	selected := rep.Titles
+	if len(selected) == 0 {
+		selected = wiki.selectPages(rep.Namespace)
+	}
+	var changed []ReplacedPage
+	for _, title := range selected {
+		page, err := wiki.load(title)
+		if err != nil {
+			return nil, fmt.Errorf("there's no page %s", title)
+		}
+		matches := len(pattern.FindAllIndex(page.Body, -1))
+		if matches == 0 {
+			continue
+		}
+		var body string
+		if rep.Regexp {
+			body = pattern.ReplaceAllString(string(page.Body), rep.Replace)
+		} else {
+			body = pattern.ReplaceAllLiteralString(string(page.Body), rep.Replace)
+		}
+		if body == string(page.Body) {
+			continue
+		}
+		replaced := ReplacedPage{Title: title, Matches: matches, body: body}
+		for _, line := range diffLines(string(page.Body), body) {
+			if line.Op != "=" {
+				replaced.Lines = append(replaced.Lines, line)
+			}
+		}
+		changed = append(changed, replaced)
+	}
+	return changed, nil
+}
+
+// fingerprint identifies the changes of a preview, for apply to confirm it makes
+// those and no others.
+func fingerprint(changed []ReplacedPage) string {
+	var all strings.Builder
+	for _, replaced := range changed {
+		fmt.Fprintf(&all, "%s\x00%s\x00", replaced.Title, replaced.body)
+	}
+	return sha256Hex([]byte(all.String()))[:16]
+}
+
+// applyReplacement makes the replacement, every changed page a revision by author
+// with the shared summary. The pages are read again, and unless the changes are still
+// those of the preview whose fingerprint confirms them, none are made: the
+// changes it would make now are returned with errStalePreview.
+func (wiki *Wiki) applyReplacement(rep Replacement, author, confirm string) ([]ReplacedPage, error) {
+	wiki.editsMu.Lock()
+	defer wiki.editsMu.Unlock()
+	changed, err := wiki.previewReplacement(rep)
+	if err != nil {
+		return nil, err
+	}
+	if fingerprint(changed) != confirm {
+		return changed, errStalePreview
+	}
+	for idx, replaced := range changed {
+		if err := wiki.recordBaseline(replaced.Title); err != nil {
+			return changed[:idx], err
+		}
+		page := &Page{Title: replaced.Title, Body: []byte(replaced.body)}
+		if err := wiki.storePage(page, author, rep.summary()); err != nil {
+			return changed[:idx], err
+		}
+		revision, err := wiki.recordRevision(page, author, rep.summary())
+		if err != nil {
+			return changed[:idx], err
+		}
+		if err := wiki.journalRevision("save", page.Title, revision); err != nil {
+			return changed[:idx+1], err
+		}
+		if err := wiki.staleReview(page.Title); err != nil {
+			return changed[:idx+1], err
+		}
+	}
+	return changed, nil
+}
+
+// splitTitles splits a list of titles separated by commas or spaces.
+func splitTitles(list string) []string {
+	return strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' || r == '\r' })
+}
+
+// ReplacePage is the data of the replace template.
+type ReplacePage struct {
+	Replacement
+	Pages   string
+	Changed []ReplacedPage
+	Applied bool
+	// Confirm is the fingerprint of the previewed changes, which applying them
+	// sends back.
+	Confirm string
+	Error   string
+}
+
+// replaceHandler serves /admin/replace to admins: POST with action=preview lists
+// the changes a find-and-replace would make, and action=apply makes them, if
+// they're still those of the preview whose fingerprint the confirm value is.
+func (wiki *Wiki) replaceHandler(w http.ResponseWriter, r *http.Request) {
+	if !wiki.isAdmin(r) {
+		wiki.serveError(w, forbidden("only admins can replace across pages"))
+		return
+	}
+	var data ReplacePage
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		data.Pages = r.FormValue("pages")
+		data.Replacement = Replacement{Find: r.FormValue("find"), Replace: r.FormValue("replace"), Regexp: r.FormValue("regexp") != "", Namespace: r.FormValue("namespace"), Titles: splitTitles(data.Pages), Summary: r.FormValue("summary")}
+		var err error
+		if r.FormValue("action") == "apply" {
+			data.Changed, err = wiki.applyReplacement(data.Replacement, wiki.requestAuthor(r), r.FormValue("confirm"))
+			data.Applied = err != errStalePreview
+		} else {
+			data.Changed, err = wiki.previewReplacement(data.Replacement)
+		}
+		if !data.Applied {
+			data.Confirm = fingerprint(data.Changed)
+		}
+		if err != nil {
+			data.Error = err.Error()
+		}
+	default:
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	wiki.renderTemplate(w, "replace.html", data)
+}
+
+// runReplace is the replace command: it prints the changes of a find-and-replace,
+// and makes them with -apply once they're confirmed, or right away with -yes.
+func (wiki *Wiki) runReplace(args []string) error {
+	flags := flag.NewFlagSet("replace", flag.ContinueOnError)
+	var rep Replacement
+	flags.StringVar(&rep.Find, "find", "", "the text to find")
+	flags.StringVar(&rep.Replace, "replace", "", "the text to replace it with")
+	flags.BoolVar(&rep.Regexp, "regexp", false, "find a regexp, whose groups the replacement refers to as $1, $2, ...")
+	flags.StringVar(&rep.Namespace, "namespace", "", "replace only in the pages of this namespace and the namespaces below it")
+	pages := flags.String("pages", "", "replace only in these pages, separated by commas")
+	flags.StringVar(&rep.Summary, "summary", "", "the edit summary of the revisions")
+	author := flags.String("author", "admin", "the author of the revisions")
+	apply := flags.Bool("apply", false, "make the changes, once confirmed, instead of only listing them")
+	yes := flags.Bool("yes", false, "with -apply, make the changes without asking to confirm them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	rep.Titles = splitTitles(*pages)
+	changed, err := wiki.previewReplacement(rep)
+	if err != nil {
+		return err
+	}
+	for _, replaced := range changed {
+		fmt.Printf("%s: %d matches\n", replaced.Title, replaced.Matches)
+		for _, line := range replaced.Lines {
+			fmt.Printf("  %s %s\n", line.Op, line.Text)
+		}
+	}
+	if !*apply || len(changed) == 0 {
+		fmt.Printf("%d pages would change, run again with -apply to change them\n", len(changed))
+		return nil
+	}
+	if !*yes {
+		fmt.Fprintf(os.Stderr, "change %d pages? [y/N] ", len(changed))
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			return errors.New("nothing changed")
+		}
+	}
+	applied, err := wiki.applyReplacement(rep, *author, fingerprint(changed))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("changed %d pages\n", len(applied))
+	return nil
+}
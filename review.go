@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+)
+
+// The states of the optional review workflow; pages nobody asked to review have none.
+const (
+	reviewNeeded   = "needs-review"
+	reviewApproved = "reviewed"
+)
+
+// PageReview is the review state of a page, kept with its metadata.
+type PageReview struct {
+	Status   string    `json:"status"`
+	Reviewer string    `json:"reviewer,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// ReviewReportEntry is a page listed in the review report.
+type ReviewReportEntry struct {
+	Title  string
+	Review PageReview
+}
+
+// ReviewReport is the data of the review report template.
+type ReviewReport struct {
+	Status string // the status the report is filtered by, empty for all
+	Pages  []ReviewReportEntry
+}
+
+// setReview changes the review state of a page, keeping the rest of its metadata.
+func (wiki *Wiki) setReview(title string, review PageReview) error {
+	metadata := wiki.metadataOf(title)
+	metadata.Review = review
+	return wiki.setMetadata(title, metadata)
+}
+
+// staleReview marks a reviewed page as needing review again once it changes.
+func (wiki *Wiki) staleReview(title string) error {
+	review := wiki.metadataOf(title).Review
+	if review.Status != reviewApproved {
+		return nil
+	}
+	return wiki.setReview(title, PageReview{Status: reviewNeeded, Time: clock.Now()})
+}
+
+// reviewHandler serves POST /review/{title}: action "request" marks the page as
+// needing review, "approve" records the requester as its reviewer and "clear"
+// takes it out of the workflow.
+func (wiki *Wiki) reviewHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if ok, reason := wiki.canEdit(r, title); !ok {
+		wiki.serveError(w, forbidden(reason))
+		return
+	}
+	if !wiki.titles.Has(title) {
+		wiki.serveError(w, notFound(""))
+		return
+	}
+	var review PageReview
+	switch r.FormValue("action") {
+	case "request":
+		review = PageReview{Status: reviewNeeded, Time: clock.Now()}
+	case "approve":
+		review = PageReview{Status: reviewApproved, Reviewer: wiki.requestAuthor(r), Time: clock.Now()}
+	case "clear":
+	default:
+		http.Error(w, translate(wiki.requestLocale(w), "error.reviewAction"), http.StatusBadRequest)
+		return
+	}
+	if err := wiki.setReview(title, review); err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/view/"+title, http.StatusFound)
+}
+
+// reviewReportHandler serves /reports/reviews, the pages in the review workflow,
+// optionally filtered by ?status=needs-review or ?status=reviewed. Pages waiting
+// the longest for a review come first.
+func (wiki *Wiki) reviewReportHandler(w http.ResponseWriter, r *http.Request) {
+	report := ReviewReport{Status: r.URL.Query().Get("status")}
+	for _, title := range wiki.titles.List() {
+		review := wiki.metadataOf(title).Review
+		if review.Status == "" || (report.Status != "" && review.Status != report.Status) {
+			continue
+		}
+		report.Pages = append(report.Pages, ReviewReportEntry{Title: title, Review: review})
+	}
+	sort.SliceStable(report.Pages, func(i, j int) bool {
+		return report.Pages[i].Review.Time.Before(report.Pages[j].Review.Time)
+	})
+	wiki.renderTemplate(w, "reviews.html", report)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Revision is one saved version of a page. The revisions of a page are kept in
+// history/<title>.jsonl in the data directory, one JSON object per line, oldest first.
+type Revision struct {
+	ID      int       `json:"id"`
+	Author  string    `json:"author"`
+	Time    time.Time `json:"time"`
+	Body    string    `json:"body"`
+	Comment string    `json:"comment,omitempty"`
+}
+
+func (wiki *Wiki) historyFilename(title string) string {
+	return wiki.dataPath("history", title+".jsonl")
+}
+
+// loadRevisions returns every revision of the page, oldest first. Pages saved before
+// the history existed have no revisions.
+func (wiki *Wiki) loadRevisions(title string) ([]Revision, error) {
+	file, err := os.Open(wiki.historyFilename(title))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var revisions []Revision
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var revision Revision
+		if err := json.Unmarshal(scanner.Bytes(), &revision); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, scanner.Err()
+}
+
+// recordRevision appends the page's current body to its history as a new revision.
+func (wiki *Wiki) recordRevision(p *Page, author, comment string) (Revision, error) {
+	wiki.revisionsMu.Lock()
+	defer wiki.revisionsMu.Unlock()
+
+	revisions, err := wiki.loadRevisions(p.Title)
+	if err != nil {
+		return Revision{}, err
+	}
+	revision := Revision{ID: len(revisions) + 1, Author: author, Time: clock.Now().UTC(), Body: string(p.Body), Comment: comment}
+	line, err := json.Marshal(revision)
+	if err != nil {
+		return Revision{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(wiki.historyFilename(p.Title)), 0700); err != nil {
+		return Revision{}, err
+	}
+	file, err := os.OpenFile(wiki.historyFilename(p.Title), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return Revision{}, err
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return Revision{}, err
+	}
+	// Baselines have no author: they record old content, nobody mentioned anyone.
+	if author == "" {
+		return revision, nil
+	}
+	var previousBody string
+	if len(revisions) > 0 {
+		previousBody = revisions[len(revisions)-1].Body
+	}
+	return revision, wiki.notifyMentions(p.Title, author, previousBody, revision)
+}
+
+// recordBaseline records the current body of a page saved before it had a history
+// as its first revision, so the first edit through the wiki can be undone and
+// diffed.
+func (wiki *Wiki) recordBaseline(title string) error {
+	revisions, err := wiki.loadRevisions(title)
+	if err != nil || revisions != nil {
+		return err
+	}
+	page, err := wiki.load(title)
+	if err != nil {
+		return nil // a new page
+	}
+	_, err = wiki.recordRevision(page, "", "saved before the history was kept")
+	return err
+}
+
+// requestAuthor returns who made a request, as withAuthor identified them.
+func (wiki *Wiki) requestAuthor(r *http.Request) string {
+	if author, ok := r.Context().Value(authorKey{}).(string); ok {
+		return author
+	}
+	return wiki.identify(r)
+}
+
+// identify returns the author of a request: the logged in user, or else the
+// client's address, anonymized.
+func (wiki *Wiki) identify(r *http.Request) string {
+	if user := wiki.currentUser(r); user != "" {
+		return user
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return anonymizeAddr(host)
+}
+
+// anonymizeAddr keeps the network of an IP address and drops the host: the last
+// byte of an IPv4 address, the last 64 bits of an IPv6 one. Edits from one
+// network are attributed alike, without the history naming the client.
+func anonymizeAddr(host string) string {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	if addr.Is4() || addr.Is4In6() {
+		prefix, _ := addr.Unmap().Prefix(24)
+		return strings.TrimSuffix(prefix.Addr().String(), ".0") + ".x"
+	}
+	prefix, _ := addr.Prefix(64)
+	return strings.TrimSuffix(prefix.Addr().String(), "::") + "::x"
+}
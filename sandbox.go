@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Every user has a sandbox, the namespace ~name, for scratch notes: only they and
+// the admins write there. Sandbox pages are only listed for them (see
+// viewer.lists): they aren't auto-linked, searched unless a query names their
+// namespace, nor exported, until they're published to the main wiki.
+const sandboxPrefix = "~"
+
+var sandboxTitle = regexp.MustCompile(`^~([a-zA-Z0-9]+)/` + pathPattern + `$`)
+
+func isSandbox(title string) bool {
+	return strings.HasPrefix(title, sandboxPrefix)
+}
+
+// sandboxOwner returns the user whose sandbox the page is in.
+func sandboxOwner(title string) (string, bool) {
+	match := sandboxTitle.FindStringSubmatch(title)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+// sandboxPages returns the titles of the pages in the user's sandbox, sorted.
+func (wiki *Wiki) sandboxPages(user string) []string {
+	var pages []string
+	for _, title := range wiki.titles.List() {
+		if owner, ok := sandboxOwner(title); ok && owner == user {
+			pages = append(pages, title)
+		}
+	}
+	return pages
+}
+
+// publishHandler serves POST /publish/~name/Title: the sandbox page moves to the
+// main wiki title of the form value "title", which must not exist yet.
+func (wiki *Wiki) publishHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if !isSandbox(title) {
+		http.Error(w, title+" is not in a sandbox", http.StatusBadRequest)
+		return
+	}
+	target := slugify(r.FormValue("title"))
+	display, _, _ := checkDisplayTitle(target, r.FormValue("title"))
+	if !validTitle.MatchString(target) || isSandbox(target) {
+		http.Error(w, translate(wiki.requestLocale(w), "error.publishTitle"), http.StatusBadRequest)
+		return
+	}
+	for _, checked := range []string{title, target} {
+		if ok, reason := wiki.canEdit(r, checked); !ok {
+			wiki.serveError(w, forbidden(reason))
+			return
+		}
+	}
+	if wiki.titles.Has(target) {
+		http.Error(w, target+" already exists", http.StatusConflict)
+		return
+	}
+	page, err := wiki.load(title)
+	if err != nil {
+		wiki.serveError(w, notFound(""))
+		return
+	}
+	author := wiki.requestAuthor(r)
+	if err := wiki.savePage(&Page{Title: target, Body: page.Body}, author); err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	if err := wiki.deletePage(title, author); err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	if display != "" {
+		metadata := wiki.metadataOf(target)
+		metadata.DisplayTitle = display
+		if err := wiki.setMetadata(target, metadata); err != nil {
+			wiki.serveError(w, err)
+			return
+		}
+	}
+	http.Redirect(w, r, "/view/"+target, http.StatusFound)
+}
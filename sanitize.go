@@ -0,0 +1,99 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// allowedElements are the HTML elements a rendered page may contain, with the
+// attributes each may have. Every other element is escaped, so it shows as text.
+var allowedElements = map[string][]string{
+	"a": {"href", "title", "class"}, "img": {"src", "alt", "title"},
+	"p": nil, "br": nil, "hr": nil, "blockquote": nil, "pre": nil, "code": {"class"},
+	"h1": {"id"}, "h2": {"id"}, "h3": {"id"}, "h4": {"id"}, "h5": {"id"}, "h6": {"id"},
+	"ul": nil, "ol": {"start"}, "li": nil, "dl": nil, "dt": nil, "dd": nil,
+	"strong": nil, "em": nil, "b": nil, "i": nil, "u": nil, "s": nil, "del": nil, "ins": nil,
+	"sub": nil, "sup": nil, "kbd": nil, "mark": nil, "small": nil, "span": {"class"}, "div": {"class"},
+	"table": nil, "thead": nil, "tbody": nil, "tr": nil, "th": nil, "td": nil,
+}
+
+var (
+	htmlElement   = regexp.MustCompile(`^<(/?)([a-zA-Z][a-zA-Z0-9]*)((?:\s[^>]*)?)/?>$`)
+	htmlAttribute = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9-]*)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'=<>` + "`" + `]+)))?`)
+	// attributeValues are what the attributes that aren't URLs may hold; text,
+	// like a title, may hold anything, it's escaped.
+	attributeValues = map[string]*regexp.Regexp{
+		"class": regexp.MustCompile(`^[\p{L}\p{N} _.+#-]*$`),
+		"id":    regexp.MustCompile(`^[\p{L}\p{N}_-]*$`),
+		"start": regexp.MustCompile(`^[0-9]{1,9}$`),
+	}
+)
+
+// htmlSanitizer is the last stage of rendering: it keeps the allowed elements and
+// attributes of the HTML the stages before it made, with URLs that can't run
+// script, and escapes the rest. The renderers escape the raw HTML of the source
+// already; this is what holds when one of them, a macro or a custom markup rule
+// lets some through.
+type htmlSanitizer struct{}
+
+func (htmlSanitizer) Render(body []byte) []byte {
+	var out strings.Builder
+	source := string(body)
+	last := 0
+	for _, tag := range htmlTagPattern.FindAllStringIndex(source, -1) {
+		out.WriteString(escapeBrackets(source[last:tag[0]]))
+		out.WriteString(sanitizeElement(source[tag[0]:tag[1]]))
+		last = tag[1]
+	}
+	out.WriteString(escapeBrackets(source[last:]))
+	return []byte(out.String())
+}
+
+// escapeBrackets escapes the stray angle brackets of text, leaving its entities be.
+func escapeBrackets(text string) string {
+	return strings.NewReplacer("<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// sanitizeElement returns the tag rewritten with only its allowed attributes, or
+// escaped when the element isn't allowed.
+func sanitizeElement(tag string) string {
+	match := htmlElement.FindStringSubmatch(tag)
+	if match == nil {
+		return html.EscapeString(tag)
+	}
+	name := strings.ToLower(match[2])
+	allowed, ok := allowedElements[name]
+	if !ok {
+		return html.EscapeString(tag)
+	}
+	if match[1] == "/" {
+		return "</" + name + ">"
+	}
+	var out strings.Builder
+	out.WriteString("<" + name)
+	for _, attribute := range htmlAttribute.FindAllStringSubmatch(match[3], -1) {
+		key := strings.ToLower(attribute[1])
+		value := html.UnescapeString(attribute[2] + attribute[3] + attribute[4])
+		if !allowedAttribute(allowed, key, value) {
+			continue
+		}
+		out.WriteString(" " + key + `="` + html.EscapeString(value) + `"`)
+	}
+	out.WriteString(">")
+	return out.String()
+}
+
+func allowedAttribute(allowed []string, key, value string) bool {
+	for _, name := range allowed {
+		if name != key {
+			continue
+		}
+		if key == "href" || key == "src" {
+			return safeURL.MatchString(value)
+		}
+		pattern := attributeValues[key]
+		return pattern == nil || pattern.MatchString(value)
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+func TestHTMLSanitizer(t *testing.T) {
+	tests := []struct {
+		name, html, want string
+	}{
+		{"allowed element", `<p>text</p>`, `<p>text</p>`},
+		{"upper case", `<STRONG>text</STRONG>`, `<strong>text</strong>`},
+		{"disallowed element", `<script>alert(1)</script>`, `&lt;script&gt;alert(1)&lt;/script&gt;`},
+		{"iframe", `<iframe src="https://example.com"></iframe>`, `&lt;iframe src=&#34;https://example.com&#34;&gt;&lt;/iframe&gt;`},
+		{"event handler", `<img src="a.png" onerror="alert(1)">`, `<img src="a.png">`},
+		{"style", `<p style="background:url(x)">text</p>`, `<p>text</p>`},
+		{"allowed attributes", `<a href="/view/Page" title="a &amp; b" class="internal">Page</a>`, `<a href="/view/Page" title="a &amp; b" class="internal">Page</a>`},
+		{"unquoted attribute", `<ol start=3><li>x</li></ol>`, `<ol start="3"><li>x</li></ol>`},
+		{`javascript: href`, `<a href="javascript:alert(1)">x</a>`, `<a>x</a>`},
+		{`javascript: href in upper case`, `<a href="JavaScript:alert(1)">x</a>`, `<a>x</a>`},
+		{`javascript: href of entities`, `<a href="&#106;avascript:alert(1)">x</a>`, `<a>x</a>`},
+		{`javascript: href with a tab`, `<a href="java&#9;script:alert(1)">x</a>`, `<a>x</a>`},
+		{"data: src", `<img src="data:text/html;base64,PHNjcmlwdD4=">`, `<img>`},
+		{"vbscript: href", `<a href='vbscript:msgbox(1)'>x</a>`, `<a>x</a>`},
+		{"class of a quote", `<span class='a" onclick="x'>x</span>`, `<span>x</span>`},
+		{"id of a space", `<h2 id="a b">x</h2>`, `<h2>x</h2>`},
+		{"start of letters", `<ol start="1e9"></ol>`, `<ol></ol>`},
+		{"stray brackets", `a < b > c`, `a &lt; b &gt; c`},
+		{"entities", `&lt;script&gt; &amp;`, `&lt;script&gt; &amp;`},
+		{"unclosed tag", `<a href="x"`, `&lt;a href="x"`},
+	}
+	for _, test := range tests {
+		if got := string(htmlSanitizer{}.Render([]byte(test.html))); got != test.want {
+			t.Errorf("%s: Render(%q) = %q, want %q", test.name, test.html, got, test.want)
+		}
+	}
+}
+
+func TestSafeURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		safe bool
+	}{
+		{"https://example.com/a?b#c", true},
+		{"HTTP://example.com", true},
+		{"mailto:someone@example.com", true},
+		{"/view/Page", true},
+		{"#section", true},
+		{"./image.png", true},
+		{"../image.png", true},
+		{"Page", true},
+		{"Page/Sub?x=1", true},
+		{"javascript:alert(1)", false},
+		{"JAVASCRIPT:alert(1)", false},
+		{" javascript:alert(1)", false},
+		{"java\tscript:alert(1)", false},
+		{"java\nscript:alert(1)", false},
+		{"data:text/html,<script>alert(1)</script>", false},
+		{"vbscript:msgbox(1)", false},
+		{"file:///etc/passwd", false},
+	}
+	for _, test := range tests {
+		if safe := safeURL.MatchString(test.url); safe != test.safe {
+			t.Errorf("safeURL.MatchString(%q) = %v, want %v", test.url, safe, test.safe)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// scanTimeout bounds how long the scanner may take over one upload.
+	scanTimeout = time.Minute
+	// maxQuarantineListed bounds how many quarantined uploads the report lists.
+	maxQuarantineListed = 20
+)
+
+// UploadScanner checks an uploaded attachment before it's accepted, the way a
+// virus scanner like ClamAV does. Scan returns what it found in the file, or ""
+// when the file is clean, and an error when it couldn't scan the file at all.
+type UploadScanner interface {
+	Scan(ctx context.Context, path, name string) (finding string, err error)
+}
+
+func newUploadScanner(command, url string) (UploadScanner, error) {
+	switch {
+	case command != "" && url != "":
+		return nil, errors.New("configure either a scan command or a scan URL, not both")
+	case command != "":
+		return commandScanner{strings.Fields(command)}, nil
+	case url != "":
+		return httpScanner{url}, nil
+	}
+	return nil, nil
+}
+
+// commandScanner runs a command with the path of the upload as its last argument.
+// Like clamscan and clamdscan, the command exits with 0 for a clean file and 1 for
+// a detection, described by its output; any other exit is a failure to scan.
+type commandScanner struct {
+	argv []string
+}
+
+func (scanner commandScanner) Scan(ctx context.Context, path, name string) (string, error) {
+	output, err := exec.CommandContext(ctx, scanner.argv[0], append(scanner.argv[1:], path)...).CombinedOutput()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return "", nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		finding, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+		// The output names the scanned file by its temporary path.
+		finding = strings.TrimSpace(strings.TrimPrefix(finding, path+":"))
+		if finding == "" {
+			finding = "rejected by " + scanner.argv[0]
+		}
+		return finding, nil
+	}
+	return "", fmt.Errorf("%s: %v: %s", scanner.argv[0], err, strings.TrimSpace(string(output)))
+}
+
+// httpScanner POSTs the upload to a callback URL, which answers 200 with a JSON
+// object {"infected": bool, "finding": string}.
+type httpScanner struct {
+	url string
+}
+
+func (scanner httpScanner) Scan(ctx context.Context, path, name string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, scanner.url, file)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/octet-stream")
+	request.Header.Set("X-Filename", name)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s answered %s", scanner.url, response.Status)
+	}
+	var verdict struct {
+		Infected bool   `json:"infected"`
+		Finding  string `json:"finding"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&verdict); err != nil {
+		return "", fmt.Errorf("%s answered with invalid JSON: %v", scanner.url, err)
+	}
+	if !verdict.Infected {
+		return "", nil
+	}
+	if verdict.Finding == "" {
+		verdict.Finding = "rejected by " + scanner.url
+	}
+	return verdict.Finding, nil
+}
+
+// errScanFailed rejects an upload the scanner couldn't check: without a verdict
+// nothing is accepted.
+var errScanFailed = errors.New("the upload couldn't be scanned, try again later")
+
+// rejectedUploadError is returned for an upload the scanner found something in.
+type rejectedUploadError struct {
+	finding string
+}
+
+func (err *rejectedUploadError) Error() string {
+	return "the upload was rejected by the scanner: " + err.finding
+}
+
+// QuarantineRecord is an entry of the audit log of the quarantine, the
+// attachments/quarantine directory that keeps rejected uploads by their hash.
+type QuarantineRecord struct {
+	Time    time.Time `json:"time"`
+	Author  string    `json:"author"`
+	Page    string    `json:"page"`
+	Name    string    `json:"name"`
+	Hash    string    `json:"hash"`
+	Size    int64     `json:"size"`
+	Finding string    `json:"finding"`
+}
+
+func (wiki *Wiki) quarantineLog() string {
+	return wiki.dataPath("attachments", "quarantine", "audit.log")
+}
+
+// scanUpload runs the scanner over the spooled upload. A detection moves the file
+// into the quarantine and is recorded in its audit log.
+func (wiki *Wiki) scanUpload(tmpName string, record QuarantineRecord) error {
+	if wiki.uploadScanner == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), scanTimeout)
+	defer cancel()
+	finding, err := wiki.uploadScanner.Scan(ctx, tmpName, record.Name)
+	if err != nil {
+		log.Printf("could not scan the upload %s of %s to %s: %v", record.Name, record.Author, record.Page, err)
+		return errScanFailed
+	}
+	if finding == "" {
+		return nil
+	}
+	record.Time, record.Finding = clock.Now(), finding
+	log.Printf("quarantined the upload %s (%s) of %s to %s: %s", record.Name, record.Hash, record.Author, record.Page, finding)
+	if err := wiki.quarantine(tmpName, record); err != nil {
+		return err
+	}
+	return &rejectedUploadError{finding}
+}
+
+func (wiki *Wiki) quarantine(tmpName string, record QuarantineRecord) error {
+	if err := os.MkdirAll(wiki.dataPath("attachments", "quarantine"), 0700); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, wiki.dataPath("attachments", "quarantine", record.Hash)); err != nil {
+		return err
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	auditLog, err := os.OpenFile(wiki.quarantineLog(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := auditLog.Write(append(line, '\n')); err != nil {
+		auditLog.Close()
+		return err
+	}
+	return auditLog.Close()
+}
+
+// recentQuarantine returns the latest entries of the quarantine's audit log,
+// newest first.
+func (wiki *Wiki) recentQuarantine() []QuarantineRecord {
+	auditLog, err := os.Open(wiki.quarantineLog())
+	if err != nil {
+		return nil
+	}
+	defer auditLog.Close()
+	var records []QuarantineRecord
+	lines := bufio.NewScanner(auditLog)
+	for lines.Scan() {
+		var record QuarantineRecord
+		if json.Unmarshal(lines.Bytes(), &record) == nil {
+			records = append(records, record)
+		}
+	}
+	records = records[max(0, len(records)-maxQuarantineListed):]
+	slices.Reverse(records)
+	return records
+}
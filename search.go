@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SearchResult is one page matching a search query.
+type SearchResult struct {
+	Title   string        `json:"title"`
+	Score   int           `json:"score"`
+	Extract string        `json:"extract"`
+	Snippet template.HTML `json:"snippet"`
+}
+
+// SavedSearch is a search query saved under a name by its owner. Pages embed its
+// results with the {{search:Name}} macro.
+type SavedSearch struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+	Owner string `json:"owner"`
+}
+
+// SearchPage is the data of the search template.
+type SearchPage struct {
+	Query         string
+	Results       []SearchResult
+	SavedSearches []SavedSearch
+}
+
+// parsedQuery is a search query split into full-text terms and filters:
+// "title:word" keeps pages whose title contains word, "namespace:name" pages in
+// that namespace.
+type parsedQuery struct {
+	terms      []string
+	titleParts []string
+	namespace  *string
+}
+
+func parseQuery(query string) parsedQuery {
+	var parsed parsedQuery
+	for _, field := range strings.Fields(strings.ToLower(query)) {
+		key, value, found := strings.Cut(field, ":")
+		switch {
+		case found && key == "title":
+			parsed.titleParts = append(parsed.titleParts, value)
+		case found && key == "namespace":
+			parsed.namespace = &value
+		default:
+			parsed.terms = append(parsed.terms, tokenize(field)...)
+		}
+	}
+	return parsed
+}
+
+// searchPages returns the pages matching every term and filter of the query, the
+// pages mentioning the terms most often first. The terms are looked up in the
+// search index; pages are only loaded for their snippets.
+func (wiki *Wiki) searchPages(query string, v viewer) []SearchResult {
+	parsed := parseQuery(query)
+	if len(parsed.terms) == 0 && len(parsed.titleParts) == 0 && parsed.namespace == nil {
+		return nil
+	}
+	candidates := wiki.searchIndex.lookup(parsed.terms)
+	if len(parsed.terms) == 0 {
+		candidates = make(map[string]int)
+		for _, title := range wiki.titles.List() {
+			candidates[title] = 0
+		}
+	}
+	var results []SearchResult
+	acl := wiki.currentACL()
+	for title, score := range candidates {
+		lowerTitle := strings.ToLower(title)
+		if parsed.namespace != nil && strings.ToLower(namespaceOf(title)) != *parsed.namespace {
+			continue
+		}
+		// Sandboxes are only searched when the query names their namespace.
+		if isSandbox(title) && parsed.namespace == nil || !v.listsWith(acl, title) {
+			continue
+		}
+		matches := true
+		for _, part := range parsed.titleParts {
+			matches = matches && strings.Contains(lowerTitle, part)
+		}
+		if !matches {
+			continue
+		}
+		page, err := wiki.load(title)
+		if err != nil {
+			continue
+		}
+		results = append(results, SearchResult{Title: title, Score: score, Extract: summarize(page).Extract, Snippet: highlightSnippet(string(page.Body), parsed.terms)})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Title < results[j].Title
+	})
+	return results
+}
+
+func (wiki *Wiki) cachedSearch(query string) []SearchResult {
+	wiki.searchCache.Lock()
+	defer wiki.searchCache.Unlock()
+	results, ok := wiki.searchCache.results[query]
+	if !ok {
+		results = wiki.searchPages(query, wiki.everyone)
+		wiki.searchCache.results[query] = results
+	}
+	return results
+}
+
+// invalidateSearchCache forgets the cached results; Page.save calls it.
+func (wiki *Wiki) invalidateSearchCache() {
+	wiki.searchCache.Lock()
+	wiki.searchCache.results = make(map[string][]SearchResult)
+	wiki.searchCache.Unlock()
+}
+
+func (wiki *Wiki) savedSearchesFilename() string {
+	return wiki.dataPath("savedSearches.json")
+}
+
+func (wiki *Wiki) loadSavedSearches() error {
+	data, err := os.ReadFile(wiki.savedSearchesFilename())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &wiki.savedSearches)
+}
+
+// storeSavedSearches must be called with savedSearchesMu held.
+func (wiki *Wiki) storeSavedSearches() error {
+	data, err := json.MarshalIndent(wiki.savedSearches, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(wiki.savedSearchesFilename(), data, 0600)
+}
+
+// savedSearchesOf returns the searches saved by owner, by name.
+func (wiki *Wiki) savedSearchesOf(owner string) []SavedSearch {
+	wiki.savedSearchesMu.Lock()
+	defer wiki.savedSearchesMu.Unlock()
+	var owned []SavedSearch
+	for _, search := range wiki.savedSearches {
+		if search.Owner == owner {
+			owned = append(owned, search)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool { return owned[i].Name < owned[j].Name })
+	return owned
+}
+
+// searchHandler serves /search?q=..., the search results page.
+func (wiki *Wiki) searchHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.FormValue("q")
+	wiki.renderTemplate(w, "search.html", SearchPage{Query: query, Results: wiki.searchPages(query, wiki.viewerOf(r)), SavedSearches: wiki.savedSearchesOf(wiki.requestAuthor(r))})
+}
+
+// savedSearchesHandler serves /api/v1/saved-searches: GET lists the requester's
+// saved searches, POST saves the form values "name" and "q", and DELETE removes
+// the saved search named by "name". Form posts from the search page are
+// redirected back to it.
+func (wiki *Wiki) savedSearchesHandler(w http.ResponseWriter, r *http.Request) {
+	owner := wiki.requestAuthor(r)
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodDelete:
+		name := r.FormValue("name")
+		if !validName.MatchString(name) {
+			http.Error(w, translate(wiki.requestLocale(w), "error.savedSearchName"), http.StatusBadRequest)
+			return
+		}
+		wiki.savedSearchesMu.Lock()
+		if existing, ok := wiki.savedSearches[name]; ok && existing.Owner != owner && !wiki.isAdmin(r) {
+			wiki.savedSearchesMu.Unlock()
+			wiki.serveError(w, forbidden(fmt.Sprintf("the saved search %s belongs to someone else", name)))
+			return
+		}
+		if r.Method == http.MethodPost {
+			wiki.savedSearches[name] = SavedSearch{Name: name, Query: r.FormValue("q"), Owner: owner}
+		} else {
+			delete(wiki.savedSearches, name)
+		}
+		err := wiki.storeSavedSearches()
+		wiki.savedSearchesMu.Unlock()
+		if err != nil {
+			wiki.serveError(w, err)
+			return
+		}
+		wiki.invalidateSearchCache()
+		if r.FormValue("redirect") != "" {
+			http.Redirect(w, r, "/search?q="+r.FormValue("q"), http.StatusFound)
+			return
+		}
+	default:
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	owned := wiki.savedSearchesOf(owner)
+	if owned == nil {
+		owned = []SavedSearch{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]SavedSearch{"savedSearches": owned})
+}
+
+var searchMacro = regexp.MustCompile(`\{\{search:([a-zA-Z0-9]+)\}\}`)
+
+// searchMacroRenderer replaces every {{search:Name}} macro in a Markdown body with
+// the list of pages the saved search finds, evaluated when the page is rendered.
+type searchMacroRenderer struct {
+	wiki *Wiki
+}
+
+func (renderer searchMacroRenderer) Render(body []byte) []byte {
+	return searchMacro.ReplaceAllFunc(body, func(macro []byte) []byte {
+		name := string(searchMacro.FindSubmatch(macro)[1])
+		renderer.wiki.savedSearchesMu.Lock()
+		search, ok := renderer.wiki.savedSearches[name]
+		renderer.wiki.savedSearchesMu.Unlock()
+		if !ok {
+			return []byte("*There is no saved search named " + name + ".*")
+		}
+		results := renderer.wiki.cachedSearch(search.Query)
+		if len(results) == 0 {
+			return []byte("*No pages match the search " + name + ".*")
+		}
+		// Embedded lists are curated listings, ordered by weight rather than score.
+		var listed []string
+		for _, result := range results {
+			listed = append(listed, result.Title)
+		}
+		renderer.wiki.sortListing(listed)
+		var list strings.Builder
+		list.WriteString("\n")
+		for _, title := range listed {
+			fmt.Fprintf(&list, "- [%s](/view/%s)\n", title, title)
+		}
+		return []byte(list.String() + "\n")
+	})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// snippetRadius is how much text around the first match a search snippet shows.
+const snippetRadius = 80
+
+// invertedIndex maps every word of the page bodies and titles to the pages it
+// appears in, with how often it appears there. It's built at startup and updated
+// on every save.
+type invertedIndex struct {
+	mu       sync.RWMutex
+	postings map[string]map[string]int // word -> title -> occurrences
+	words    map[string][]string       // title -> the distinct words indexed for it
+}
+
+// tokenize splits text into lower-case words of letters and digits.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// update replaces the indexed words of the page with those of its current body.
+// Words of the title count twice.
+func (idx *invertedIndex) update(p *Page) {
+	counts := make(map[string]int)
+	for _, word := range tokenize(string(p.Body)) {
+		counts[word]++
+	}
+	for _, word := range tokenize(p.Title) {
+		counts[word] += 2
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(p.Title)
+	words := make([]string, 0, len(counts))
+	for word, count := range counts {
+		if idx.postings[word] == nil {
+			idx.postings[word] = make(map[string]int)
+		}
+		idx.postings[word][p.Title] = count
+		words = append(words, word)
+	}
+	idx.words[p.Title] = words
+}
+
+func (idx *invertedIndex) remove(title string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(title)
+}
+
+func (idx *invertedIndex) removeLocked(title string) {
+	for _, word := range idx.words[title] {
+		delete(idx.postings[word], title)
+		if len(idx.postings[word]) == 0 {
+			delete(idx.postings, word)
+		}
+	}
+	delete(idx.words, title)
+}
+
+// lookup returns the pages containing every word, with the total number of
+// occurrences as their score.
+func (idx *invertedIndex) lookup(words []string) map[string]int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	var scores map[string]int
+	for _, word := range words {
+		matching := make(map[string]int)
+		for title, count := range idx.postings[word] {
+			if scores == nil {
+				matching[title] = count
+			} else if score, ok := scores[title]; ok {
+				matching[title] = score + count
+			}
+		}
+		scores = matching
+		if len(scores) == 0 {
+			break
+		}
+	}
+	return scores
+}
+
+// highlightSnippet returns the text around the first word of the body that
+// matches one of words, with every matching word marked.
+func highlightSnippet(body string, words []string) template.HTML {
+	wanted := make(map[string]bool)
+	for _, word := range words {
+		wanted[word] = true
+	}
+	runes := []rune(body)
+	first := -1
+	for start := 0; start < len(runes); {
+		for start < len(runes) && !unicode.IsLetter(runes[start]) && !unicode.IsDigit(runes[start]) {
+			start++
+		}
+		end := start
+		for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end])) {
+			end++
+		}
+		if end > start && wanted[strings.ToLower(string(runes[start:end]))] {
+			first = start
+			break
+		}
+		start = end
+	}
+	if first < 0 {
+		first = 0
+	}
+	from, to := max(first-snippetRadius, 0), min(first+snippetRadius, len(runes))
+	snippet := strings.Join(strings.Fields(string(runes[from:to])), " ")
+
+	var out strings.Builder
+	if from > 0 {
+		out.WriteString("…")
+	}
+	word := []rune{}
+	flush := func() {
+		if len(word) == 0 {
+			return
+		}
+		if wanted[strings.ToLower(string(word))] {
+			out.WriteString("<mark>" + html.EscapeString(string(word)) + "</mark>")
+		} else {
+			out.WriteString(html.EscapeString(string(word)))
+		}
+		word = word[:0]
+	}
+	for _, r := range snippet {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			word = append(word, r)
+			continue
+		}
+		flush()
+		out.WriteString(html.EscapeString(string(r)))
+	}
+	flush()
+	if to < len(runes) {
+		out.WriteString("…")
+	}
+	return template.HTML(out.String())
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// headingAnchor is the id the renderer gives a heading, so other pages can link
+// to its section: its plain text in lower case, with every run of other
+// characters than letters and digits turned into a "-".
+func headingAnchor(heading string) string {
+	words := strings.FieldsFunc(strings.ToLower(plainText(heading)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, "-")
+}
+
+// uniqueAnchor returns the anchor of a heading, marking it used. A heading whose
+// anchor an earlier one of the page has already gets the first free of anchor-2,
+// anchor-3 and so on, and one without letters or digits is a "section", so that
+// every section of a page can be linked to.
+func uniqueAnchor(used map[string]bool, anchor string) string {
+	if anchor == "" {
+		anchor = "section"
+	}
+	unique := anchor
+	for n := 2; used[unique]; n++ {
+		unique = anchor + "-" + strconv.Itoa(n)
+	}
+	used[unique] = true
+	return unique
+}
+
+// headingAnchors returns the anchors of every heading of a Markdown body, as the
+// renderer gives them.
+func headingAnchors(body []byte) map[string]bool {
+	anchors := make(map[string]bool)
+	inCode := false
+	for _, line := range strings.Split(string(body), "\n") {
+		if codeFence.MatchString(line) {
+			inCode = !inCode
+		}
+		if match := atxHeading.FindStringSubmatch(line); match != nil && !inCode {
+			uniqueAnchor(anchors, headingAnchor(match[2]))
+		}
+	}
+	return anchors
+}
+
+// renderedHeading matches a heading of the rendered HTML with its anchor.
+var renderedHeading = regexp.MustCompile(`<h([1-6]) id="([^"]*)">(.*?)</h[1-6]>`)
+
+// uniqueHeadingIDs gives the headings of a rendered page the anchors uniqueAnchor
+// makes of theirs, in the order they come in.
+func uniqueHeadingIDs(rendered []byte) []byte {
+	used := make(map[string]bool)
+	return renderedHeading.ReplaceAllFunc(rendered, func(heading []byte) []byte {
+		match := renderedHeading.FindSubmatch(heading)
+		return []byte(fmt.Sprintf(`<h%s id="%s">%s</h%s>`, match[1], uniqueAnchor(used, string(match[2])), match[3], match[1]))
+	})
+}
+
+// tocMinHeadings is how many headings a page has at least to show a table of
+// contents.
+const tocMinHeadings = 3
+
+// TOCEntry is a heading in the table of contents of a page: its text, its anchor
+// and how much deeper than the page's top headings it is.
+type TOCEntry struct {
+	Text   string
+	Anchor string
+	Depth  int
+}
+
+// tableOfContents returns the headings of a rendered page, none when it has fewer
+// than tocMinHeadings.
+func tableOfContents(rendered []byte) []TOCEntry {
+	matches := renderedHeading.FindAllSubmatch(rendered, -1)
+	if len(matches) < tocMinHeadings {
+		return nil
+	}
+	top := 6
+	for _, match := range matches {
+		top = min(top, int(match[1][0]-'0'))
+	}
+	entries := make([]TOCEntry, len(matches))
+	for idx, match := range matches {
+		text := html.UnescapeString(htmlTagPattern.ReplaceAllString(string(match[3]), ""))
+		entries[idx] = TOCEntry{Text: text, Anchor: string(match[2]), Depth: int(match[1][0]-'0') - top}
+	}
+	return entries
+}
+
+// wikiLink matches [[Title]] and [[Title#Heading]], where the title is a slug or
+// a display title like [[Go Routines]], and either with the text to show for it,
+// like [[Title|the other page]].
+var wikiLink = regexp.MustCompile(`\[\[([^\]#|]+)(?:#([^\]|]+))?(?:\|([^\]]+))?\]\]`)
+
+// wikiLinkRenderer turns the [[Title]], [[Title#Heading]] and [[Title|text]]
+// links of a Markdown body into Markdown links. A section link points at the
+// heading's anchor on the target page, or at the top of the page when it has no
+// such heading.
+type wikiLinkRenderer struct {
+	wiki *Wiki
+}
+
+func (renderer wikiLinkRenderer) Render(body []byte) []byte {
+	return wikiLink.ReplaceAllFunc(body, func(link []byte) []byte {
+		match := wikiLink.FindSubmatch(link)
+		label, heading := strings.TrimSpace(string(match[1])), strings.TrimSpace(string(match[2]))
+		title := renderer.wiki.linkTarget(label)
+		if !validTitle.MatchString(title) {
+			return link
+		}
+		if text := strings.TrimSpace(string(match[3])); text != "" {
+			label = text
+		} else if heading != "" {
+			label += " § " + heading
+		}
+		if heading == "" {
+			return []byte(fmt.Sprintf("[%s](/view/%s)", label, title))
+		}
+		if target, err := renderer.wiki.load(title); err == nil {
+			if anchor := headingAnchor(heading); headingAnchors(target.Body)[anchor] {
+				return []byte(fmt.Sprintf("[%s](/view/%s#%s)", label, title, anchor))
+			}
+		}
+		return []byte(fmt.Sprintf("[%s](/view/%s)", label, title))
+	})
+}
+
+// missingLinkMarker marks the links to pages that don't exist yet with the class
+// "new", which the view page shows in red, and the title "create this page":
+// following one opens the editor on it.
+type missingLinkMarker struct {
+	wiki *Wiki
+}
+
+func (renderer missingLinkMarker) Render(body []byte) []byte {
+	return renderer.wiki.viewLinkPattern.ReplaceAllFunc(body, func(link []byte) []byte {
+		match := renderer.wiki.viewLinkPattern.FindSubmatch(link)
+		if renderer.wiki.titles.Has(string(match[1])) {
+			return link
+		}
+		return []byte(fmt.Sprintf(`<a href="%s/view/%s%s" class="new" title="create this page">%s</a>`, renderer.wiki.config.BasePath, match[1], match[2], match[3]))
+	})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	readHeaderTimeout = 5 * time.Second
+	readTimeout       = 30 * time.Second
+	// writeTimeout leaves room for the synchronous matrix computations; the
+	// event streams of background jobs lift it for their own responses.
+	writeTimeout = 2 * time.Minute
+	idleTimeout  = 2 * time.Minute
+	// shutdownTimeout is how long requests in flight, and the page writes they
+	// make, get to finish once the server is asked to stop.
+	shutdownTimeout = 15 * time.Second
+)
+
+// newRouter returns the handler of everything the wiki serves from one mux: its
+// pages, its API and the modules mounted next to them, see registerRoutes.
+// Every request gets an ID and its author, is logged, and a panic in a handler
+// is answered with a 500 rather than a dropped connection. Requests over the
+// limits of -limit wait their turn, and saves past -max-page-bytes are refused.
+// A private wiki lets only the clients of -allow-ips or -basic-auth in. ctx is
+// the lifetime of the server, the background jobs of the modules stop with it.
+func (wiki *Wiki) newRouter(ctx context.Context) http.Handler {
+	mux := http.NewServeMux()
+	wiki.registerRoutes(ctx, mux)
+	return chain(mux, wiki.withBasePath, wiki.withForwardedClient, withRequestID, wiki.withAuthor, wiki.withAuditClient, wiki.withTheme, wiki.withLocale, wiki.logRequests, wiki.restrictAccess, compressResponses, wiki.recoverPanics, wiki.holdWritesWhileScanning, wiki.limitWrites, wiki.limitConcurrency, wiki.limitBodies, wiki.checkCSRF, wiki.checkAccess)
+}
+
+// newServer returns the wiki's http.Server for the configured address, serving
+// for the lifetime of ctx.
+func (wiki *Wiki) newServer(ctx context.Context) *http.Server {
+	return &http.Server{
+		Addr:              wiki.config.Addr,
+		Handler:           wiki.newRouter(ctx),
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+}
+
+// Run serves the wiki until ctx is done, then shuts the server down gracefully:
+// it stops accepting connections and waits up to shutdownTimeout for the
+// requests in flight to finish. setup must have been called first. With a
+// certificate, or -autocert, it serves HTTPS, and -http-addr redirects to it.
+// With -wikis it hosts the wikis of the file too, see Tenant.
+func (wiki *Wiki) Run(ctx context.Context) error {
+	var tenants []*Tenant
+	if wiki.config.Wikis != "" {
+		var err error
+		if tenants, err = wiki.openTenants(ctx); err != nil {
+			return err
+		}
+	}
+	tlsConfig, httpHandler, err := wiki.serverTLS()
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", wiki.config.Addr)
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	if wiki.config.HTTPAddr != "" {
+		go wiki.serveHTTP(ctx, httpHandler)
+	}
+	wiki.startJobs(ctx)
+	server := wiki.newServer(ctx)
+	if len(tenants) > 0 {
+		// the wikis are reached past the host's own middleware, but for its
+		// private access
+		server.Handler = chain(routeTenants(tenants, server.Handler), wiki.withForwardedClient, wiki.restrictAccess)
+	}
+	err = wiki.serve(ctx, server, listener)
+	// the wikis' requests were the host server's, they're done with it
+	for _, tenant := range tenants {
+		if closeErr := tenant.close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// startJobs starts the background jobs of the wiki, which stop with ctx.
+func (wiki *Wiki) startJobs(ctx context.Context) {
+	go wiki.runDigests(ctx)
+	go wiki.runGardenReports(ctx)
+	go wiki.runTrashPurge(ctx)
+	go wiki.runBackups(ctx)
+	go wiki.runViewsFlush(ctx)
+}
+
+func (wiki *Wiki) serve(ctx context.Context, server *http.Server, listener net.Listener) error {
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+	log.Printf("serving the wiki on %s", listener.Addr())
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+	log.Print("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return wiki.close()
+}
+
+// close closes what the wiki holds open once its server shut down.
+func (wiki *Wiki) close() error {
+	// the live updates' sockets were hijacked, the server doesn't close them
+	wiki.liveUpdates.closeAll()
+	if err := wiki.flushViews(); err != nil {
+		log.Printf("could not write the view counts: %v", err)
+	}
+	// Every page write has finished with its request; stores holding files
+	// open, like the bolt store, can now flush and close them.
+	if closer, ok := wiki.store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
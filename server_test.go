@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunShutsDownWhenCancelled(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	previous := testWiki.config.Addr
+	testWiki.config.Addr = addr
+	defer func() { testWiki.config.Addr = previous }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ran := make(chan error, 1)
+	go func() {
+		ran <- testWiki.Run(ctx)
+	}()
+
+	client := &http.Client{Timeout: time.Second}
+	var response *http.Response
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if response, err = client.Get("http://" + addr + "/healthz"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the server never answered: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("GET /healthz: status %d, want %d", response.StatusCode, http.StatusOK)
+	}
+
+	cancel()
+	select {
+	case err := <-ran:
+		if err != nil {
+			t.Errorf("Run returned %v after its context was cancelled, want nil", err)
+		}
+	case <-time.After(shutdownTimeout + time.Second):
+		t.Fatal("Run didn't return once its context was cancelled")
+	}
+	if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		conn.Close()
+		t.Errorf("the server still accepts connections on %s after shutting down", addr)
+	}
+}
+
+func TestMatmulAPIAlias(t *testing.T) {
+	router := testWiki.newRouter(context.Background())
+	for _, path := range []string{"/api/v1/matrix/matmul", "/api/matmul"} {
+		request := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"a": [[1, 2], [3, 4]], "b": [[5], [6]]}`))
+		request.Header.Set("Content-Type", "application/json")
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		if response.Code != http.StatusOK || !strings.Contains(response.Body.String(), `"product":[[17],[39]]`) {
+			t.Errorf("POST %s = %d %s, want the product [[17],[39]]", path, response.Code, response.Body)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	sessionCookie = "gowiki_session"
+	// maxSessions bounds how many sessions are remembered; the least recently seen
+	// are forgotten first.
+	maxSessions = 10000
+	// maxEditTokens bounds how many edit tokens a session holds; the oldest are
+	// forgotten first.
+	maxEditTokens = 50
+)
+
+var recentViewsLimit = flag.Int("recent-views", 10, "how many recently viewed pages are remembered per session")
+
+type session struct {
+	lastSeen    time.Time
+	recentViews []string // most recent first
+	editTokens  []editToken
+}
+
+// editToken is issued with every edit form the session is shown, and spent when
+// the form is saved: a second submission of the same form, like a resubmitted
+// POST, spends it again and is ignored.
+type editToken struct {
+	token string
+	title string
+	spent bool
+}
+
+// sessionStore keeps per-visitor state in memory, keyed by an anonymous session
+// cookie, until the wiki has accounts.
+type sessionStore struct {
+	wiki     *Wiki
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// sessionID returns the id from the request's session cookie, setting a new
+// cookie when there is none.
+func sessionID(w http.ResponseWriter, r *http.Request) string {
+	if cookie, err := r.Cookie(sessionCookie); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	cookie := &http.Cookie{Name: sessionCookie, Value: ids.Token(16), Path: "/", HttpOnly: true, Secure: isSecure(r), SameSite: http.SameSiteLaxMode}
+	http.SetCookie(w, cookie)
+	// the rest of the request is in the new session too
+	r.AddCookie(cookie)
+	return cookie.Value
+}
+
+// get returns the session with the id, starting it if it's new; it must be called
+// with s.mu held.
+func (s *sessionStore) get(id string) *session {
+	sess := s.sessions[id]
+	if sess == nil {
+		s.forgetOldSessions()
+		sess = &session{}
+		s.sessions[id] = sess
+	}
+	sess.lastSeen = clock.Now()
+	return sess
+}
+
+// recordView moves title to the front of the session's recently viewed pages.
+func (s *sessionStore) recordView(id, title string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess := s.get(id)
+	views := []string{title}
+	for _, viewed := range sess.recentViews {
+		if viewed != title && len(views) < *recentViewsLimit {
+			views = append(views, viewed)
+		}
+	}
+	sess.recentViews = views
+}
+
+// recentViews returns the session's recently viewed pages that still exist, most
+// recent first.
+func (s *sessionStore) recentViews(id string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess := s.sessions[id]
+	if sess == nil {
+		return nil
+	}
+	var views []string
+	for _, title := range sess.recentViews {
+		if s.wiki.titles.Has(title) {
+			views = append(views, title)
+		}
+	}
+	return views
+}
+
+// issueEditToken returns a new token for an edit form of the page.
+func (s *sessionStore) issueEditToken(id, title string) string {
+	token := ids.Token(16)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess := s.get(id)
+	sess.editTokens = append(sess.editTokens, editToken{token: token, title: title})
+	if len(sess.editTokens) > maxEditTokens {
+		sess.editTokens = sess.editTokens[len(sess.editTokens)-maxEditTokens:]
+	}
+	return sess.editTokens[len(sess.editTokens)-1].token
+}
+
+// editTokenSpent reports whether the edit form of the page with the token was
+// saved already. A token the session doesn't know, like one issued before a
+// restart, isn't.
+func (s *sessionStore) editTokenSpent(id, token, title string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, issued := range s.get(id).editTokens {
+		if issued.token == token && issued.title == title {
+			return issued.spent
+		}
+	}
+	return false
+}
+
+// spendEditToken records that the edit form with the token has been saved.
+func (s *sessionStore) spendEditToken(id, token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess := s.get(id)
+	for idx := range sess.editTokens {
+		if sess.editTokens[idx].token == token {
+			sess.editTokens[idx].spent = true
+		}
+	}
+}
+
+// forgetOldSessions must be called with s.mu held.
+func (s *sessionStore) forgetOldSessions() {
+	if len(s.sessions) < maxSessions {
+		return
+	}
+	var oldestID string
+	var oldest time.Time
+	for id, sess := range s.sessions {
+		if oldestID == "" || sess.lastSeen.Before(oldest) {
+			oldestID, oldest = id, sess.lastSeen
+		}
+	}
+	delete(s.sessions, oldestID)
+}
+
+// recentViewsHandler serves GET /api/v1/recent-views with the titles the session
+// viewed last, most recent first.
+func (wiki *Wiki) recentViewsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	views := wiki.sessions.recentViews(sessionID(w, r))
+	if views == nil {
+		views = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"recentViews": views})
+}
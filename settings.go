@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Settings are the per-namespace behaviour of the wiki. Every field is optional: a
+// namespace only overrides what it sets, and inherits the rest from its parent
+// namespaces, up to the root namespace "".
+type Settings struct {
+	Theme    string `json:"theme,omitempty"`
+	ReadOnly *bool  `json:"readOnly,omitempty"`
+	// DefaultTemplate is the title of the page whose body new pages start with.
+	DefaultTemplate string `json:"defaultTemplate,omitempty"`
+	// Editors, when set, are the only authors allowed to change pages; admins
+	// always are.
+	Editors []string `json:"editors,omitempty"`
+	// AttachmentTypes are the MIME types attachments may have, as sniffed from
+	// their content; "image/*" allows every image type.
+	AttachmentTypes []string `json:"attachmentTypes,omitempty"`
+	// MaxAttachmentBytes bounds the size of an attachment, up to the 32MB of
+	// any upload, and MaxAttachments the number of attachments of a page.
+	MaxAttachmentBytes int64 `json:"maxAttachmentBytes,omitempty"`
+	MaxAttachments     int   `json:"maxAttachments,omitempty"`
+	// MaxPageBytes bounds the body of a page, up to -max-page-bytes, and
+	// PageQuotaBytes the bytes its body and attachments take together.
+	MaxPageBytes   int64 `json:"maxPageBytes,omitempty"`
+	PageQuotaBytes int64 `json:"pageQuotaBytes,omitempty"`
+}
+
+func (wiki *Wiki) loadSettings() error {
+	data, err := os.ReadFile(wiki.dataPath("settings.json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &wiki.namespaceSettings)
+}
+
+// namespaceOf returns the namespace of a title, the part before its last "/".
+func namespaceOf(title string) string {
+	if idx := strings.LastIndex(title, "/"); idx >= 0 {
+		return title[:idx]
+	}
+	return ""
+}
+
+// settingsFor resolves the settings of a page: the root namespace's settings,
+// overridden by those of every namespace on the way down to the page's own.
+func (wiki *Wiki) settingsFor(title string) Settings {
+	namespaces := []string{""}
+	if namespace := namespaceOf(title); namespace != "" {
+		parts := strings.Split(namespace, "/")
+		for idx := range parts {
+			namespaces = append(namespaces, strings.Join(parts[:idx+1], "/"))
+		}
+	}
+	var resolved Settings
+	for _, namespace := range namespaces {
+		override, ok := wiki.namespaceSettings[namespace]
+		if !ok {
+			continue
+		}
+		if override.Theme != "" {
+			resolved.Theme = override.Theme
+		}
+		if override.ReadOnly != nil {
+			resolved.ReadOnly = override.ReadOnly
+		}
+		if override.DefaultTemplate != "" {
+			resolved.DefaultTemplate = override.DefaultTemplate
+		}
+		if override.Editors != nil {
+			resolved.Editors = override.Editors
+		}
+		if override.AttachmentTypes != nil {
+			resolved.AttachmentTypes = override.AttachmentTypes
+		}
+		if override.MaxAttachmentBytes != 0 {
+			resolved.MaxAttachmentBytes = override.MaxAttachmentBytes
+		}
+		if override.MaxAttachments != 0 {
+			resolved.MaxAttachments = override.MaxAttachments
+		}
+		if override.MaxPageBytes != 0 {
+			resolved.MaxPageBytes = override.MaxPageBytes
+		}
+		if override.PageQuotaBytes != 0 {
+			resolved.PageQuotaBytes = override.PageQuotaBytes
+		}
+	}
+	return resolved
+}
+
+func (s Settings) isReadOnly() bool {
+	return s.ReadOnly != nil && *s.ReadOnly
+}
+
+// canEdit reports whether the requester may change the page, and why not.
+func (wiki *Wiki) canEdit(r *http.Request, title string) (bool, string) {
+	if wiki.isAdmin(r) {
+		return true, ""
+	}
+	if wiki.readOnly.Load() {
+		return false, "the wiki is read-only"
+	}
+	if wiki.currentUser(r) == "" {
+		return false, "log in to edit " + title
+	}
+	if owner, ok := sandboxOwner(title); ok && wiki.currentUser(r) != owner {
+		return false, title + " is in the sandbox of " + owner
+	}
+	if wiki.roleOf(wiki.currentACL(), wiki.currentUser(r), title) < roleEditor {
+		return false, "you are not allowed to edit " + title
+	}
+	settings := wiki.settingsFor(title)
+	if settings.isReadOnly() {
+		return false, title + " is read-only"
+	}
+	if settings.Editors == nil {
+		return true, ""
+	}
+	author := wiki.requestAuthor(r)
+	for _, editor := range settings.Editors {
+		if editor == author {
+			return true, ""
+		}
+	}
+	return false, "you are not allowed to edit " + title
+}
+
+// themeOf is the "theme" template function: the theme of a page, "default" when
+// none is set.
+func (wiki *Wiki) themeOf(title string) string {
+	if theme := wiki.settingsFor(title).Theme; theme != "" {
+		return theme
+	}
+	return "default"
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// sitemapURL is a page of the sitemap: where it is and when it last changed.
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// buildSitemap makes the sitemap of the pages listed for everyone, each dated by
+// when the store last wrote it, or by its last revision for a store that
+// doesn't know.
+func (wiki *Wiki) buildSitemap() ([]byte, error) {
+	base := strings.TrimSuffix(wiki.config.BaseURL, "/")
+	set := sitemapURLSet{URLs: []sitemapURL{{Loc: base + "/"}}}
+	acl := wiki.currentACL()
+	for _, entry := range wiki.pageIndex.all() {
+		if !wiki.everyone.listsWith(acl, entry.Title) {
+			continue
+		}
+		page := sitemapURL{Loc: base + "/view/" + entry.Title}
+		modified := wiki.pageModTime(entry.Title)
+		if modified.IsZero() {
+			modified = entry.Modified
+		}
+		if !modified.IsZero() {
+			page.LastMod = modified.UTC().Format(time.RFC3339)
+		}
+		set.URLs = append(set.URLs, page)
+	}
+	data, err := xml.Marshal(set)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// sitemapHandler serves /sitemap.xml, the pages search engines may index.
+func (wiki *Wiki) sitemapHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	version := wiki.pageIndex.currentVersion()
+	wiki.sitemap.Lock()
+	if wiki.sitemap.data == nil || wiki.sitemap.version != version {
+		data, err := wiki.buildSitemap()
+		if err != nil {
+			wiki.sitemap.Unlock()
+			wiki.serveError(w, err)
+			return
+		}
+		wiki.sitemap.data, wiki.sitemap.version = data, version
+	}
+	data := wiki.sitemap.data
+	wiki.sitemap.Unlock()
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write(data)
+}
+
+// robotsDisallowed are the paths of the default robots.txt that crawlers stay
+// out of: the forms, the histories and diffs, the searches and the API, which
+// are many pages for every page of the wiki, and the admins' own.
+var robotsDisallowed = []string{"/edit/", "/save/", "/history/", "/diff/", "/revert/", "/delete/", "/rename/",
+	"/preview/", "/undo/", "/comment/", "/print/", "/search", "/api/", "/admin/", "/trash", "/login", "/export", "/import"}
+
+// robotsHandler serves /robots.txt: the file of -robots, or one keeping crawlers
+// to the pages themselves and pointing them at the sitemap.
+func (wiki *Wiki) robotsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if wiki.config.Robots != "" {
+		data, err := os.ReadFile(wiki.config.Robots)
+		if err != nil {
+			wiki.serveError(w, err)
+			return
+		}
+		w.Write(data)
+		return
+	}
+	var robots bytes.Buffer
+	robots.WriteString("User-agent: *\n")
+	for _, path := range robotsDisallowed {
+		robots.WriteString("Disallow: " + path + "\n")
+	}
+	robots.WriteString("\nSitemap: " + strings.TrimSuffix(wiki.config.BaseURL, "/") + "/sitemap.xml\n")
+	w.Write(robots.Bytes())
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A title is a slug: words of Unicode letters and digits joined by hyphens, the
+// form used in URLs, file names and links. The title as it was typed, with its
+// spaces and punctuation, is kept in the page's metadata as its display title.
+const pageNamePattern = `[\p{L}\p{M}\p{N}]+(?:-[\p{L}\p{M}\p{N}]+)*`
+
+func isSlugRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsNumber(r)
+}
+
+// slugify returns the slug of a display title: lower case, with every run of
+// spaces, hyphens and punctuation a single hyphen, so "How to deploy GoWiki" is
+// how-to-deploy-gowiki. A sandbox's ~name/ prefix is kept, and the namespaces
+// before slashes are slugs of their own: "Projects/Go Wiki" is projects/go-wiki.
+func slugify(display string) string {
+	namespace := ""
+	if owner, page, ok := strings.Cut(display, "/"); ok && strings.HasPrefix(owner, sandboxPrefix) {
+		namespace, display = owner+"/", page
+	}
+	var segments []string
+	for _, segment := range strings.Split(display, "/") {
+		words := strings.FieldsFunc(strings.ToLower(segment), func(r rune) bool { return !isSlugRune(r) })
+		if len(words) > 0 {
+			segments = append(segments, strings.Join(words, "-"))
+		}
+	}
+	return namespace + strings.Join(segments, "/")
+}
+
+// linkTarget returns the title a link or redirect names: the page with that
+// display title, the title itself when it's one already, like donaldTrump, and
+// otherwise its slug.
+func (wiki *Wiki) linkTarget(text string) string {
+	text = strings.TrimSpace(text)
+	if title := wiki.titles.Resolve(text); title != text || validTitle.MatchString(text) {
+		return title
+	}
+	return slugify(text)
+}
+
+// uniqueSlug returns slug, or when a page has it already the first of slug-2,
+// slug-3, ... that's free.
+func (wiki *Wiki) uniqueSlug(slug string) string {
+	candidate := slug
+	for n := 2; wiki.titles.Has(candidate); n++ {
+		candidate = fmt.Sprintf("%s-%d", slug, n)
+	}
+	return candidate
+}
+
+// slugMatches reports whether title is the slug of display, ignoring case, or
+// that slug made unique with a numeric suffix.
+func slugMatches(title, display string) bool {
+	slug := slugify(display)
+	if strings.EqualFold(slug, title) {
+		return true
+	}
+	if len(title) <= len(slug)+1 || !strings.EqualFold(title[:len(slug)+1], slug+"-") {
+		return false
+	}
+	_, err := strconv.Atoi(title[len(slug)+1:])
+	return err == nil
+}
+
+// displayTitle returns the human-readable title of a page, its slug when it
+// doesn't have a display title.
+func (wiki *Wiki) displayTitle(title string) string {
+	if display := wiki.metadataOf(title).DisplayTitle; display != "" {
+		return display
+	}
+	return title
+}
+
+// checkDisplayTitle normalizes the display title given to the page title. It
+// returns the display title to store, "" when it's the title itself, and the
+// title the page goes under: its own, unless the words of the display title
+// changed and give it a new slug. It fails for a display title without a word.
+func checkDisplayTitle(title, display string) (string, string, bool) {
+	display = strings.Join(strings.Fields(display), " ")
+	switch {
+	case display == "" || display == title:
+		return "", title, true
+	case slugMatches(title, display):
+		return display, title, true
+	}
+	slug := slugify(display)
+	return display, slug, validTitle.MatchString(slug)
+}
+
+// moveToSlug moves the page, with its metadata and attachments, from its slug to
+// the slug of its new display title. The old slug becomes a redirect, so links and
+// bookmarks to it keep working.
+func (wiki *Wiki) moveToSlug(from, to, author string, body []byte, metadata PageMetadata) error {
+	if err := wiki.savePage(&Page{Title: to, Body: body}, author); err != nil {
+		return err
+	}
+	if err := wiki.setMetadata(to, metadata); err != nil {
+		return err
+	}
+	if err := wiki.moveAttachments(from, to); err != nil {
+		return err
+	}
+	if err := wiki.savePage(&Page{Title: from, Body: []byte("#REDIRECT " + to + "\n")}, author); err != nil {
+		return err
+	}
+	return wiki.setMetadata(from, PageMetadata{})
+}
+
+// redirectToSlug sends a GET for a path with a display title, like
+// /edit/Go Routines, to the same action on its slug. The display title is carried
+// to the edit form, which stores it with the page.
+func (wiki *Wiki) redirectToSlug(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	action, raw, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	slug := slugify(raw)
+	if !ok || slug == raw || !validPath.MatchString("/"+action+"/"+slug) {
+		return false
+	}
+	// a new page doesn't take over the slug of another page
+	if action == "edit" && wiki.titles.Has(slug) && wiki.displayTitle(slug) != raw {
+		slug = wiki.uniqueSlug(slug)
+	}
+	target := "/" + action + "/" + slug
+	if action == "edit" {
+		target += "?title=" + url.QueryEscape(raw)
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+	return true
+}
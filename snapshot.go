@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// maxEmbeddedImageBytes bounds each image inlined into a snapshot; bigger
+	// images keep their link.
+	maxEmbeddedImageBytes = 2 << 20
+	// imageFetchTimeout bounds the fetching of all the images of a snapshot.
+	imageFetchTimeout = 10 * time.Second
+)
+
+// SnapshotPage is the data of the snapshot template.
+type SnapshotPage struct {
+	Title    string
+	Body     template.HTML
+	Source   string
+	Exported time.Time
+	Revision int
+	// Standalone is a file of its own, with its images inlined, rather than
+	// a page in the browser of the reader of the wiki.
+	Standalone bool
+}
+
+var imageSource = regexp.MustCompile(`<img src="([^"]*)"`)
+
+// embedImages replaces the source of every image of the rendered body with a
+// data URI of its content, fetched relative to base. Images that can't be fetched,
+// aren't images or are too big keep their source.
+func embedImages(ctx context.Context, body string, base *url.URL) string {
+	ctx, cancel := context.WithTimeout(ctx, imageFetchTimeout)
+	defer cancel()
+	return imageSource.ReplaceAllStringFunc(body, func(image string) string {
+		src := html.UnescapeString(imageSource.FindStringSubmatch(image)[1])
+		location, err := base.Parse(src)
+		if err != nil || (location.Scheme != "http" && location.Scheme != "https") {
+			return image
+		}
+		dataURI, err := fetchDataURI(ctx, location.String())
+		if err != nil {
+			return image
+		}
+		return `<img src="` + dataURI + `"`
+	})
+}
+
+func fetchDataURI(ctx context.Context, location string) (string, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return "", err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", location, response.Status)
+	}
+	mediaType, _, err := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "image/") {
+		return "", fmt.Errorf("%s is not an image", location)
+	}
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxEmbeddedImageBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxEmbeddedImageBytes {
+		return "", fmt.Errorf("%s is bigger than %d bytes", location, maxEmbeddedImageBytes)
+	}
+	return "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// snapshotHandler serves GET /export/{title}.html: the page as one self-contained
+// HTML file, to mail or archive. Macros are expanded, links point back to the wiki
+// with absolute URLs, styles are inline and images embedded as data URIs. GET
+// /export/{title}.pdf is pdfHandler's.
+func (wiki *Wiki) snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if title, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/export/"), ".pdf"); ok && validTitle.MatchString(title) {
+		wiki.pdfHandler(w, r, title)
+		return
+	}
+	title, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/export/"), ".html")
+	if !ok || !validTitle.MatchString(title) {
+		wiki.serveError(w, notFound(""))
+		return
+	}
+	out, err := wiki.renderSnapshot(r, title, "snapshot.html", true)
+	if errors.Is(err, fs.ErrNotExist) {
+		wiki.serveError(w, notFound(""))
+		return
+	}
+	if err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+strings.ReplaceAll(title, "/", "_")+`.html"`)
+	w.Write(out)
+}
+
+// renderSnapshot renders the page with the template, its links pointing back
+// to the wiki as the request reached it, and with embed its images inlined.
+// There's no such page when the error is fs.ErrNotExist.
+func (wiki *Wiki) renderSnapshot(r *http.Request, title, templateFilename string, embed bool) ([]byte, error) {
+	page, err := wiki.load(title)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", title, fs.ErrNotExist)
+	}
+	scheme := "http"
+	if isSecure(r) {
+		scheme = "https"
+	}
+	base := &url.URL{Scheme: scheme, Host: r.Host, Path: wiki.wikiPath("/view/" + title)}
+	body := wiki.viewLinkPattern.ReplaceAllStringFunc(string(wiki.rendererFor(title, false).Render(wiki.embedAttachments(title, page.Body))), func(link string) string {
+		match := wiki.viewLinkPattern.FindStringSubmatch(link)
+		return fmt.Sprintf(`<a href="%s://%s%s/view/%s%s">%s</a>`, scheme, r.Host, wiki.config.BasePath, match[1], match[2], match[3])
+	})
+	revisions, err := wiki.loadRevisions(title)
+	if err != nil {
+		return nil, err
+	}
+	if embed {
+		body = embedImages(r.Context(), body, base)
+	}
+	snapshot := SnapshotPage{
+		Title:    title,
+		Body:     template.HTML(body),
+		Source:   base.String(),
+		Exported: clock.Now().UTC(),
+		Revision: len(revisions),
+		// the PDF is printed from the page as a file
+		Standalone: embed,
+	}
+	current, err := wiki.currentTemplates(wiki.config.Theme, wiki.config.Locale)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := current.ExecuteTemplate(&out, templateFilename, snapshot); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// scanReportInterval is how often the startup scan logs how far it got.
+const scanReportInterval = 2 * time.Second
+
+type pageScan struct {
+	wiki           *Wiki
+	total, scanned atomic.Int64
+	done           chan struct{}
+	// err is why the metadata couldn't be read, once done is closed.
+	err error
+}
+
+// run scans the titles with workers at once, and closes done.
+func (scan *pageScan) run(titles []string, workers int) {
+	defer close(scan.done)
+	start := time.Now()
+	scan.total.Store(int64(len(titles)))
+	if err := scan.wiki.loadMetadata(); err != nil {
+		scan.err = fmt.Errorf("could not read the page metadata: %w", err)
+		log.Print(scan.err)
+	}
+	stopReports := make(chan struct{})
+	go scan.report(stopReports)
+	defer close(stopReports)
+
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	for worker := 0; worker < max(workers, 1); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for title := range queue {
+				if page, err := scan.wiki.peek(title); err == nil {
+					scan.wiki.searchIndex.update(page)
+					scan.wiki.pageIndex.indexEntry(page)
+					scan.wiki.backlinks.update(page)
+				}
+				scan.scanned.Add(1)
+			}
+		}()
+	}
+	for _, title := range titles {
+		queue <- title
+	}
+	close(queue)
+	wg.Wait()
+	log.Printf("scanned %d pages in %v", len(titles), time.Since(start).Round(time.Millisecond))
+}
+
+// report logs the progress of the scan every scanReportInterval until stop.
+func (scan *pageScan) report(stop chan struct{}) {
+	ticker := time.NewTicker(scanReportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			log.Printf("scanning the pages: %s", scan.progress())
+		}
+	}
+}
+
+func (scan *pageScan) progress() string {
+	return fmt.Sprintf("%d of %d pages", scan.scanned.Load(), scan.total.Load())
+}
+
+// finished reports whether the scan is done.
+func (scan *pageScan) finished() bool {
+	select {
+	case <-scan.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait waits for the scan to be done.
+func (scan *pageScan) wait() {
+	<-scan.done
+}
+
+// check is the "scan" check of /readyz.
+func (scan *pageScan) check() error {
+	if !scan.finished() {
+		return fmt.Errorf("scanning the pages, %s so far", scan.progress())
+	}
+	return scan.err
+}
+
+// holdWritesWhileScanning answers the requests that may change a page with 503
+// and a Retry-After until the startup scan is done, and while a reindex runs.
+// Logging in and out doesn't.
+func (wiki *Wiki) holdWritesWhileScanning(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions,
+			r.URL.Path == "/login" || r.URL.Path == "/logout" || r.URL.Path == "/theme" || r.URL.Path == "/locale",
+			wiki.startupScan.finished() && !wiki.reindexing.Load():
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", "1")
+		if wiki.reindexing.Load() {
+			http.Error(w, translate(wiki.requestLocale(w), "error.reindexing"), http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, translate(wiki.requestLocale(w), "error.starting", wiki.startupScan.progress()), http.StatusServiceUnavailable)
+	})
+}
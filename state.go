@@ -0,0 +1,280 @@
+package main
+
+import (
+	"html/template"
+	"net/netip"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Wiki is a wiki the server serves, the one the flags open or one of -wikis, with
+// its own configuration and the state of its pages, users and indexes. What the
+// wikis share, like the clock, the catalogs and the limits of the requests, stays
+// in the package.
+type Wiki struct {
+	// accessControl holds the rules of the ACL page, which are read again whenever
+	// it changed since.
+	accessControl struct {
+		sync.Mutex
+		body string
+		acl  accessList
+	}
+	// attachments is the index of every page's attachments, by file name, to the hash
+	// of their content, and of the blobs. Each page's attachments are kept in its
+	// manifest, attachments/pages/{title}.json, and the blobs with their references
+	// in attachments/blobs.json, so attaching to a page, or renaming it, writes only
+	// what changed.
+	attachments struct {
+		sync.Mutex
+		Pages map[string]map[string]string `json:"pages"`
+		Blobs map[string]*blob             `json:"blobs"`
+	}
+	// auditFile serializes the appends to the audit trail, which is only ever
+	// appended to and synced as it's written.
+	auditFile sync.Mutex
+	// auditClients are the addresses of the writes in flight, by their author, for
+	// the audit trail to tell the changes they make where they came from: the page
+	// hooks are told of the changes, not of the requests making them. Of an author
+	// writing from two addresses at once, the latest is taken; the changes of the
+	// commands have no address.
+	auditClients struct {
+		sync.Mutex
+		byAuthor map[string]*auditClient
+	}
+	usersMu sync.Mutex
+	users   map[string]User
+	// logins maps the SHA-256 of the token of the auth cookie, see loginKey, to the
+	// logged in user. They are stored in logins.json in the data directory, next to
+	// users.json, so a restart logs no one out; the expired ones are swept as users
+	// log in.
+	logins struct {
+		sync.Mutex
+		byToken map[string]login
+	}
+	// backups is the state of the backups, for the admins' status page: when the
+	// last one was made, or failed, and whether one is being made.
+	backups struct {
+		sync.Mutex
+		last    time.Time
+		lastErr error
+		running bool
+	}
+	bannerMu      sync.Mutex
+	banner        *Banner
+	templateFuncs template.FuncMap
+	// pageCache is the cache of the store; nil with -cache-pages=0.
+	pageCache *cachedStore
+	// The discussion of a page is kept as comments/{title}.json in the data
+	// directory, apart from the page, so that it doesn't fill up its history.
+	// comments serialises the changes of the files.
+	comments sync.Mutex
+	// config is the configuration of the wiki, which it keeps to itself.
+	config Config
+	// editsMu serializes checking an edit's base against the stored page with the save
+	// that follows, so two edits of the same version can't both be saved.
+	editsMu sync.Mutex
+	// digests are kept per user in digests.json in the data directory.
+	digests struct {
+		sync.Mutex
+		byUser map[string]DigestPreferences
+	}
+	// absoluteLink matches the links and images of a rendered page to the server's
+	// own paths, which don't exist on a static site, with their -base-path left out;
+	// checkBasePath compiles it for the wiki's.
+	absoluteLink *regexp.Regexp
+	// pageFormats are the formats by name. A page picks its format in its metadata;
+	// a page without one is rendered in the wiki's -format.
+	pageFormats map[string]PageFormat
+	// garden holds the reports the scheduler compiled last.
+	garden struct {
+		sync.Mutex
+		report *GardenReport
+	}
+	// graphqlSchema is the schema of /graphql, built on what the pages API and the
+	// pages of the wiki show.
+	graphqlSchema   *gqlSchema
+	readinessChecks []readinessCheck
+	// highlight is the highlighter of the fenced code blocks, nil for none.
+	highlight Highlighter
+	// pageHooks are the registered hooks, starting with those every wiki has.
+	pageHooks []PageHook
+	// The journal is journal.jsonl in the data directory, one entry per line, synced
+	// as it's written. The commands, like replace, write to it too: an entry takes
+	// the number after the last one in the file, which is read again whenever the
+	// file grew behind this process's back.
+	journalFile struct {
+		sync.Mutex
+		seq  uint64
+		size int64 // of the file after the last entry this process wrote, -1 before
+	}
+	// viewLinkPattern matches the links the renderer makes to other wiki pages, and
+	// their sections: the title, the "#anchor" if any, and the link text. The other
+	// attributes of a link, like the class of one to a missing page, are left out.
+	// The links start with -base-path; checkBasePath compiles it for the wiki's.
+	viewLinkPattern *regexp.Regexp
+	backlinks       *linkIndex
+	// dictionary is the set of the words of -dictionary, lowercased; nil when there's
+	// none, and the words aren't checked.
+	dictionary map[string]bool
+	// liveUpdates are the viewers of every page, by title.
+	liveUpdates *liveRegistry
+	// mailer is configured by -smtp-addr; without it, no mail is sent.
+	mailer Mailer
+	// notifications are kept per user in notifications.json in the data directory,
+	// oldest first.
+	notifications struct {
+		sync.Mutex
+		byUser map[string][]Notification
+	}
+	// pageMetadata holds the metadata of every page, and byTag, the index of the
+	// pages with each tag.
+	pageMetadata struct {
+		sync.RWMutex
+		byTitle map[string]PageMetadata
+		byTag   map[string]map[string]bool
+	}
+	oauthProviders     map[string]*OAuthProvider
+	oauthProviderNames []string
+	oauthLogins        struct {
+		sync.Mutex
+		byState map[string]pendingOAuth
+	}
+	// identities maps "{provider}:{subject}" to the user the identity is linked
+	// to, in identities.json in the data directory.
+	identities struct {
+		sync.Mutex
+		byKey map[string]string
+	}
+	pageIndex *pageEntries
+	// privateAccess is who may reach a private wiki at all, before its own logins:
+	// the clients of the networks of -allow-ips, and else those sending the HTTP
+	// Basic credentials of a user of -basic-auth. With -private-scope=writes the
+	// wiki is read by anybody and only its writes are guarded.
+	privateAccess struct {
+		networks []netip.Prefix
+		// credentials are the SHA-256 digests of the passwords of the users.
+		credentials map[string][]byte
+		writesOnly  bool
+	}
+	// writeBuckets are the buckets of the clients that wrote lately, by address. A
+	// bucket that has refilled is dropped, as it's the same as a new one.
+	writeBuckets struct {
+		sync.Mutex
+		byClient map[string]*writeBucket
+	}
+	// readOnly is whether the whole wiki is read-only: nobody but the admins changes
+	// a page, and the templates hide the links to edit them, so the wiki can be
+	// exposed publicly while only its admins write. It starts as -read-only and is
+	// toggled by the admins at /admin/read-only until the wiki restarts.
+	readOnly atomic.Bool
+	// reindexing is whether a reindex is running, which holds the writes off like
+	// the startup scan does.
+	reindexing atomic.Bool
+	// pageRenderer expands the macros and wiki links of the stored Markdown, renders
+	// it to HTML, marks the links to missing pages, then links the @mentions of users
+	// and the titles of other wiki pages mentioned in its text.
+	pageRenderer Renderer
+	// safeRenderer leaves out every stage that evaluates dynamic content, like the
+	// macros; it's used when a page's dynamic content is broken, or when it's embedded
+	// in a context that must not run anything. Raw HTML is escaped by either renderer.
+	safeRenderer Renderer
+	// revisionsMu serializes appends to the history files.
+	revisionsMu sync.Mutex
+	// uploadScanner is configured by -scan-command or -scan-url; without either,
+	// uploads aren't scanned.
+	uploadScanner UploadScanner
+	// searchCache remembers the results of the queries embedded in pages until a page
+	// is saved.
+	searchCache struct {
+		sync.Mutex
+		results map[string][]SearchResult
+	}
+	savedSearchesMu sync.Mutex
+	savedSearches   map[string]SavedSearch
+	searchIndex     *invertedIndex
+	sessions        *sessionStore
+	// namespaceSettings maps a namespace ("" for the root, "docs", "docs/public", ...)
+	// to its overrides. It's read from settings.json at startup.
+	namespaceSettings map[string]Settings
+	// sitemap is the last sitemap made, and the version of the page index it was
+	// made from: it's only made again once a page was saved or deleted since.
+	sitemap struct {
+		sync.Mutex
+		version uint64
+		data    []byte
+	}
+	// startupScan indexes the pages after the wiki starts serving: it reads the
+	// sidecar metadata, then every page once, with a pool of workers, for the
+	// search, page and link indexes. Listing the titles is all setup waits for, so a
+	// wiki with many pages starts at once; until the scan is done /readyz answers
+	// 503 with its progress, the listings and searches may miss pages, and writes
+	// are held off, so that a page read by the scan never overwrites a newer save
+	// in the indexes.
+	startupScan *pageScan
+	store       PageStore
+	titles      *TitleRegistry
+	// viewCounts counts the views of every page, in memory, since Since; the
+	// counts are written to views.json every viewsFlushInterval and as the server
+	// stops. Without -count-views nothing is counted.
+	viewCounts struct {
+		sync.Mutex
+		counts map[string]int64
+		since  time.Time
+		// dirty is whether a view was counted since the counts were last written.
+		dirty bool
+	}
+	// everyone is the viewer of what is shown to anybody alike.
+	everyone viewer
+	// webhooks is nil without -webhooks.
+	webhooks *webhookHook
+	// templates are those of every theme, by its name and then by locale.
+	templates map[string]map[string]*template.Template
+}
+
+// newWiki returns the wiki of the configuration, which setup then opens.
+func newWiki(config Config) *Wiki {
+	wiki := &Wiki{config: config}
+	wiki.attachments.Pages = make(map[string]map[string]string)
+	wiki.attachments.Blobs = make(map[string]*blob)
+	wiki.auditClients.byAuthor = make(map[string]*auditClient)
+	wiki.users = map[string]User{}
+	wiki.logins.byToken = make(map[string]login)
+	wiki.templateFuncs = wiki.newTemplateFuncs()
+	wiki.digests.byUser = make(map[string]DigestPreferences)
+	wiki.absoluteLink = regexp.MustCompile(`<a href="(/[^"]*)"([^>]*)>(.*?)</a>|src="(/files/[^"]*)"`)
+	wiki.pageRenderer = renderPipeline{searchMacroRenderer{wiki: wiki}, wikiLinkRenderer{wiki: wiki}, markdownRenderer{wiki: wiki}, missingLinkMarker{wiki: wiki}, mentionLinker{wiki: wiki}, autoLinker{wiki: wiki}}
+	wiki.safeRenderer = renderPipeline{wikiLinkRenderer{wiki: wiki}, markdownRenderer{wiki: wiki}, missingLinkMarker{wiki: wiki}, mentionLinker{wiki: wiki}, autoLinker{wiki: wiki}}
+	wiki.pageFormats = wiki.newPageFormats()
+	wiki.titles = NewTitleRegistry(wiki)
+	wiki.graphqlSchema = wiki.newGraphQLSchema()
+	wiki.startupScan = &pageScan{wiki: wiki, done: make(chan struct{})}
+	wiki.readinessChecks = []readinessCheck{
+		{"data", wiki.checkDataWritable},
+		{"templates", wiki.checkTemplates},
+		{"store", func() error { return pingStore(wiki.store) }},
+		{"scan", wiki.startupScan.check},
+	}
+	wiki.pageHooks = []PageHook{indexHook{wiki: wiki}, aclHook{wiki: wiki}, auditHook{wiki: wiki}, viewsHook{wiki: wiki}, watchHook{wiki: wiki}}
+	wiki.journalFile.size = -1
+	wiki.viewLinkPattern = regexp.MustCompile(`<a href="/view/([\p{L}\p{M}\p{N}/~-]+)(#[^"]*)?"(?:\s[^>]*)?>(.*?)</a>`)
+	wiki.backlinks = &linkIndex{wiki: wiki, outgoing: make(map[string][]string), incoming: make(map[string]map[string]bool)}
+	wiki.liveUpdates = &liveRegistry{viewers: make(map[string]map[*liveViewer]bool)}
+	wiki.notifications.byUser = make(map[string][]Notification)
+	wiki.pageMetadata.byTitle = make(map[string]PageMetadata)
+	wiki.pageMetadata.byTag = make(map[string]map[string]bool)
+	wiki.oauthProviders = map[string]*OAuthProvider{}
+	wiki.oauthLogins.byState = make(map[string]pendingOAuth)
+	wiki.identities.byKey = make(map[string]string)
+	wiki.pageIndex = &pageEntries{wiki: wiki, byTitle: make(map[string]PageEntry)}
+	wiki.writeBuckets.byClient = make(map[string]*writeBucket)
+	wiki.searchCache.results = make(map[string][]SearchResult)
+	wiki.savedSearches = map[string]SavedSearch{}
+	wiki.searchIndex = &invertedIndex{postings: make(map[string]map[string]int), words: make(map[string][]string)}
+	wiki.sessions = &sessionStore{wiki: wiki, sessions: make(map[string]*session)}
+	wiki.namespaceSettings = map[string]Settings{}
+	wiki.viewCounts.counts = make(map[string]int64)
+	wiki.everyone = viewer{wiki: wiki}
+	return wiki
+}
@@ -0,0 +1,65 @@
+// The service worker of the wiki: the pages read online are kept for reading
+// offline, where /offline stands in for the pages that weren't. BASE, the
+// -base-path of the wiki, is put before it as it's served, see
+// serviceWorkerHandler; the wikis of a site each keep their pages apart.
+const CACHE = "gowiki-pages-v1" + BASE;
+const PRECACHED = [BASE + "/offline", BASE + "/static/icon.svg"];
+// the caches of the versions of the worker, by the wiki they're of
+const VERSIONS = /^gowiki-pages-v\d+(.*)$/;
+
+self.addEventListener("install", event => {
+  event.waitUntil(caches.open(CACHE).then(cache => cache.addAll(PRECACHED)).then(() => self.skipWaiting()));
+});
+
+self.addEventListener("activate", event => {
+  event.waitUntil(caches.keys()
+    .then(names => Promise.all(names.filter(name => name !== CACHE && VERSIONS.exec(name)?.[1] === BASE).map(name => caches.delete(name))))
+    .then(() => self.clients.claim()));
+});
+
+// cached says whether a response is kept for offline reading: the pages,
+// the front page and what they're styled with, but not what the wiki asks to be
+// kept nowhere.
+function cached(request, response) {
+  const url = new URL(request.url);
+  if (url.origin !== self.location.origin || !response.ok || response.type !== "basic") {
+    return false;
+  }
+  if (/no-store/.test(response.headers.get("Cache-Control") || "")) {
+    return false;
+  }
+  return url.pathname === BASE + "/" || url.pathname.startsWith(BASE + "/view/") ||
+    url.pathname.startsWith(BASE + "/static/") || url.pathname.startsWith(BASE + "/themes/");
+}
+
+self.addEventListener("fetch", event => {
+  const request = event.request;
+  const url = new URL(request.url);
+  if (url.origin !== self.location.origin) {
+    return;
+  }
+  // logging out forgets the pages read logged in
+  if (request.method === "POST" && url.pathname === BASE + "/logout") {
+    event.respondWith(caches.delete(CACHE).then(() => fetch(request)));
+    return;
+  }
+  if (request.method !== "GET") {
+    return;
+  }
+  // online the wiki answers, its pages are kept as they're read
+  event.respondWith(fetch(request).then(response => {
+    if (cached(request, response)) {
+      const copy = response.clone();
+      event.waitUntil(caches.open(CACHE).then(cache => cache.put(request, copy)));
+    }
+    return response;
+  }).catch(() => caches.match(request, {ignoreSearch: url.pathname.startsWith(BASE + "/view/")}).then(response => {
+    if (response) {
+      return response;
+    }
+    if (request.mode === "navigate") {
+      return caches.match(BASE + "/offline");
+    }
+    return Response.error();
+  })));
+});
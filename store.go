@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PageStore is where the pages live. The backend is picked with the -store flag.
+type PageStore interface {
+	// Load returns the page, or an error wrapping os.ErrNotExist if there is none.
+	Load(title string) (*Page, error)
+	Save(p *Page) error
+	// List returns the titles of every page, sorted.
+	List() ([]string, error)
+	Delete(title string) error
+}
+
+// storeBackends opens a store of the named backend in the data directory.
+var storeBackends = map[string]func(dir string) (PageStore, error){
+	"file": newFileStore,
+}
+
+// A committingStore keeps who saved or deleted a page and why along with the
+// change, as the git store does in its commits. An empty author is the wiki
+// itself, an empty message the store's own.
+type committingStore interface {
+	SaveAs(p *Page, author, message string) error
+	DeleteAs(title, author, message string) error
+}
+
+// saveAs saves the page to the store, by author with the message if it keeps them.
+func saveAs(s PageStore, p *Page, author, message string) error {
+	if committing, ok := s.(committingStore); ok {
+		return committing.SaveAs(p, author, message)
+	}
+	return s.Save(p)
+}
+
+// deleteAs deletes the page from the store, by author with the message if it
+// keeps them.
+func deleteAs(s PageStore, title, author, message string) error {
+	if committing, ok := s.(committingStore); ok {
+		return committing.DeleteAs(title, author, message)
+	}
+	return s.Delete(title)
+}
+
+// A pingingStore checks that it can be reached, for the readiness probe.
+type pingingStore interface {
+	Ping() error
+}
+
+// pingStore checks that the store can be reached, by listing its pages if it
+// has no cheaper way.
+func pingStore(s PageStore) error {
+	if pinging, ok := s.(pingingStore); ok {
+		return pinging.Ping()
+	}
+	_, err := s.List()
+	return err
+}
+
+func openStore(backend, dir string) (PageStore, error) {
+	open, ok := storeBackends[backend]
+	if !ok {
+		var backends []string
+		for name := range storeBackends {
+			backends = append(backends, name)
+		}
+		sort.Strings(backends)
+		return nil, fmt.Errorf("%q is an unknown store, expected one of %s", backend, strings.Join(backends, ", "))
+	}
+	return open(dir)
+}
+
+// fileStore keeps every page as a <title>.txt file in a directory, and the pages
+// of a sandbox in its ~name subdirectory. Saves are
+// atomic: the new body is written to a temporary file that is synced and renamed
+// over the page, after the previous body was put aside the same way in
+// <title>.txt.bak, so a crash never leaves a truncated page behind.
+type fileStore struct {
+	dir string
+}
+
+func newFileStore(dir string) (PageStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	s := &fileStore{dir: dir}
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Ping checks that the directory of the pages is still there.
+func (s *fileStore) Ping() error {
+	info, err := os.Stat(s.dir)
+	if err == nil && !info.IsDir() {
+		err = fmt.Errorf("%s is not a directory", s.dir)
+	}
+	return err
+}
+
+func (s *fileStore) filename(title string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(title)+".txt")
+}
+
+// pageDirs returns the directories holding pages, by the prefix of their titles:
+// the store's directory, the sandboxes in it and the namespaces, down to the
+// innermost. The directories of the wiki's own state aren't namespaces.
+func (s *fileStore) pageDirs() (map[string]string, error) {
+	dirs := map[string]string{"": s.dir}
+	var walk func(prefix, dir string) error
+	walk = func(prefix, dir string) error {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			switch {
+			case !entry.IsDir():
+				continue
+			case prefix == "" && reservedNamespaces[name]:
+				continue
+			case prefix == "" && strings.HasPrefix(name, sandboxPrefix) && validName.MatchString(name[len(sandboxPrefix):]):
+			case !validSegment.MatchString(name):
+				continue
+			}
+			dirs[prefix+name+"/"] = filepath.Join(dir, name)
+			if err := walk(prefix+name+"/", filepath.Join(dir, name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk("", s.dir); err != nil {
+		return nil, err
+	}
+	return dirs, nil
+}
+
+// validSegment matches the directory of a namespace, one slug of its title.
+var validSegment = regexp.MustCompile("^" + pageNamePattern + "$")
+
+func (s *fileStore) backupFilename(title string) string {
+	return s.filename(title) + ".bak"
+}
+
+// recover cleans up after saves a crash interrupted: their temporary files are
+// removed, and pages whose file went missing are restored from their backup.
+func (s *fileStore) recover() error {
+	dirs, err := s.pageDirs()
+	if err != nil {
+		return err
+	}
+	for prefix, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			switch {
+			case strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".tmp"):
+				log.Printf("discarding %s, left behind by an interrupted save", prefix+name)
+				if err := os.Remove(filepath.Join(dir, name)); err != nil {
+					return err
+				}
+			case strings.HasSuffix(name, ".txt.bak"):
+				title := prefix + strings.TrimSuffix(name, ".txt.bak")
+				if _, err := os.Stat(s.filename(title)); !os.IsNotExist(err) {
+					continue
+				}
+				log.Printf("restoring page %s from its backup %s", title, prefix+name)
+				body, err := os.ReadFile(s.backupFilename(title))
+				if err != nil {
+					return err
+				}
+				if err := s.writeFile(s.filename(title), body); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// writeFile atomically replaces filename with data.
+func (s *fileStore) writeFile(filename string, data []byte) error {
+	dirname := filepath.Dir(filename)
+	if err := os.MkdirAll(dirname, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dirname, "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return err
+	}
+	// The rename itself is only durable once the directory is synced.
+	dir, err := os.Open(dirname)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+func (s *fileStore) Load(title string) (*Page, error) {
+	body, err := os.ReadFile(s.filename(title))
+	if err != nil {
+		return nil, err
+	}
+	return &Page{Title: title, Body: body}, nil
+}
+
+func (s *fileStore) Save(p *Page) error {
+	previous, err := os.ReadFile(s.filename(p.Title))
+	switch {
+	case err == nil:
+		if err := s.writeFile(s.backupFilename(p.Title), previous); err != nil {
+			return err
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+	return s.writeFile(s.filename(p.Title), p.Body)
+}
+
+func (s *fileStore) List() ([]string, error) {
+	dirs, err := s.pageDirs()
+	if err != nil {
+		return nil, err
+	}
+	var titles []string
+	for prefix, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if title, isPage := strings.CutSuffix(entry.Name(), ".txt"); isPage && !entry.IsDir() {
+				titles = append(titles, prefix+title)
+			}
+		}
+	}
+	sort.Strings(titles)
+	return titles, nil
+}
+
+// Delete removes the backup first, so that recover never brings a deleted page back.
+func (s *fileStore) Delete(title string) error {
+	if err := os.Remove(s.backupFilename(title)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Remove(s.filename(title))
+}
+
+// ModTime returns when the page was last saved.
+func (s *fileStore) ModTime(title string) (time.Time, error) {
+	info, err := os.Stat(s.filename(title))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
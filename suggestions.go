@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// TitleSuggestion proposes a title matching the first heading of a page whose
+// title has diverged from its content.
+type TitleSuggestion struct {
+	Title     string
+	Heading   string
+	Suggested string
+	// Exists tells whether a page already has the suggested title, in which case
+	// a redirect can't be added there.
+	Exists bool
+}
+
+// EditTemplatePage is the data of the edit template.
+type EditTemplatePage struct {
+	Title        string
+	DisplayTitle string
+	Body         []byte
+	Suggestion   *TitleSuggestion
+	Metadata     PageMetadata
+	// Format is the markup the page is written in, one of Formats.
+	Format  string
+	Formats []string
+	// Base is the revision token of the version being edited, and EditToken
+	// identifies the form so that it's saved once.
+	Base      string
+	EditToken string
+	// Exists tells whether the page is stored yet, and Attachments are its
+	// attachments once it is.
+	Exists      bool
+	Attachments []Attachment
+	// PageTemplates are offered to scaffold a page that doesn't exist yet, and
+	// PageTemplate is the one its body was filled from.
+	PageTemplates []string
+	PageTemplate  string
+	// Warnings are the lint warnings that kept the body from being saved,
+	// with -lint-strict.
+	Warnings []LintWarning
+	// LockedBy is the lock of the user who had the page open in the editor
+	// first, if it isn't the editor's own.
+	LockedBy *EditLock
+}
+
+var headingPattern = regexp.MustCompile(`^#{1,6}\s+(.*?)\s*#*\s*$`)
+
+// firstHeading returns the text of the first heading of a page body after its
+// front matter, or "".
+func firstHeading(body []byte) string {
+	for _, line := range strings.Split(string(stripFrontMatter(body)), "\n") {
+		if redirectLine.MatchString(line) {
+			return ""
+		}
+		if match := headingPattern.FindStringSubmatch(strings.TrimSpace(line)); match != nil {
+			return plainText(match[1])
+		}
+	}
+	return ""
+}
+
+// titleFromHeading turns a heading into a title in the style of the wiki's
+// titles: its words in lowerCamelCase, letters and digits only.
+func titleFromHeading(heading string) string {
+	var out strings.Builder
+	words := strings.FieldsFunc(heading, func(r rune) bool {
+		return r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r))
+	})
+	for idx, word := range words {
+		if idx == 0 {
+			out.WriteString(strings.ToLower(word[:1]) + word[1:])
+		} else {
+			out.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+	return out.String()
+}
+
+// suggestTitle returns a suggestion when the first heading of the page names
+// something else than its title, ignoring case and punctuation.
+func (wiki *Wiki) suggestTitle(p *Page) *TitleSuggestion {
+	heading := firstHeading(p.Body)
+	suggested := titleFromHeading(heading)
+	if suggested == "" || strings.EqualFold(suggested, p.Title) {
+		return nil
+	}
+	return &TitleSuggestion{Title: p.Title, Heading: heading, Suggested: suggested, Exists: wiki.titles.Has(suggested)}
+}
+
+// titleSuggestionsHandler serves /reports/titles: every page whose first heading
+// diverges from its title.
+func (wiki *Wiki) titleSuggestionsHandler(w http.ResponseWriter, r *http.Request) {
+	var suggestions []TitleSuggestion
+	for _, title := range wiki.titles.List() {
+		page, err := wiki.load(title)
+		if err != nil {
+			continue
+		}
+		if suggestion := wiki.suggestTitle(page); suggestion != nil {
+			suggestions = append(suggestions, *suggestion)
+		}
+	}
+	wiki.renderTemplate(w, "titles.html", suggestions)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// maxExtractLength bounds the first-paragraph extract of a page summary.
+const maxExtractLength = 300
+
+// PageSummary is what a hover card shows for a page.
+type PageSummary struct {
+	Title     string `json:"title"`
+	Extract   string `json:"extract"`
+	Thumbnail string `json:"thumbnail,omitempty"`
+}
+
+var (
+	imagePattern      = regexp.MustCompile(`!\[[^\]]*\]\(([^)\s]+)[^)]*\)|<img[^>]+src="([^"]+)"`)
+	markdownLink      = regexp.MustCompile(`!?\[([^\]]*)\]\([^)]*\)`)
+	wikiLinkMarkup    = regexp.MustCompile(`\[\[([^\]|]+\|)?([^\]]+)\]\]`)
+	emphasisMarkup    = regexp.MustCompile("\\*\\*|__|[*`]")
+	htmlTag           = regexp.MustCompile(`<[^>]*>`)
+	listOrQuoteMarker = regexp.MustCompile(`^\s*([-*+>]|\d+\.)\s+`)
+)
+
+// summarize extracts the first paragraph of the page as plain text, skipping
+// the front matter and headings, and the first image as the thumbnail.
+func summarize(p *Page) PageSummary {
+	summary := PageSummary{Title: p.Title}
+	body := string(stripFrontMatter(p.Body))
+	if match := imagePattern.FindStringSubmatch(body); match != nil {
+		summary.Thumbnail = match[1] + match[2]
+	}
+
+	var paragraph []string
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" && len(paragraph) > 0:
+			summary.Extract = plainText(strings.Join(paragraph, " "))
+			if summary.Extract != "" {
+				return truncateExtract(summary)
+			}
+			paragraph = nil
+		case line == "", strings.HasPrefix(line, "#"), strings.HasPrefix(line, "```"):
+		default:
+			paragraph = append(paragraph, line)
+		}
+	}
+	summary.Extract = plainText(strings.Join(paragraph, " "))
+	return truncateExtract(summary)
+}
+
+func plainText(markup string) string {
+	text := listOrQuoteMarker.ReplaceAllString(markup, "")
+	text = markdownLink.ReplaceAllString(text, "$1")
+	text = wikiLinkMarkup.ReplaceAllString(text, "$2")
+	text = htmlTag.ReplaceAllString(text, "")
+	text = emphasisMarkup.ReplaceAllString(text, "")
+	return strings.Join(strings.Fields(text), " ")
+}
+
+func truncateExtract(summary PageSummary) PageSummary {
+	runes := []rune(summary.Extract)
+	if len(runes) <= maxExtractLength {
+		return summary
+	}
+	extract := string(runes[:maxExtractLength])
+	if cut := strings.LastIndex(extract, " "); cut > 0 {
+		extract = extract[:cut]
+	}
+	summary.Extract = extract + "…"
+	return summary
+}
+
+// pageEndpoints are the resources of a page below /api/v1/pages/{title}/.
+var pageEndpoints = map[string]bool{"summary": true, "meta": true, "history": true}
+
+// apiPageTitle splits the path of the pages API into the title of the page, in the
+// case the page has it, and the endpoint of it. Titles hold slashes themselves, so
+// the endpoint is cut off the end: a page can't be called summary, meta or history in a namespace
+// through the API.
+func (wiki *Wiki) apiPageTitle(path string) (string, string) {
+	title := strings.TrimPrefix(strings.TrimPrefix(path, "/api/v1/pages"), "/")
+	if slash := strings.LastIndex(title, "/"); slash >= 0 && pageEndpoints[title[slash+1:]] {
+		return wiki.titles.Canonical(title[:slash]), title[slash+1:]
+	}
+	return wiki.titles.Canonical(title), ""
+}
+
+// pagesAPIHandler serves the pages API: the list at /api/v1/pages, every page at
+// /api/v1/pages/{title} and the per-page APIs below it.
+func (wiki *Wiki) pagesAPIHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/v1/pages"), "/")
+	if path == "" {
+		wiki.pagesListHandler(w, r)
+		return
+	}
+	title, endpoint := wiki.apiPageTitle(r.URL.Path)
+	if !validTitle.MatchString(title) || isReserved(title) {
+		http.NotFound(w, r)
+		return
+	}
+	switch endpoint {
+	case "":
+		wiki.pageResourceHandler(w, r, title)
+	case "summary":
+		wiki.summaryHandler(w, r, title)
+	case "meta":
+		wiki.metaHandler(w, r, title)
+	case "history":
+		wiki.pageHistoryAPIHandler(w, r, title)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// summaryHandler serves GET /api/v1/pages/{title}/summary. Summaries carry an ETag
+// and Last-Modified, so hover cards are revalidated instead of refetched.
+func (wiki *Wiki) summaryHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	page, err := wiki.load(title)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	body, err := json.Marshal(summarize(page))
+	if err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	hash := sha256.Sum256(body)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(hash[:8])+`"`)
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	w.Header().Set("Content-Type", "application/json")
+	// ServeContent answers If-None-Match and If-Modified-Since with 304 Not Modified.
+	http.ServeContent(w, r, "", wiki.pageModTime(title), strings.NewReader(string(body)))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// cloudSize is how many tags the front page's cloud shows at most, the most used.
+const cloudSize = 40
+
+// validTag matches a tag: a slug without namespaces.
+var validTag = regexp.MustCompile("^" + pageNamePattern + "$")
+
+// parseTags reads the tags field of the edit form: tags separated by commas, or
+// by spaces when there's no comma, so "Web Dev, go" are web-dev and go. Every tag
+// is made a slug; they're returned sorted, without duplicates.
+func parseTags(field string) []string {
+	separators := " "
+	if strings.Contains(field, ",") {
+		separators = ","
+	}
+	seen := make(map[string]bool)
+	var tags []string
+	for _, tag := range strings.FieldsFunc(field, func(r rune) bool { return strings.ContainsRune(separators, r) }) {
+		tag = strings.ReplaceAll(slugify(tag), "/", "-")
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// indexTagsLocked replaces the tags of the page in the tag index with its new
+// ones. pageMetadata must be locked.
+func (wiki *Wiki) indexTagsLocked(title string, tags []string) {
+	for _, tag := range wiki.pageMetadata.byTitle[title].Tags {
+		delete(wiki.pageMetadata.byTag[tag], title)
+		if len(wiki.pageMetadata.byTag[tag]) == 0 {
+			delete(wiki.pageMetadata.byTag, tag)
+		}
+	}
+	for _, tag := range tags {
+		if wiki.pageMetadata.byTag[tag] == nil {
+			wiki.pageMetadata.byTag[tag] = make(map[string]bool)
+		}
+		wiki.pageMetadata.byTag[tag][title] = true
+	}
+}
+
+// taggedPages returns the pages with the tag the viewer is shown, in the order of
+// the listings. A deleted page keeps its metadata, but isn't listed.
+func (wiki *Wiki) taggedPages(v viewer, tag string) []string {
+	wiki.pageMetadata.RLock()
+	var tagged []string
+	for title := range wiki.pageMetadata.byTag[tag] {
+		if wiki.titles.Has(title) {
+			tagged = append(tagged, title)
+		}
+	}
+	wiki.pageMetadata.RUnlock()
+	tagged = v.filter(tagged)
+	wiki.sortListing(tagged)
+	return tagged
+}
+
+// TagCount is a tag of the cloud with how many pages have it, and its Weight from
+// 1, the tags used least, to 5, those used most, which sets its size.
+type TagCount struct {
+	Tag    string
+	Pages  int
+	Weight int
+}
+
+// tagCloud returns the most used tags of the pages the viewer is shown, sorted by
+// name.
+func (wiki *Wiki) tagCloud(v viewer) []TagCount {
+	wiki.pageMetadata.RLock()
+	tagged := make(map[string][]string, len(wiki.pageMetadata.byTag))
+	for tag, pages := range wiki.pageMetadata.byTag {
+		for title := range pages {
+			tagged[tag] = append(tagged[tag], title)
+		}
+	}
+	wiki.pageMetadata.RUnlock()
+	acl := wiki.currentACL()
+	var cloud []TagCount
+	for tag, pages := range tagged {
+		listed := 0
+		for _, title := range pages {
+			if wiki.titles.Has(title) && v.listsWith(acl, title) {
+				listed++
+			}
+		}
+		if listed > 0 {
+			cloud = append(cloud, TagCount{Tag: tag, Pages: listed})
+		}
+	}
+	sort.Slice(cloud, func(i, j int) bool {
+		if cloud[i].Pages != cloud[j].Pages {
+			return cloud[i].Pages > cloud[j].Pages
+		}
+		return cloud[i].Tag < cloud[j].Tag
+	})
+	cloud = cloud[:min(len(cloud), cloudSize)]
+	if len(cloud) > 0 {
+		most, least := cloud[0].Pages, cloud[len(cloud)-1].Pages
+		for idx := range cloud {
+			cloud[idx].Weight = 1
+			if most > least {
+				cloud[idx].Weight += 4 * (cloud[idx].Pages - least) / (most - least)
+			}
+		}
+	}
+	sort.Slice(cloud, func(i, j int) bool { return cloud[i].Tag < cloud[j].Tag })
+	return cloud
+}
+
+// TagPage is the data of the tag template.
+type TagPage struct {
+	Tag   string
+	Pages []string
+}
+
+// tagHandler serves /tag/{name}: the pages with the tag.
+func (wiki *Wiki) tagHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	tag := strings.TrimPrefix(r.URL.Path, "/tag/")
+	if !validTag.MatchString(tag) {
+		wiki.serveError(w, notFound(""))
+		return
+	}
+	wiki.renderTemplate(w, "tag.html", TagPage{Tag: tag, Pages: wiki.taggedPages(wiki.viewerOf(r), strings.ToLower(tag))})
+}
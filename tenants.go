@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// tenantPrefix is where the wikis of -wikis are served, as /w/{name}/.
+const tenantPrefix = "/w/"
+
+// validTenantName matches the names of the wikis of -wikis. They name the
+// cookies of their wiki too, so they have no dots.
+var validTenantName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9-]{0,63}$`)
+
+// A Tenant is a wiki hosted next to the server's own, in the file of -wikis. It
+// is a Wiki of its own in the server's process, with its own data directory, and
+// so its own pages, title registry, users and everything else; its templates are
+// those of Templates, or the built in ones. It's served under /w/{Name}/, its
+// -base-path, which its pages link under, and, for a Host, at that hostname too.
+type Tenant struct {
+	Name      string `json:"name"`
+	Host      string `json:"host,omitempty"`
+	Data      string `json:"data"`
+	Templates string `json:"templates,omitempty"`
+	// AdminToken is the admin token of the wiki; it has none without one, the
+	// host's being its own.
+	AdminToken string `json:"adminToken,omitempty"`
+	// Args are more flags of the wiki, like its -base-url or -store.
+	Args []string `json:"args,omitempty"`
+
+	wiki    *Wiki
+	handler http.Handler
+}
+
+// loadTenants reads the wikis to host from the JSON list of the file.
+func (wiki *Wiki) loadTenants(filename string) ([]*Tenant, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var tenants []*Tenant
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	names, hosts, dirs := make(map[string]bool), make(map[string]bool), make(map[string]bool)
+	own, _ := filepath.Abs(wiki.config.DataDir)
+	for _, tenant := range tenants {
+		if !validTenantName.MatchString(tenant.Name) {
+			return nil, fmt.Errorf("%s: %q is no name for a wiki, it's letters, digits and dashes", filename, tenant.Name)
+		}
+		if names[tenant.Name] {
+			return nil, fmt.Errorf("%s: there are two wikis named %s", filename, tenant.Name)
+		}
+		names[tenant.Name] = true
+		if tenant.Host = strings.ToLower(tenant.Host); tenant.Host != "" {
+			if hosts[tenant.Host] {
+				return nil, fmt.Errorf("%s: two wikis are at %s", filename, tenant.Host)
+			}
+			hosts[tenant.Host] = true
+		}
+		dir, err := filepath.Abs(tenant.Data)
+		if err != nil || tenant.Data == "" {
+			return nil, fmt.Errorf("%s: the wiki %s needs a data directory of its own", filename, tenant.Name)
+		}
+		if dir == own || dirs[dir] {
+			return nil, fmt.Errorf("%s: the wiki %s shares the data directory %s", filename, tenant.Name, tenant.Data)
+		}
+		dirs[dir] = true
+	}
+	return tenants, nil
+}
+
+// config returns the configuration of the wiki: that of the host, then its Args.
+// It serves under its prefix, from its own data and templates, has an admin token
+// of its own, and leaves the listening, and TLS, to the host. It doesn't take the
+// identity providers, private access, webhooks or migration of the host, which
+// its Args may give it its own of. The themes, locales and matrix module are the
+// process's, so it keeps the host's.
+func (t *Tenant) config(host Config) (Config, error) {
+	config := host
+	flags := flag.NewFlagSet(t.Name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	registerConfigFlags(flags, &config)
+	config = host
+	config.DataDir, config.BackupDir, config.TemplateDir = t.Data, filepath.Clean(t.Data)+"-backups", t.Templates
+	config.AdminToken, config.OAuthProviders, config.AllowIPs, config.BasicAuth = t.AdminToken, "", "", ""
+	config.Webhooks, config.WebhookSecret, config.MigrateFrom = "", "", ""
+	if err := flags.Parse(t.Args); err != nil {
+		return Config{}, fmt.Errorf("the wiki %s: %w", t.Name, err)
+	}
+	config.DataDir, config.BasePath, config.Wikis = t.Data, t.prefix(), ""
+	config.Addr, config.HTTPAddr, config.TLSCert, config.TLSKey, config.AutocertDomains, config.AutocertDir = "", "", "", "", "", ""
+	config.ThemeDir, config.LocaleDir, config.MatrixTimeout = host.ThemeDir, host.LocaleDir, host.MatrixTimeout
+	return config, nil
+}
+
+// open sets the wiki up as main does the host, and starts its background jobs,
+// for the lifetime of ctx.
+func (t *Tenant) open(ctx context.Context, host Config) error {
+	config, err := t.config(host)
+	if err != nil {
+		return err
+	}
+	t.wiki = newWiki(config)
+	t.wiki.setup()
+	t.wiki.startJobs(ctx)
+	t.handler = t.wiki.newRouter(ctx)
+	return nil
+}
+
+// close closes the wiki once the host's server shut down, as main does the host.
+func (t *Tenant) close() error {
+	err := t.wiki.close()
+	t.wiki.closeWebhooks()
+	return err
+}
+
+// openTenants opens the wikis of -wikis, one after the other, before the host
+// serves: setting a wiki up loads the themes and locales of the process again.
+func (wiki *Wiki) openTenants(ctx context.Context) ([]*Tenant, error) {
+	tenants, err := wiki.loadTenants(wiki.config.Wikis)
+	if err != nil {
+		return nil, err
+	}
+	for _, tenant := range tenants {
+		if err := tenant.open(ctx, wiki.config); err != nil {
+			return nil, err
+		}
+	}
+	return tenants, nil
+}
+
+func (t *Tenant) prefix() string {
+	return tenantPrefix + t.Name
+}
+
+// cookiePrefix names the cookies of the wiki served under its prefix, which
+// the browser sends to the host's pages as well, and the host's to it.
+func (t *Tenant) cookiePrefix() string {
+	return "w." + t.Name + "."
+}
+
+// servePrefixed serves a request under the prefix, with only the wiki's own
+// cookies, by their names.
+func (t *Tenant) servePrefixed(w http.ResponseWriter, r *http.Request) {
+	own := r.Clone(r.Context())
+	own.Header.Del("Cookie")
+	for _, cookie := range r.Cookies() {
+		if name, ok := strings.CutPrefix(cookie.Name, t.cookiePrefix()); ok {
+			own.AddCookie(&http.Cookie{Name: name, Value: cookie.Value})
+		}
+	}
+	t.handler.ServeHTTP(&tenantCookieWriter{tenant: t, ResponseWriter: w}, own)
+}
+
+// tenantCookieWriter names the cookies the wiki sets under its prefix as the
+// header is written, for the host's to be told apart from them, and keeps them
+// to its paths. The wiki's pages and redirects point under the prefix
+// themselves, see -base-path.
+type tenantCookieWriter struct {
+	tenant *Tenant
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (tw *tenantCookieWriter) WriteHeader(status int) {
+	if !tw.wroteHeader {
+		tw.wroteHeader = true
+		tw.prefixCookies()
+	}
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *tenantCookieWriter) Write(b []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+func (tw *tenantCookieWriter) prefixCookies() {
+	cookies := tw.Header().Values("Set-Cookie")
+	if len(cookies) == 0 {
+		return
+	}
+	tw.Header().Del("Set-Cookie")
+	for _, line := range cookies {
+		cookie, err := http.ParseSetCookie(line)
+		if err != nil {
+			continue
+		}
+		cookie.Name = tw.tenant.cookiePrefix() + cookie.Name
+		cookie.Path = tw.tenant.prefix() + "/" + strings.TrimPrefix(cookie.Path, "/")
+		tw.Header().Add("Set-Cookie", cookie.String())
+	}
+}
+
+func (tw *tenantCookieWriter) Flush() {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := tw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (tw *tenantCookieWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// routeTenants serves the wikis of -wikis at their hosts and under their
+// prefixes, and every other request from the host's own wiki.
+func routeTenants(tenants []*Tenant, own http.Handler) http.Handler {
+	byHost, byName := make(map[string]*Tenant), make(map[string]*Tenant)
+	for _, tenant := range tenants {
+		if tenant.Host != "" {
+			byHost[tenant.Host] = tenant
+		}
+		byName[tenant.Name] = tenant
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.ToLower(r.Host)
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		if tenant := byHost[host]; tenant != nil {
+			tenant.handler.ServeHTTP(w, r)
+			return
+		}
+		if rest, ok := strings.CutPrefix(r.URL.Path, tenantPrefix); ok {
+			name, _, slash := strings.Cut(rest, "/")
+			if tenant := byName[name]; tenant != nil {
+				if !slash {
+					http.Redirect(w, r, tenant.prefix()+"/", http.StatusMovedPermanently)
+					return
+				}
+				tenant.servePrefixed(w, r)
+				return
+			}
+		}
+		own.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTenantsKeepTheirOwnPages(t *testing.T) {
+	dir := t.TempDir()
+	wikis := filepath.Join(dir, "wikis.json")
+	list := `[{"name": "alpha", "host": "alpha.example", "data": "` + filepath.Join(dir, "alpha") + `"}, {"name": "beta", "data": "` + filepath.Join(dir, "beta") + `"}]`
+	if err := os.WriteFile(wikis, []byte(list), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	previous := testWiki.config.Wikis
+	testWiki.config.Wikis = wikis
+	defer func() { testWiki.config.Wikis = previous }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tenants, err := testWiki.openTenants(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, tenant := range tenants {
+			tenant.close()
+		}
+	}()
+	alpha := tenants[0]
+	alpha.wiki.startupScan.wait()
+	if err := alpha.wiki.savePage(&Page{Title: "Tenancy", Body: []byte("the pages of alpha")}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	router := routeTenants(tenants, testWiki.newRouter(ctx))
+	for _, test := range []struct {
+		url      string
+		status   int
+		location string
+	}{
+		{"/w/alpha/view/Tenancy", http.StatusOK, ""},
+		{"http://alpha.example/view/Tenancy", http.StatusOK, ""},
+		{"/w/beta/view/Tenancy", http.StatusFound, "/w/beta/edit/Tenancy"},
+		{"/view/Tenancy", http.StatusFound, "/edit/Tenancy"},
+	} {
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, test.url, nil))
+		if response.Code != test.status || response.Header().Get("Location") != test.location {
+			t.Errorf("GET %s = %d to %q, want %d to %q", test.url, response.Code, response.Header().Get("Location"), test.status, test.location)
+		}
+	}
+
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/w/alpha/", nil))
+	cookies := response.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("the front page of alpha set no cookie")
+	}
+	for _, cookie := range cookies {
+		if !strings.HasPrefix(cookie.Name, "w.alpha.") || !strings.HasPrefix(cookie.Path, "/w/alpha/") {
+			t.Errorf("alpha set the cookie %s for %s, want it named and kept under w.alpha", cookie.Name, cookie.Path)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// themeCookie keeps the theme a visitor chose over -theme.
+const themeCookie = "gowiki_theme"
+
+// themeCookieTTL is how long the choice of a theme lasts.
+const themeCookieTTL = 365 * 24 * time.Hour
+
+// embeddedThemes are the themes built into the binary: light, the templates as
+// they are, and dark.
+//
+//go:embed themes
+var embeddedThemes embed.FS
+
+// themeFiles maps the name of every theme to its directory: a theme.css the
+// pages link to, and any of the templateFiles it renders differently, which
+// replace those of the templates. It falls back to the templates for the others.
+var themeFiles map[string]fs.FS
+
+// loadThemes finds the built in themes and those in the subdirectories of dir,
+// which replace the built in ones of the same name.
+func (wiki *Wiki) loadThemes(dir string) error {
+	themeFiles = make(map[string]fs.FS)
+	embedded, _ := fs.Sub(embeddedThemes, "themes")
+	if err := addThemes(embedded); err != nil {
+		return err
+	}
+	if dir != "" {
+		if err := addThemes(os.DirFS(dir)); err != nil {
+			return err
+		}
+	}
+	if themeFiles[wiki.config.Theme] == nil {
+		return fmt.Errorf("there's no theme %s, the themes are %s", wiki.config.Theme, strings.Join(themeNames(), ", "))
+	}
+	return nil
+}
+
+func addThemes(themes fs.FS) error {
+	entries, err := fs.ReadDir(themes, ".")
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !validName.MatchString(entry.Name()) {
+			continue
+		}
+		files, err := fs.Sub(themes, entry.Name())
+		if err != nil {
+			return err
+		}
+		themeFiles[entry.Name()] = files
+	}
+	return nil
+}
+
+// themeNames is the "themes" template function: the names of the themes, sorted.
+func themeNames() []string {
+	var names []string
+	for name := range themeFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readThemedTemplates parses the templates of every theme, by its name and then
+// by locale: the templates of dir, the theme's own files parsed over them. The
+// "currentTheme" template function of each returns the name of the theme, and
+// "t" the messages of the locale.
+func (wiki *Wiki) readThemedTemplates(dir string) (map[string]map[string]*template.Template, error) {
+	base, err := wiki.readTemplates(dir)
+	if err != nil {
+		return nil, err
+	}
+	themed := make(map[string]map[string]*template.Template)
+	for name, files := range themeFiles {
+		set, err := base.Clone()
+		if err != nil {
+			return nil, err
+		}
+		theme := name
+		set.Funcs(template.FuncMap{"currentTheme": func() string { return theme }})
+		var own []string
+		for _, filename := range templateFiles {
+			if _, err := fs.Stat(files, filename); err == nil {
+				own = append(own, filename)
+			}
+		}
+		if len(own) > 0 {
+			if _, err := set.ParseFS(files, own...); err != nil {
+				return nil, fmt.Errorf("the theme %s: %w", name, err)
+			}
+		}
+		themed[name] = make(map[string]*template.Template)
+		for code := range catalogs {
+			localized, err := set.Clone()
+			if err != nil {
+				return nil, err
+			}
+			locale := code
+			localized.Funcs(template.FuncMap{"currentLocale": func() string { return locale },
+				"t": func(key string, args ...interface{}) string { return translate(locale, key, args...) }})
+			themed[name][code] = localized
+		}
+	}
+	return themed, nil
+}
+
+// themedWriter carries the theme the pages of a request are rendered in, for
+// renderTemplate to find as it finds the CSRF token.
+type themedWriter struct {
+	http.ResponseWriter
+	theme string
+}
+
+func (tw *themedWriter) Flush() {
+	if flusher, ok := tw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (tw *themedWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// requestTheme returns the theme the page is rendered in for the request, that of
+// -theme when it isn't rendered for one.
+func (wiki *Wiki) requestTheme(w http.ResponseWriter) string {
+	for {
+		switch writer := w.(type) {
+		case *themedWriter:
+			return writer.theme
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = writer.Unwrap()
+		default:
+			return wiki.config.Theme
+		}
+	}
+}
+
+// withTheme renders the pages of the request in the theme of the visitor's
+// cookie, or else that of -theme.
+func (wiki *Wiki) withTheme(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		theme := wiki.config.Theme
+		if cookie, err := r.Cookie(themeCookie); err == nil && themeFiles[cookie.Value] != nil {
+			theme = cookie.Value
+		}
+		next.ServeHTTP(&themedWriter{ResponseWriter: w, theme: theme}, r)
+	})
+}
+
+// themeHandler serves POST /theme, which chooses the theme of the "theme" form
+// value for the visitor and sends them back to the page they chose it on, and
+// /themes/{name}/{file}, the stylesheets and the other files of the themes.
+func (wiki *Wiki) themeHandler(w http.ResponseWriter, r *http.Request) {
+	if rest, ok := strings.CutPrefix(r.URL.Path, "/themes/"); ok {
+		name, file, _ := strings.Cut(rest, "/")
+		files := themeFiles[name]
+		if files == nil || path.Ext(file) == ".html" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		http.StripPrefix("/themes/"+name, http.FileServer(http.FS(files))).ServeHTTP(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	theme := r.FormValue("theme")
+	if themeFiles[theme] == nil {
+		http.Error(w, translate(wiki.requestLocale(w), "error.noTheme", theme), http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: themeCookie, Value: theme, Path: "/", MaxAge: int(themeCookieTTL.Seconds()),
+		HttpOnly: true, Secure: isSecure(r), SameSite: http.SameSiteLaxMode})
+	next := "/"
+	if referer, err := url.Parse(r.Referer()); err == nil && strings.EqualFold(referer.Host, r.Host) {
+		next = localRedirect(referer.RequestURI())
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
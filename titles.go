@@ -0,0 +1,231 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// TitleRegistry is the set of page titles that exist, and the trie that finds
+// mentions of them for inter-linking. It's safe for concurrent use.
+//
+// Titles are told apart regardless of case: GoRoutines and goroutines are the
+// same page, which keeps the case it was first saved with. Looking a title up in
+// another case finds it, and mentions of it in any case are linked.
+type TitleRegistry struct {
+	wiki   *Wiki
+	mu     sync.RWMutex
+	titles map[string]bool
+	// folded maps the lower case of every title to the title as it was
+	// registered first.
+	folded map[string]string
+	// displays maps the display titles that differ from their slug to it, so
+	// mentions of either are linked; foldedDisplays is it by their lower case.
+	displays       map[string]string
+	foldedDisplays map[string]string
+	// mentions holds the titles and display titles of the pages listed for
+	// everyone, linked are they; adding or removing a page changes only its
+	// own.
+	mentions *titleTrie
+	linked   map[string]bool
+	// locks are the edit locks of the pages open in an editor, by the lower
+	// case of their title.
+	locks map[string]EditLock
+}
+
+func NewTitleRegistry(wiki *Wiki) *TitleRegistry {
+	return &TitleRegistry{wiki: wiki, titles: make(map[string]bool), folded: make(map[string]string), displays: make(map[string]string),
+		foldedDisplays: make(map[string]string), mentions: newTitleTrie(), linked: make(map[string]bool), locks: make(map[string]EditLock)}
+}
+
+// Add registers titles, and links their mentions.
+func (reg *TitleRegistry) Add(newTitles ...string) {
+	acl := reg.wiki.currentACL()
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	for _, title := range newTitles {
+		if !reg.titles[title] {
+			reg.titles[title] = true
+			if _, ok := reg.folded[strings.ToLower(title)]; !ok {
+				reg.folded[strings.ToLower(title)] = title
+			}
+			reg.linkLocked(acl, title)
+		}
+	}
+}
+
+// Rebuild links the mentions of the titles again, once the ACL changed who's
+// shown which page.
+func (reg *TitleRegistry) Rebuild() {
+	acl := reg.wiki.currentACL()
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.mentions, reg.linked = newTitleTrie(), make(map[string]bool)
+	for title := range reg.titles {
+		reg.linkLocked(acl, title)
+	}
+}
+
+// linkLocked links the mentions of the title and its display title, unless the
+// page isn't listed for everyone: the sandbox pages and those the ACL hides from
+// visitors are left out, scratch notes are only linked explicitly.
+func (reg *TitleRegistry) linkLocked(acl accessList, title string) {
+	if !reg.wiki.everyone.listsWith(acl, title) {
+		return
+	}
+	reg.linkKeyLocked(title)
+	for display, displayed := range reg.displays {
+		if displayed == title {
+			reg.linkKeyLocked(display)
+		}
+	}
+}
+
+// unlinkLocked stops linking the mentions of the title and its display title.
+func (reg *TitleRegistry) unlinkLocked(title string) {
+	reg.unlinkKeyLocked(title)
+	for display, displayed := range reg.displays {
+		if displayed == title {
+			reg.unlinkKeyLocked(display)
+		}
+	}
+}
+
+func (reg *TitleRegistry) linkKeyLocked(key string) {
+	if !reg.linked[key] {
+		reg.linked[key] = true
+		reg.mentions.insert(key)
+	}
+}
+
+func (reg *TitleRegistry) unlinkKeyLocked(key string) {
+	if reg.linked[key] {
+		delete(reg.linked, key)
+		reg.mentions.remove(key)
+	}
+}
+
+// foldDisplaysLocked maps the display titles by their lower case again; one that
+// is a title itself in another case wins.
+func (reg *TitleRegistry) foldDisplaysLocked() {
+	clear(reg.foldedDisplays)
+	for display, title := range reg.displays {
+		if _, ok := reg.foldedDisplays[strings.ToLower(display)]; !ok || display == title {
+			reg.foldedDisplays[strings.ToLower(display)] = title
+		}
+	}
+}
+
+// Remove unregisters a title, so mentions of it stop being linked.
+func (reg *TitleRegistry) Remove(title string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if reg.titles[title] {
+		reg.unlinkLocked(title)
+		delete(reg.titles, title)
+		key := strings.ToLower(title)
+		if reg.folded[key] == title {
+			delete(reg.folded, key)
+			// a page saved in another case before case was ignored takes its place
+			for _, other := range reg.sortedLocked() {
+				if strings.ToLower(other) == key {
+					reg.folded[key] = other
+					break
+				}
+			}
+		}
+	}
+}
+
+// SetDisplay registers the display title of a page, replacing its previous one;
+// "" leaves the page with just its slug.
+func (reg *TitleRegistry) SetDisplay(title, display string) {
+	acl := reg.wiki.currentACL()
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	changed := false
+	for existing, existingTitle := range reg.displays {
+		if existingTitle == title && existing != display {
+			reg.unlinkKeyLocked(existing)
+			delete(reg.displays, existing)
+			changed = true
+		}
+	}
+	if display != "" && display != title && reg.displays[display] != title {
+		reg.displays[display] = title
+		changed = true
+	}
+	if changed {
+		reg.foldDisplaysLocked()
+		if reg.titles[title] {
+			reg.linkLocked(acl, title)
+		}
+	}
+}
+
+// Resolve returns the title a mention the registry found refers to, a display
+// title or a title in any case.
+func (reg *TitleRegistry) Resolve(mention string) string {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	if title, ok := reg.displays[mention]; ok {
+		return title
+	}
+	if reg.titles[mention] {
+		return mention
+	}
+	if title, ok := reg.foldedDisplays[strings.ToLower(mention)]; ok {
+		return title
+	}
+	return reg.canonicalLocked(mention)
+}
+
+// Canonical returns the title of the page the title names in whatever case: the
+// title itself when there is no such page.
+func (reg *TitleRegistry) Canonical(title string) string {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return reg.canonicalLocked(title)
+}
+
+func (reg *TitleRegistry) canonicalLocked(title string) string {
+	if reg.titles[title] {
+		return title
+	}
+	if registered, ok := reg.folded[strings.ToLower(title)]; ok {
+		return registered
+	}
+	return title
+}
+
+// Has reports whether a page with the title, in any case, exists.
+func (reg *TitleRegistry) Has(title string) bool {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	_, ok := reg.folded[strings.ToLower(title)]
+	return ok
+}
+
+// List returns every title, sorted.
+func (reg *TitleRegistry) List() []string {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return reg.sortedLocked()
+}
+
+func (reg *TitleRegistry) sortedLocked() []string {
+	sorted := make([]string, 0, len(reg.titles))
+	for title := range reg.titles {
+		sorted = append(sorted, title)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// Mentions returns the start and end of every mention of a linked title in text,
+// in order. Resolve tells which page each one is.
+func (reg *TitleRegistry) Mentions(text string) [][2]int {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return reg.mentions.find(text)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// titleTrie finds the mentions of titles in text. It's a trie of the titles in
+// lower case: a mention starts at the start of a word, and is the longest title
+// the text continues with there, in any case, that ends at the end of a word, so
+// "go" isn't found in "gopher". Titles are added and removed one at a time, in
+// the time of their length, and finding them takes the time of the text times
+// the length of the mentions, however many titles there are.
+type titleTrie struct {
+	root *trieNode
+}
+
+type trieNode struct {
+	children map[rune]*trieNode
+	// keys counts the titles ending here: a title and a display title of
+	// another page may be the same but for their case.
+	keys int
+}
+
+func newTitleTrie() *titleTrie {
+	return &titleTrie{root: &trieNode{}}
+}
+
+// insert adds the title.
+func (t *titleTrie) insert(title string) {
+	node := t.root
+	for _, r := range title {
+		r = unicode.ToLower(r)
+		child, ok := node.children[r]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[rune]*trieNode)
+			}
+			child = &trieNode{}
+			node.children[r] = child
+		}
+		node = child
+	}
+	node.keys++
+}
+
+// remove takes the title out again, and the nodes only it needed.
+func (t *titleTrie) remove(title string) {
+	path := []*trieNode{t.root}
+	var runes []rune
+	for _, r := range title {
+		r = unicode.ToLower(r)
+		child, ok := path[len(path)-1].children[r]
+		if !ok {
+			return
+		}
+		path = append(path, child)
+		runes = append(runes, r)
+	}
+	node := path[len(path)-1]
+	if node.keys == 0 {
+		return
+	}
+	node.keys--
+	for depth := len(runes); depth > 0; depth-- {
+		node := path[depth]
+		if node.keys > 0 || len(node.children) > 0 {
+			return
+		}
+		delete(path[depth-1].children, runes[depth-1])
+	}
+}
+
+// find returns the start and end of every mention in text, in order.
+func (t *titleTrie) find(text string) [][2]int {
+	var mentions [][2]int
+	inWord := false
+	for start := 0; start < len(text); {
+		r, size := utf8.DecodeRuneInString(text[start:])
+		if !inWord {
+			if end := t.longest(text, start); end > start {
+				mentions = append(mentions, [2]int{start, end})
+				last, _ := utf8.DecodeLastRuneInString(text[:end])
+				start, inWord = end, isSlugRune(last)
+				continue
+			}
+		}
+		inWord = isSlugRune(r)
+		start += size
+	}
+	return mentions
+}
+
+// longest returns the end of the longest title text continues with at start that
+// ends at the end of a word, start when there is none.
+func (t *titleTrie) longest(text string, start int) int {
+	end := start
+	node := t.root
+	for pos := start; pos < len(text); {
+		r, size := utf8.DecodeRuneInString(text[pos:])
+		if node = node.children[unicode.ToLower(r)]; node == nil {
+			break
+		}
+		pos += size
+		if node.keys == 0 {
+			continue
+		}
+		if next, _ := utf8.DecodeRuneInString(text[pos:]); pos == len(text) || !isSlugRune(next) || !isSlugRune(r) {
+			end = pos
+		}
+	}
+	return end
+}
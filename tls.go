@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// newAutocert returns the TLS config that gets the certificates of the domains
+// from Let's Encrypt as they're asked for, keeping them in dir, and what the
+// HTTP listener answers the challenges of Let's Encrypt with before handing the
+// other requests to fallback. It's only set in a build with -tags autocert.
+var newAutocert func(domains []string, dir string) (*tls.Config, func(fallback http.Handler) http.Handler)
+
+// serverTLS returns the TLS config the server serves HTTPS with, nil to serve
+// plain HTTP, and the handler of the -http-addr listener.
+func (wiki *Wiki) serverTLS() (*tls.Config, http.Handler, error) {
+	redirect := http.Handler(http.HandlerFunc(wiki.redirectToHTTPS))
+	switch {
+	case (wiki.config.TLSCert == "") != (wiki.config.TLSKey == ""):
+		return nil, nil, errors.New("-tls-cert and -tls-key go together")
+	case wiki.config.TLSCert != "" && wiki.config.AutocertDomains != "":
+		return nil, nil, errors.New("-autocert gets the certificates, it doesn't go with -tls-cert")
+	case wiki.config.TLSCert != "":
+		certificate, err := tls.LoadX509KeyPair(wiki.config.TLSCert, wiki.config.TLSKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not load the certificate: %w", err)
+		}
+		return &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12}, redirect, nil
+	case wiki.config.AutocertDomains != "":
+		if newAutocert == nil {
+			return nil, nil, errors.New("-autocert needs a wiki built with -tags autocert")
+		}
+		dir := wiki.config.AutocertDir
+		if dir == "" {
+			dir = wiki.dataPath("certs")
+		}
+		var domains []string
+		for _, domain := range strings.Split(wiki.config.AutocertDomains, ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				domains = append(domains, domain)
+			}
+		}
+		tlsConfig, challenges := newAutocert(domains, dir)
+		return tlsConfig, challenges(redirect), nil
+	case wiki.config.HTTPAddr != "":
+		return nil, nil, errors.New("-http-addr redirects to HTTPS, which needs -tls-cert or -autocert")
+	}
+	return nil, nil, nil
+}
+
+// redirectToHTTPS sends a plain HTTP request to the same URL over HTTPS, on the
+// port of -addr unless that's 443.
+func (wiki *Wiki) redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if withoutPort, _, err := net.SplitHostPort(host); err == nil {
+		host = withoutPort
+	}
+	if _, port, err := net.SplitHostPort(wiki.config.Addr); err == nil && port != "443" && port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+// serveHTTP serves the plain HTTP listener until ctx is done.
+func (wiki *Wiki) serveHTTP(ctx context.Context, handler http.Handler) {
+	server := &http.Server{Addr: wiki.config.HTTPAddr, Handler: handler, ReadHeaderTimeout: readHeaderTimeout, IdleTimeout: idleTimeout}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	log.Printf("redirecting HTTP on %s to HTTPS", wiki.config.HTTPAddr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("could not serve HTTP on %s: %v", wiki.config.HTTPAddr, err)
+	}
+}
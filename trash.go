@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// trashPurgeInterval is how often the pages past -trash-retention are purged.
+const trashPurgeInterval = time.Hour
+
+// TrashedPage is a deleted page in the trash: its body as it was deleted, when
+// and by whom. Purge is when it's purged from the trash, the zero time if it's
+// kept until it's restored.
+type TrashedPage struct {
+	Title   string    `json:"title"`
+	Body    string    `json:"body"`
+	Deleted time.Time `json:"deleted"`
+	Author  string    `json:"author,omitempty"`
+	Purge   time.Time `json:"-"`
+}
+
+// purgeTime is when a page deleted then is purged from the trash, the zero time
+// if it's kept until it's restored.
+func (wiki *Wiki) purgeTime(deleted time.Time) time.Time {
+	if wiki.config.TrashRetention <= 0 {
+		return time.Time{}
+	}
+	return deleted.Add(wiki.config.TrashRetention)
+}
+
+// The trash keeps every deleted page as trash/{title}.json in the data directory,
+// whatever the store, until it's restored or purged. A page deleted again
+// replaces the one in the trash; its history has both.
+func (wiki *Wiki) trashFilename(title string) string {
+	return wiki.dataPath("trash", filepath.FromSlash(title)+".json")
+}
+
+// trashPage puts the page, about to be deleted, in the trash.
+func (wiki *Wiki) trashPage(page *Page, author string) error {
+	filename := wiki.trashFilename(page.Title)
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(TrashedPage{Title: page.Title, Body: string(page.Body), Deleted: clock.Now(), Author: author})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0600)
+}
+
+// loadTrashed returns the page in the trash, or an error wrapping
+// os.ErrNotExist if it isn't there.
+func (wiki *Wiki) loadTrashed(title string) (*TrashedPage, error) {
+	data, err := os.ReadFile(wiki.trashFilename(title))
+	if err != nil {
+		return nil, err
+	}
+	var trashed TrashedPage
+	if err := json.Unmarshal(data, &trashed); err != nil {
+		return nil, err
+	}
+	return &trashed, nil
+}
+
+// removeTrashed takes the page out of the trash, and the directories of its
+// namespaces once they're empty.
+func (wiki *Wiki) removeTrashed(title string) error {
+	filename := wiki.trashFilename(title)
+	if err := os.Remove(filename); err != nil {
+		return err
+	}
+	for dir := filepath.Dir(filename); dir != wiki.dataPath("trash"); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// trashedPages returns the pages in the trash, deleted last first.
+func (wiki *Wiki) trashedPages() ([]TrashedPage, error) {
+	var trashed []TrashedPage
+	err := filepath.WalkDir(wiki.dataPath("trash"), func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return filepath.SkipDir
+		}
+		if err != nil || entry.IsDir() || !strings.HasSuffix(path, ".json") {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var page TrashedPage
+		if err := json.Unmarshal(data, &page); err != nil {
+			return err
+		}
+		page.Purge = wiki.purgeTime(page.Deleted)
+		trashed = append(trashed, page)
+		return nil
+	})
+	sort.Slice(trashed, func(i, j int) bool { return trashed[i].Deleted.After(trashed[j].Deleted) })
+	return trashed, err
+}
+
+// errPageExists is why a page in the trash can't be restored over a new one.
+var errPageExists = conflict("a page of that title was created since it was deleted")
+
+// restorePage brings the page back from the trash, recorded as a new revision by
+// author. It fails if a page of that title was created since.
+func (wiki *Wiki) restorePage(title, author string) error {
+	trashed, err := wiki.loadTrashed(title)
+	if err != nil {
+		return err
+	}
+	if wiki.titles.Has(title) {
+		return errPageExists
+	}
+	const comment = "restored from the trash"
+	page := &Page{Title: title, Body: []byte(trashed.Body)}
+	if err := wiki.storePage(page, author, comment); err != nil {
+		return err
+	}
+	revision, err := wiki.recordRevision(page, author, comment)
+	if err != nil {
+		return err
+	}
+	if err := wiki.journalRevision("save", title, revision); err != nil {
+		return err
+	}
+	wiki.titles.Add(title)
+	wiki.backlinks.relink(title)
+	if err := wiki.staleReview(title); err != nil {
+		return err
+	}
+	return wiki.removeTrashed(title)
+}
+
+// purgeTrash removes the pages deleted longer than -trash-retention ago from the
+// trash for good, and their attachments with them. Their histories are kept.
+func (wiki *Wiki) purgeTrash() error {
+	if wiki.config.TrashRetention <= 0 {
+		return nil
+	}
+	trashed, err := wiki.trashedPages()
+	if err != nil {
+		return err
+	}
+	now := clock.Now()
+	for _, page := range trashed {
+		if now.After(page.Purge) {
+			if err := wiki.removeTrashed(page.Title); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err := wiki.dropAttachments(page.Title); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runTrashPurge purges the trash now and every trashPurgeInterval until ctx is
+// done.
+func (wiki *Wiki) runTrashPurge(ctx context.Context) {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		if err := wiki.purgeTrash(); err != nil {
+			log.Printf("could not purge the trash: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// TrashPage is the data of the trash template.
+type TrashPage struct {
+	Pages     []TrashedPage
+	Retention time.Duration
+}
+
+// trashHandler serves /trash to admins: the deleted pages, and POST restore={title}
+// to bring one back.
+func (wiki *Wiki) trashHandler(w http.ResponseWriter, r *http.Request) {
+	if !wiki.isAdmin(r) {
+		wiki.serveError(w, forbidden("only admins can see the trash"))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost:
+		title := r.FormValue("restore")
+		if !validTitle.MatchString(title) {
+			http.Error(w, translate(wiki.requestLocale(w), "error.restoreTitle"), http.StatusBadRequest)
+			return
+		}
+		switch err := wiki.restorePage(title, wiki.requestAuthor(r)); {
+		case errors.Is(err, os.ErrNotExist):
+			wiki.serveError(w, notFound(title+" is not in the trash"))
+			return
+		case errors.Is(err, errPageExists):
+			http.Error(w, title+" exists again, rename it to restore the deleted one", http.StatusConflict)
+			return
+		case err != nil:
+			wiki.serveError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/view/"+title, http.StatusFound)
+		return
+	default:
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	trashed, err := wiki.trashedPages()
+	if err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	wiki.renderTemplate(w, "trash.html", TrashPage{Pages: trashed, Retention: wiki.config.TrashRetention})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/subtle"
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var undoWindow = flag.Duration("undo-window", 15*time.Minute, "how long after an edit its author may still undo it")
+
+// isAdmin reports whether the request is by a user with the admin role, or
+// carries the wiki's admin token, GOWIKI_ADMIN_TOKEN, in the X-Admin-Token
+// header or the admin_token field of a posted form; never in the URL, which ends
+// up in logs and Referer headers. Without one only the admin users are admins.
+func (wiki *Wiki) isAdmin(r *http.Request) bool {
+	if user := wiki.currentUser(r); user != "" && wiki.userRole(user) == roleAdmin {
+		return true
+	}
+	token := wiki.config.AdminToken
+	if token == "" {
+		return false
+	}
+	given := r.Header.Get("X-Admin-Token")
+	if given == "" {
+		given = r.PostFormValue("admin_token")
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// undoHandler serves POST /undo/{title}: it reverts the most recent revision of the
+// page to the one before it, if the requester made that revision within the undo
+// window, or is an admin. The undo is recorded as a new revision, so it can be
+// undone in turn.
+func (wiki *Wiki) undoHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if ok, reason := wiki.canEdit(r, title); !ok {
+		wiki.serveError(w, forbidden(reason))
+		return
+	}
+	revisions, err := wiki.loadRevisions(title)
+	if err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	if len(revisions) < 2 {
+		http.Error(w, translate(wiki.requestLocale(w), "error.noEarlierRevision", title), http.StatusConflict)
+		return
+	}
+	last, previous := revisions[len(revisions)-1], revisions[len(revisions)-2]
+	if !wiki.isAdmin(r) {
+		if last.Author != wiki.requestAuthor(r) {
+			wiki.serveError(w, forbidden("the last edit of "+title+" was made by someone else"))
+			return
+		}
+		if time.Since(last.Time) > *undoWindow {
+			wiki.serveError(w, forbidden(fmt.Sprintf("the last edit of %s is older than %s and can't be undone anymore", title, *undoWindow)))
+			return
+		}
+	}
+
+	wiki.restoreRevision(w, r, title, previous.Body, fmt.Sprintf("undo of revision %d", last.ID))
+}
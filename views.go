@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	// viewsFlushInterval is how often the view counts are written to
+	// views.json; the views of the last interval are lost with a crash.
+	viewsFlushInterval = time.Minute
+	// mostViewedShown is how many pages the front page lists as the most viewed.
+	mostViewedShown = 5
+	// statsPagesShown is how many pages /admin/stats lists.
+	statsPagesShown = 100
+)
+
+// storedViews is views.json.
+type storedViews struct {
+	Since  time.Time        `json:"since"`
+	Counts map[string]int64 `json:"counts"`
+}
+
+func (wiki *Wiki) loadViews() error {
+	wiki.viewCounts.Lock()
+	defer wiki.viewCounts.Unlock()
+	data, err := os.ReadFile(wiki.dataPath("views.json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var stored storedViews
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	wiki.viewCounts.since = stored.Since
+	if stored.Counts != nil {
+		wiki.viewCounts.counts = stored.Counts
+	}
+	return nil
+}
+
+// flushViews writes the view counts to views.json if they changed.
+func (wiki *Wiki) flushViews() error {
+	wiki.viewCounts.Lock()
+	defer wiki.viewCounts.Unlock()
+	if !wiki.viewCounts.dirty {
+		return nil
+	}
+	data, err := json.MarshalIndent(storedViews{wiki.viewCounts.since, wiki.viewCounts.counts}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(wiki.dataPath("views.json"), data, 0600); err != nil {
+		return err
+	}
+	wiki.viewCounts.dirty = false
+	return nil
+}
+
+// runViewsFlush writes the view counts every viewsFlushInterval until ctx is
+// done; serve writes them once more as the server stops.
+func (wiki *Wiki) runViewsFlush(ctx context.Context) {
+	ticker := time.NewTicker(viewsFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := wiki.flushViews(); err != nil {
+			log.Printf("could not write the view counts: %v", err)
+		}
+	}
+}
+
+func (wiki *Wiki) countView(title string) {
+	if !wiki.config.CountViews {
+		return
+	}
+	wiki.viewCounts.Lock()
+	defer wiki.viewCounts.Unlock()
+	if wiki.viewCounts.since.IsZero() {
+		wiki.viewCounts.since = time.Now()
+	}
+	wiki.viewCounts.counts[title]++
+	wiki.viewCounts.dirty = true
+}
+
+func (wiki *Wiki) viewsOf(title string) int64 {
+	wiki.viewCounts.Lock()
+	defer wiki.viewCounts.Unlock()
+	return wiki.viewCounts.counts[title]
+}
+
+// viewsHook is the page hook that forgets the views of the deleted pages and
+// moves those of a renamed page to its new title.
+type viewsHook struct {
+	wiki *Wiki
+	noPageHook
+}
+
+func (hook viewsHook) AfterDelete(title, author string) {
+	hook.wiki.viewCounts.Lock()
+	defer hook.wiki.viewCounts.Unlock()
+	if _, ok := hook.wiki.viewCounts.counts[title]; ok {
+		delete(hook.wiki.viewCounts.counts, title)
+		hook.wiki.viewCounts.dirty = true
+	}
+}
+
+func (hook viewsHook) AfterChange(entry JournalEntry) {
+	if entry.Op != "rename" {
+		return
+	}
+	hook.wiki.viewCounts.Lock()
+	defer hook.wiki.viewCounts.Unlock()
+	if views, ok := hook.wiki.viewCounts.counts[entry.Title]; ok {
+		hook.wiki.viewCounts.counts[entry.To] += views
+		delete(hook.wiki.viewCounts.counts, entry.Title)
+		hook.wiki.viewCounts.dirty = true
+	}
+}
+
+// PageViews is how often a page was viewed.
+type PageViews struct {
+	Title string
+	Views int64
+}
+
+// mostViewed returns the n pages, with their views, the viewer is shown that
+// were viewed the most, the most viewed first; all of them for n 0.
+func (wiki *Wiki) mostViewed(v viewer, n int) []PageViews {
+	acl := wiki.currentACL()
+	wiki.viewCounts.Lock()
+	var pages []PageViews
+	for title, views := range wiki.viewCounts.counts {
+		pages = append(pages, PageViews{title, views})
+	}
+	wiki.viewCounts.Unlock()
+	listed := pages[:0]
+	for _, page := range pages {
+		if wiki.titles.Has(page.Title) && !isSandbox(page.Title) && v.listsWith(acl, page.Title) {
+			listed = append(listed, page)
+		}
+	}
+	sort.Slice(listed, func(i, j int) bool {
+		if listed[i].Views != listed[j].Views {
+			return listed[i].Views > listed[j].Views
+		}
+		return listed[i].Title < listed[j].Title
+	})
+	if n > 0 && len(listed) > n {
+		listed = listed[:n]
+	}
+	return listed
+}
+
+// StatsPage is the data of the stats template: the views of Pages of the wiki
+// since Since, Total of them all, and the most viewed pages.
+type StatsPage struct {
+	Counting bool
+	Since    time.Time
+	Pages    int
+	Total    int64
+	Viewed   []PageViews
+}
+
+// statsHandler serves /admin/stats to admins: how often the pages were viewed,
+// the most viewed first.
+func (wiki *Wiki) statsHandler(w http.ResponseWriter, r *http.Request) {
+	if !wiki.isAdmin(r) {
+		wiki.serveError(w, forbidden("only admins can see the statistics of the wiki"))
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	wiki.viewCounts.Lock()
+	stats := StatsPage{Counting: wiki.config.CountViews, Since: wiki.viewCounts.since}
+	wiki.viewCounts.Unlock()
+	stats.Viewed = wiki.mostViewed(wiki.viewerOf(r), 0)
+	stats.Pages = len(stats.Viewed)
+	for _, page := range stats.Viewed {
+		stats.Total += page.Views
+	}
+	if len(stats.Viewed) > statsPagesShown {
+		stats.Viewed = stats.Viewed[:statsPagesShown]
+	}
+	wiki.renderTemplate(w, "stats.html", stats)
+}
@@ -0,0 +1,50 @@
+package main
+
+import "net/http"
+
+// A viewer is who pages are listed for: a logged in user, an admin, or nobody in
+// particular, like a feed reader, or the titles every page is auto-linked to,
+// which are the same for all of them.
+type viewer struct {
+	wiki  *Wiki
+	user  string
+	admin bool
+}
+
+func (wiki *Wiki) viewerOf(r *http.Request) viewer {
+	return viewer{wiki: wiki, user: wiki.currentUser(r), admin: wiki.isAdmin(r)}
+}
+
+// lists reports whether the viewer is shown the page wherever pages are listed:
+// the front page, the search results, the recent changes and their feed, the
+// API's listing, the reports and the auto-links. It hides the sandbox pages from
+// everybody but their owner and the admins, and the pages the ACL doesn't let
+// the viewer read. Every listing asks it rather than checking for itself, so
+// that those shown a page see it everywhere.
+func (v viewer) lists(title string) bool {
+	return v.listsWith(v.wiki.currentACL(), title)
+}
+
+// filter returns those of the titles listed for the viewer.
+func (v viewer) filter(titles []string) []string {
+	acl := v.wiki.currentACL()
+	var listed []string
+	for _, title := range titles {
+		if v.listsWith(acl, title) {
+			listed = append(listed, title)
+		}
+	}
+	return listed
+}
+
+// listsWith is lists with the rules of the ACL page read already, for a listing
+// that asks about every page.
+func (v viewer) listsWith(acl accessList, title string) bool {
+	if v.admin {
+		return true
+	}
+	if owner, ok := sandboxOwner(title); isSandbox(title) && (!ok || v.user == "" || owner != v.user) {
+		return false
+	}
+	return v.wiki.roleOf(acl, v.user, title) >= roleReader
+}
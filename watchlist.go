@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// watchNotifications are the ways a user can be told of the changes to the pages
+// they watch as they're made: not at all, leaving them to the digest, with a
+// notification on the wiki, or by mail as well.
+var watchNotifications = []string{"", "app", "email"}
+
+// setWatchNotifications stores how the user is told of the changes to the pages
+// they watch. Mail goes to the address of their digest.
+func (wiki *Wiki) setWatchNotifications(user, notify string) error {
+	if !slices.Contains(watchNotifications, notify) {
+		return fmt.Errorf("changes are notified on the wiki or by e-mail, not %q", notify)
+	}
+	wiki.digests.Lock()
+	defer wiki.digests.Unlock()
+	prefs := wiki.digests.byUser[user]
+	if notify == "email" && prefs.Email == "" {
+		return fmt.Errorf("give an e-mail address on /users/%s to be mailed the changes", user)
+	}
+	prefs.Notify = notify
+	wiki.digests.byUser[user] = prefs
+	return wiki.saveDigests()
+}
+
+// changeMessage describes the change of the journal entry to the page's
+// watchers, "" for changes they aren't told of.
+func changeMessage(entry JournalEntry) string {
+	switch entry.Op {
+	case "save":
+		return entry.Author + " changed " + entry.Title
+	case "delete":
+		return entry.Author + " deleted " + entry.Title
+	case "rename":
+		return entry.Author + " renamed " + entry.Title + " to " + entry.To
+	}
+	return ""
+}
+
+// watchHook is the page hook that tells the users watching a page of its
+// changes, but for their own, the way they chose, and keeps them watching it
+// when it's renamed. Changes without an author, like those fsck records, are
+// left to the digest.
+type watchHook struct {
+	wiki *Wiki
+	noPageHook
+}
+
+func (hook watchHook) AfterChange(entry JournalEntry) {
+	message := changeMessage(entry)
+	if message == "" || entry.Author == "" {
+		return
+	}
+	page := entry.Title
+	if entry.Op == "rename" {
+		page = entry.To
+	}
+	hook.wiki.digests.Lock()
+	defer hook.wiki.digests.Unlock()
+	renamed := false
+	for user, prefs := range hook.wiki.digests.byUser {
+		if !slices.Contains(prefs.Watchlist, entry.Title) {
+			continue
+		}
+		if entry.Op == "rename" {
+			prefs.Watchlist = slices.DeleteFunc(prefs.Watchlist, func(watched string) bool { return watched == entry.Title || watched == entry.To })
+			prefs.Watchlist = append(prefs.Watchlist, entry.To)
+			slices.Sort(prefs.Watchlist)
+			hook.wiki.digests.byUser[user] = prefs
+			renamed = true
+		}
+		if user == entry.Author || prefs.Notify == "" || !(viewer{wiki: hook.wiki, user: user}).lists(page) {
+			continue
+		}
+		if err := hook.wiki.notify(user, Notification{Time: entry.Time, From: entry.Author, Page: page, Message: message}); err != nil {
+			log.Printf("could not notify %s of the change to %s: %v", user, entry.Title, err)
+		}
+		if prefs.Notify == "email" && hook.wiki.mailer != nil && prefs.Email != "" {
+			go hook.wiki.mailChange(user, prefs.Email, page, message, entry.Comment)
+		}
+	}
+	if renamed {
+		if err := hook.wiki.saveDigests(); err != nil {
+			log.Printf("could not move the watchlists to %s: %v", entry.To, err)
+		}
+	}
+}
+
+// mailChange mails the user the message of a change to a page they watch.
+func (wiki *Wiki) mailChange(user, email, page, message, comment string) {
+	base := strings.TrimSuffix(wiki.config.BaseURL, "/")
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hello %s,\n\n%s.\n", user, message)
+	if comment != "" {
+		fmt.Fprintf(&body, "\n  %s\n", comment)
+	}
+	fmt.Fprintf(&body, "\n%s/view/%s\n\nYou watch this page. Change how you're told of changes on %s/watchlist\n", base, page, base)
+	if err := wiki.mailer.Send(email, message, body.String()); err != nil {
+		log.Printf("could not mail %s the change to %s: %v", user, page, err)
+	}
+}
+
+// WatchlistPage is the data of the watchlist template.
+type WatchlistPage struct {
+	User    string
+	Entries []PageEntry
+	Notify  string
+	Email   string
+	Mailer  bool
+	Error   string
+}
+
+// watchlistHandler serves /watchlist: the pages the user watches, the page they
+// changed last first, and how they're told of the changes, which they POST to
+// change.
+func (wiki *Wiki) watchlistHandler(w http.ResponseWriter, r *http.Request) {
+	user := wiki.currentUser(r)
+	if user == "" {
+		http.Redirect(w, r, "/login?next=/watchlist", http.StatusFound)
+		return
+	}
+	var formError string
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := wiki.setWatchNotifications(user, r.FormValue("notify")); err != nil {
+			formError = err.Error()
+			break
+		}
+		http.Redirect(w, r, "/watchlist", http.StatusFound)
+		return
+	default:
+		http.Error(w, translate(wiki.requestLocale(w), "error.methodNotAllowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	prefs := wiki.digestPreferencesOf(user)
+	watched := (viewer{wiki: wiki, user: user}).filter(slices.DeleteFunc(slices.Clone(prefs.Watchlist), func(title string) bool { return !wiki.titles.Has(title) }))
+	entries := wiki.pageIndex.entries(watched)
+	slices.SortStableFunc(entries, func(a, b PageEntry) int { return b.Modified.Compare(a.Modified) })
+	wiki.renderTemplate(w, "watchlist.html", WatchlistPage{User: user, Entries: entries, Notify: prefs.Notify, Email: prefs.Email, Mailer: wiki.mailer != nil, Error: formError})
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// webhookQueue bounds how many events wait to be POSTed; past it they're
+	// dropped, and the receivers catch up from the journal API by their seq.
+	webhookQueue = 256
+	// webhookAttempts is how often an event is POSTed to a URL that doesn't
+	// answer 2xx, a second more apart each time.
+	webhookAttempts = 3
+	// webhookTimeout bounds a POST, and waiting for the queue to be sent on exit.
+	webhookTimeout = 10 * time.Second
+)
+
+// WebhookEvent is the JSON body POSTed to the webhooks for a change to a page:
+// Event is "save", "delete" or "rename", Seq that of the journal entry and URL
+// where the page is read, that of To for a rename.
+type WebhookEvent struct {
+	Seq      uint64    `json:"seq"`
+	Event    string    `json:"event"`
+	Time     time.Time `json:"time"`
+	Title    string    `json:"title"`
+	URL      string    `json:"url"`
+	Author   string    `json:"author,omitempty"`
+	Revision int       `json:"revision,omitempty"`
+	Comment  string    `json:"comment,omitempty"`
+	To       string    `json:"to,omitempty"`
+}
+
+// webhookHook is the page hook that POSTs the changes to the pages listed to
+// everyone to the URLs of -webhooks, one event after the other from a queue, so
+// a slow receiver holds up neither the saves nor the events' order. With
+// GOWIKI_WEBHOOK_SECRET, X-Gowiki-Signature is sha256= and the hex HMAC-SHA256
+// of the body by the secret.
+type webhookHook struct {
+	wiki *Wiki
+	noPageHook
+	urls   []string
+	secret []byte
+	client *http.Client
+	// mu guards the queue against the events of the changes made as it's closed.
+	mu     sync.Mutex
+	closed bool
+	queue  chan WebhookEvent
+	done   chan struct{}
+}
+
+// newWebhooks registers the hook of the URLs of list, separated by commas, and
+// starts sending its events.
+func (wiki *Wiki) newWebhooks(list string) error {
+	var urls []string
+	for _, target := range strings.Split(list, ",") {
+		if target = strings.TrimSpace(target); target == "" {
+			continue
+		}
+		parsed, err := url.Parse(target)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("%s of -webhooks is not an http or https URL", target)
+		}
+		urls = append(urls, target)
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+	wiki.webhooks = &webhookHook{wiki: wiki, urls: urls, secret: []byte(wiki.config.WebhookSecret), client: &http.Client{Timeout: webhookTimeout},
+		queue: make(chan WebhookEvent, webhookQueue), done: make(chan struct{})}
+	wiki.registerPageHook(wiki.webhooks)
+	go wiki.webhooks.send()
+	return nil
+}
+
+func (hook *webhookHook) AfterChange(entry JournalEntry) {
+	if entry.Op != "save" && entry.Op != "delete" && entry.Op != "rename" {
+		return
+	}
+	if !hook.wiki.everyone.lists(entry.Title) && (entry.To == "" || !hook.wiki.everyone.lists(entry.To)) {
+		return
+	}
+	page := entry.Title
+	if entry.Op == "rename" {
+		page = entry.To
+	}
+	event := WebhookEvent{Seq: entry.Seq, Event: entry.Op, Time: entry.Time, Title: entry.Title,
+		URL: strings.TrimSuffix(hook.wiki.config.BaseURL, "/") + "/view/" + page, Author: entry.Author,
+		Revision: entry.Revision, Comment: entry.Comment, To: entry.To}
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+	if hook.closed {
+		return
+	}
+	select {
+	case hook.queue <- event:
+	default:
+		log.Printf("dropped the webhook event %d of %s, %d are waiting to be sent", event.Seq, event.Title, webhookQueue)
+	}
+}
+
+// send POSTs the events of the queue until it's closed.
+func (hook *webhookHook) send() {
+	defer close(hook.done)
+	for event := range hook.queue {
+		body, err := json.Marshal(event)
+		if err != nil {
+			log.Printf("could not encode the webhook event %d: %v", event.Seq, err)
+			continue
+		}
+		var wg sync.WaitGroup
+		for _, target := range hook.urls {
+			wg.Add(1)
+			go func(target string) {
+				defer wg.Done()
+				if err := hook.post(target, event.Event, body); err != nil {
+					log.Printf("could not send the webhook event %d to %s: %v", event.Seq, target, err)
+				}
+			}(target)
+		}
+		wg.Wait()
+	}
+}
+
+// post POSTs the body to the URL, trying again while it fails.
+func (hook *webhookHook) post(target, event string, body []byte) error {
+	var err error
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(time.Duration(attempt-1) * time.Second)
+		}
+		var req *http.Request
+		if req, err = http.NewRequest(http.MethodPost, target, bytes.NewReader(body)); err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "gowiki-webhook")
+		req.Header.Set("X-Gowiki-Event", event)
+		if len(hook.secret) > 0 {
+			mac := hmac.New(sha256.New, hook.secret)
+			mac.Write(body)
+			req.Header.Set("X-Gowiki-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		}
+		var resp *http.Response
+		if resp, err = hook.client.Do(req); err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 == 2 {
+			return nil
+		}
+		err = fmt.Errorf("it answered %s", resp.Status)
+	}
+	return err
+}
+
+// closeWebhooks waits for the events of the queue to be sent, up to
+// webhookTimeout, before the wiki or a command exits.
+func (wiki *Wiki) closeWebhooks() {
+	if wiki.webhooks == nil {
+		return
+	}
+	wiki.webhooks.mu.Lock()
+	wiki.webhooks.closed = true
+	close(wiki.webhooks.queue)
+	wiki.webhooks.mu.Unlock()
+	select {
+	case <-wiki.webhooks.done:
+	case <-time.After(webhookTimeout):
+		log.Printf("gave up sending %d webhook events", len(wiki.webhooks.queue))
+	}
+}
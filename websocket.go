@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketGUID is what RFC 6455 appends to the client's key for the accept.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// The opcodes of the WebSocket frames the wiki looks at; the others are read
+// and let go.
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xa
+)
+
+// wsMaxPayload is the longest frame read from a client; the wiki only sends on
+// its sockets, a client has no more to say than a ping or a close.
+const wsMaxPayload = 4096
+
+// wsWriteTimeout is how long a frame may take to reach the client.
+const wsWriteTimeout = 10 * time.Second
+
+// wsConn is the server's end of a WebSocket: just enough of RFC 6455 for the
+// wiki to send text frames, answer pings and close.
+type wsConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	// writing keeps the frames of the writers, like a pong and an update, whole.
+	writing sync.Mutex
+}
+
+// headerHasToken reports whether the comma separated header lists the token.
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgradeWebSocket answers the opening handshake of a WebSocket and takes over
+// the connection. A browser's socket must come from a page of the wiki itself,
+// or any site could open one with the visitor's cookie. It returns nil, the
+// request answered already, when the handshake fails.
+func (wiki *Wiki) upgradeWebSocket(w http.ResponseWriter, r *http.Request) *wsConn {
+	if r.Method != http.MethodGet || !headerHasToken(r.Header, "Connection", "upgrade") || !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		http.Error(w, translate(wiki.requestLocale(w), "error.webSocketHandshake"), http.StatusBadRequest)
+		return nil
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, translate(wiki.requestLocale(w), "error.webSocketVersion"), http.StatusUpgradeRequired)
+		return nil
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, translate(wiki.requestLocale(w), "error.webSocketKey"), http.StatusBadRequest)
+		return nil
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if parsed, err := url.Parse(origin); err != nil || !strings.EqualFold(parsed.Host, r.Host) {
+			http.Error(w, translate(wiki.requestLocale(w), "error.webSocketOrigin"), http.StatusForbidden)
+			return nil
+		}
+	}
+	conn, buffered, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, translate(wiki.requestLocale(w), "error.webSocketHijack"), http.StatusInternalServerError)
+		return nil
+	}
+	// the server's read and write timeouts are for requests, not for a socket
+	// kept open as long as the page is
+	conn.SetDeadline(time.Time{})
+	accept := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(buffered, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(accept[:]))
+	if err := buffered.Flush(); err != nil {
+		conn.Close()
+		return nil
+	}
+	return &wsConn{conn: conn, reader: buffered.Reader}
+}
+
+// writeFrame sends a whole, unmasked frame, as a server does.
+func (ws *wsConn) writeFrame(opcode byte, payload []byte) error {
+	ws.writing.Lock()
+	defer ws.writing.Unlock()
+	header := []byte{0x80 | opcode}
+	switch length := len(payload); {
+	case length < 126:
+		header = append(header, byte(length))
+	case length <= 0xffff:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+	ws.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := ws.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readFrame reads the next frame from the client, unmasked. Clients must mask
+// theirs, and keep them under wsMaxPayload.
+func (ws *wsConn) readFrame() (byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(ws.reader, header[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := header[0] & 0x0f
+	if header[1]&0x80 == 0 {
+		return 0, nil, errors.New("unmasked frame from the client")
+	}
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(ws.reader, extended[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(ws.reader, extended[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if length > wsMaxPayload {
+		return 0, nil, fmt.Errorf("frame of %d bytes from the client", length)
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(ws.reader, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(ws.reader, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// Close ends the socket with a close frame, going away, as when the wiki shuts
+// down, and closes the connection.
+func (ws *wsConn) Close() error {
+	ws.writeFrame(wsClose, binary.BigEndian.AppendUint16(nil, 1001))
+	return ws.conn.Close()
+}
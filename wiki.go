@@ -1,21 +1,25 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"embed"
+	"flag"
 	"fmt"
 	"html/template"
-	"io/ioutil"
+	"io/fs"
 	"log"
 	"net/http"
-	"path/filepath"
+	"net/url"
+	"os"
 	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
-
-	"github.com/shksa/gowiki/matrixRoute"
+	"time"
 )
 
-// This path has to be absolute without aliases like ~ and others.
-var packageDir = filepath.Dir("")
-
 // Page is a custom structure type that stores title and the body of a wiki.
 type Page struct {
 	Title string
@@ -24,22 +28,56 @@ type Page struct {
 
 // ViewTemplatePage is a custom structure type that stores Title and the HTML body specifially for the view template page
 type ViewTemplatePage struct {
-	Title string
-	Body  template.HTML
+	Title       string
+	Body        template.HTML
+	Warnings    []LintWarning
+	Meta        *PageMeta
+	Safe        bool
+	Review      PageReview
+	Attachments []Attachment
+	Backlinks   []string
+	// TOC are the headings of the body, shown above it to jump to its sections.
+	TOC []TOCEntry
+	// Duplicate tells that a resubmitted edit form was ignored.
+	Duplicate bool
+	// Static renders the page for a static site: without the controls, the
+	// forms and the scripts that need the wiki running.
+	Static bool
+	// Comments are the threads of the page's discussion, and Commented when it
+	// was last commented on.
+	Comments  []CommentThread
+	Commented time.Time
+	// EditLock tells that somebody other than the viewer has the page open in
+	// the editor.
+	EditLock *EditLock
+	// Watching tells that the viewer starred the page.
+	Watching bool
+	// RedirectedFrom is the redirect page the reader came by, and RedirectLoop
+	// the chain of redirects the page starts, back to one of them, which is
+	// shown rather than followed.
+	RedirectedFrom string
+	RedirectLoop   []string
 }
 
-func (p *Page) save() error {
-	filename := p.Title + ".txt"
-	return ioutil.WriteFile(filepath.Join(packageDir, "data", filename), p.Body, 0600)
+// storePage stores the page, saved by author with the message to the stores that
+// keep them, once the page hooks let it, and tells them of it after, which keeps
+// the indexes up to date.
+func (wiki *Wiki) storePage(p *Page, author, message string) error {
+	if isReserved(p.Title) {
+		return reservedError(p.Title)
+	}
+	if err := wiki.beforeSave(p, author); err != nil {
+		return err
+	}
+	if err := saveAs(wiki.store, p, author, message); err != nil {
+		return err
+	}
+	wiki.afterSave(p, author)
+	return nil
 }
 
-func load(title string) (*Page, error) {
-	filename := title + ".txt"
-	body, err := ioutil.ReadFile(filepath.Join(packageDir, "data", filename))
-	if err != nil {
-		return nil, err
-	}
-	return &Page{Title: title, Body: body}, nil
+func (wiki *Wiki) load(title string) (*Page, error) {
+	return wiki.store.Load(wiki.titles.Canonical(title))
 }
 
 /* Title validation
@@ -47,14 +85,28 @@ func load(title string) (*Page, error) {
 2. MustCompile is distinct from Compile in that it will panic if the expression compilation fails,
 while Compile returns an error as a second parameter.
 */
-var validPath = regexp.MustCompile("^/(edit|save|view)/([a-zA-Z0-9]+)$")
+var validPath = regexp.MustCompile("^/(edit|save|view|undo|history|diff|revert|delete|review|publish|backlinks|watch|rename|preview|comment|print|lock)/(" + titlePattern + ")$")
+
+// titlePattern matches a title: a slug of letters and digits, in a user's sandbox
+// when prefixed with ~name/, and in namespaces when prefixed with slugs and
+// slashes, like projects/gowiki/design. A slug has no dots, so a title can't step
+// out of the data directory with "..".
+const titlePattern = "(?:~[a-zA-Z0-9]+/)?" + pathPattern
+
+const pathPattern = pageNamePattern + "(?:/" + pageNamePattern + ")*"
+
+var validTitle = regexp.MustCompile("^" + titlePattern + "$")
+
+// validName matches the names of users and saved searches.
+var validName = regexp.MustCompile("^[a-zA-Z0-9]+$")
 
 /* Template caching
 1. renderTemplate should not call ParseFiles every time when a page needs to be rendered.
 2. A better approach would be to call ParseFiles once at program initialization,
 parsing all templates into a single *Template.
 3. Then we can use the ExecuteTemplate method to render a specific template.
-4. First we create a global variable named templates, and initialize it with ParseFiles.
+4. First we create a global variable named templates, and initialize it with ParseFiles in setup, once the
+template directory is configured.
 5. The function template.Must is a convenience wrapper that panics when passed a non-nil error value,
 and otherwise returns the *Template unaltered. A panic is appropriate here; if the templates can't be
 loaded the only sensible thing to do is exit the program.
@@ -63,34 +115,164 @@ and parses those files into templates that are named after the base file name.
 6. So the template name is the template file name.
 */
 
-var templates = template.Must(template.ParseFiles(
-	filepath.Join(packageDir, "tmpl", "edit.html"),
-	filepath.Join(packageDir, "tmpl", "view.html"),
-	filepath.Join(packageDir, "tmpl", "frontPage.html"),
-))
+// embeddedTemplates are the templates built into the binary, served unless
+// -tmpl-dir names a directory to read them from instead, or -dev reads tmpl/.
+//
+//go:embed tmpl
+var embeddedTemplates embed.FS
+
+// templateFiles are parsed from the templates at startup.
+var templateFiles = []string{
+	"banner.html",
+	"delete.html",
+	"rename.html",
+	"edit.html",
+	"view.html",
+	"frontPage.html",
+	"export.html",
+	"snapshot.html",
+	"history.html",
+	"changes.html",
+	"namespace.html",
+	"reports.html",
+	"login.html",
+	"redirects.html",
+	"titles.html",
+	"reviews.html",
+	"backlinks.html",
+	"conflict.html",
+	"rateLimited.html",
+	"readOnly.html",
+	"trash.html",
+	"links.html",
+	"backups.html",
+	"replace.html",
+	"import.html",
+	"users.html",
+	"tag.html",
+	"attachments.html",
+	"storage.html",
+	"stats.html",
+	"audit.html",
+	"reindex.html",
+	"profile.html",
+	"watchlist.html",
+	"stubs.html",
+	"search.html",
+	"diff.html",
+	"matrix.html",
+	"matrixBenchmark.html",
+	"print.html",
+	"error.html",
+	"offline.html",
+}
+
+func (wiki *Wiki) parseTemplates(dir string) map[string]map[string]*template.Template {
+	themed, err := wiki.readThemedTemplates(dir)
+	if err != nil {
+		panic(err)
+	}
+	return themed
+}
+
+// templateFS returns the files of the templates: the directory, or the embedded
+// ones when it's "".
+func templateFS(dir string) fs.FS {
+	if dir == "" {
+		embedded, _ := fs.Sub(embeddedTemplates, "tmpl")
+		return embedded
+	}
+	return os.DirFS(dir)
+}
+
+func (wiki *Wiki) readTemplates(dir string) (*template.Template, error) {
+	return template.New("").Funcs(wiki.templateFuncs).ParseFS(templateFS(dir), templateFiles...)
+}
 
-func renderTemplate(w http.ResponseWriter, templateFilename string, data interface{}) {
-	err := templates.ExecuteTemplate(w, templateFilename, data)
+// currentTemplates returns the templates of the theme and locale to render with,
+// or of -theme and -locale when there are no such: those parsed at startup, or in
+// -dev mode those on disk now, so that a template being worked on shows its
+// latest change on a reload.
+func (wiki *Wiki) currentTemplates(theme, locale string) (*template.Template, error) {
+	themed := wiki.templates
+	if wiki.config.Dev {
+		var err error
+		if themed, err = wiki.readThemedTemplates(wiki.config.TemplateDir); err != nil {
+			return nil, err
+		}
+	}
+	localized := themed[theme]
+	if localized == nil {
+		localized = themed[wiki.config.Theme]
+	}
+	if current := localized[locale]; current != nil {
+		return current, nil
+	}
+	return localized[wiki.config.Locale], nil
+}
 
+func (wiki *Wiki) renderTemplate(w http.ResponseWriter, templateFilename string, data interface{}) {
+	page, err := wiki.executeTemplate(w, templateFilename, data)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wiki.serveError(w, err)
+		return
 	}
+	w.Write(page)
 }
 
-func renderViewTemplate(w http.ResponseWriter, templateFilename string, pageData *Page) {
-	viewTemplatePageData := ViewTemplatePage{Title: pageData.Title}
+// executeTemplate renders the template in the theme and the language of the
+// request, with the CSRF token of the visitor's session in its forms, which post
+// back with it.
+func (wiki *Wiki) executeTemplate(w http.ResponseWriter, templateFilename string, data interface{}) ([]byte, error) {
+	var page bytes.Buffer
+	current, err := wiki.currentTemplates(wiki.requestTheme(w), wiki.requestLocale(w))
+	if err != nil {
+		return nil, err
+	}
+	if err := current.ExecuteTemplate(&page, templateFilename, data); err != nil {
+		return nil, err
+	}
+	if token := formToken(w); token != "" {
+		return addCSRFTokens(page.Bytes(), token), nil
+	}
+	return page.Bytes(), nil
+}
 
-	viewTemplatePageData.Body = template.HTML(
-		availableTitlesRegExp.ReplaceAllStringFunc(
-			string(pageData.Body),
-			func(match string) string {
-				replacementOfMatch := fmt.Sprintf(`<a href="/view/%s">%s</a>`, match, match)
-				return replacementOfMatch
-			},
-		),
-	)
+func (wiki *Wiki) renderViewTemplate(w http.ResponseWriter, v viewer, templateFilename string, pageData *Page, warnings []LintWarning, safe, duplicate bool) {
+	viewTemplatePageData, err := wiki.viewTemplateData(v, pageData, warnings, safe, duplicate)
+	if err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	wiki.renderTemplate(w, templateFilename, viewTemplatePageData)
+}
 
-	renderTemplate(w, templateFilename, viewTemplatePageData)
+// viewTemplateData is what the view template shows of the page to the viewer.
+func (wiki *Wiki) viewTemplateData(v viewer, pageData *Page, warnings []LintWarning, safe, duplicate bool) (ViewTemplatePage, error) {
+	viewTemplatePageData := ViewTemplatePage{Title: pageData.Title, Warnings: warnings, Safe: safe, Duplicate: duplicate}
+	meta, err := wiki.pageMeta(pageData)
+	if err != nil {
+		return viewTemplatePageData, err
+	}
+	viewTemplatePageData.Meta = meta
+	if lock, ok := wiki.titles.EditLockOf(pageData.Title, clock.Now()); ok && (v.user == "" || lock.User != v.user) {
+		viewTemplatePageData.EditLock = &lock
+	}
+	viewTemplatePageData.Review = wiki.metadataOf(pageData.Title).Review
+	viewTemplatePageData.Watching = v.user != "" && slices.Contains(wiki.digestPreferencesOf(v.user).Watchlist, pageData.Title)
+	viewTemplatePageData.Attachments = wiki.attachmentsOf(pageData.Title)
+	viewTemplatePageData.Backlinks = v.filter(wiki.backlinksOf(pageData.Title))
+	discussion, err := wiki.commentsOf(pageData.Title)
+	if err != nil {
+		return viewTemplatePageData, err
+	}
+	viewTemplatePageData.Comments = commentThreads(v, pageData.Title, discussion)
+	viewTemplatePageData.Commented = lastCommented(discussion)
+	// The body is rendered in the page's format, which escapes it and links the titles of other pages.
+	body := wiki.rendererFor(pageData.Title, safe).Render(wiki.embedAttachments(pageData.Title, pageData.Body))
+	viewTemplatePageData.Body = template.HTML(body)
+	viewTemplatePageData.TOC = tableOfContents(body)
+	return viewTemplatePageData, nil
 }
 
 /*  Using decorators to reduce code duplication.
@@ -105,20 +287,39 @@ func renderViewTemplate(w http.ResponseWriter, templateFilename string, pageData
 title as arguments.
 */
 
-func makeHandler(fn func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
+func (wiki *Wiki) makeHandler(fn func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		match := validPath.FindStringSubmatch(r.URL.Path)
 		if match == nil {
-			http.NotFound(w, r)
+			if wiki.redirectToSlug(w, r) {
+				return
+			}
+			wiki.serveError(w, notFound(""))
 			return
 		}
 		title := match[2]
+		if isReserved(title) {
+			wiki.serveError(w, notFound(reservedError(title).Error()))
+			return
+		}
+		// a title in another case than the page's is the page
+		if canonical := wiki.titles.Canonical(title); canonical != title {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				target := "/" + match[1] + "/" + canonical
+				if r.URL.RawQuery != "" {
+					target += "?" + r.URL.RawQuery
+				}
+				http.Redirect(w, r, target, http.StatusFound)
+				return
+			}
+			title = canonical
+		}
 		fn(w, r, title)
 	}
 }
 
-func viewHandler(w http.ResponseWriter, r *http.Request, title string) {
-	pageData, err := load(title)
+func (wiki *Wiki) viewHandler(w http.ResponseWriter, r *http.Request, title string) {
+	pageData, err := wiki.load(title)
 	if err != nil {
 		// http.Redirect replies to the request with a redirect to url.
 		// The http.Redirect function adds an HTTP status code of http.StatusFound (302)
@@ -126,74 +327,357 @@ func viewHandler(w http.ResponseWriter, r *http.Request, title string) {
 		http.Redirect(w, r, "/edit/"+title, http.StatusFound)
 		return
 	}
-	renderViewTemplate(w, "view.html", pageData)
+	// a redirect page, like the old slug of a retitled page, sends the reader on
+	// to the end of its chain unless ?redirect=no asks for the redirect page
+	// itself, or the chain loops
+	var loop []string
+	if target := wiki.redirectTarget(pageData); target != "" && r.URL.Query().Get("redirect") != "no" {
+		final, chain, loops := wiki.followRedirects(title, target)
+		if !loops {
+			http.Redirect(w, r, "/view/"+final+"?redirectedfrom="+url.QueryEscape(title), http.StatusFound)
+			return
+		}
+		loop = append([]string{title}, chain...)
+	}
+	// saveHandler redirects here with ?saved=1, that's when the lint warnings are shown.
+	var warnings []LintWarning
+	if r.URL.Query().Get("saved") != "" {
+		warnings = wiki.lintPage(pageData)
+	}
+	wiki.sessions.recordView(sessionID(w, r), title)
+	wiki.countView(title)
+	data, err := wiki.viewTemplateData(wiki.viewerOf(r), pageData, warnings, wiki.isSafeMode(r), r.URL.Query().Get("duplicate") != "")
+	if err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	data.RedirectLoop = loop
+	if from := r.URL.Query().Get("redirectedfrom"); validTitle.MatchString(from) && wiki.titles.Has(from) {
+		data.RedirectedFrom = wiki.titles.Canonical(from)
+	}
+	// a browser or proxy that has the page as it is now, but for its view
+	// count, is answered 304
+	modified := wiki.pageModTime(title)
+	if data.Commented.After(modified) {
+		modified = data.Commented
+	}
+	serveConditional(w, r, modified, func(w http.ResponseWriter, stable bool) {
+		if stable {
+			meta := *data.Meta
+			meta.Views = 0
+			uncounted := data
+			uncounted.Meta = &meta
+			wiki.renderTemplate(w, "view.html", uncounted)
+			return
+		}
+		wiki.renderTemplate(w, "view.html", data)
+	})
 }
 
-func editHandler(w http.ResponseWriter, r *http.Request, title string) {
-	pageData, err := load(title)
-	if err != nil {
+func (wiki *Wiki) editHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if wiki.readOnly.Load() && !wiki.isAdmin(r) {
+		wiki.renderReadOnly(w, title)
+		return
+	}
+	// viewing is public, editing needs a login
+	if wiki.currentUser(r) == "" && !wiki.isAdmin(r) {
+		http.Redirect(w, r, "/login?next=/edit/"+title, http.StatusFound)
+		return
+	}
+	// a display title typed on the front page arrives from redirectToSlug
+	display := wiki.displayTitle(title)
+	if typed := r.URL.Query().Get("title"); typed != "" && slugMatches(title, typed) {
+		display = typed
+	}
+	editPage := EditTemplatePage{Title: title, DisplayTitle: display, Metadata: wiki.metadataOf(title), Format: wiki.formatOf(title), Formats: wiki.formatNames()}
+	pageData, err := wiki.load(title)
+	editPage.Base = revisionToken(nil)
+	if err == nil {
+		editPage.Base, editPage.Attachments, editPage.Exists = revisionToken(pageData.Body), wiki.attachmentsOf(title), true
+	} else {
 		pageData = &Page{Title: title}
+		// New pages start with the chosen page template, or else the default
+		// template of their namespace.
+		editPage.PageTemplates, editPage.PageTemplate = wiki.pageTemplateNames(), r.URL.Query().Get("template")
+		if editPage.PageTemplate != "" {
+			if pageData.Body, err = wiki.expandPageTemplate(editPage.PageTemplate, display); err != nil {
+				wiki.serveError(w, notFound("there's no page template "+editPage.PageTemplate))
+				return
+			}
+		} else if templateTitle := wiki.settingsFor(title).DefaultTemplate; templateTitle != "" {
+			if templatePage, err := wiki.load(templateTitle); err == nil {
+				pageData.Body = templatePage.Body
+			}
+		}
 	}
-	renderTemplate(w, "edit.html", pageData)
+	editPage.Body, editPage.Suggestion = pageData.Body, wiki.suggestTitle(pageData)
+	editPage.EditToken = wiki.sessions.issueEditToken(sessionID(w, r), title)
+	// opening the editor locks the page, unless somebody else has it open
+	if lock, ok := wiki.titles.TakeEditLock(title, wiki.requestAuthor(r), clock.Now(), false); !ok {
+		editPage.LockedBy = &lock
+	}
+	wiki.renderTemplate(w, "edit.html", editPage)
 }
 
-func saveHandler(w http.ResponseWriter, r *http.Request, title string) {
+func (wiki *Wiki) saveHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if wiki.readOnly.Load() && !wiki.isAdmin(r) {
+		wiki.renderReadOnly(w, title)
+		return
+	}
+	if ok, reason := wiki.canEdit(r, title); !ok {
+		wiki.serveError(w, forbidden(reason))
+		return
+	}
 	// <textarea name="body" rows="20" cols="80">
 	body := r.FormValue("body")
-	newPageData := &Page{Title: title, Body: []byte(body)}
-	// save() writes the new page data to file
-	err := newPageData.save()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := wiki.checkPageSize(title, int64(len(body))); err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	display, target, ok := checkDisplayTitle(title, r.FormValue("displayTitle"))
+	if !ok {
+		http.Error(w, translate(wiki.requestLocale(w), "error.titleLetters", display), http.StatusBadRequest)
 		return
 	}
-	// update the wiki title list if the current title isn't already present
-	if isAlreadyPresent := availableWikiTitles[title]; !isAlreadyPresent {
-		updateWikiTitleList(title)
-		updateWikiTitlesRexEx(title)
+	// the edit form sends the version it started from; a save of any other
+	// version than the stored one would lose the changes made since
+	wiki.editsMu.Lock()
+	defer wiki.editsMu.Unlock()
+	// a form that was saved already, submitted again by a refresh or the back
+	// button, would only add an identical revision
+	session, editToken := sessionID(w, r), r.Form.Get("editToken")
+	if editToken != "" && wiki.sessions.editTokenSpent(session, editToken, title) {
+		http.Redirect(w, r, "/view/"+title+"?duplicate=1", http.StatusSeeOther)
+		return
 	}
-	// client is redirected to the /view/ page.
-	http.Redirect(w, r, "/view/"+title, http.StatusFound)
+	if r.Form.Has("base") {
+		conflict, err := wiki.editConflict(title, r.Form.Get("base"), body)
+		if err != nil {
+			wiki.serveError(w, err)
+			return
+		}
+		if conflict != nil {
+			conflict.DisplayTitle, conflict.Weight, conflict.Format, conflict.EditToken = r.Form.Get("displayTitle"), r.Form.Get("weight"), r.Form.Get("format"), editToken
+			conflict.Tags = r.Form.Get("tags")
+			wiki.renderConflict(w, conflict)
+			return
+		}
+	}
+	// the weight field of the edit form orders the page in listings, the
+	// display title is shown instead of the slug, the tags list it under
+	// /tag/{name}, and the format is the markup the body is rendered from
+	metadata := wiki.metadataOf(title)
+	if r.Form.Has("weight") {
+		metadata.Weight, _ = strconv.Atoi(r.Form.Get("weight"))
+	}
+	if r.Form.Has("displayTitle") {
+		metadata.DisplayTitle = display
+	}
+	if r.Form.Has("tags") {
+		metadata.Tags = parseTags(r.Form.Get("tags"))
+	}
+	if r.Form.Has("format") {
+		format := r.Form.Get("format")
+		if err := wiki.checkFormat(format); err != nil {
+			wiki.serveError(w, badRequest(err.Error()))
+			return
+		}
+		// the wiki's format isn't pinned, so the page follows a change of -format
+		metadata.Format = format
+		if format == wiki.config.Format && wiki.metadataOf(title).Format == "" {
+			metadata.Format = ""
+		}
+	}
+	if strings.EqualFold(title, aclTitle) {
+		if _, err := parseACL([]byte(body)); err != nil {
+			wiki.serveError(w, badRequest(err.Error()))
+			return
+		}
+	}
+	if wiki.config.LintStrict {
+		if warnings := wiki.lintPage(&Page{Title: target, Body: []byte(body)}); len(warnings) > 0 {
+			wiki.renderLintRejection(w, r, title, body, metadata, warnings)
+			return
+		}
+	}
+	// a display title with other words moves the page to its new slug
+	if target != title {
+		if wiki.titles.Has(target) {
+			http.Error(w, translate(wiki.requestLocale(w), "error.pageExists", target), http.StatusConflict)
+			return
+		}
+		if ok, reason := wiki.canEdit(r, target); !ok {
+			wiki.serveError(w, forbidden(reason))
+			return
+		}
+		if err := wiki.moveToSlug(title, target, wiki.requestAuthor(r), []byte(body), metadata); err != nil {
+			wiki.serveError(w, err)
+			return
+		}
+		wiki.discardSavedDraft(r, title)
+		wiki.titles.ReleaseEditLock(title, wiki.requestAuthor(r))
+		wiki.sessions.spendEditToken(session, editToken)
+		http.Redirect(w, r, "/view/"+target+"?saved=1", http.StatusFound)
+		return
+	}
+	if err := wiki.savePage(&Page{Title: title, Body: []byte(body)}, wiki.requestAuthor(r)); err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	if err := wiki.setMetadata(title, metadata); err != nil {
+		wiki.serveError(w, err)
+		return
+	}
+	wiki.discardSavedDraft(r, title)
+	wiki.titles.ReleaseEditLock(title, wiki.requestAuthor(r))
+	wiki.sessions.spendEditToken(session, editToken)
+	// client is redirected to the /view/ page, which shows the lint warnings of the saved page.
+	http.Redirect(w, r, "/view/"+title+"?saved=1", http.StatusFound)
 }
 
-func rootHandler(w http.ResponseWriter, r *http.Request) {
-	renderTemplate(w, "frontPage.html", availableWikiTitles)
+// FrontPage is the data of the front page template.
+type FrontPage struct {
+	Index       PageListing
+	Tags        []TagCount
+	RecentViews []string
+	User        string
+	Unread      int
+	// Sandbox lists the pages in the logged in user's sandbox.
+	Sandbox []string
+	// MostViewed are the pages viewed the most, without -count-views none.
+	MostViewed []PageViews
 }
 
-// for page inter-linking
-var availableTitlesPattern = ""
-var availableTitlesRegExp *regexp.Regexp
-
-// for inter-linking new page titles
-func updateWikiTitlesRexEx(title string) {
-	availableTitlesPattern += fmt.Sprintf("|%s", title)
-	availableTitlesRegExp = regexp.MustCompile("(" + availableTitlesPattern + ")")
+// savePage saves a new version of the page by author: it's stored, recorded in the
+// history and its title registered for the front page listing and inter-linking.
+func (wiki *Wiki) savePage(p *Page, author string) error {
+	if err := wiki.recordBaseline(p.Title); err != nil {
+		return err
+	}
+	isNew := !wiki.titles.Has(p.Title)
+	// storePage writes the new page data to the store
+	if err := wiki.storePage(p, author, ""); err != nil {
+		return err
+	}
+	revision, err := wiki.recordRevision(p, author, "")
+	if err != nil {
+		return err
+	}
+	if err := wiki.journalRevision("save", p.Title, revision); err != nil {
+		return err
+	}
+	wiki.titles.Add(p.Title)
+	if isNew {
+		wiki.backlinks.relink(p.Title)
+	}
+	return wiki.staleReview(p.Title)
 }
 
-// for front page listing
-var availableWikiTitles = make(map[string]bool)
-
-func updateWikiTitleList(title string) {
-	availableWikiTitles[title] = true
+func (wiki *Wiki) rootHandler(w http.ResponseWriter, r *http.Request) {
+	user := wiki.currentUser(r)
+	front := FrontPage{Index: wiki.frontPageListing(r), Tags: wiki.tagCloud(wiki.viewerOf(r)), RecentViews: wiki.sessions.recentViews(sessionID(w, r)), User: user, Unread: wiki.unreadNotifications(user)}
+	if user != "" {
+		front.Sandbox = wiki.sandboxPages(user)
+	}
+	if wiki.config.CountViews {
+		front.MostViewed = wiki.mostViewed(wiki.viewerOf(r), mostViewedShown)
+	}
+	wiki.renderTemplate(w, "frontPage.html", front)
 }
 
-func init() {
-	files, err := ioutil.ReadDir(filepath.Join(packageDir, "data"))
+// setup parses the templates, opens the page store and loads the titles and settings of the wiki,
+// then starts the scan that indexes the pages in the background.
+func (wiki *Wiki) setup() {
+	if wiki.config.Dev && wiki.config.TemplateDir == "" {
+		wiki.config.TemplateDir = "tmpl"
+	}
+	if err := wiki.checkBasePath(); err != nil {
+		log.Fatal(err)
+	}
+	if err := wiki.loadThemes(wiki.config.ThemeDir); err != nil {
+		log.Fatal("could not read the themes due to error:\n" + err.Error())
+	}
+	if err := wiki.loadLocales(wiki.config.LocaleDir); err != nil {
+		log.Fatal("could not read the locales due to error:\n" + err.Error())
+	}
+	wiki.templates = wiki.parseTemplates(wiki.config.TemplateDir)
+	wiki.readOnly.Store(wiki.config.ReadOnly)
+	var err error
+	if wiki.store, err = openStore(wiki.config.Store, wiki.config.DataDir); err != nil {
+		log.Fatal("could not open the page store due to error:\n" + err.Error())
+	}
+	if wiki.config.CachePages > 0 {
+		wiki.pageCache = newCachedStore(wiki.store, wiki.config.CachePages)
+		wiki.store = wiki.pageCache
+	}
+	if wiki.config.MigrateFrom != "" {
+		if err := checkMigration(wiki.config.Store, wiki.config.DataDir, wiki.config.MigrateFrom); err != nil {
+			log.Fatal(err)
+		}
+		if wiki.store, err = newMigratingStore(wiki.store, wiki.config.MigrateFrom); err != nil {
+			log.Fatal("could not read the legacy pages due to error:\n" + err.Error())
+		}
+	}
+	storedTitles, err := wiki.store.List()
 	if err != nil {
-		log.Fatal("could not read files from the ~/go/src/github.com/shksa/gowiki/data directory due to error:\n" + err.Error())
+		log.Fatal("could not list the pages due to error:\n" + err.Error())
+	}
+	wiki.titles.Add(storedTitles...)
+	if err := wiki.loadUsers(); err != nil {
+		log.Fatal("could not read the users due to error:\n" + err.Error())
+	}
+	if err := wiki.loadLogins(); err != nil {
+		log.Fatal("could not read the logins due to error:\n" + err.Error())
+	}
+	if err := wiki.loadOAuthProviders(wiki.config.OAuthProviders); err != nil {
+		log.Fatal("could not read the identity providers due to error:\n" + err.Error())
+	}
+	if err := wiki.loadNotifications(); err != nil {
+		log.Fatal("could not read the notifications due to error:\n" + err.Error())
+	}
+	if err := wiki.loadViews(); err != nil {
+		log.Fatal("could not read the view counts due to error:\n" + err.Error())
+	}
+	if err := wiki.loadAttachments(); err != nil {
+		log.Fatal("could not read the attachments due to error:\n" + err.Error())
+	}
+	if wiki.uploadScanner, err = newUploadScanner(wiki.config.ScanCommand, wiki.config.ScanURL); err != nil {
+		log.Fatal("could not configure the upload scanner due to error:\n" + err.Error())
+	}
+	if err := wiki.loadPrivateAccess(wiki.config.AllowIPs, wiki.config.BasicAuth, wiki.config.PrivateScope); err != nil {
+		log.Fatal("could not configure the private access due to error:\n" + err.Error())
 	}
-	for _, file := range files {
-		title := strings.Split(file.Name(), ".")[0] // bcoz ".txt" should not be included in the title
-		availableTitlesPattern += fmt.Sprintf("%s|", title)
-		availableWikiTitles[title] = true
+	if err := wiki.loadDictionary(wiki.config.Dictionary); err != nil {
+		log.Fatal("could not read the dictionary due to error:\n" + err.Error())
 	}
-	availableTitlesPattern = availableTitlesPattern[:len(availableTitlesPattern)-1]
-	availableTitlesRegExp = regexp.MustCompile("(" + availableTitlesPattern + ")")
-	// fmt.Println(availableTitlesPattern)
-	// fmt.Printf("%s\n", availableTitlesRegExp.ReplaceAllFunc([]byte("messi president of america is donaldTrump. He is pretty test."), func(match []byte) []byte {
-	// 	replacementOfMatch := fmt.Sprintf(`<a href="/view/%s">%s</a>`, match, match)
-	// 	return []byte(replacementOfMatch)
-	// }))
+	if err := wiki.loadMarkupRules(wiki.config.MarkupRules); err != nil {
+		log.Fatal("could not read the markup rules due to error:\n" + err.Error())
+	}
+	if err := wiki.checkFormat(wiki.config.Format); err != nil {
+		log.Fatal(err)
+	}
+	if wiki.highlight, err = openHighlighter(wiki.config.Highlight); err != nil {
+		log.Fatal(err)
+	}
+	if err := wiki.loadDigests(); err != nil {
+		log.Fatal("could not read the digest preferences due to error:\n" + err.Error())
+	}
+	if wiki.mailer, err = newMailer(wiki.config.SMTPAddr, wiki.config.MailFrom); err != nil {
+		log.Fatal("could not configure the mailer due to error:\n" + err.Error())
+	}
+	if err := wiki.newWebhooks(wiki.config.Webhooks); err != nil {
+		log.Fatal("could not configure the webhooks due to error:\n" + err.Error())
+	}
+	if err := wiki.loadSettings(); err != nil {
+		log.Fatal("could not read settings.json due to error:\n" + err.Error())
+	}
+	if err := wiki.loadSavedSearches(); err != nil {
+		log.Fatal("could not read the saved searches due to error:\n" + err.Error())
+	}
+	if err := wiki.loadBanner(); err != nil {
+		log.Fatal("could not read the banner due to error:\n" + err.Error())
+	}
+	go wiki.startupScan.run(storedTitles, runtime.GOMAXPROCS(0))
 }
 
 /*
@@ -201,10 +685,123 @@ func init() {
 2. If the requested Page doesn't exist, it should redirect the client to the edit Page so the content may be created.
 */
 func main() {
-	http.HandleFunc("/", rootHandler)
-	http.HandleFunc("/view/", makeHandler(viewHandler))
-	http.HandleFunc("/edit/", makeHandler(editHandler))
-	http.HandleFunc("/save/", makeHandler(saveHandler))
-	http.HandleFunc("/mm", matrixRoute.MatrixHandler)
-	log.Fatal(http.ListenAndServe(":80", nil))
+	flag.Var(mounts, "mount", "mount an optional module under a path prefix, as name=/prefix (empty prefix disables it)")
+	flag.Var(limits, "limit", "serve at most n requests of a group at once, as group=n for total, views, saves or matrix (0 lifts it)")
+	flag.Usage = printUsage
+	flag.Parse()
+	if err := setupLogging(hostConfig.LogFormat); err != nil {
+		log.Fatal(err)
+	}
+	var err error
+	if ids, err = newIDSource(hostConfig.IDs, clock); err != nil {
+		log.Fatal(err)
+	}
+	name, args := "serve", flag.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(flag.CommandLine.Output(), "%s is not a command\n", name)
+		printUsage()
+		os.Exit(2)
+	}
+	wiki := newWiki(hostConfig)
+	wiki.setup()
+	if name != "serve" {
+		// the commands work on the whole wiki, the server serves while it's scanned
+		wiki.startupScan.wait()
+	}
+	err = cmd.run(wiki, args)
+	wiki.closeWebhooks()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// registerRoutes registers the wiki's handlers, and those of the mounted modules, in mux.
+// The work the modules do in the background stops once ctx is done.
+func (wiki *Wiki) registerRoutes(ctx context.Context, mux *http.ServeMux) {
+	mux.HandleFunc("/", wiki.rootHandler)
+	mux.HandleFunc("/view/", wiki.withNamespaceIndex(wiki.makeHandler(wiki.viewHandler)))
+	mux.HandleFunc("/edit/", wiki.makeHandler(wiki.editHandler))
+	mux.HandleFunc("/save/", wiki.makeHandler(wiki.saveHandler))
+	mux.HandleFunc("/undo/", wiki.makeHandler(wiki.undoHandler))
+	mux.HandleFunc("/history/", wiki.makeHandler(wiki.historyHandler))
+	mux.HandleFunc("/files/", wiki.filesHandler)
+	mux.HandleFunc("/backlinks/", wiki.makeHandler(wiki.backlinksHandler))
+	mux.HandleFunc("/diff/", wiki.makeHandler(wiki.diffHandler))
+	mux.HandleFunc("/revert/", wiki.makeHandler(wiki.revertHandler))
+	mux.HandleFunc("/delete/", wiki.makeHandler(wiki.deleteHandler))
+	mux.HandleFunc("/review/", wiki.makeHandler(wiki.reviewHandler))
+	mux.HandleFunc("/publish/", wiki.makeHandler(wiki.publishHandler))
+	mux.HandleFunc("/watch/", wiki.makeHandler(wiki.watchHandler))
+	mux.HandleFunc("/rename/", wiki.makeHandler(wiki.renameHandler))
+	mux.HandleFunc("/preview/", wiki.makeHandler(wiki.previewHandler))
+	mux.HandleFunc("/comment/", wiki.makeHandler(wiki.commentHandler))
+	mux.HandleFunc("/print/", wiki.makeHandler(wiki.printHandler))
+	mux.HandleFunc("/lock/", wiki.makeHandler(wiki.lockHandler))
+	mux.HandleFunc("/export", wiki.bundleExportHandler)
+	mux.HandleFunc("/export/", wiki.snapshotHandler)
+	mux.HandleFunc("/import", wiki.bundleImportHandler)
+	mux.HandleFunc("/attachments/", wiki.attachmentsHandler)
+	mux.HandleFunc("/login", wiki.loginHandler)
+	mux.HandleFunc("/login/oauth/", wiki.oauthHandler)
+	mux.HandleFunc("/logout", wiki.logoutHandler)
+	mux.HandleFunc("/users/", wiki.profileHandler)
+	mux.HandleFunc("/watchlist", wiki.watchlistHandler)
+	mux.HandleFunc("/random", wiki.randomHandler)
+	mux.HandleFunc("/stubs", wiki.stubsHandler)
+	mux.HandleFunc("/reports", wiki.reportsHandler)
+	mux.HandleFunc("/reports.csv", wiki.reportsHandler)
+	mux.HandleFunc("/reports/redirects", wiki.redirectsReportHandler)
+	mux.HandleFunc("/reports/titles", wiki.titleSuggestionsHandler)
+	mux.HandleFunc("/reports/reviews", wiki.reviewReportHandler)
+	mux.HandleFunc("/reports/attachments", wiki.attachmentReportHandler)
+	mux.HandleFunc("/reports/storage", wiki.storageReportHandler)
+	mux.HandleFunc("/admin/replace", wiki.replaceHandler)
+	mux.HandleFunc("/admin/users", wiki.usersHandler)
+	mux.HandleFunc("/admin/read-only", wiki.readOnlyHandler)
+	mux.HandleFunc("/admin/links", wiki.linkReportHandler)
+	mux.HandleFunc("/admin/backups", wiki.backupsHandler)
+	mux.HandleFunc("/admin/stats", wiki.statsHandler)
+	mux.HandleFunc("/admin/audit", wiki.auditHandler)
+	mux.HandleFunc("/admin/reindex", wiki.reindexHandler)
+	mux.HandleFunc("/trash", wiki.trashHandler)
+	mux.HandleFunc("/sitemap.xml", wiki.sitemapHandler)
+	mux.HandleFunc("/ws", wiki.liveUpdatesHandler)
+	mux.HandleFunc("/robots.txt", wiki.robotsHandler)
+	mux.HandleFunc("/manifest.webmanifest", wiki.manifestHandler)
+	mux.HandleFunc("/sw.js", wiki.serviceWorkerHandler)
+	mux.HandleFunc("/offline", wiki.offlineHandler)
+	mux.Handle("/static/", staticHandler())
+	mux.HandleFunc("/theme", wiki.themeHandler)
+	mux.HandleFunc("/locale", wiki.localeHandler)
+	mux.HandleFunc("/themes/", wiki.themeHandler)
+	mux.HandleFunc("/search", wiki.searchHandler)
+	mux.HandleFunc("/tag/", wiki.tagHandler)
+	mux.HandleFunc("/api/v1/saved-searches", wiki.savedSearchesHandler)
+	mux.HandleFunc("/api/v1/lint", wiki.lintAPIHandler)
+	mux.HandleFunc("/api/v1/lint/", wiki.lintAPIHandler)
+	mux.HandleFunc("/api/lint", wiki.lintAPIHandler)
+	mux.HandleFunc("/api/lint/", wiki.lintAPIHandler)
+	mux.HandleFunc("/api/v1/convert", wiki.convertHandler)
+	mux.HandleFunc("/api/v1/pages", wiki.pagesAPIHandler)
+	mux.HandleFunc("/api/v1/pages/", wiki.pagesAPIHandler)
+	mux.HandleFunc("/api/v1/recent-views", wiki.recentViewsHandler)
+	mux.HandleFunc("/api/v1/banner", wiki.bannerHandler)
+	mux.HandleFunc("/api/v1/notifications", wiki.notificationsHandler)
+	mux.HandleFunc("/api/v1/drafts/", wiki.draftsHandler)
+	mux.HandleFunc("/api/drafts/", wiki.draftsHandler)
+	mux.HandleFunc("/api/v1/changes", wiki.changesAPIHandler)
+	mux.HandleFunc("/api/v1/csrf", csrfAPIHandler)
+	mux.HandleFunc("/api/v1/cache", wiki.cacheStatsHandler)
+	mux.HandleFunc("/api/v1/journal", wiki.journalAPIHandler)
+	mux.HandleFunc("/graphql", wiki.graphqlHandler)
+	mux.HandleFunc("/changes", wiki.recentChangesHandler)
+	mux.HandleFunc("/changes.atom", wiki.changesFeedHandler)
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", wiki.readyzHandler)
+	wiki.registerDebugRoutes(mux)
+	wiki.mountModules(ctx, mux)
 }
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testWiki is the wiki the tests work on.
+var testWiki *Wiki
+
+// TestMain sets the wiki up as main does, on a data directory of its own that's
+// removed after the tests, so they never touch the pages of data/.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "gowiki-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	hostConfig.DataDir, hostConfig.BackupDir = dir, filepath.Join(dir, "backups")
+	if ids, err = newIDSource(hostConfig.IDs, clock); err != nil {
+		log.Fatal(err)
+	}
+	testWiki = newWiki(hostConfig)
+	testWiki.setup()
+	testWiki.startupScan.wait()
+	code := m.Run()
+	testWiki.closeWebhooks()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// savePages saves the pages of the bodies by their titles as admin, failing the
+// test if one can't be saved.
+func savePages(t *testing.T, bodies map[string]string) {
+	t.Helper()
+	for title, body := range bodies {
+		if err := testWiki.savePage(&Page{Title: title, Body: []byte(body)}, "admin"); err != nil {
+			t.Fatalf("could not save %s: %v", title, err)
+		}
+	}
+}